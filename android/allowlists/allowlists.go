@@ -264,6 +264,13 @@ var (
 		"car-ui-androidx-constraintlayout-solver-nodeps",
 	}
 
+	// Per-directory allowlists of module names, for directories with a few convertible modules
+	// among many that are not ready for conversion. Only the listed modules of the directory
+	// default to bp2build_available: true, the other ones default to false, whatever the entry of
+	// the directory in Bp2buildDefaultConfig. Modules can still opt in or out with their
+	// bp2build_available property. Not recursive.
+	Bp2buildDirectoryModuleNames = map[string][]string{}
+
 	Bp2buildModuleTypeAlwaysConvertList = []string{
 		"java_import",
 		"java_import_host",
@@ -366,6 +366,11 @@ func (a *AndroidMkEntries) getDistContributions(mod blueprint.Module) *distContr
 
 	// Iterate over this module's dist structs, merged from the dist and dists properties.
 	for _, dist := range amod.Dists() {
+		// Skip the dists of the other variants of the module.
+		if !distVariantMatches(mod, dist) {
+			continue
+		}
+
 		// Get the list of goals this dist should be enabled for. e.g. sdk, droidcore
 		goals := strings.Join(dist.Targets, " ")
 
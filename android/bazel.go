@@ -20,12 +20,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android/allowlists"
+	"android/soong/bazel"
+	"android/soong/starlark_fmt"
 )
 
 const (
@@ -33,6 +37,12 @@ const (
 	// no package path. This is also the module dir for top level Android.bp
 	// modules.
 	Bp2BuildTopLevel = "."
+
+	// The file name used for hand-crafted build targets.
+	// NOTE: It is okay that this matches the name of generated BUILD files, since we generate BUILD
+	// files in a different directory to source files
+	// FIXME: Because there are hundreds of existing BUILD.bazel files in the AOSP tree, we should pick another name here, like BUILD.android
+	HandcraftedBuildFileName = "BUILD.bazel"
 )
 
 type bazelModuleProperties struct {
@@ -51,11 +61,163 @@ type bazelModuleProperties struct {
 	// To defer the default setting for the directory, do not set the value.
 	Bp2build_available *bool
 
+	// If set, bp2build only converts this module for the listed build variants, one of "eng",
+	// "userdebug" or "user". This further restricts bp2build_available and the allowlists.
+	Bp2build_available_variants []string
+
+	// If true, the module is never replaced by its Bazel target in mixed builds, even if it is
+	// converted by bp2build or has a handcrafted label. This is the per-module equivalent of
+	// MixedBuildsDisabledList in android/allowlists/allowlists.go.
+	Mixed_build_disabled *bool
+
+	// Attributes of the Bazel target generated by bp2build to replace with the given string lists,
+	// e.g. [{ name: "copts", value: ["-Wall"] }]. The overrides are applied after the bp2build
+	// converter, so they can correct individual attributes without handcrafting the whole target
+	// with label.
+	Attribute_overrides []bazelStringListAttributeOverride
+
+	// Like attribute_overrides, for the bool attributes of the generated Bazel target, e.g.
+	// [{ name: "alwayslink", value: true }].
+	Bool_attribute_overrides []bazelBoolAttributeOverride
+
 	// CanConvertToBazel is set via InitBazelModule to indicate that a module type can be converted to
 	// Bazel with Bp2build.
 	CanConvertToBazel bool `blueprint:"mutated"`
 }
 
+// bazelStringListAttributeOverride replaces a string list attribute of a generated Bazel target.
+type bazelStringListAttributeOverride struct {
+	// The name of the attribute.
+	Name *string
+
+	// The value replacing the attribute.
+	Value []string
+}
+
+// bazelBoolAttributeOverride replaces a bool attribute of a generated Bazel target.
+type bazelBoolAttributeOverride struct {
+	// The name of the attribute.
+	Name *string
+
+	// The value replacing the attribute.
+	Value *bool
+}
+
+// BazelAttributeOverrides maps the names of attributes of a generated Bazel target to the values
+// replacing them, set with bazel_module: { attribute_overrides: [...] } for string lists and
+// bazel_module: { bool_attribute_overrides: [...] } for bools.
+type BazelAttributeOverrides map[string]interface{}
+
+// bazelAttributeKind is the kind of value an attribute override may set.
+type bazelAttributeKind int
+
+const (
+	// The attribute can't be overridden.
+	otherBazelAttribute bazelAttributeKind = iota
+	stringListBazelAttribute
+	boolBazelAttribute
+)
+
+func (k bazelAttributeKind) String() string {
+	switch k {
+	case stringListBazelAttribute:
+		return "string list"
+	case boolBazelAttribute:
+		return "bool"
+	default:
+		return "string list or bool"
+	}
+}
+
+// universalBazelAttributes are the attributes that every Bazel rule accepts, so they may be
+// overridden even if the bp2build converter doesn't set them.
+var universalBazelAttributes = map[string]bazelAttributeKind{
+	"features": stringListBazelAttribute,
+	"tags":     stringListBazelAttribute,
+	"testonly": boolBazelAttribute,
+}
+
+// bazelAttributeKindOf returns the kind of value that overrides an attribute of type t.
+func bazelAttributeKindOf(t reflect.Type) bazelAttributeKind {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Bool, t == reflect.TypeOf(bazel.BoolAttribute{}):
+		return boolBazelAttribute
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String,
+		t == reflect.TypeOf(bazel.StringListAttribute{}),
+		t == reflect.TypeOf(bazel.LabelListAttribute{}):
+		return stringListBazelAttribute
+	default:
+		return otherBazelAttribute
+	}
+}
+
+// bazelAttributeKinds returns the kinds of the attributes of the given attribute structs, keyed by
+// their Bazel names.
+func bazelAttributeKinds(attrs ...interface{}) map[string]bazelAttributeKind {
+	kinds := map[string]bazelAttributeKind{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous {
+				addFields(field.Type)
+			} else if field.PkgPath == "" && !proptools.HasTag(field, "blueprint", "mutated") {
+				kinds[proptools.PropertyNameForField(field.Name)] = bazelAttributeKindOf(field.Type)
+			}
+		}
+	}
+	for _, a := range attrs {
+		addFields(reflect.TypeOf(a))
+	}
+	for name, kind := range universalBazelAttributes {
+		kinds[name] = kind
+	}
+	return kinds
+}
+
+// bazelAttributeOverrides returns the attribute overrides of the module, or nil if it has none.
+// It reports a property error for each overridden attribute that the generated target, described
+// by attrs, doesn't accept, or that has a different kind of value.
+func (b *BazelModuleBase) bazelAttributeOverrides(ctx BaseModuleContext, ruleClass string, attrs ...interface{}) BazelAttributeOverrides {
+	props := &b.bazelProperties.Bazel_module
+	if len(props.Attribute_overrides) == 0 && len(props.Bool_attribute_overrides) == 0 {
+		return nil
+	}
+
+	kinds := bazelAttributeKinds(attrs...)
+	overrides := BazelAttributeOverrides{}
+	add := func(property string, name *string, kind bazelAttributeKind, value interface{}) {
+		if name == nil {
+			ctx.PropertyErrorf(property, "missing attribute name")
+		} else if want, ok := kinds[*name]; !ok {
+			ctx.PropertyErrorf(property, "unknown attribute %q for rule class %q", *name, ruleClass)
+		} else if want != kind {
+			ctx.PropertyErrorf(property, "attribute %q of rule class %q must be overridden with a %s",
+				*name, ruleClass, want)
+		} else if _, exists := overrides[*name]; exists {
+			ctx.PropertyErrorf(property, "attribute %q is overridden more than once", *name)
+		} else {
+			overrides[*name] = value
+		}
+	}
+	for _, o := range props.Attribute_overrides {
+		add("bazel_module.attribute_overrides", o.Name, stringListBazelAttribute, o.Value)
+	}
+	for _, o := range props.Bool_attribute_overrides {
+		add("bazel_module.bool_attribute_overrides", o.Name, boolBazelAttribute, proptools.Bool(o.Value))
+	}
+	return overrides
+}
+
 // Properties contains common module properties for Bazel migration purposes.
 type properties struct {
 	// In USE_BAZEL_ANALYSIS=1 mode, this represents the Bazel target replacing
@@ -97,6 +259,8 @@ type Bazelable interface {
 	GetBazelLabel(ctx BazelConversionPathContext, module blueprint.Module) string
 	ShouldConvertWithBp2build(ctx BazelConversionContext) bool
 	shouldConvertWithBp2build(ctx bazelOtherModuleContext, module blueprint.Module) bool
+	shouldConvertWithBp2buildAllowlist(ctx bazelOtherModuleContext, module blueprint.Module,
+		allowlist bp2BuildConversionAllowlist) bool
 	GetBazelBuildFileContents(c Config, path, name string) (string, error)
 	ConvertWithBp2build(ctx TopDownMutatorContext)
 
@@ -113,6 +277,7 @@ type Bazelable interface {
 	setNamespacedVariableProps(props namespacedVariableProperties)
 	BaseModuleType() string
 	SetBaseModuleType(baseModuleType string)
+	bazelAttributeOverrides(ctx BaseModuleContext, ruleClass string, attrs ...interface{}) BazelAttributeOverrides
 }
 
 // BazelModule is a lightweight wrapper interface around Module for Bazel-convertible modules.
@@ -128,6 +293,71 @@ func InitBazelModule(module BazelModule) {
 	module.bazelProps().Bazel_module.CanConvertToBazel = true
 }
 
+// registerBazelModuleConfigMutator registers the mutator that validates the bazel_module
+// properties and records the modules whose bazel_module properties conflict. It must run after the defaults mutators, which can set them.
+func registerBazelModuleConfigMutator(ctx RegisterMutatorsContext) {
+	ctx.BottomUp("bazel_module_config", bazelModuleConfigMutator).Parallel()
+}
+
+func bazelModuleConfigMutator(ctx BottomUpMutatorContext) {
+	if _, ok := ctx.Module().(Defaults); ok {
+		// Only the modules the defaults are applied to are converted.
+		return
+	}
+	b, ok := ctx.Module().(Bazelable)
+	if !ok {
+		return
+	}
+	props := b.bazelProps().Bazel_module
+	if props.Label != nil && proptools.Bool(props.Bp2build_available) {
+		addModuleWithConflictingBazelConfig(ctx.Config(), ctx.ModuleName())
+	}
+	for _, variant := range props.Bp2build_available_variants {
+		if !InList(variant, buildVariants) {
+			ctx.PropertyErrorf("bazel_module.bp2build_available_variants",
+				"unknown build variant %q, expected one of %q", variant, buildVariants)
+		}
+	}
+}
+
+var modulesWithConflictingBazelConfigKey = NewOnceKey("modulesWithConflictingBazelConfig")
+
+// modulesWithConflictingBazelConfig holds the names of the modules that set both
+// bazel_module.label and bazel_module.bp2build_available: true.
+type modulesWithConflictingBazelConfig struct {
+	lock    sync.Mutex
+	modules map[string]bool
+}
+
+func getModulesWithConflictingBazelConfig(config Config) *modulesWithConflictingBazelConfig {
+	return config.Once(modulesWithConflictingBazelConfigKey, func() interface{} {
+		return &modulesWithConflictingBazelConfig{modules: make(map[string]bool)}
+	}).(*modulesWithConflictingBazelConfig)
+}
+
+func addModuleWithConflictingBazelConfig(config Config, name string) {
+	conflicting := getModulesWithConflictingBazelConfig(config)
+	conflicting.lock.Lock()
+	defer conflicting.lock.Unlock()
+	conflicting.modules[name] = true
+}
+
+// Bp2buildConvertibleModuleTypes returns the sorted names of the registered module types that
+// have a bp2build converter.
+func Bp2buildConvertibleModuleTypes() []string {
+	return bp2buildConvertibleModuleTypes(ModuleTypeFactories())
+}
+
+func bp2buildConvertibleModuleTypes(factories map[string]ModuleFactory) []string {
+	var moduleTypes []string
+	for _, moduleType := range SortedStringKeys(factories) {
+		if b, ok := factories[moduleType]().(Bazelable); ok && b.bazelProps().Bazel_module.CanConvertToBazel {
+			moduleTypes = append(moduleTypes, moduleType)
+		}
+	}
+	return moduleTypes
+}
+
 // bazelProps returns the Bazel properties for the given BazelModuleBase.
 func (b *BazelModuleBase) bazelProps() *properties {
 	return &b.bazelProperties
@@ -190,6 +420,11 @@ type bp2BuildConversionAllowlist struct {
 	// when they have the same type as one listed.
 	moduleTypeAlwaysConvert map[string]bool
 
+	// Per-directory allowlists of module names. Only the listed modules of these directories
+	// default to being converted, which takes precedence over defaultConfig but not over the
+	// bp2build_available property of the modules.
+	directoryModuleNames map[string]map[string]bool
+
 	// Per-module denylist to always opt modules out of both bp2build and mixed builds.
 	moduleDoNotConvert map[string]bool
 
@@ -210,6 +445,7 @@ func NewBp2BuildAllowlist() bp2BuildConversionAllowlist {
 		map[string]bool{},
 		map[string]bool{},
 		map[string]bool{},
+		map[string]map[string]bool{},
 		map[string]bool{},
 		map[string]bool{},
 		map[string]bool{},
@@ -264,6 +500,23 @@ func (a bp2BuildConversionAllowlist) SetModuleTypeAlwaysConvertList(moduleTypeAl
 	return a
 }
 
+// SetDirectoryModuleNames copies the entries from directoryModuleNames into the allowlist
+func (a bp2BuildConversionAllowlist) SetDirectoryModuleNames(directoryModuleNames map[string][]string) bp2BuildConversionAllowlist {
+	if a.directoryModuleNames == nil {
+		a.directoryModuleNames = map[string]map[string]bool{}
+	}
+	for dir, names := range directoryModuleNames {
+		if a.directoryModuleNames[dir] == nil {
+			a.directoryModuleNames[dir] = map[string]bool{}
+		}
+		for _, name := range names {
+			a.directoryModuleNames[dir][name] = true
+		}
+	}
+
+	return a
+}
+
 // SetModuleDoNotConvertList copies the entries from moduleDoNotConvert into the allowlist
 func (a bp2BuildConversionAllowlist) SetModuleDoNotConvertList(moduleDoNotConvert []string) bp2BuildConversionAllowlist {
 	if a.moduleDoNotConvert == nil {
@@ -305,10 +558,115 @@ var bp2buildAllowlist = NewBp2BuildAllowlist().
 	SetKeepExistingBuildFile(allowlists.Bp2buildKeepExistingBuildFile).
 	SetModuleAlwaysConvertList(allowlists.Bp2buildModuleAlwaysConvertList).
 	SetModuleTypeAlwaysConvertList(allowlists.Bp2buildModuleTypeAlwaysConvertList).
+	SetDirectoryModuleNames(allowlists.Bp2buildDirectoryModuleNames).
 	SetModuleDoNotConvertList(allowlists.Bp2buildModuleDoNotConvertList).
 	SetCcLibraryStaticOnlyList(allowlists.Bp2buildCcLibraryStaticOnlyList).
 	SetMixedBuildsDisabledList(allowlists.MixedBuildsDisabledList)
 
+// bp2buildConfigEntryNames are the names of the allowlists.BazelConversionConfigEntry values in
+// the Starlark representation of the allowlist.
+var bp2buildConfigEntryNames = map[allowlists.BazelConversionConfigEntry]string{
+	allowlists.Bp2BuildDefaultTrueRecursively: "default_true_recursively",
+	allowlists.Bp2BuildDefaultTrue:            "default_true",
+	allowlists.Bp2BuildDefaultFalse:           "default_false",
+}
+
+// DiffBp2BuildConfig returns a line describing each directory whose entry was added, removed or
+// changed between the bp2build configs a and b, sorted by directory, e.g. to review which
+// directories change their default with an edit to allowlists.Bp2buildDefaultConfig.
+func DiffBp2BuildConfig(a, b allowlists.Bp2BuildConfig) []string {
+	dirs := make(map[string]bool, len(a)+len(b))
+	for dir := range a {
+		dirs[dir] = true
+	}
+	for dir := range b {
+		dirs[dir] = true
+	}
+
+	var changes []string
+	for _, dir := range SortedStringKeys(dirs) {
+		before, inA := a[dir]
+		after, inB := b[dir]
+		switch {
+		case !inA:
+			changes = append(changes, fmt.Sprintf("added %q: %s", dir, bp2buildConfigEntryName(after)))
+		case !inB:
+			changes = append(changes, fmt.Sprintf("removed %q: %s", dir, bp2buildConfigEntryName(before)))
+		case before != after:
+			changes = append(changes, fmt.Sprintf("changed %q: %s -> %s", dir,
+				bp2buildConfigEntryName(before), bp2buildConfigEntryName(after)))
+		}
+	}
+	return changes
+}
+
+// bp2buildConfigEntryName returns the name of a bp2build config entry, or its value if it is
+// unknown.
+func bp2buildConfigEntryName(entry allowlists.BazelConversionConfigEntry) string {
+	if name, ok := bp2buildConfigEntryNames[entry]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", entry)
+}
+
+// StarlarkBp2buildAllowlist returns a Starlark representation of the allowlists and denylists used
+// to decide which modules are converted by bp2build, so that Bazel-side tooling reads the same data
+// as Soong.
+func StarlarkBp2buildAllowlist() string {
+	return bp2buildAllowlist.starlarkString()
+}
+
+func (a bp2BuildConversionAllowlist) starlarkString() string {
+	defaultConfig := make(map[string]string, len(a.defaultConfig))
+	for dir, entry := range a.defaultConfig {
+		name, ok := bp2buildConfigEntryNames[entry]
+		if !ok {
+			panic(fmt.Errorf("unknown bp2build config entry %d for %q", entry, dir))
+		}
+		defaultConfig[dir] = `"` + name + `"`
+	}
+
+	directoryModuleNames := make(map[string][]string, len(a.directoryModuleNames))
+	for dir, names := range a.directoryModuleNames {
+		directoryModuleNames[dir] = SortedStringKeys(names)
+	}
+
+	return fmt.Sprintf(`
+_bp2build_default_config = %s
+
+_keep_existing_build_file = %s
+
+_module_always_convert = %s
+
+_module_type_always_convert = %s
+
+_directory_module_names = %s
+
+_module_do_not_convert = %s
+
+_cc_library_static_only = %s
+
+_mixed_builds_disabled = %s
+
+bp2build_default_config = _bp2build_default_config
+keep_existing_build_file = _keep_existing_build_file
+module_always_convert = _module_always_convert
+module_type_always_convert = _module_type_always_convert
+directory_module_names = _directory_module_names
+module_do_not_convert = _module_do_not_convert
+cc_library_static_only = _cc_library_static_only
+mixed_builds_disabled = _mixed_builds_disabled
+`, starlark_fmt.PrintDict(defaultConfig, 0),
+		starlark_fmt.PrintBoolDict(a.keepExistingBuildFile, 0),
+		starlark_fmt.PrintStringList(SortedStringKeys(a.moduleAlwaysConvert), 0),
+		starlark_fmt.PrintStringList(SortedStringKeys(a.moduleTypeAlwaysConvert), 0),
+		starlark_fmt.PrintStringListDict(directoryModuleNames, 0),
+		starlark_fmt.PrintStringList(SortedStringKeys(a.moduleDoNotConvert), 0),
+		starlark_fmt.PrintStringList(SortedStringKeys(a.ccLibraryStaticOnly), 0),
+		starlark_fmt.PrintStringList(SortedStringKeys(a.mixedBuildsDisabled), 0),
+	)
+}
+
 // GenerateCcLibraryStaticOnly returns whether a cc_library module should only
 // generate a static version of itself based on the current global configuration.
 func GenerateCcLibraryStaticOnly(moduleName string) bool {
@@ -321,17 +679,23 @@ func ShouldKeepExistingBuildFileForDir(dir string) bool {
 	return shouldKeepExistingBuildFileForDir(bp2buildAllowlist, dir)
 }
 
+// EffectiveShouldWriteBuildFile returns whether bp2build writes a generated BUILD file for dir
+// based on the current global configuration. It doesn't if dir is listed in
+// keepExistingBuildFile, or if one of its ancestors is listed recursively.
+func EffectiveShouldWriteBuildFile(dir string) bool {
+	return !shouldKeepExistingBuildFileForDir(bp2buildAllowlist, dir)
+}
+
 func shouldKeepExistingBuildFileForDir(allowlist bp2BuildConversionAllowlist, dir string) bool {
 	if _, ok := allowlist.keepExistingBuildFile[dir]; ok {
 		// Exact dir match
 		return true
 	}
-	// Check if subtree match
-	for prefix, recursive := range allowlist.keepExistingBuildFile {
-		if recursive {
-			if strings.HasPrefix(dir, prefix+"/") {
-				return true
-			}
+	// Check if an ancestor, including the root directory, is kept recursively
+	for ancestor := dir; ancestor != "." && ancestor != "/"; {
+		ancestor = filepath.Dir(ancestor)
+		if allowlist.keepExistingBuildFile[ancestor] {
+			return true
 		}
 	}
 	// Default
@@ -361,16 +725,73 @@ func (b *BazelModuleBase) MixedBuildsEnabled(ctx ModuleContext) bool {
 		// variants of a cc_library.
 		return false
 	}
+	if proptools.Bool(b.bazelProperties.Bazel_module.Mixed_build_disabled) {
+		return false
+	}
 	return !bp2buildAllowlist.mixedBuildsDisabled[ctx.Module().Name()]
 }
 
+// validateHandcraftedBazelLabel reports an error if the handcrafted Bazel label of the module
+// doesn't exist in the Bazel workspace. It only checks the label in mixed builds, the labels of all
+// the modules are queried together when the BazelContext is invoked after the first pass.
+func validateHandcraftedBazelLabel(ctx ModuleContext) {
+	b, ok := ctx.Module().(Bazelable)
+	if !ok || !b.HasHandcraftedLabel() || !ctx.Config().BazelContext.BazelEnabled() {
+		return
+	}
+	label := b.HandcraftedLabel()
+	if exists, ok := ctx.Config().BazelContext.LabelExists(label); ok && !exists {
+		ctx.PropertyErrorf("bazel_module.label", "%q doesn't exist in the Bazel workspace", label)
+	}
+}
+
 // ConvertedToBazel returns whether this module has been converted (with bp2build or manually) to Bazel.
+// Modules converted with bp2build that require modules that aren't converted are not, as their
+// generated targets are dropped.
 func convertedToBazel(ctx BazelConversionContext, module blueprint.Module) bool {
 	b, ok := module.(Bazelable)
 	if !ok {
 		return false
 	}
-	return b.shouldConvertWithBp2build(ctx, module) || b.HasHandcraftedLabel()
+	if b.HasHandcraftedLabel() {
+		return true
+	}
+	return b.shouldConvertWithBp2build(ctx, module) && !requiresUnconvertedBp2buildModules(ctx, module, nil)
+}
+
+// requiresUnconvertedBp2buildModules returns true if the module, directly or through the modules it
+// requires, requires a module that isn't converted to Bazel or doesn't exist. The targets generated
+// for such a module couldn't be installed with the required modules, so they are dropped. visited
+// holds the modules already checked.
+func requiresUnconvertedBp2buildModules(ctx BazelConversionContext, module blueprint.Module, visited map[blueprint.Module]bool) bool {
+	m, ok := module.(Module)
+	if !ok {
+		return false
+	}
+	base := m.base()
+	if base.bp2buildRequiresMissingModules {
+		return true
+	}
+	if visited == nil {
+		visited = make(map[blueprint.Module]bool)
+	}
+	visited[module] = true
+	for _, dep := range base.bp2buildRequiredModules {
+		if visited[dep] {
+			continue
+		}
+		b, ok := dep.(Bazelable)
+		if !ok {
+			return true
+		}
+		if b.HasHandcraftedLabel() {
+			continue
+		}
+		if !b.shouldConvertWithBp2build(ctx, dep) || requiresUnconvertedBp2buildModules(ctx, dep, visited) {
+			return true
+		}
+	}
+	return false
 }
 
 // ShouldConvertWithBp2build returns whether the given BazelModuleBase should be converted with bp2build
@@ -387,10 +808,24 @@ type bazelOtherModuleContext interface {
 }
 
 func (b *BazelModuleBase) shouldConvertWithBp2build(ctx bazelOtherModuleContext, module blueprint.Module) bool {
+	return b.shouldConvertWithBp2buildAllowlist(ctx, module, ctx.Config().bp2buildPackageConfig)
+}
+
+// shouldConvertWithBp2buildAllowlist is shouldConvertWithBp2build with the given allowlist in place
+// of the one of the config.
+func (b *BazelModuleBase) shouldConvertWithBp2buildAllowlist(ctx bazelOtherModuleContext, module blueprint.Module,
+	allowlist bp2BuildConversionAllowlist) bool {
 	if !b.bazelProps().Bazel_module.CanConvertToBazel {
 		return false
 	}
 
+	if variants := b.bazelProperties.Bazel_module.Bp2build_available_variants; variants != nil {
+		// Unknown variants are reported by bazelModuleConfigMutator and never match here.
+		if !InList(ctx.Config().BuildVariant(), variants) {
+			return false
+		}
+	}
+
 	propValue := b.bazelProperties.Bazel_module.Bp2build_available
 	packagePath := ctx.OtherModuleDir(module)
 
@@ -402,7 +837,6 @@ func (b *BazelModuleBase) shouldConvertWithBp2build(ctx bazelOtherModuleContext,
 	}
 
 	moduleName := module.Name()
-	allowlist := ctx.Config().bp2buildPackageConfig
 	moduleNameAllowed := allowlist.moduleAlwaysConvert[moduleName]
 	moduleTypeAllowed := allowlist.moduleTypeAlwaysConvert[ctx.OtherModuleType(module)]
 	allowlistConvert := moduleNameAllowed || moduleTypeAllowed
@@ -426,6 +860,12 @@ func (b *BazelModuleBase) shouldConvertWithBp2build(ctx bazelOtherModuleContext,
 		}
 	}
 
+	// The allowlist of module names of the directory takes precedence over its default.
+	if names, ok := allowlist.directoryModuleNames[packagePath]; ok {
+		// Allow modules to explicitly opt-in or opt-out.
+		return proptools.BoolDefault(propValue, names[moduleName] || allowlistConvert)
+	}
+
 	// This is a tristate value: true, false, or unset.
 	if ok, directoryPath := bp2buildDefaultTrueRecursively(packagePath, allowlist.defaultConfig); ok {
 		if moduleNameAllowed {
@@ -503,17 +943,73 @@ func (b *BazelModuleBase) GetBazelBuildFileContents(c Config, path, name string)
 	return string(data[:]), nil
 }
 
+// HandcraftedBuildFileInfo reports the result of resolving the BUILD file that contains the
+// handcrafted target of a module with a bazel_module.label.
+type HandcraftedBuildFileInfo struct {
+	// The path of the BUILD file that was looked up, relative to the root of the source tree.
+	Path string
+	// Whether the BUILD file was found and read.
+	Found bool
+	// The error encountered while reading the BUILD file, if it was not found.
+	Error string
+}
+
+var HandcraftedBuildFileInfoProvider = blueprint.NewMutatorProvider(HandcraftedBuildFileInfo{}, "bp2build_conversion")
+
+// resolveHandcraftedBuildFile looks up the BUILD file of the package of the handcrafted label of
+// the module the same way bp2build does when appending it to the generated BUILD file.
+func resolveHandcraftedBuildFile(c Config, b Bazelable) HandcraftedBuildFileInfo {
+	pkg := strings.Split(strings.TrimPrefix(b.HandcraftedLabel(), "//"), ":")[0]
+	info := HandcraftedBuildFileInfo{
+		Path: filepath.Join(pkg, HandcraftedBuildFileName),
+	}
+	if _, err := b.GetBazelBuildFileContents(c, pkg, HandcraftedBuildFileName); err != nil {
+		info.Error = err.Error()
+	} else {
+		info.Found = true
+	}
+	return info
+}
+
 func registerBp2buildConversionMutator(ctx RegisterMutatorsContext) {
+	ctx.TopDown("bp2build_required", collectBp2buildRequired).Parallel()
 	ctx.TopDown("bp2build_conversion", convertWithBp2build).Parallel()
 }
 
+// collectBp2buildRequired records the modules required by the module before any module is
+// converted, so that whether a module is converted doesn't depend on the order the modules it
+// requires are converted in, see requiresUnconvertedBp2buildModules.
+func collectBp2buildRequired(ctx TopDownMutatorContext) {
+	if b, ok := ctx.Module().(Bazelable); !ok || !b.bazelProps().Bazel_module.CanConvertToBazel {
+		return
+	}
+	base := ctx.Module().base()
+	for _, name := range base.bp2buildRequired(ctx) {
+		if m, _ := ctx.ModuleFromName(name); m != nil {
+			base.bp2buildRequiredModules = append(base.bp2buildRequiredModules, m)
+		} else {
+			base.bp2buildRequiresMissingModules = true
+		}
+	}
+}
+
 func convertWithBp2build(ctx TopDownMutatorContext) {
 	bModule, ok := ctx.Module().(Bazelable)
-	if !ok || !bModule.shouldConvertWithBp2build(ctx, ctx.Module()) {
+	if !ok {
 		return
 	}
 
-	bModule.ConvertWithBp2build(ctx)
+	if bModule.HasHandcraftedLabel() {
+		ctx.SetProvider(HandcraftedBuildFileInfoProvider, resolveHandcraftedBuildFile(ctx.Config(), bModule))
+	}
+
+	if bModule.shouldConvertWithBp2build(ctx, ctx.Module()) {
+		bModule.ConvertWithBp2build(ctx)
+	}
+
+	if dir := ctx.Config().Bp2buildTrialDir(); dir != "" && ctx.ModuleDir() == dir {
+		ctx.SetProvider(Bp2buildTrialInfoProvider, trialConvertWithBp2build(ctx, bModule))
+	}
 }
 
 // GetMainClassInManifest scans the manifest file specified in filepath and returns
@@ -87,6 +87,10 @@ type BazelContext interface {
 	// Returns the executable binary resultant from building together the python sources
 	GetPythonBinary(label string, cfgKey configKey) (string, bool)
 
+	// Returns whether the given label exists in the Bazel workspace. The labels are checked in a
+	// single Bazel query when InvokeBazel is called.
+	LabelExists(label string) (bool, bool)
+
 	// ** End cquery methods
 
 	// Issues commands to Bazel to receive results for all cquery requests
@@ -126,6 +130,9 @@ type bazelContext struct {
 
 	results map[cqueryKey]string // Results of cquery requests after Bazel invocations
 
+	labelRequests  map[string]bool // Labels whose existence has not yet been queried
+	existingLabels map[string]bool // Labels that exist in the workspace, nil before Bazel invocations
+
 	// Build statements which should get registered to reflect Bazel's outputs.
 	buildStatements []bazel.BuildStatement
 }
@@ -144,6 +151,7 @@ type MockBazelContext struct {
 	LabelToOutputFiles  map[string][]string
 	LabelToCcInfo       map[string]cquery.CcInfo
 	LabelToPythonBinary map[string]string
+	LabelToExists       map[string]bool
 }
 
 func (m MockBazelContext) GetOutputFiles(label string, cfgKey configKey) ([]string, bool) {
@@ -161,6 +169,11 @@ func (m MockBazelContext) GetPythonBinary(label string, cfgKey configKey) (strin
 	return result, ok
 }
 
+func (m MockBazelContext) LabelExists(label string) (bool, bool) {
+	result, ok := m.LabelToExists[label]
+	return result, ok
+}
+
 func (m MockBazelContext) InvokeBazel() error {
 	panic("unimplemented")
 }
@@ -208,6 +221,16 @@ func (bazelCtx *bazelContext) GetPythonBinary(label string, cfgKey configKey) (s
 	return ret, ok
 }
 
+func (bazelCtx *bazelContext) LabelExists(label string) (bool, bool) {
+	if bazelCtx.existingLabels != nil {
+		return bazelCtx.existingLabels[label], true
+	}
+	bazelCtx.requestMutex.Lock()
+	defer bazelCtx.requestMutex.Unlock()
+	bazelCtx.labelRequests[label] = true
+	return false, false
+}
+
 func (n noopBazelContext) GetOutputFiles(label string, cfgKey configKey) ([]string, bool) {
 	panic("unimplemented")
 }
@@ -220,6 +243,10 @@ func (n noopBazelContext) GetPythonBinary(label string, cfgKey configKey) (strin
 	panic("unimplemented")
 }
 
+func (n noopBazelContext) LabelExists(label string) (bool, bool) {
+	panic("unimplemented")
+}
+
 func (n noopBazelContext) InvokeBazel() error {
 	panic("unimplemented")
 }
@@ -248,9 +275,10 @@ func NewBazelContext(c *config) (BazelContext, error) {
 		return nil, err
 	}
 	return &bazelContext{
-		bazelRunner: &builtinBazelRunner{},
-		paths:       p,
-		requests:    make(map[cqueryKey]bool),
+		bazelRunner:   &builtinBazelRunner{},
+		paths:         p,
+		requests:      make(map[cqueryKey]bool),
+		labelRequests: make(map[string]bool),
 	}, nil
 }
 
@@ -404,6 +432,11 @@ func (r *builtinBazelRunner) issueBazelCommand(paths *bazelPaths, runName bazel.
 	bazelCmd.Stderr = stderr
 
 	if output, err := bazelCmd.Output(); err != nil {
+		// With --keep_going, exit code 3 means that the command only failed for some of the targets,
+		// the output has the results for the others.
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 3 && InList("--keep_going", extraFlags) {
+			return string(output), string(stderr.Bytes()), nil
+		}
 		return "", string(stderr.Bytes()),
 			fmt.Errorf("bazel command failed. command: [%s], env: [%s], error [%s]", bazelCmd, bazelCmd.Env, stderr)
 	} else {
@@ -670,6 +703,7 @@ func (context *bazelContext) InvokeBazel() error {
 			return err
 		}
 	}
+
 	err = ioutil.WriteFile(filepath.Join(soongInjectionPath, "WORKSPACE.bazel"), []byte{}, 0666)
 	if err != nil {
 		return err
@@ -682,12 +716,25 @@ func (context *bazelContext) InvokeBazel() error {
 		return err
 	}
 
-	err = ioutil.WriteFile(
-		filepath.Join(mixedBuildsPath, "BUILD.bazel"),
-		context.mainBuildFileContents(), 0666)
+	buildFilePath := filepath.Join(mixedBuildsPath, "BUILD.bazel")
+	err = ioutil.WriteFile(buildFilePath, context.mainBuildFileContents(), 0666)
 	if err != nil {
 		return err
 	}
+
+	// The query needs the @soong_injection repository to be complete, so the requests for missing
+	// labels are dropped once its files are written, and the BUILD file is then rewritten without
+	// them.
+	requestCount := len(context.requests)
+	if err := context.queryExistingLabels(); err != nil {
+		return err
+	}
+	if len(context.requests) != requestCount {
+		err = ioutil.WriteFile(buildFilePath, context.mainBuildFileContents(), 0666)
+		if err != nil {
+			return err
+		}
+	}
 	cqueryFileRelpath := filepath.Join(context.paths.injectedFilesDir(), "buildroot.cquery")
 	err = ioutil.WriteFile(
 		absolutePath(cqueryFileRelpath),
@@ -765,6 +812,42 @@ func (context *bazelContext) InvokeBazel() error {
 
 	// Clear requests.
 	context.requests = map[cqueryKey]bool{}
+	context.labelRequests = map[string]bool{}
+	return nil
+}
+
+// queryExistingLabels checks which of the labels requested with LabelExists exist in the Bazel
+// workspace with a single query. Requests for cquery results of missing labels are dropped, so that
+// the modules that depend on them fail with an error naming the label instead of the whole cquery
+// failing.
+func (context *bazelContext) queryExistingLabels() error {
+	context.existingLabels = make(map[string]bool)
+	if len(context.labelRequests) == 0 {
+		return nil
+	}
+
+	labels := SortedStringKeys(context.labelRequests)
+	output, _, err := context.issueBazelCommand(
+		context.paths,
+		bazel.QueryHandcraftedLabelsRunName,
+		bazelCommand{"query", strings.Join(labels, " + ")},
+		// Report the labels that exist even if some of them don't.
+		"--keep_going",
+		"--output=label")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if label := strings.TrimSpace(line); label != "" {
+			context.existingLabels[label] = true
+		}
+	}
+
+	for key := range context.requests {
+		if context.labelRequests[key.label] && !context.existingLabels[key.label] {
+			delete(context.requests, key)
+		}
+	}
 	return nil
 }
 
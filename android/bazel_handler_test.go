@@ -1,9 +1,11 @@
 package android
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -29,6 +31,41 @@ func TestRequestResultsAfterInvokeBazel(t *testing.T) {
 	}
 }
 
+func TestLabelExistsAfterInvokeBazel(t *testing.T) {
+	cfg := configKey{"arm64_armv8-a", Android}
+	bazelContext, baseDir := testBazelContext(t, map[bazelCommand]string{
+		bazelCommand{command: "query", expression: "//foo:found + //foo:missing"}:                        "//foo:found\n",
+		bazelCommand{command: "cquery", expression: "deps(@soong_injection//mixed_builds:buildroot, 2)"}: `//foo:found|arm64_armv8-a|android>>out/foo/found.txt`,
+	})
+	for _, label := range []string{"//foo:found", "//foo:missing"} {
+		if _, ok := bazelContext.LabelExists(label); ok {
+			t.Errorf("Did not expect result for %s prior to running InvokeBazel()", label)
+		}
+		bazelContext.GetOutputFiles(label, cfg)
+	}
+
+	// The cquery request for the missing label is dropped, so the cquery doesn't fail.
+	err := bazelContext.InvokeBazel()
+	if err != nil {
+		t.Fatalf("Did not expect error invoking Bazel, but got %s", err)
+	}
+	if exists, ok := bazelContext.LabelExists("//foo:found"); !ok || !exists {
+		t.Errorf("Expected //foo:found to exist, got exists: %t, ok: %t", exists, ok)
+	}
+	if exists, ok := bazelContext.LabelExists("//foo:missing"); !ok || exists {
+		t.Errorf("Expected //foo:missing not to exist, got exists: %t, ok: %t", exists, ok)
+	}
+
+	// The BUILD file is rewritten without the missing label once the query has run.
+	buildFile, err := ioutil.ReadFile(filepath.Join(baseDir, "soong_injection", "mixed_builds", "BUILD.bazel"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading BUILD.bazel %s", err)
+	}
+	if contents := string(buildFile); !strings.Contains(contents, "//foo:found") || strings.Contains(contents, "//foo:missing") {
+		t.Errorf("Expected BUILD.bazel to only request //foo:found, got %s", contents)
+	}
+}
+
 func TestInvokeBazelWritesBazelFiles(t *testing.T) {
 	bazelContext, baseDir := testBazelContext(t, map[bazelCommand]string{})
 	err := bazelContext.InvokeBazel()
@@ -111,8 +148,9 @@ func testBazelContext(t *testing.T, bazelCommandResults map[bazelCommand]string)
 	}
 	runner := &mockBazelRunner{bazelCommandResults: bazelCommandResults}
 	return &bazelContext{
-		bazelRunner: runner,
-		paths:       &p,
-		requests:    map[cqueryKey]bool{},
+		bazelRunner:   runner,
+		paths:         &p,
+		requests:      map[cqueryKey]bool{},
+		labelRequests: map[string]bool{},
 	}, p.soongOutDir
 }
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"android/soong/bazel"
 
@@ -239,6 +240,11 @@ func transformSubpackagePath(ctx BazelConversionPathContext, path bazel.Label) b
 		return newPath
 	}
 
+	if escapesModuleDir(path.Label) {
+		newPath.Label = labelForPathOutsideModuleDir(ctx, path.Label)
+		return newPath
+	}
+
 	newLabel := ""
 	pathComponents := strings.Split(path.Label, "/")
 	foundBlueprint := false
@@ -276,6 +282,80 @@ func transformSubpackagePath(ctx BazelConversionPathContext, path bazel.Label) b
 	return newPath
 }
 
+// escapesModuleDir returns true if the module-relative path refers to a file outside of the module
+// directory, e.g. "../common/foo.cpp".
+func escapesModuleDir(path string) bool {
+	cleaned := filepath.Clean(path)
+	return cleaned == ".." || strings.HasPrefix(cleaned, "../")
+}
+
+// labelForPathOutsideModuleDir returns the absolute label of a file referenced by a module-relative
+// path that escapes the module directory. The file belongs to the package of the closest enclosing
+// directory with an Android.bp file, which has to export it to make it visible to the module, so
+// the file is recorded in the files exported by that package. Paths that escape the source tree
+// are returned unchanged.
+func labelForPathOutsideModuleDir(ctx BazelConversionPathContext, path string) string {
+	rootRelative := filepath.Join(ctx.ModuleDir(), path)
+	if escapesModuleDir(rootRelative) {
+		return path
+	}
+
+	pkg := filepath.Dir(rootRelative)
+	for pkg != "." {
+		if exists, _, _ := ctx.Config().fs.Exists(filepath.Join(pkg, "Android.bp")); exists {
+			break
+		}
+		pkg = filepath.Dir(pkg)
+	}
+
+	file, _ := filepath.Rel(pkg, rootRelative)
+	if !ctx.Module().base().bp2buildTrial {
+		addBp2buildExportedFile(ctx.Config(), pkg, file)
+	}
+	if pkg == "." {
+		return "//:" + file
+	}
+	return "//" + pkg + ":" + file
+}
+
+var bp2buildExportedFilesKey = NewOnceKey("bp2buildExportedFiles")
+
+// bp2buildExportedFiles holds the files referenced by converted modules in packages other than
+// their own, keyed by the directory of the package that contains them.
+type bp2buildExportedFiles struct {
+	lock  sync.Mutex
+	files map[string]map[string]bool
+}
+
+func getBp2buildExportedFiles(config Config) *bp2buildExportedFiles {
+	return config.Once(bp2buildExportedFilesKey, func() interface{} {
+		return &bp2buildExportedFiles{files: make(map[string]map[string]bool)}
+	}).(*bp2buildExportedFiles)
+}
+
+func addBp2buildExportedFile(config Config, pkg, file string) {
+	exported := getBp2buildExportedFiles(config)
+	exported.lock.Lock()
+	defer exported.lock.Unlock()
+	if exported.files[pkg] == nil {
+		exported.files[pkg] = make(map[string]bool)
+	}
+	exported.files[pkg][file] = true
+}
+
+// Bp2buildExportedFiles returns the sorted files that each package must export because converted
+// modules in other packages reference them directly, keyed by package directory.
+func Bp2buildExportedFiles(config Config) map[string][]string {
+	exported := getBp2buildExportedFiles(config)
+	exported.lock.Lock()
+	defer exported.lock.Unlock()
+	ret := make(map[string][]string, len(exported.files))
+	for pkg, files := range exported.files {
+		ret[pkg] = SortedStringKeys(files)
+	}
+	return ret
+}
+
 // Transform paths to acknowledge package boundaries
 // See transformSubpackagePath() for more information
 func transformSubpackagePaths(ctx BazelConversionPathContext, paths bazel.LabelList) bazel.LabelList {
@@ -422,11 +502,41 @@ func samePackage(label1, label2 string) bool {
 }
 
 func bp2buildModuleLabel(ctx BazelConversionContext, module blueprint.Module) string {
-	moduleName := ctx.OtherModuleName(module)
+	moduleName := BazelTargetName(ctx.OtherModuleName(module))
 	moduleDir := ctx.OtherModuleDir(module)
+	if moduleDir == Bp2BuildTopLevel {
+		return fmt.Sprintf("//:%s", moduleName)
+	}
 	return fmt.Sprintf("//%s:%s", moduleDir, moduleName)
 }
 
+// isBazelTargetNameChar returns whether c is allowed in Bazel target names, see
+// https://bazel.build/concepts/labels#target-names.
+func isBazelTargetNameChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		strings.ContainsRune("!%-@^_\"#$&'()*+,;<=>?[]{|}~/.", c)
+}
+
+// BazelTargetName returns the name of the Bazel target generated for a Soong module or target
+// named name. Characters that Bazel rejects in target names, e.g. " " or ":", are replaced with
+// their hex code surrounded by underscores, so "foo bar" becomes "foo_20_bar". Names that Bazel
+// accepts, e.g. "libc++", are returned unchanged. Labels of dependencies must be built with the
+// same names.
+func BazelTargetName(name string) string {
+	if strings.IndexFunc(name, func(c rune) bool { return !isBazelTargetNameChar(c) }) == -1 {
+		return name
+	}
+	var sb strings.Builder
+	for _, c := range name {
+		if isBazelTargetNameChar(c) {
+			sb.WriteRune(c)
+		} else {
+			fmt.Fprintf(&sb, "_%x_", c)
+		}
+	}
+	return sb.String()
+}
+
 // BazelOutPath is a Bazel output path compatible to be used for mixed builds within Soong/Ninja.
 type BazelOutPath struct {
 	OutputPath
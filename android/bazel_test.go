@@ -156,9 +156,10 @@ func (m TestBazelModule) GenerateBuildActions(blueprint.ModuleContext) {
 }
 
 type TestBazelConversionContext struct {
-	omc       bazel.OtherModuleTestContext
-	allowlist bp2BuildConversionAllowlist
-	errors    []string
+	omc              bazel.OtherModuleTestContext
+	allowlist        bp2BuildConversionAllowlist
+	productVariables productVariables
+	errors           []string
 }
 
 var _ bazelOtherModuleContext = &TestBazelConversionContext{}
@@ -183,6 +184,7 @@ func (bcc *TestBazelConversionContext) Config() Config {
 	return Config{
 		&config{
 			bp2buildPackageConfig: bcc.allowlist,
+			productVariables:      bcc.productVariables,
 		},
 	}
 }
@@ -325,6 +327,93 @@ func TestBp2BuildAllowlist(t *testing.T) {
 				},
 			},
 		},
+		{
+			description:   "module in directory module names allowlist",
+			shouldConvert: true,
+			module: TestBazelModule{
+				TestModuleInfo: bazel.TestModuleInfo{
+					ModuleName: "foo",
+					Typ:        "rule1",
+					Dir:        "dir1",
+				},
+				BazelModuleBase: bazelableBazelModuleBase,
+			},
+			allowlist: NewBp2BuildAllowlist().
+				SetDefaultConfig(allowlists.Bp2BuildConfig{"dir1": allowlists.Bp2BuildDefaultFalse}).
+				SetDirectoryModuleNames(map[string][]string{"dir1": {"foo"}}),
+		},
+		{
+			description:   "module not in directory module names allowlist of enabled directory",
+			shouldConvert: false,
+			module: TestBazelModule{
+				TestModuleInfo: bazel.TestModuleInfo{
+					ModuleName: "bar",
+					Typ:        "rule1",
+					Dir:        "dir1",
+				},
+				BazelModuleBase: bazelableBazelModuleBase,
+			},
+			allowlist: NewBp2BuildAllowlist().
+				SetDefaultConfig(allowlists.Bp2BuildConfig{"dir1": allowlists.Bp2BuildDefaultTrueRecursively}).
+				SetDirectoryModuleNames(map[string][]string{"dir1": {"foo"}}),
+		},
+		{
+			description:   "directory module names allowlist is not recursive",
+			shouldConvert: true,
+			module: TestBazelModule{
+				TestModuleInfo: bazel.TestModuleInfo{
+					ModuleName: "bar",
+					Typ:        "rule1",
+					Dir:        "dir1/subdir",
+				},
+				BazelModuleBase: bazelableBazelModuleBase,
+			},
+			allowlist: NewBp2BuildAllowlist().
+				SetDefaultConfig(allowlists.Bp2BuildConfig{"dir1": allowlists.Bp2BuildDefaultTrueRecursively}).
+				SetDirectoryModuleNames(map[string][]string{"dir1": {"foo"}}),
+		},
+		{
+			description:   "module in directory module names allowlist opts out",
+			shouldConvert: false,
+			module: TestBazelModule{
+				TestModuleInfo: bazel.TestModuleInfo{
+					ModuleName: "foo",
+					Typ:        "rule1",
+					Dir:        "dir1",
+				},
+				BazelModuleBase: BazelModuleBase{
+					bazelProperties: properties{
+						Bazel_module: bazelModuleProperties{
+							CanConvertToBazel:  true,
+							Bp2build_available: proptools.BoolPtr(false),
+						},
+					},
+				},
+			},
+			allowlist: NewBp2BuildAllowlist().
+				SetDirectoryModuleNames(map[string][]string{"dir1": {"foo"}}),
+		},
+		{
+			description:   "module not in directory module names allowlist opts in",
+			shouldConvert: true,
+			module: TestBazelModule{
+				TestModuleInfo: bazel.TestModuleInfo{
+					ModuleName: "bar",
+					Typ:        "rule1",
+					Dir:        "dir1",
+				},
+				BazelModuleBase: BazelModuleBase{
+					bazelProperties: properties{
+						Bazel_module: bazelModuleProperties{
+							CanConvertToBazel:  true,
+							Bp2build_available: proptools.BoolPtr(true),
+						},
+					},
+				},
+			},
+			allowlist: NewBp2BuildAllowlist().
+				SetDirectoryModuleNames(map[string][]string{"dir1": {"foo"}}),
+		},
 		{
 			description:   "module enabled in unit test short-circuits other allowlists",
 			shouldConvert: true,
@@ -386,3 +475,439 @@ func TestBp2BuildAllowlist(t *testing.T) {
 		})
 	}
 }
+
+func TestBp2BuildAllowlistStarlark(t *testing.T) {
+	allowlist := NewBp2BuildAllowlist().
+		SetDefaultConfig(allowlists.Bp2BuildConfig{
+			"dir1":      allowlists.Bp2BuildDefaultTrueRecursively,
+			"dir2":      allowlists.Bp2BuildDefaultTrue,
+			"dir2/sub1": allowlists.Bp2BuildDefaultFalse,
+		}).
+		SetKeepExistingBuildFile(map[string]bool{"dir3": true}).
+		SetModuleAlwaysConvertList([]string{"foo"}).
+		SetDirectoryModuleNames(map[string][]string{"dir4": {"b", "a"}}).
+		SetModuleDoNotConvertList([]string{"qux", "bar"})
+
+	expected := `
+_bp2build_default_config = {
+    "dir1": "default_true_recursively",
+    "dir2": "default_true",
+    "dir2/sub1": "default_false",
+}
+
+_keep_existing_build_file = {
+    "dir3": True,
+}
+
+_module_always_convert = ["foo"]
+
+_module_type_always_convert = []
+
+_directory_module_names = {
+    "dir4": [
+        "a",
+        "b",
+    ],
+}
+
+_module_do_not_convert = [
+    "bar",
+    "qux",
+]
+
+_cc_library_static_only = []
+
+_mixed_builds_disabled = []
+
+bp2build_default_config = _bp2build_default_config
+keep_existing_build_file = _keep_existing_build_file
+module_always_convert = _module_always_convert
+module_type_always_convert = _module_type_always_convert
+directory_module_names = _directory_module_names
+module_do_not_convert = _module_do_not_convert
+cc_library_static_only = _cc_library_static_only
+mixed_builds_disabled = _mixed_builds_disabled
+`
+	AssertStringEquals(t, "allowlist starlark", expected, allowlist.starlarkString())
+
+	// Every entry of the real allowlist must be representable.
+	StarlarkBp2buildAllowlist()
+}
+
+func TestBp2buildDoNotConvertModules(t *testing.T) {
+	config := TestConfig(t.TempDir(), nil, "", nil)
+	// Use the shipped allowlist, like NewConfig.
+	config.bp2buildPackageConfig = bp2buildAllowlist
+
+	modules := config.Bp2buildDoNotConvertModules()
+	AssertArrayString(t, "do not convert modules",
+		SortedUniqueStrings(allowlists.Bp2buildModuleDoNotConvertList), modules)
+	AssertStringListContains(t, "do not convert modules", modules, "libsepol")
+	AssertStringListContains(t, "do not convert modules", modules, "linker")
+}
+
+func TestBp2buildDefaultConfigEntry(t *testing.T) {
+	config := TestConfig(t.TempDir(), nil, "", nil)
+	config.bp2buildPackageConfig = NewBp2BuildAllowlist().SetDefaultConfig(allowlists.Bp2BuildConfig{
+		"bionic":             allowlists.Bp2BuildDefaultTrueRecursively,
+		"bionic/libc/kernel": allowlists.Bp2BuildDefaultFalse,
+		"external/foo":       allowlists.Bp2BuildDefaultTrue,
+	})
+
+	testCases := []struct {
+		packagePath string
+		entry       string
+		ok          bool
+	}{
+		{packagePath: "bionic", entry: "bionic", ok: true},
+		{packagePath: "bionic/libc", entry: "bionic", ok: true},
+		{packagePath: "bionic/libc/tools", entry: "bionic", ok: true},
+		{packagePath: "bionic/libc/kernel", entry: "", ok: false},
+		{packagePath: "external/foo", entry: "external/foo", ok: true},
+		{packagePath: "external/foo/bar", entry: "", ok: false},
+		{packagePath: "external", entry: "", ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.packagePath, func(t *testing.T) {
+			entry, ok := config.Bp2buildDefaultConfigEntry(tc.packagePath)
+			AssertBoolEquals(t, "matched", tc.ok, ok)
+			AssertStringEquals(t, "entry", tc.entry, entry)
+		})
+	}
+}
+
+func TestShouldKeepExistingBuildFileForDir(t *testing.T) {
+	allowlist := NewBp2BuildAllowlist().
+		SetKeepExistingBuildFile(map[string]bool{
+			"a":       true,
+			"b":       false,
+			"b/c/d":   false,
+			"e/f":     true,
+			"e/f/g/h": false,
+		})
+
+	testCases := []struct {
+		dir  string
+		keep bool
+	}{
+		{dir: "a", keep: true},
+		{dir: "a/sub/dir", keep: true},
+		{dir: "ab", keep: false},
+		{dir: "b", keep: true},
+		{dir: "b/c", keep: false},
+		{dir: "b/c/d", keep: true},
+		{dir: "e", keep: false},
+		{dir: "e/f/g", keep: true},
+		{dir: "e/f/g/h/i", keep: true},
+		{dir: ".", keep: false},
+	}
+	for _, tc := range testCases {
+		AssertBoolEquals(t, tc.dir, tc.keep, shouldKeepExistingBuildFileForDir(allowlist, tc.dir))
+	}
+
+	root := NewBp2BuildAllowlist().SetKeepExistingBuildFile(map[string]bool{".": true})
+	AssertBoolEquals(t, "root kept recursively", true, shouldKeepExistingBuildFileForDir(root, "a/b"))
+}
+
+func TestEffectiveShouldWriteBuildFile(t *testing.T) {
+	// The directories are listed in allowlists.Bp2buildKeepExistingBuildFile.
+	testCases := []struct {
+		dir   string
+		write bool
+	}{
+		// build/bazel is listed, but not recursively.
+		{dir: "build/bazel", write: false},
+		{dir: "build/bazel/examples/apex/minimal", write: true},
+		// build/bazel/rules is listed recursively.
+		{dir: "build/bazel/rules", write: false},
+		{dir: "build/bazel/rules/apex", write: false},
+		{dir: "build/bazel_unlisted", write: true},
+	}
+	for _, tc := range testCases {
+		AssertBoolEquals(t, tc.dir, tc.write, EffectiveShouldWriteBuildFile(tc.dir))
+	}
+}
+
+func TestBp2BuildAvailableVariants(t *testing.T) {
+	module := TestBazelModule{
+		TestModuleInfo: bazel.TestModuleInfo{
+			ModuleName: "foo",
+			Typ:        "rule1",
+			Dir:        "dir1",
+		},
+		BazelModuleBase: bazelableBazelModuleBase,
+	}
+	module.bazelProperties.Bazel_module.Bp2build_available_variants = []string{"userdebug"}
+
+	testCases := []struct {
+		description   string
+		variables     productVariables
+		shouldConvert bool
+	}{
+		{
+			description:   "eng",
+			variables:     productVariables{Eng: boolPtr(true), Debuggable: boolPtr(true)},
+			shouldConvert: false,
+		},
+		{
+			description:   "userdebug",
+			variables:     productVariables{Debuggable: boolPtr(true)},
+			shouldConvert: true,
+		},
+		{
+			description:   "user",
+			shouldConvert: false,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.description, func(t *testing.T) {
+			bcc := &TestBazelConversionContext{
+				omc: bazel.OtherModuleTestContext{
+					Modules: []bazel.TestModuleInfo{module.TestModuleInfo},
+				},
+				allowlist: bp2BuildConversionAllowlist{
+					moduleAlwaysConvert: map[string]bool{"foo": true},
+				},
+				productVariables: test.variables,
+			}
+
+			shouldConvert := module.shouldConvertWithBp2build(bcc, module.TestModuleInfo)
+			if test.shouldConvert != shouldConvert {
+				t.Errorf("Module shouldConvert expected to be: %v, but was: %v", test.shouldConvert, shouldConvert)
+			}
+			if len(bcc.errors) > 0 {
+				t.Errorf("Expected no errors, but were: %v", bcc.errors)
+			}
+		})
+	}
+}
+
+func TestBazelTargetName(t *testing.T) {
+	testCases := []struct {
+		name, expected string
+	}{
+		{"libfoo", "libfoo"},
+		{"android.hardware.foo@1.0-impl_x", "android.hardware.foo@1.0-impl_x"},
+		{"libc++", "libc++"},
+		{"libfoo,bar=baz/qux", "libfoo,bar=baz/qux"},
+		{"foo bar", "foo_20_bar"},
+		{"foo:bar", "foo_3a_bar"},
+	}
+
+	for _, tc := range testCases {
+		AssertStringEquals(t, tc.name, tc.expected, BazelTargetName(tc.name))
+	}
+}
+
+func TestBp2buildConvertibleModuleTypes(t *testing.T) {
+	factories := map[string]ModuleFactory{
+		"test":      mutatorTestModuleFactory,
+		"filegroup": FileGroupFactory,
+	}
+	AssertArrayString(t, "convertible module types", []string{"filegroup"},
+		bp2buildConvertibleModuleTypes(factories))
+}
+
+func TestDiffBp2BuildConfig(t *testing.T) {
+	a := allowlists.Bp2BuildConfig{
+		"a/unchanged": allowlists.Bp2BuildDefaultTrue,
+		"b/removed":   allowlists.Bp2BuildDefaultFalse,
+		"c/changed":   allowlists.Bp2BuildDefaultTrue,
+	}
+	b := allowlists.Bp2BuildConfig{
+		"a/unchanged": allowlists.Bp2BuildDefaultTrue,
+		"c/changed":   allowlists.Bp2BuildDefaultTrueRecursively,
+		"d/added":     allowlists.Bp2BuildDefaultFalse,
+	}
+
+	AssertArrayString(t, "diff", []string{
+		`removed "b/removed": default_false`,
+		`changed "c/changed": default_true -> default_true_recursively`,
+		`added "d/added": default_false`,
+	}, DiffBp2BuildConfig(a, b))
+
+	AssertArrayString(t, "no diff", nil, DiffBp2BuildConfig(a, a))
+}
+
+type bazelConfigTestModule struct {
+	ModuleBase
+	DefaultableModuleBase
+	BazelModuleBase
+}
+
+func (m *bazelConfigTestModule) GenerateAndroidBuildActions(ModuleContext) {}
+
+func (m *bazelConfigTestModule) ConvertWithBp2build(TopDownMutatorContext) {}
+
+func bazelConfigTestModuleFactory() Module {
+	module := &bazelConfigTestModule{}
+	InitAndroidModule(module)
+	InitBazelModule(module)
+	InitDefaultableModule(module)
+	return module
+}
+
+func TestModulesWithConflictingBazelConfig(t *testing.T) {
+	result := GroupFixturePreparers(
+		prepareForDefaultsTest,
+		FixtureRegisterWithContext(func(ctx RegistrationContext) {
+			ctx.RegisterModuleType("bazel_config_test", bazelConfigTestModuleFactory)
+			ctx.PreArchMutators(registerBazelModuleConfigMutator)
+		}),
+	).RunTestWithBp(t, `
+		bazel_config_test {
+			name: "conflicting",
+			bazel_module: {
+				label: "//foo:conflicting",
+				bp2build_available: true,
+			},
+		}
+
+		defaults {
+			name: "available_defaults",
+			bazel_module: {
+				bp2build_available: true,
+			},
+		}
+
+		bazel_config_test {
+			name: "conflicting_with_defaults",
+			defaults: ["available_defaults"],
+			bazel_module: {
+				label: "//foo:conflicting_with_defaults",
+			},
+		}
+
+		bazel_config_test {
+			name: "handcrafted",
+			bazel_module: {
+				label: "//foo:handcrafted",
+			},
+		}
+
+		bazel_config_test {
+			name: "generated",
+			defaults: ["available_defaults"],
+		}
+	`)
+
+	AssertArrayString(t, "modules with conflicting bazel config",
+		[]string{"conflicting", "conflicting_with_defaults"},
+		result.Config.ModulesWithConflictingBazelConfig())
+}
+
+func TestBp2BuildAvailableVariantsUnknown(t *testing.T) {
+	GroupFixturePreparers(
+		prepareForDefaultsTest,
+		FixtureRegisterWithContext(func(ctx RegistrationContext) {
+			ctx.RegisterModuleType("bazel_config_test", bazelConfigTestModuleFactory)
+			ctx.PreArchMutators(registerBazelModuleConfigMutator)
+		}),
+	).ExtendWithErrorHandler(FixtureExpectsAllErrorsToMatchAPattern([]string{
+		`module "foo": bazel_module.bp2build_available_variants: unknown build variant "debug"`,
+	})).RunTestWithBp(t, `
+		bazel_config_test {
+			name: "foo",
+			bazel_module: {
+				bp2build_available_variants: ["userdebug", "debug"],
+			},
+		}
+	`)
+}
+
+func TestMixedBuildDisabledProperty(t *testing.T) {
+	bp := `
+		filegroup {
+			name: "enabled",
+			srcs: ["a"],
+			bazel_module: { bp2build_available: true },
+		}
+
+		filegroup {
+			name: "disabled",
+			srcs: ["b"],
+			bazel_module: {
+				bp2build_available: true,
+				mixed_build_disabled: true,
+			},
+		}
+	`
+	result := GroupFixturePreparers(
+		PrepareForTestWithFilegroup,
+		FixtureModifyConfig(func(config Config) {
+			config.BazelContext = MockBazelContext{
+				OutputBaseDir: "outputbase",
+				LabelToOutputFiles: map[string][]string{
+					"//:enabled":  []string{"bazel_a"},
+					"//:disabled": []string{"bazel_b"},
+				},
+			}
+		}),
+	).RunTestWithBp(t, bp)
+
+	enabled := result.Module("enabled", "").(*fileGroup)
+	AssertPathsRelativeToTopEquals(t, "enabled srcs", []string{"outputbase/execroot/__main__/bazel_a"}, enabled.Srcs())
+
+	disabled := result.Module("disabled", "").(*fileGroup)
+	AssertPathsRelativeToTopEquals(t, "disabled srcs", []string{"b"}, disabled.Srcs())
+
+	// The property doesn't prevent bp2build from converting the module.
+	module := TestBazelModule{
+		TestModuleInfo: bazel.TestModuleInfo{
+			ModuleName: "foo",
+			Typ:        "rule1",
+			Dir:        "dir1",
+		},
+		BazelModuleBase: bazelableBazelModuleBase,
+	}
+	module.bazelProperties.Bazel_module.Mixed_build_disabled = boolPtr(true)
+	bcc := &TestBazelConversionContext{
+		omc: bazel.OtherModuleTestContext{
+			Modules: []bazel.TestModuleInfo{module.TestModuleInfo},
+		},
+		allowlist: bp2BuildConversionAllowlist{
+			moduleAlwaysConvert: map[string]bool{"foo": true},
+		},
+	}
+	AssertBoolEquals(t, "converted with mixed_build_disabled", true,
+		module.shouldConvertWithBp2build(bcc, module.TestModuleInfo))
+}
+
+func TestValidateHandcraftedBazelLabel(t *testing.T) {
+	bp := `
+		filegroup {
+			name: "found",
+			srcs: ["a"],
+			bazel_module: { label: "//foo:found" },
+		}
+
+		filegroup {
+			name: "missing",
+			srcs: ["b"],
+			bazel_module: { label: "//foo:missing" },
+		}
+	`
+	bazelContext := FixtureModifyConfig(func(config Config) {
+		config.BazelContext = MockBazelContext{
+			LabelToExists: map[string]bool{
+				"//foo:found":   true,
+				"//foo:missing": false,
+			},
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		GroupFixturePreparers(
+			PrepareForTestWithFilegroup,
+			bazelContext,
+		).ExtendWithErrorHandler(FixtureExpectsAllErrorsToMatchAPattern([]string{
+			`module "missing": bazel_module.label: "//foo:missing" doesn't exist in the Bazel workspace`,
+		})).RunTestWithBp(t, bp)
+	})
+
+	t.Run("skipped without bazel", func(t *testing.T) {
+		PrepareForTestWithFilegroup.RunTestWithBp(t, bp)
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"github.com/google/blueprint"
+)
+
+const (
+	// Bp2buildPackageClassificationManifest is the checked-in manifest of the expected
+	// classification of each package of the bp2build workspace as handcrafted, generated or merged.
+	Bp2buildPackageClassificationManifest = "build/bazel/bp2build_package_classification.txt"
+
+	// Bp2buildPackageClassificationFile is the file, relative to the Soong output directory, that
+	// bp2build writes the actual classification of each package to.
+	Bp2buildPackageClassificationFile = "bp2build_package_classification.txt"
+)
+
+var (
+	_ = pctx.HostBinToolVariable("bp2buildPackageCheckCmd", "bp2build_package_check")
+
+	bp2buildPackageClassificationCheckRule = pctx.AndroidStaticRule("bp2buildPackageClassificationCheck",
+		blueprint.RuleParams{
+			Command:     "${bp2buildPackageCheckCmd} --manifest ${manifest} --actual $in && touch $out",
+			CommandDeps: []string{"${bp2buildPackageCheckCmd}"},
+		}, "manifest")
+)
+
+func init() {
+	RegisterSingletonType("bp2build_package_classification_check", bp2buildPackageClassificationCheckFactory)
+}
+
+func bp2buildPackageClassificationCheckFactory() Singleton {
+	return &bp2buildPackageClassificationCheckSingleton{}
+}
+
+// bp2buildPackageClassificationCheckSingleton creates the bp2build_package_classification_check
+// target, which fails if the classification of a package written by the last bp2build run differs
+// from the checked-in manifest, e.g. because a handcrafted BUILD file was deleted and bp2build
+// started generating one. It is only created if the manifest exists.
+type bp2buildPackageClassificationCheckSingleton struct{}
+
+func (s *bp2buildPackageClassificationCheckSingleton) GenerateBuildActions(ctx SingletonContext) {
+	manifest := ExistentPathForSource(ctx, Bp2buildPackageClassificationManifest)
+	if !manifest.Valid() {
+		return
+	}
+
+	stamp := PathForOutput(ctx, "bp2build_package_classification.stamp")
+	ctx.Build(pctx, BuildParams{
+		Rule:        bp2buildPackageClassificationCheckRule,
+		Description: "check bp2build package classification",
+		Input:       PathForOutput(ctx, Bp2buildPackageClassificationFile),
+		Implicit:    manifest.Path(),
+		Output:      stamp,
+		Args: map[string]string{
+			"manifest": manifest.String(),
+		},
+	})
+	ctx.Phony("bp2build_package_classification_check", stamp)
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+func TestBp2buildPackageClassificationCheck(t *testing.T) {
+	prepare := GroupFixturePreparers(
+		FixtureRegisterWithContext(func(ctx RegistrationContext) {
+			ctx.RegisterSingletonType("bp2build_package_classification_check", bp2buildPackageClassificationCheckFactory)
+		}),
+	)
+
+	result := GroupFixturePreparers(
+		prepare,
+		FixtureAddTextFile(Bp2buildPackageClassificationManifest, "a generated\n"),
+	).RunTest(t)
+
+	check := result.SingletonForTests("bp2build_package_classification_check").
+		Output("out/soong/bp2build_package_classification.stamp")
+	AssertPathRelativeToTopEquals(t, "input", "out/soong/bp2build_package_classification.txt", check.Input)
+	AssertStringEquals(t, "manifest", Bp2buildPackageClassificationManifest, check.Args["manifest"])
+
+	t.Run("without manifest", func(t *testing.T) {
+		result := prepare.RunTest(t)
+		singleton := result.SingletonForTests("bp2build_package_classification_check")
+		AssertIntEquals(t, "outputs", 0, len(singleton.AllOutputs()))
+	})
+}
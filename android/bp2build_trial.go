@@ -0,0 +1,227 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android/allowlists"
+	"android/soong/bazel"
+)
+
+// Bp2buildTrialInfo is the result of converting a module of the bp2build trial directory as if
+// the directory was marked Bp2BuildDefaultTrue, see Config.Bp2buildTrialDir. The targets the trial
+// conversion creates are discarded, it doesn't change the generated BUILD files.
+type Bp2buildTrialInfo struct {
+	// Whether the module is converted by the current allowlist.
+	AlreadyConverted bool
+	// The names of the targets the module is converted to.
+	Targets []string
+	// The modules the converted targets depend on that are not converted.
+	UnconvertedDeps []string
+	// The modules the converted targets depend on that don't exist.
+	MissingDeps []string
+	// The required modules that are not converted or don't exist, which prevent the generated
+	// targets from being used.
+	UnconvertedRequiredDeps []string
+	// Why the module can't be converted, or empty if the converter ran.
+	Blocked string
+}
+
+var Bp2buildTrialInfoProvider = blueprint.NewMutatorProvider(Bp2buildTrialInfo{}, "bp2build_conversion")
+
+var bp2buildTrialAllowlistKey = NewOnceKey("bp2buildTrialAllowlist")
+
+// bp2buildTrialAllowlist returns a copy of the allowlist of the config with the bp2build trial
+// directory marked Bp2BuildDefaultTrue, and without its allowlist of module names if it has one.
+func bp2buildTrialAllowlist(config Config) bp2BuildConversionAllowlist {
+	return config.Once(bp2buildTrialAllowlistKey, func() interface{} {
+		allowlist := config.bp2buildPackageConfig
+		allowlist.defaultConfig = make(allowlists.Bp2BuildConfig, len(allowlist.defaultConfig)+1)
+		for dir, entry := range config.bp2buildPackageConfig.defaultConfig {
+			allowlist.defaultConfig[dir] = entry
+		}
+		allowlist.defaultConfig[config.Bp2buildTrialDir()] = allowlists.Bp2BuildDefaultTrue
+		allowlist.directoryModuleNames = make(map[string]map[string]bool, len(allowlist.directoryModuleNames))
+		for dir, names := range config.bp2buildPackageConfig.directoryModuleNames {
+			if dir != config.Bp2buildTrialDir() {
+				allowlist.directoryModuleNames[dir] = names
+			}
+		}
+		return allowlist
+	}).(bp2BuildConversionAllowlist)
+}
+
+// trialConvertWithBp2build converts a module of the bp2build trial directory with the trial
+// allowlist, and discards the targets and the dependencies the conversion recorded. The converter
+// runs with a context that records its errors, and the modules it reports errors for or that try to
+// create or rename modules are reported as blocked instead of failing the build.
+func trialConvertWithBp2build(ctx TopDownMutatorContext, b Bazelable) Bp2buildTrialInfo {
+	module := ctx.Module()
+	base := module.base()
+
+	if b.HasHandcraftedLabel() {
+		return Bp2buildTrialInfo{AlreadyConverted: true, Targets: []string{b.HandcraftedLabel()}}
+	}
+	if base.IsConvertedByBp2build() {
+		return Bp2buildTrialInfo{
+			AlreadyConverted: true,
+			Targets:          bp2buildTargetNames(base.Bp2buildTargets()),
+			UnconvertedDeps:  base.GetUnconvertedBp2buildDeps(),
+			MissingDeps:      base.GetMissingBp2buildDeps(),
+
+			UnconvertedRequiredDeps: base.GetUnconvertedBp2buildRequiredDeps(),
+		}
+	}
+
+	if !b.bazelProps().Bazel_module.CanConvertToBazel {
+		return Bp2buildTrialInfo{Blocked: fmt.Sprintf("module type %q has no bp2build converter", ctx.ModuleType())}
+	}
+	allowlist := bp2buildTrialAllowlist(ctx.Config())
+	if allowlist.moduleDoNotConvert[ctx.ModuleName()] {
+		return Bp2buildTrialInfo{Blocked: "the module is listed in moduleDoNotConvert"}
+	}
+	if !b.shouldConvertWithBp2buildAllowlist(ctx, module, allowlist) {
+		return Bp2buildTrialInfo{Blocked: "the module disables bp2build with its bazel_module properties"}
+	}
+
+	props := &base.commonProperties
+	numTargets := len(props.Bp2buildInfo)
+	numUnconvertedDeps := len(props.UnconvertedBp2buildDeps)
+	numMissingDeps := len(props.MissingBp2buildDeps)
+	numUnconvertedRequiredDeps := len(props.UnconvertedBp2buildRequiredDeps)
+
+	errs := &bp2buildTrialErrors{}
+	base.bp2buildTrial = true
+	blocked := runBp2buildTrialConverter(newBp2buildTrialContext(ctx, errs), b)
+	base.bp2buildTrial = false
+
+	info := Bp2buildTrialInfo{
+		Targets:         bp2buildTargetNames(props.Bp2buildInfo[numTargets:]),
+		UnconvertedDeps: FirstUniqueStrings(props.UnconvertedBp2buildDeps[numUnconvertedDeps:]),
+		MissingDeps:     FirstUniqueStrings(props.MissingBp2buildDeps[numMissingDeps:]),
+
+		UnconvertedRequiredDeps: FirstUniqueStrings(
+			props.UnconvertedBp2buildRequiredDeps[numUnconvertedRequiredDeps:]),
+	}
+	if blocked != "" {
+		info.Blocked = blocked
+	} else if len(errs.errs) > 0 {
+		info.Blocked = "the converter reported errors: " + strings.Join(errs.errs, "; ")
+	} else if len(info.Targets) == 0 {
+		info.Blocked = "the converter created no targets"
+	}
+
+	props.Bp2buildInfo = props.Bp2buildInfo[:numTargets]
+	props.UnconvertedBp2buildDeps = props.UnconvertedBp2buildDeps[:numUnconvertedDeps]
+	props.MissingBp2buildDeps = props.MissingBp2buildDeps[:numMissingDeps]
+	props.UnconvertedBp2buildRequiredDeps = props.UnconvertedBp2buildRequiredDeps[:numUnconvertedRequiredDeps]
+
+	return info
+}
+
+func bp2buildTargetNames(targets []bp2buildInfo) []string {
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.TargetName())
+	}
+	return names
+}
+
+// runBp2buildTrialConverter runs the converter of b with the trial context ctx, and returns why the
+// module is blocked if the converter tried to do something the trial can't discard, or an empty
+// string. Other panics are not recovered, they are bugs in the converter.
+func runBp2buildTrialConverter(ctx TopDownMutatorContext, b Bazelable) (blocked string) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(errBp2buildTrialSideEffect)
+			if !ok {
+				panic(r)
+			}
+			blocked = string(err)
+		}
+	}()
+	b.ConvertWithBp2build(ctx)
+	return ""
+}
+
+// errBp2buildTrialSideEffect is panicked with by the trial context when the converter does
+// something that can't be discarded after the trial conversion.
+type errBp2buildTrialSideEffect string
+
+// bp2buildTrialErrors records the errors reported by a converter during the trial conversion.
+type bp2buildTrialErrors struct {
+	errs []string
+}
+
+func (e *bp2buildTrialErrors) add(format string, args ...interface{}) {
+	e.errs = append(e.errs, fmt.Sprintf(format, args...))
+}
+
+// bp2buildTrialContext wraps the context of the trial conversion. It records the errors reported
+// through it instead of failing the build, and panics with errBp2buildTrialSideEffect when the
+// converter creates or renames modules.
+type bp2buildTrialContext struct {
+	TopDownMutatorContext
+	errs *bp2buildTrialErrors
+}
+
+func newBp2buildTrialContext(ctx TopDownMutatorContext, errs *bp2buildTrialErrors) TopDownMutatorContext {
+	return bp2buildTrialContext{ctx, errs}
+}
+
+func (c bp2buildTrialContext) Errorf(pos scanner.Position, format string, args ...interface{}) {
+	c.errs.add(format, args...)
+}
+
+func (c bp2buildTrialContext) ModuleErrorf(format string, args ...interface{}) {
+	c.errs.add(format, args...)
+}
+
+func (c bp2buildTrialContext) PropertyErrorf(property, format string, args ...interface{}) {
+	c.errs.add(property+": "+format, args...)
+}
+
+func (c bp2buildTrialContext) OtherModuleErrorf(m blueprint.Module, format string, args ...interface{}) {
+	c.errs.add(c.OtherModuleName(m)+": "+format, args...)
+}
+
+func (c bp2buildTrialContext) Failed() bool {
+	return len(c.errs.errs) > 0 || c.TopDownMutatorContext.Failed()
+}
+
+// CreateBazelTargetModule and CreateBazelTargetModuleWithRestrictions are forwarded with the trial
+// context, so that the errors reported while filling the common attributes are recorded too.
+func (c bp2buildTrialContext) CreateBazelTargetModule(bazelProps bazel.BazelTargetModuleProperties,
+	commonAttrs CommonAttributes, attrs interface{}) {
+	createBazelTargetModule(c, bazelProps, commonAttrs, attrs, bazel.BoolAttribute{})
+}
+
+func (c bp2buildTrialContext) CreateBazelTargetModuleWithRestrictions(bazelProps bazel.BazelTargetModuleProperties,
+	commonAttrs CommonAttributes, attrs interface{}, enabledProperty bazel.BoolAttribute) {
+	createBazelTargetModule(c, bazelProps, commonAttrs, attrs, enabledProperty)
+}
+
+func (c bp2buildTrialContext) CreateModule(ModuleFactory, ...interface{}) Module {
+	panic(errBp2buildTrialSideEffect("the converter creates modules, which can't be discarded after the trial"))
+}
+
+func (c bp2buildTrialContext) Rename(string) {
+	panic(errBp2buildTrialSideEffect("the converter renames the module, which can't be undone after the trial"))
+}
@@ -169,6 +169,11 @@ type config struct {
 	// regenerate build.ninja.
 	ninjaFileDepsSet sync.Map
 
+	// The sanitizers enabled by any module in the build, recorded by the active_sanitizers
+	// singleton after all modules have been analyzed.
+	activeSanitizers    []string
+	activeSanitizersSet bool
+
 	OncePer
 }
 
@@ -932,6 +937,20 @@ func (c *config) Eng() bool {
 	return Bool(c.productVariables.Eng)
 }
 
+// buildVariants are the values of TARGET_BUILD_VARIANT.
+var buildVariants = []string{"eng", "userdebug", "user"}
+
+// BuildVariant returns the TARGET_BUILD_VARIANT the product is built with: "eng", "userdebug"
+// or "user".
+func (c *config) BuildVariant() string {
+	if c.Eng() {
+		return "eng"
+	} else if c.Debuggable() {
+		return "userdebug"
+	}
+	return "user"
+}
+
 // DevicePrimaryArchType returns the ArchType for the first configured device architecture, or
 // Common if there are no device architectures.
 func (c *config) DevicePrimaryArchType() ArchType {
@@ -957,6 +976,62 @@ func (c *config) SanitizeDeviceArch() []string {
 	return append([]string(nil), c.productVariables.SanitizeDeviceArch...)
 }
 
+// SanitizeVendorImage returns the sanitizers to enable globally for the vendor and product image
+// variants of modules, in addition to SanitizeDevice.
+func (c *config) SanitizeVendorImage() []string {
+	return append([]string(nil), c.productVariables.SanitizeVendorImage...)
+}
+
+// SanitizeBootCriticalModules returns the modules the product adds to the built-in set of
+// boot-critical modules that global device sanitization skips.
+func (c *config) SanitizeBootCriticalModules() []string {
+	return append([]string(nil), c.productVariables.SanitizeBootCriticalModules...)
+}
+
+type sanitizeModules struct {
+	modules map[string][]string
+	err     error
+}
+
+var sanitizeModulesKey = NewOnceKey("sanitizeModules")
+
+// SanitizeModules returns the sanitizers to enable for each module listed in the JSON file named
+// by the SANITIZE_MODULES_FILE environment variable, e.g. {"libfoo": ["address"]}, which lets CI
+// sanitize specific modules without modifying their Android.bp files. The sanitizers are enabled
+// like the global sanitizers in SANITIZE_TARGET and SANITIZE_HOST, so they don't override the
+// sanitize properties set by the module. It returns nil if the variable isn't set or the file
+// can't be loaded, see SanitizeModulesFile.
+func (c *config) SanitizeModules() map[string][]string {
+	return c.loadSanitizeModules().modules
+}
+
+// SanitizeModulesFile returns the path of the SANITIZE_MODULES_FILE, or an empty string if it isn't
+// set, and the error encountered loading it. The file is only loaded once, and the error must be
+// reported once for the whole build rather than by every module reading SanitizeModules.
+func (c *config) SanitizeModulesFile() (string, error) {
+	return c.Getenv("SANITIZE_MODULES_FILE"), c.loadSanitizeModules().err
+}
+
+func (c *config) loadSanitizeModules() sanitizeModules {
+	return c.Once(sanitizeModulesKey, func() interface{} {
+		file := c.Getenv("SANITIZE_MODULES_FILE")
+		if file == "" {
+			return sanitizeModules{}
+		}
+		r, err := c.fs.Open(file)
+		if err != nil {
+			return sanitizeModules{err: fmt.Errorf("failed to open SANITIZE_MODULES_FILE %q: %s", file, err)}
+		}
+		defer r.Close()
+
+		var modules map[string][]string
+		if err := json.NewDecoder(r).Decode(&modules); err != nil {
+			return sanitizeModules{err: fmt.Errorf("failed to parse SANITIZE_MODULES_FILE %q: %s", file, err)}
+		}
+		return sanitizeModules{modules: modules}
+	}).(sanitizeModules)
+}
+
 func (c *config) EnableCFI() bool {
 	if c.productVariables.EnableCFI == nil {
 		return true
@@ -968,6 +1043,79 @@ func (c *config) DisableScudo() bool {
 	return Bool(c.productVariables.DisableScudo)
 }
 
+// SeparateSanitizerOutputs returns true if the outputs of sanitizer variants should be placed
+// under $OUT/soong/sanitized instead of alongside the outputs of the other variants.
+func (c *config) SeparateSanitizerOutputs() bool {
+	return Bool(c.productVariables.SeparateSanitizerOutputs)
+}
+
+// ExcludeSanitizerVariantsFromPackaging returns true if the files installed by sanitizer variants
+// should be left out of the packages that depend on them, e.g. for production images, while test
+// images keep them.
+func (c *config) ExcludeSanitizerVariantsFromPackaging() bool {
+	return Bool(c.productVariables.ExcludeSanitizerVariantsFromPackaging)
+}
+
+// SanitizerRuntimesExcludeLibs returns the basenames of static runtime libraries whose symbols
+// should be hidden with -Wl,--exclude-libs in every module that links them.
+func (c *config) SanitizerRuntimesExcludeLibs() []string {
+	return c.productVariables.SanitizerRuntimesExcludeLibs
+}
+
+// SanitizerRuntimeOverride returns the runtime library module to use instead of the default one
+// for the given sanitizer, e.g. "address", on the given architecture, e.g. "arm". The overrides are
+// keyed by "<sanitizer>:<arch>" in the SanitizerRuntimeOverrides product variable.
+func (c *config) SanitizerRuntimeOverride(sanitizer, arch string) (string, bool) {
+	if sanitizer == "" {
+		return "", false
+	}
+	runtime, ok := c.productVariables.SanitizerRuntimeOverrides[sanitizer+":"+arch]
+	return runtime, ok
+}
+
+// SanitizerRuntimesFromSource returns true if the sanitizer runtime libraries should be built from
+// the compiler-rt sources in the tree instead of using the prebuilts of the toolchain, e.g. to test
+// changes to compiler-rt. Set with the SanitizerRuntimesFromSource product variable or
+// SANITIZER_RUNTIMES_FROM_SOURCE=true.
+func (c *config) SanitizerRuntimesFromSource() bool {
+	return Bool(c.productVariables.SanitizerRuntimesFromSource) ||
+		c.IsEnvTrue("SANITIZER_RUNTIMES_FROM_SOURCE")
+}
+
+// FuzzerDeviceSanitizer returns the sanitizer, address or hwaddress, that the device variants of
+// cc_fuzz modules are built with unless they set either explicitly, or an empty string if they
+// only use the globally enabled sanitizers.
+func (c *config) FuzzerDeviceSanitizer() string {
+	return String(c.productVariables.FuzzerDeviceSanitizer)
+}
+
+// ThinLTOCacheDir returns the ThinLTO cache directory shared by all modules and variants, or an
+// empty string if each build should use the default cache location.
+func (c *config) ThinLTOCacheDir() string {
+	return String(c.productVariables.ThinLtoCacheDir)
+}
+
+// ActiveSanitizers returns the sorted names of the sanitizers enabled by any module in the build,
+// either by the module itself or by global settings like SANITIZE_TARGET. They are recorded by the
+// active_sanitizers singleton, so only singletons registered after it see them; the second return
+// value is false when they haven't been recorded yet, e.g. when called from a module.
+func (c *config) ActiveSanitizers() ([]string, bool) {
+	return c.activeSanitizers, c.activeSanitizersSet
+}
+
+// SetActiveSanitizers records the sanitizers enabled by any module in the build, see
+// ActiveSanitizers. It is called by the active_sanitizers singleton.
+func (c *config) SetActiveSanitizers(sanitizers []string) {
+	c.activeSanitizers = sanitizers
+	c.activeSanitizersSet = true
+}
+
+// CompilerFlagsReport returns true if the categorized compiler flags of every cc module variant
+// should be written to a JSON report.
+func (c *config) CompilerFlagsReport() bool {
+	return Bool(c.productVariables.CompilerFlagsReport)
+}
+
 func (c *config) Android64() bool {
 	for _, t := range c.Targets[Android] {
 		if t.Arch.ArchType.Multilib == "lib64" {
@@ -1155,6 +1303,49 @@ func (c *config) PrebuiltHiddenApiDir(ctx PathContext) string {
 	return String(c.productVariables.PrebuiltHiddenApiDir)
 }
 
+// ModulesWithConflictingBazelConfig returns the sorted names of the modules that set both
+// bazel_module.label and bazel_module.bp2build_available: true, which converts them to a generated
+// target that may conflict with the handcrafted one, either directly or through their defaults. It
+// is only complete once the mutators that run after the defaults mutators have started.
+func (c Config) ModulesWithConflictingBazelConfig() []string {
+	conflicting := getModulesWithConflictingBazelConfig(c)
+	conflicting.lock.Lock()
+	defer conflicting.lock.Unlock()
+	return SortedStringKeys(conflicting.modules)
+}
+
+// Bp2buildTrialDir returns the directory whose modules bp2build converts in a trial run, as if the
+// directory was marked Bp2BuildDefaultTrue in the allowlist, to report whether it can be added to
+// the allowlist. It returns an empty string if no directory is set.
+func (c *config) Bp2buildTrialDir() string {
+	return String(c.productVariables.Bp2buildTrialDir)
+}
+
+// Bp2buildDoNotConvertModules returns the sorted names of the modules that bp2build never converts,
+// see Bp2buildModuleDoNotConvertList in android/allowlists/allowlists.go. The entries are exact
+// module names, not patterns.
+func (c *config) Bp2buildDoNotConvertModules() []string {
+	return SortedStringKeys(c.bp2buildPackageConfig.moduleDoNotConvert)
+}
+
+// Bp2buildDefaultConfigEntry returns the entry of Bp2buildDefaultConfig in
+// android/allowlists/allowlists.go that makes bp2build convert the modules of the package by
+// default, e.g. "bionic" for "bionic/libc" if "bionic" is marked Bp2BuildDefaultTrueRecursively.
+// It returns false if no entry does, including if the package is marked Bp2BuildDefaultFalse.
+func (c *config) Bp2buildDefaultConfigEntry(packagePath string) (string, bool) {
+	if ok, entry := bp2buildDefaultTrueRecursively(packagePath, c.bp2buildPackageConfig.defaultConfig); ok {
+		return entry, true
+	}
+	return "", false
+}
+
+// Bp2buildKeepExistingBuildFile returns true if the existing BUILD file of dir is used instead of a
+// generated one, because dir or one of its ancestors is listed recursively in
+// Bp2buildKeepExistingBuildFile in android/allowlists/allowlists.go.
+func (c *config) Bp2buildKeepExistingBuildFile(dir string) bool {
+	return shouldKeepExistingBuildFileForDir(c.bp2buildPackageConfig, dir)
+}
+
 func (c *deviceConfig) Arches() []Arch {
 	var arches []Arch
 	for _, target := range c.config.Targets[Android] {
@@ -1418,6 +1609,15 @@ func (c *config) CFIEnabledForPath(path string) bool {
 	return HasAnyPrefix(path, c.productVariables.CFIIncludePaths) && !c.CFIDisabledForPath(path)
 }
 
+// SanitizeNeverRequiresReasonForPath returns true if modules in path that disable sanitizers with
+// sanitize.never must explain why in sanitize.never_reason.
+func (c *config) SanitizeNeverRequiresReasonForPath(path string) bool {
+	if len(c.productVariables.SanitizeNeverRequiresReasonPaths) == 0 {
+		return false
+	}
+	return HasAnyPrefix(path, c.productVariables.SanitizeNeverRequiresReasonPaths)
+}
+
 func (c *config) MemtagHeapDisabledForPath(path string) bool {
 	if len(c.productVariables.MemtagHeapExcludePaths) == 0 {
 		return false
@@ -1439,6 +1639,20 @@ func (c *config) MemtagHeapSyncEnabledForPath(path string) bool {
 	return HasAnyPrefix(path, c.productVariables.MemtagHeapSyncIncludePaths) && !c.MemtagHeapDisabledForPath(path)
 }
 
+func (c *config) MemtagHeapAsyncIncludePaths() []string {
+	return c.productVariables.MemtagHeapAsyncIncludePaths
+}
+
+func (c *config) MemtagHeapSyncIncludePaths() []string {
+	return c.productVariables.MemtagHeapSyncIncludePaths
+}
+
+// MemtagHeapDisableAllowedForModule returns true if the module may set memtag_heap: false inside
+// a MemtagHeapSyncIncludePaths or MemtagHeapAsyncIncludePaths directory.
+func (c *config) MemtagHeapDisableAllowedForModule(name string) bool {
+	return InList(name, c.productVariables.MemtagHeapDisableAllowlist)
+}
+
 func (c *config) VendorConfig(name string) VendorConfig {
 	return soongconfig.Config(c.productVariables.VendorVars[name])
 }
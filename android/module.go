@@ -326,6 +326,10 @@ type BaseModuleContext interface {
 	// AddMissingBp2buildDep stores the module name of a direct dependency that was not found.
 	AddMissingBp2buildDep(dep string)
 
+	// AddUnconvertedBp2buildRequiredDep stores the name of a required module that was not converted
+	// to Bazel or not found.
+	AddUnconvertedBp2buildRequiredDep(dep string)
+
 	Target() Target
 	TargetPrimary() bool
 
@@ -527,6 +531,7 @@ type Module interface {
 	Bp2buildTargets() []bp2buildInfo
 	GetUnconvertedBp2buildDeps() []string
 	GetMissingBp2buildDeps() []string
+	GetUnconvertedBp2buildRequiredDeps() []string
 
 	BuildParamsForTests() []BuildParams
 	RuleParamsForTests() map[blueprint.Rule]blueprint.RuleParams
@@ -626,6 +631,31 @@ type Dist struct {
 	// default output files provided by the modules, i.e. the result of calling
 	// OutputFiles("").
 	Tag *string `android:"arch_variant"`
+
+	// The name of the variant of the module whose output files are copied, e.g. "hwasan" for the
+	// hwasan sanitizer variant of a cc module. Defaults to the variant installed by default. Only
+	// supported by the module types that implement DistVariantModule.
+	Variant *string `android:"arch_variant"`
+}
+
+// DistVariantModule is implemented by the module types whose variants can be named by the variant
+// property of the dist entries, see Dist.Variant.
+type DistVariantModule interface {
+	// DistVariants returns the names of the variants of the module created in this build that
+	// dist entries can name, and the names of this variant, which include "" if it is the variant
+	// installed by default.
+	DistVariants() (available []string, names []string)
+}
+
+// distVariantMatches returns true if the dist entry copies the output files of this variant of
+// the module.
+func distVariantMatches(module blueprint.Module, dist Dist) bool {
+	variant := proptools.String(dist.Variant)
+	if m, ok := module.(DistVariantModule); ok {
+		_, names := m.DistVariants()
+		return InList(variant, names)
+	}
+	return variant == ""
 }
 
 // NamedPath associates a path with a name. e.g. a license text path with a package name
@@ -896,6 +926,10 @@ type commonProperties struct {
 	// Whether the module has been replaced by a prebuilt
 	ReplacedByPrebuilt bool `blueprint:"mutated"`
 
+	// The directory under the soong output directory that the outputs of this variant are placed
+	// in, see ModuleBase.SetOutputSubdir.
+	OutputSubdir string `blueprint:"mutated"`
+
 	// Disabled by mutators. If set to true, it overrides Enabled property.
 	ForcedDisabled bool `blueprint:"mutated"`
 
@@ -924,6 +958,10 @@ type commonProperties struct {
 
 	// MissingBp2buildDep stores the module names of direct dependency that were not found
 	MissingBp2buildDeps []string `blueprint:"mutated"`
+
+	// UnconvertedBp2buildRequiredDeps stores the names of the modules in required that were not
+	// converted to Bazel or not found
+	UnconvertedBp2buildRequiredDeps []string `blueprint:"mutated"`
 }
 
 // CommonAttributes represents the common Bazel attributes from which properties
@@ -934,6 +972,8 @@ type CommonAttributes struct {
 	Name string
 	// Data mapped from: Required
 	Data bazel.LabelListAttribute
+	// Tags mapped from: Required, as "required:<label>"
+	Tags bazel.StringListAttribute
 }
 
 // constraintAttributes represents Bazel attributes pertaining to build constraints,
@@ -1160,7 +1200,7 @@ func InitCommonOSAndroidMultiTargetsArchModule(m Module, hod HostOrDeviceSupport
 	m.base().commonProperties.CreateCommonOSVariant = true
 }
 
-func (attrs *CommonAttributes) fillCommonBp2BuildModuleAttrs(ctx *topDownMutatorContext,
+func (attrs *CommonAttributes) fillCommonBp2BuildModuleAttrs(ctx TopDownMutatorContext,
 	enabledPropertyOverrides bazel.BoolAttribute) constraintAttributes {
 	// Assert passed-in attributes include Name
 	name := attrs.Name
@@ -1178,6 +1218,7 @@ func (attrs *CommonAttributes) fillCommonBp2BuildModuleAttrs(ctx *topDownMutator
 	data := &attrs.Data
 
 	required := depsToLabelList(props.Required)
+	requiredTags := bazel.MakeStringListAttribute(bp2buildRequiredTags(ctx, props.Required))
 	archVariantProps := mod.GetArchVariantProperties(ctx, &commonProperties{})
 
 	var enabledProperty bazel.BoolAttribute
@@ -1189,6 +1230,7 @@ func (attrs *CommonAttributes) fillCommonBp2BuildModuleAttrs(ctx *topDownMutator
 		for config, _props := range configToProps {
 			if archProps, ok := _props.(*commonProperties); ok {
 				required.SetSelectValue(axis, config, depsToLabelList(archProps.Required).Value)
+				requiredTags.SetSelectValue(axis, config, bp2buildRequiredTags(ctx, archProps.Required))
 				if archProps.Enabled != nil {
 					enabledProperty.SetSelectValue(axis, config, archProps.Enabled)
 				}
@@ -1237,6 +1279,7 @@ func (attrs *CommonAttributes) fillCommonBp2BuildModuleAttrs(ctx *topDownMutator
 	}
 
 	data.Append(required)
+	attrs.Tags.Append(requiredTags)
 
 	constraints := constraintAttributes{}
 	moduleEnableConstraints := bazel.LabelListAttribute{}
@@ -1247,9 +1290,39 @@ func (attrs *CommonAttributes) fillCommonBp2BuildModuleAttrs(ctx *topDownMutator
 	return constraints
 }
 
+// bp2buildRequiredTags returns the tags recording the Bazel labels of the required modules. Required
+// modules that aren't converted to Bazel are recorded in UnconvertedBp2buildRequiredDeps instead,
+// as the generated target couldn't be installed with them.
+func bp2buildRequiredTags(ctx TopDownMutatorContext, required []string) []string {
+	var tags []string
+	for _, dep := range SortedUniqueStrings(required) {
+		m, _ := ctx.ModuleFromName(dep)
+		if m == nil || !convertedToBazel(ctx, m) {
+			ctx.AddUnconvertedBp2buildRequiredDep(dep)
+			continue
+		}
+		tags = append(tags, "required:"+BazelModuleLabel(ctx, m))
+	}
+	return tags
+}
+
+// bp2buildRequired returns the names of the modules in the required property of the module on any
+// architecture.
+func (m *ModuleBase) bp2buildRequired(ctx ArchVariantContext) []string {
+	required := append([]string(nil), m.commonProperties.Required...)
+	for _, configToProps := range m.GetArchVariantProperties(ctx, &commonProperties{}) {
+		for _, props := range configToProps {
+			if archProps, ok := props.(*commonProperties); ok {
+				required = append(required, archProps.Required...)
+			}
+		}
+	}
+	return SortedUniqueStrings(required)
+}
+
 // Check product variables for `enabled: true` flag override.
 // Returns a list of the constraint_value targets who enable this override.
-func productVariableConfigEnableLabels(ctx *topDownMutatorContext) []bazel.Label {
+func productVariableConfigEnableLabels(ctx TopDownMutatorContext) []bazel.Label {
 	productVariableProps := ProductVariableProperties(ctx)
 	productConfigEnablingTargets := []bazel.Label{}
 	const propName = "Enabled"
@@ -1339,6 +1412,16 @@ type ModuleBase struct {
 	// Properties specific to the Blueprint to BUILD migration.
 	bazelTargetModuleProperties bazel.BazelTargetModuleProperties
 
+	// Set while bp2build converts the module in a trial run, whose results are discarded, see
+	// Config.Bp2buildTrialDir.
+	bp2buildTrial bool
+
+	// The modules in the required property of the module on any architecture, and whether any of
+	// them doesn't exist, collected by the bp2build_required mutator before any module is converted
+	// by bp2build, see requiresUnconvertedBp2buildModules.
+	bp2buildRequiredModules        []blueprint.Module
+	bp2buildRequiresMissingModules bool
+
 	// Information about all the properties on the module that contains visibility rules that need
 	// checking.
 	visibilityPropertyInfo []visibilityProperty
@@ -1397,6 +1480,9 @@ type bp2buildInfo struct {
 	CommonAttrs     CommonAttributes
 	ConstraintAttrs constraintAttributes
 	Attrs           interface{}
+
+	// AttributeOverrides replace attributes generated from Attrs, or nil if there are none.
+	AttributeOverrides BazelAttributeOverrides
 }
 
 // TargetName returns the Bazel target name of a bp2build converted target.
@@ -1425,6 +1511,12 @@ func (b bp2buildInfo) BazelAttributes() []interface{} {
 	return []interface{}{&b.CommonAttrs, &b.ConstraintAttrs, b.Attrs}
 }
 
+// BazelAttributeOverrides returns the attribute overrides of a bp2build converted target, applied
+// over the attributes returned by BazelAttributes. It returns nil if there are none.
+func (b bp2buildInfo) BazelAttributeOverrides() BazelAttributeOverrides {
+	return b.AttributeOverrides
+}
+
 func (m *ModuleBase) addBp2buildInfo(info bp2buildInfo) {
 	m.commonProperties.Bp2buildInfo = append(m.commonProperties.Bp2buildInfo, info)
 }
@@ -1451,6 +1543,13 @@ func (b *baseModuleContext) AddMissingBp2buildDep(dep string) {
 	*missingDeps = append(*missingDeps, dep)
 }
 
+// AddUnconvertedBp2buildRequiredDep stores the name of a required module that was not converted to
+// Bazel or not found.
+func (b *baseModuleContext) AddUnconvertedBp2buildRequiredDep(dep string) {
+	requiredDeps := &b.Module().base().commonProperties.UnconvertedBp2buildRequiredDeps
+	*requiredDeps = append(*requiredDeps, dep)
+}
+
 // GetUnconvertedBp2buildDeps returns the list of module names of this module's direct dependencies that
 // were not converted to Bazel.
 func (m *ModuleBase) GetUnconvertedBp2buildDeps() []string {
@@ -1462,6 +1561,12 @@ func (m *ModuleBase) GetMissingBp2buildDeps() []string {
 	return FirstUniqueStrings(m.commonProperties.MissingBp2buildDeps)
 }
 
+// GetUnconvertedBp2buildRequiredDeps returns the names of the required modules of this module that
+// were not converted to Bazel or not found.
+func (m *ModuleBase) GetUnconvertedBp2buildRequiredDeps() []string {
+	return FirstUniqueStrings(m.commonProperties.UnconvertedBp2buildRequiredDeps)
+}
+
 func (m *ModuleBase) AddJSONData(d *map[string]interface{}) {
 	(*d)["Android"] = map[string]interface{}{
 		// Properties set in Blueprint or in blueprint of a defaults modules
@@ -1870,6 +1975,13 @@ func (m *ModuleBase) IsHideFromMake() bool {
 	return m.commonProperties.HideFromMake == true
 }
 
+// SetOutputSubdir places the outputs of this variant under the subdir directory of the soong
+// output directory, e.g. $OUT/soong/<subdir>/.intermediates, instead of directly under it. It must
+// be called by a mutator, before the paths of the outputs are created.
+func (m *ModuleBase) SetOutputSubdir(subdir string) {
+	m.commonProperties.OutputSubdir = subdir
+}
+
 // SkipInstall marks this variant to not create install rules when ctx.Install* are called.
 func (m *ModuleBase) SkipInstall() {
 	m.commonProperties.SkipInstall = true
@@ -2249,6 +2361,8 @@ func (m *ModuleBase) GenerateBuildActions(blueprintCtx blueprint.ModuleContext)
 			}
 		})
 
+		validateHandcraftedBazelLabel(ctx)
+
 		m.noticeFiles = make([]Path, 0)
 		optPath := OptionalPath{}
 		notice := proptools.StringDefault(m.commonProperties.Notice, "")
@@ -2303,7 +2417,9 @@ func (m *ModuleBase) GenerateBuildActions(blueprintCtx blueprint.ModuleContext)
 
 		m.installFiles = append(m.installFiles, ctx.installFiles...)
 		m.checkbuildFiles = append(m.checkbuildFiles, ctx.checkbuildFiles...)
-		m.packagingSpecs = append(m.packagingSpecs, ctx.packagingSpecs...)
+		if !excludedFromPackaging(ctx) {
+			m.packagingSpecs = append(m.packagingSpecs, ctx.packagingSpecs...)
+		}
 		m.katiInstalls = append(m.katiInstalls, ctx.katiInstalls...)
 		m.katiSymlinks = append(m.katiSymlinks, ctx.katiSymlinks...)
 	} else if ctx.Config().AllowMissingDependencies() {
@@ -2354,6 +2470,14 @@ func checkDistProperties(ctx *moduleContext, property string, dist *Dist) {
 			ctx.PropertyErrorf(property+".suffix", "Suffix may not contain a '/' character.")
 		}
 	}
+	if dist.Variant != nil {
+		if m, ok := ctx.Module().(DistVariantModule); !ok {
+			ctx.PropertyErrorf(property+".variant", "is not supported by %s modules", ctx.ModuleType())
+		} else if available, _ := m.DistVariants(); !InList(*dist.Variant, available) {
+			ctx.PropertyErrorf(property+".variant", "variant %q doesn't exist in this build or is "+
+				"hidden from Make, the available variants are %q", *dist.Variant, available)
+		}
+	}
 
 }
 
@@ -330,6 +330,26 @@ func TestDistErrorChecking(t *testing.T) {
 		RunTestWithBp(t, bp)
 }
 
+func TestDistVariantErrorChecking(t *testing.T) {
+	bp := `
+		deps {
+			name: "foo",
+			dists: [
+				{
+					targets: ["my_goal"],
+					variant: "hwasan",
+				},
+			],
+		}
+	`
+
+	prepareForModuleTests.
+		ExtendWithErrorHandler(FixtureExpectsAllErrorsToMatchAPattern([]string{
+			"\\Qmodule \"foo\": dists[0].variant: is not supported by deps modules\\E",
+		})).
+		RunTestWithBp(t, bp)
+}
+
 func TestInstall(t *testing.T) {
 	if runtime.GOOS != "linux" {
 		t.Skip("requires linux")
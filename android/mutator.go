@@ -41,6 +41,7 @@ func RegisterMutatorsForBazelConversion(ctx *Context, preArchMutators []Register
 	bp2buildMutators := append([]RegisterMutatorFunc{
 		RegisterNamespaceMutator,
 		RegisterDefaultsPreArchMutators,
+		registerBazelModuleConfigMutator,
 		// TODO(b/165114590): this is required to resolve deps that are only prebuilts, but we should
 		// evaluate the impact on conversion.
 		RegisterPrebuiltsPreArchMutators,
@@ -141,6 +142,11 @@ var preArch = []RegisterMutatorFunc{
 	// a DefaultableHook.
 	RegisterDefaultsPreArchMutators,
 
+	// Record the modules with conflicting bazel_module properties.
+	//
+	// Must be run after defaults, which can set the properties.
+	registerBazelModuleConfigMutator,
+
 	// Add dependencies on any components so that any component references can be
 	// resolved within the deps mutator.
 	//
@@ -510,7 +516,7 @@ func (t *topDownMutatorContext) CreateBazelTargetModule(
 	bazelProps bazel.BazelTargetModuleProperties,
 	commonAttrs CommonAttributes,
 	attrs interface{}) {
-	t.createBazelTargetModule(bazelProps, commonAttrs, attrs, bazel.BoolAttribute{})
+	createBazelTargetModule(t, bazelProps, commonAttrs, attrs, bazel.BoolAttribute{})
 }
 
 func (t *topDownMutatorContext) CreateBazelTargetModuleWithRestrictions(
@@ -518,10 +524,11 @@ func (t *topDownMutatorContext) CreateBazelTargetModuleWithRestrictions(
 	commonAttrs CommonAttributes,
 	attrs interface{},
 	enabledProperty bazel.BoolAttribute) {
-	t.createBazelTargetModule(bazelProps, commonAttrs, attrs, enabledProperty)
+	createBazelTargetModule(t, bazelProps, commonAttrs, attrs, enabledProperty)
 }
 
-func (t *topDownMutatorContext) createBazelTargetModule(
+func createBazelTargetModule(
+	t TopDownMutatorContext,
 	bazelProps bazel.BazelTargetModuleProperties,
 	commonAttrs CommonAttributes,
 	attrs interface{},
@@ -535,6 +542,10 @@ func (t *topDownMutatorContext) createBazelTargetModule(
 		ConstraintAttrs: constraintAttributes,
 		Attrs:           attrs,
 	}
+	if b, ok := mod.(Bazelable); ok {
+		info.AttributeOverrides = b.bazelAttributeOverrides(t, bazelProps.Rule_class,
+			&commonAttrs, &constraintAttributes, attrs)
+	}
 	mod.base().addBp2buildInfo(info)
 }
 
@@ -197,6 +197,25 @@ type PackagingItem interface {
 	IsPackagingItem() bool
 }
 
+// SanitizerVariantModule is implemented by modules that can have variants built with sanitizers.
+type SanitizerVariantModule interface {
+	// IsTestOnlySanitizerVariant returns true if this variant of the module is built with a
+	// sanitizer that is only used for testing, e.g. address, as opposed to the sanitizers that
+	// production modules are built with, e.g. cfi.
+	IsTestOnlySanitizerVariant() bool
+}
+
+// excludedFromPackaging returns true if the files installed by the module variant must not be
+// added to the packages that depend on it, which is the case for the device variants built with
+// test-only sanitizers when the product excludes them from packaging.
+func excludedFromPackaging(ctx ModuleContext) bool {
+	if !ctx.Config().ExcludeSanitizerVariantsFromPackaging() || !ctx.Device() {
+		return false
+	}
+	s, ok := ctx.Module().(SanitizerVariantModule)
+	return ok && s.IsTestOnlySanitizerVariant()
+}
+
 // DepTag provides default implementation of PackagingItem interface.
 // PackagingBase-derived modules can define their own dependency tag by embedding this, which
 // can be passed to AddDeps() or AddDependencies().
@@ -1466,6 +1466,11 @@ type ModuleOutPathContext interface {
 }
 
 func pathForModuleOut(ctx ModuleOutPathContext) OutputPath {
+	if mctx, ok := ctx.(interface{ Module() Module }); ok {
+		if subdir := mctx.Module().base().commonProperties.OutputSubdir; subdir != "" {
+			return PathForOutput(ctx, subdir, ".intermediates", ctx.ModuleDir(), ctx.ModuleName(), ctx.ModuleSubDir())
+		}
+	}
 	return PathForOutput(ctx, ".intermediates", ctx.ModuleDir(), ctx.ModuleName(), ctx.ModuleSubDir())
 }
 
@@ -1668,6 +1673,12 @@ func (p InstallPath) PartitionDir() string {
 	}
 }
 
+// Partition returns the name of the partition the install path is in, e.g. "system" or "vendor"
+// for device modules.
+func (p InstallPath) Partition() string {
+	return p.partition
+}
+
 // Join creates a new InstallPath with paths... joined with the current path. The
 // provided paths... may not use '..' to escape from the current path.
 func (p InstallPath) Join(ctx PathContext, paths ...string) InstallPath {
@@ -254,6 +254,10 @@ type RegistrationContext interface {
 	PreDepsMutators(f RegisterMutatorFunc)
 	PostDepsMutators(f RegisterMutatorFunc)
 	FinalDepsMutators(f RegisterMutatorFunc)
+
+	// Register mutators that run prior to deps and conversion when converting Android Blueprint
+	// modules into Bazel BUILD targets.
+	PreArchBp2BuildMutators(f RegisterMutatorFunc)
 }
 
 // Used to register build components from an init() method, e.g.
@@ -342,3 +346,7 @@ func (ctx *initRegistrationContext) PostDepsMutators(f RegisterMutatorFunc) {
 func (ctx *initRegistrationContext) FinalDepsMutators(f RegisterMutatorFunc) {
 	FinalDepsMutators(f)
 }
+
+func (ctx *initRegistrationContext) PreArchBp2BuildMutators(f RegisterMutatorFunc) {
+	PreArchBp2BuildMutators(f)
+}
@@ -122,6 +122,12 @@ var PrepareForTestWithMakevars = FixtureRegisterWithContext(func(ctx Registratio
 	ctx.RegisterSingletonType("makevars", makeVarsSingletonFunc)
 })
 
+// PrepareForTestWithPhony registers the phony singleton, which creates the phony rules added with
+// SingletonContext.Phony and ModuleContext.Phony.
+var PrepareForTestWithPhony = FixtureRegisterWithContext(func(ctx RegistrationContext) {
+	ctx.RegisterSingletonType("phony", phonySingletonFactory)
+})
+
 // Test fixture preparer that will register most java build components.
 //
 // Singletons and mutators should only be added here if they are needed for a majority of java
@@ -170,6 +176,9 @@ var PrepareForTestDisallowNonExistentPaths = FixtureModifyConfig(func(config Con
 	config.TestAllowNonExistentPaths = false
 })
 
+// Prepares a test that builds host modules against musl instead of glibc.
+var PrepareForTestWithHostMusl = FixtureModifyConfig(modifyTestConfigForMusl)
+
 func NewTestArchContext(config Config) *TestContext {
 	ctx := NewTestContext(config)
 	ctx.preDeps = append(ctx.preDeps, registerArchMutator)
@@ -333,9 +333,25 @@ type productVariables struct {
 
 	DisableScudo *bool `json:",omitempty"`
 
+	SeparateSanitizerOutputs              *bool `json:",omitempty"`
+	ExcludeSanitizerVariantsFromPackaging *bool `json:",omitempty"`
+
+	SanitizerRuntimesExcludeLibs []string          `json:",omitempty"`
+	SanitizerRuntimeOverrides    map[string]string `json:",omitempty"`
+	SanitizerRuntimesFromSource  *bool             `json:",omitempty"`
+
+	SanitizeNeverRequiresReasonPaths []string `json:",omitempty"`
+
+	FuzzerDeviceSanitizer *string `json:",omitempty"`
+
+	ThinLtoCacheDir *string `json:",omitempty"`
+
+	CompilerFlagsReport *bool `json:",omitempty"`
+
 	MemtagHeapExcludePaths      []string `json:",omitempty"`
 	MemtagHeapAsyncIncludePaths []string `json:",omitempty"`
 	MemtagHeapSyncIncludePaths  []string `json:",omitempty"`
+	MemtagHeapDisableAllowlist  []string `json:",omitempty"`
 
 	VendorPath    *string `json:",omitempty"`
 	OdmPath       *string `json:",omitempty"`
@@ -357,10 +373,13 @@ type productVariables struct {
 	// Set by NewConfig
 	Native_coverage *bool `json:",omitempty"`
 
-	SanitizeHost       []string `json:",omitempty"`
-	SanitizeDevice     []string `json:",omitempty"`
-	SanitizeDeviceDiag []string `json:",omitempty"`
-	SanitizeDeviceArch []string `json:",omitempty"`
+	SanitizeHost        []string `json:",omitempty"`
+	SanitizeDevice      []string `json:",omitempty"`
+	SanitizeDeviceDiag  []string `json:",omitempty"`
+	SanitizeDeviceArch  []string `json:",omitempty"`
+	SanitizeVendorImage []string `json:",omitempty"`
+
+	SanitizeBootCriticalModules []string `json:",omitempty"`
 
 	ArtUseReadBarrier *bool `json:",omitempty"`
 
@@ -494,6 +513,8 @@ type productVariables struct {
 	ForceMultilibFirstOnDevice bool `json:",omitempty"`
 
 	IncludeTags []string `json:",omitempty"`
+
+	Bp2buildTrialDir *string `json:",omitempty"`
 }
 
 func boolPtr(v bool) *bool {
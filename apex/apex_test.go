@@ -2023,6 +2023,108 @@ func TestQApexesUseLatestStubsInBundledBuildsAndHWASAN(t *testing.T) {
 	expectLink("libx", "shared_hwasan_apex29", "libbar", "shared_current")
 }
 
+func TestApexMinSdkVersion_ExplicitHwaddress(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			min_sdk_version: "28",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+			min_sdk_version: "28",
+			sanitize: {
+				hwaddress: %t,
+			},
+		}
+	`
+
+	// The global hwaddress sanitizer skips mylib, which must run on API level 28.
+	ctx := testApex(t, fmt.Sprintf(bp, false), prepareForTestWithSantitizeHwaddress)
+	ensureListNotContains(t, ctx.ModuleVariantsForTests("mylib"), "android_arm64_armv8-a_shared_hwasan_apex28")
+	ensureListContains(t, ctx.ModuleVariantsForTests("mylib"), "android_arm64_armv8-a_shared_apex28")
+
+	// Enabling it explicitly fails the min_sdk_version check of the apex.
+	testApexError(t, `module "mylib".*: should support min_sdk_version\(28\) for "myapex": sanitizer hwaddress requires min_sdk_version 29 or later`,
+		fmt.Sprintf(bp, true))
+}
+
+func TestApexMinSdkVersion_TestMemtagHeapDefault(t *testing.T) {
+	// Tests default to heap tagging, which doesn't fail the min_sdk_version check of the apex even
+	// though it requires a newer API level.
+	ctx := testApex(t, `
+		apex_test {
+			name: "myapex",
+			key: "myapex.key",
+			tests: ["mytest"],
+			min_sdk_version: "28",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_test {
+			name: "mytest",
+			gtest: false,
+			srcs: ["mytest.cpp"],
+			system_shared_libs: [],
+			static_executable: true,
+			stl: "none",
+			apex_available: ["myapex"],
+			min_sdk_version: "28",
+		}
+	`)
+
+	mytest := ctx.ModuleForTests("mytest", "android_arm64_armv8-a_apex28").Module().(*cc.Module)
+	if !mytest.IsSanitizerEnabled(cc.Memtag_heap) {
+		t.Errorf("expected mytest to default to heap tagging")
+	}
+}
+
+func TestHwaddressMinSdkVersion(t *testing.T) {
+	testApexError(t, `module "mybin".*: should support min_sdk_version\(28\) for "myapex": sanitizer hwaddress requires min_sdk_version 29 or later`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			binaries: ["mybin"],
+			min_sdk_version: "28",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_binary {
+			name: "mybin",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+			min_sdk_version: "28",
+			sanitize: {
+				hwaddress: true,
+			},
+		}
+	`)
+}
+
 func TestQTargetApexUsesStaticUnwinder(t *testing.T) {
 	ctx := testApex(t, `
 		apex {
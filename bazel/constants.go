@@ -16,6 +16,9 @@ const (
 	// Perform cquery of the Bazel build root and its dependencies.
 	CqueryBuildRootRunName = RunName("cquery-buildroot")
 
+	// Perform a query of the handcrafted labels of modules to check that they exist.
+	QueryHandcraftedLabelsRunName = RunName("query-handcrafted-labels")
+
 	// Run bazel as a ninja executer
 	BazelNinjaExecRunName = RunName("bazel-ninja-exec")
 
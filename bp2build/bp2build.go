@@ -41,14 +41,35 @@ func Codegen(ctx *CodegenContext) CodegenMetrics {
 		os.Exit(1)
 	}
 	bp2buildFiles := CreateBazelFiles(nil, res.buildFileToTargets, ctx.mode)
+	if ctx.existingBuildFileStubs {
+		bp2buildFiles = append(bp2buildFiles, createExistingBuildFileStubs(ctx.Config(), res.buildFileToTargets)...)
+	}
 	writeFiles(ctx, bp2buildDir, bp2buildFiles)
 
+	for _, dir := range android.SortedStringKeys(res.buildFileToTargets) {
+		if android.EffectiveShouldWriteBuildFile(dir) {
+			res.metrics.generatedPackages = append(res.metrics.generatedPackages, dir)
+		}
+	}
+
 	soongInjectionDir := android.PathForOutput(ctx, bazel.SoongInjectionDirName)
 	writeFiles(ctx, soongInjectionDir, CreateSoongInjectionFiles(ctx.Config(), res.metrics))
 
+	if dir := ctx.Config().Bp2buildTrialDir(); dir != "" {
+		report := CreateTrialReport(ctx, dir).String()
+		reportFile := android.PathForOutput(ctx, bp2buildTrialReportFile)
+		if err := writeFile(ctx, reportFile, report); err != nil {
+			panic(fmt.Errorf("Failed to write %q due to %q", reportFile, err))
+		}
+	}
+
 	return res.metrics
 }
 
+// The report of the trial conversion of android.Config.Bp2buildTrialDir, relative to the output
+// directory.
+const bp2buildTrialReportFile = "bp2build_trial_report.txt"
+
 // Get the output directory and create it if it doesn't exist.
 func getOrCreateOutputDir(outputDir android.OutputPath, ctx android.PathContext, dir string) android.OutputPath {
 	dirPath := outputDir.Join(ctx, dir)
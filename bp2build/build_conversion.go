@@ -77,14 +77,22 @@ func (targets BazelTargets) hasHandcraftedTargets() bool {
 }
 
 // sort a list of BazelTargets in-place, by name, and by generated/handcrafted types.
+// Targets sharing a name are ordered by rule class and then by content, so that the
+// result does not depend on the order in which the targets were generated.
 func (targets BazelTargets) sort() {
-	sort.Slice(targets, func(i, j int) bool {
+	sort.SliceStable(targets, func(i, j int) bool {
 		if targets[i].handcrafted != targets[j].handcrafted {
 			// Handcrafted targets will be generated after the bp2build generated targets.
 			return targets[j].handcrafted
 		}
 		// This will cover all bp2build generated targets.
-		return targets[i].name < targets[j].name
+		if targets[i].name != targets[j].name {
+			return targets[i].name < targets[j].name
+		}
+		if targets[i].ruleClass != targets[j].ruleClass {
+			return targets[i].ruleClass < targets[j].ruleClass
+		}
+		return targets[i].content < targets[j].content
 	})
 }
 
@@ -95,7 +103,7 @@ func (targets BazelTargets) String() string {
 	var res string
 	for i, target := range targets {
 		// There is only at most 1 handcrafted "target", because its contents
-		// represent the entire BUILD file content from the tree. See
+		// represent all the targets extracted from a BUILD file in the tree. See
 		// build_conversion.go#getHandcraftedBuildContent for more information.
 		//
 		// Add a header to make it easy to debug where the handcrafted targets
@@ -117,30 +125,13 @@ func (targets BazelTargets) String() string {
 // LoadStatements return the string representation of the sorted and deduplicated
 // Starlark rule load statements needed by a group of BazelTargets.
 func (targets BazelTargets) LoadStatements() string {
-	bzlToLoadedSymbols := map[string][]string{}
+	buildFile := newBuildFileBuilder()
 	for _, target := range targets {
 		if target.IsLoadedFromStarlark() {
-			bzlToLoadedSymbols[target.bzlLoadLocation] =
-				append(bzlToLoadedSymbols[target.bzlLoadLocation], target.ruleClass)
+			buildFile.addLoad(target.bzlLoadLocation, target.ruleClass)
 		}
 	}
-
-	var loadStatements []string
-	for bzl, ruleClasses := range bzlToLoadedSymbols {
-		loadStatement := "load(\""
-		loadStatement += bzl
-		loadStatement += "\", "
-		ruleClasses = android.SortedUniqueStrings(ruleClasses)
-		for i, ruleClass := range ruleClasses {
-			loadStatement += "\"" + ruleClass + "\""
-			if i != len(ruleClasses)-1 {
-				loadStatement += ", "
-			}
-		}
-		loadStatement += ")"
-		loadStatements = append(loadStatements, loadStatement)
-	}
-	return strings.Join(android.SortedUniqueStrings(loadStatements), "\n")
+	return buildFile.loadStatements()
 }
 
 type bpToBuildContext interface {
@@ -159,6 +150,10 @@ type CodegenContext struct {
 	mode               CodegenMode
 	additionalDeps     []string
 	unconvertedDepMode unconvertedDepsMode
+	// Write a stub pointing to the existing BUILD file for directories whose existing BUILD file
+	// is kept instead of a generated one.
+	// Enabled by setting environment variable `BP2BUILD_EXISTING_BUILD_FILE_STUBS`
+	existingBuildFileStubs bool
 }
 
 func (c *CodegenContext) Mode() CodegenMode {
@@ -228,10 +223,11 @@ func NewCodegenContext(config android.Config, context android.Context, mode Code
 		unconvertedDeps = errorModulesUnconvertedDeps
 	}
 	return &CodegenContext{
-		context:            context,
-		config:             config,
-		mode:               mode,
-		unconvertedDepMode: unconvertedDeps,
+		context:                context,
+		config:                 config,
+		mode:                   mode,
+		unconvertedDepMode:     unconvertedDeps,
+		existingBuildFileStubs: config.IsEnvTrue("BP2BUILD_EXISTING_BUILD_FILE_STUBS"),
 	}
 }
 
@@ -256,17 +252,21 @@ func (r conversionResults) BuildDirToTargets() map[string]BazelTargets {
 
 func GenerateBazelTargets(ctx *CodegenContext, generateFilegroups bool) (conversionResults, []error) {
 	buildFileToTargets := make(map[string]BazelTargets)
-	buildFileToAppend := make(map[string]bool)
+	buildFileToAppend := make(map[string]*handcraftedBuildFile)
 
 	// Simple metrics tracking for bp2build
 	metrics := CodegenMetrics{
 		ruleClassCount:           make(map[string]uint64),
 		convertedModuleTypeCount: make(map[string]uint64),
 		totalModuleTypeCount:     make(map[string]uint64),
+		moduleConversionOutcomes: make(map[string]string),
 	}
 
 	dirs := make(map[string]bool)
 
+	// The unescaped target names of the generated targets, keyed by their labels.
+	targetNames := make(map[string]string)
+
 	var errs []error
 
 	bpCtx := ctx.Context()
@@ -288,8 +288,10 @@ func GenerateBazelTargets(ctx *CodegenContext, generateFilegroups bool) (convers
 				// Handle modules converted to handcrafted targets.
 				//
 				// Since these modules are associated with some handcrafted
-				// target in a BUILD file, we simply append the entire contents
-				// of that BUILD file to the generated BUILD file.
+				// target in a BUILD file, the targets they are associated
+				// with are extracted from that BUILD file and appended to
+				// the generated BUILD file once all modules have been
+				// visited.
 				//
 				// The append operation is only done once, even if there are
 				// multiple modules from the same directory associated to
@@ -297,26 +299,32 @@ func GenerateBazelTargets(ctx *CodegenContext, generateFilegroups bool) (convers
 
 				// Log the module.
 				metrics.AddConvertedModule(m, moduleType, Handcrafted)
+				metrics.addModuleConversionOutcome(dir, m.Name(), conversionOutcomeHandcrafted)
 
 				pathToBuildFile := getBazelPackagePath(b)
-				if _, exists := buildFileToAppend[pathToBuildFile]; exists {
-					// Append the BUILD file content once per package, at most.
-					return
+				f, exists := buildFileToAppend[pathToBuildFile]
+				if !exists {
+					f = &handcraftedBuildFile{module: b, moduleName: bpCtx.ModuleName(m), dir: dir}
+					buildFileToAppend[pathToBuildFile] = f
 				}
-				t, err := getHandcraftedBuildContent(ctx, b, pathToBuildFile)
-				if err != nil {
-					errs = append(errs, fmt.Errorf("Error converting %s: %s", bpCtx.ModuleName(m), err))
-					return
-				}
-				targets = append(targets, t)
-				// TODO(b/181575318): currently we append the whole BUILD file, let's change that to do
-				// something more targeted based on the rule type and target
-				buildFileToAppend[pathToBuildFile] = true
+				f.targetNames = append(f.targetNames, getBazelTargetName(b))
+				return
 			} else if aModule, ok := m.(android.Module); ok && aModule.IsConvertedByBp2build() {
 				// Handle modules converted to generated targets.
 
+				// Modules that require unconverted modules aren't converted, as their targets
+				// couldn't be installed with the required modules.
+				if required := aModule.GetUnconvertedBp2buildRequiredDeps(); len(required) > 0 {
+					msg := fmt.Sprintf("%q requires unconverted modules: %s", m.Name(), strings.Join(required, ", "))
+					metrics.moduleWithUnconvertedRequiredDepsMsgs = append(metrics.moduleWithUnconvertedRequiredDepsMsgs, msg)
+					metrics.AddUnconvertedModule(moduleType)
+					metrics.addModuleConversionOutcome(dir, m.Name(), conversionOutcomeUnconverted)
+					return
+				}
+
 				// Log the module.
 				metrics.AddConvertedModule(aModule, moduleType, Generated)
+				metrics.addModuleConversionOutcome(dir, m.Name(), conversionOutcomeGenerated)
 
 				// Handle modules with unconverted deps. By default, emit a warning.
 				if unconvertedDeps := aModule.GetUnconvertedBp2buildDeps(); len(unconvertedDeps) > 0 {
@@ -337,7 +345,19 @@ func GenerateBazelTargets(ctx *CodegenContext, generateFilegroups bool) (convers
 						return
 					}
 				}
-				targets = generateBazelTargets(bpCtx, aModule)
+				if hasBazelAttributeOverrides(aModule) {
+					metrics.AddModuleWithAttributeOverrides(m.Name())
+				}
+				if err := checkBazelTargetNameCollisions(targetNames, aModule); err != nil {
+					errs = append(errs, err)
+					return
+				}
+				var err error
+				targets, err = generateBazelTargets(bpCtx, aModule)
+				if err != nil {
+					errs = append(errs, err)
+					return
+				}
 				for _, t := range targets {
 					// A module can potentially generate more than 1 Bazel
 					// target, each of a different rule class.
@@ -345,6 +365,7 @@ func GenerateBazelTargets(ctx *CodegenContext, generateFilegroups bool) (convers
 				}
 			} else {
 				metrics.AddUnconvertedModule(moduleType)
+				metrics.addModuleConversionOutcome(dir, m.Name(), conversionOutcomeUnconverted)
 				return
 			}
 		case QueryView:
@@ -364,6 +385,28 @@ func GenerateBazelTargets(ctx *CodegenContext, generateFilegroups bool) (convers
 		buildFileToTargets[dir] = append(buildFileToTargets[dir], targets...)
 	})
 
+	for _, pathToBuildFile := range android.SortedStringKeys(buildFileToAppend) {
+		f := buildFileToAppend[pathToBuildFile]
+		t, err := getHandcraftedBuildContent(ctx, f.module, pathToBuildFile, f.targetNames)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error converting %s: %s", f.moduleName, err))
+			continue
+		}
+		buildFileToTargets[f.dir] = append(buildFileToTargets[f.dir], t)
+	}
+
+	if ctx.Mode() == Bp2Build {
+		exportedFiles := android.Bp2buildExportedFiles(ctx.Config())
+		for _, dir := range android.SortedStringKeys(exportedFiles) {
+			t, err := generateExportsFilesTarget(dir, exportedFiles[dir], buildFileToTargets[dir])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			buildFileToTargets[dir] = append(buildFileToTargets[dir], t)
+		}
+	}
+
 	if len(errs) > 0 {
 		return conversionResults{}, errs
 	}
@@ -386,6 +429,31 @@ func GenerateBazelTargets(ctx *CodegenContext, generateFilegroups bool) (convers
 	}, errs
 }
 
+// generateExportsFilesTarget returns an exports_files target for the files in the package dir that
+// converted modules in other packages reference directly, e.g. with srcs: ["../dir/foo.cpp"].
+// The exported files must not have the same name as the other targets of the package.
+func generateExportsFilesTarget(dir string, files []string, targets BazelTargets) (BazelTarget, error) {
+	for _, t := range targets {
+		if android.InList(t.name, files) {
+			return BazelTarget{}, fmt.Errorf("file %q in package %q is referenced from other packages, but "+
+				"conflicts with the target of the same name", t.name, dir)
+		}
+	}
+
+	var content strings.Builder
+	content.WriteString("exports_files([\n")
+	for _, f := range files {
+		content.WriteString(fmt.Sprintf("    %q,\n", f))
+	}
+	content.WriteString("])")
+
+	return BazelTarget{
+		packageName: dir,
+		content:     content.String(),
+		ruleClass:   "exports_files",
+	}, nil
+}
+
 func getBazelPackagePath(b android.Bazelable) string {
 	label := b.HandcraftedLabel()
 	pathToBuildFile := strings.TrimPrefix(label, "//")
@@ -393,7 +461,33 @@ func getBazelPackagePath(b android.Bazelable) string {
 	return pathToBuildFile
 }
 
-func getHandcraftedBuildContent(ctx *CodegenContext, b android.Bazelable, pathToBuildFile string) (BazelTarget, error) {
+// getBazelTargetName returns the name of the target referenced by the handcrafted label of b.
+func getBazelTargetName(b android.Bazelable) string {
+	label := b.HandcraftedLabel()
+	if i := strings.LastIndex(label, ":"); i >= 0 {
+		return label[i+1:]
+	}
+	return label[strings.LastIndex(label, "/")+1:]
+}
+
+// handcraftedBuildFile records the modules associated with targets of a handcrafted BUILD file.
+type handcraftedBuildFile struct {
+	// The first module associated with a target of the BUILD file, and the directory of the
+	// generated BUILD file its targets are appended to.
+	module     android.Bazelable
+	moduleName string
+	dir        string
+
+	// The names of the targets associated with modules.
+	targetNames []string
+}
+
+// getHandcraftedBuildContent returns the targets with the given names from the handcrafted BUILD
+// file, along with the local targets they depend on and the deduplicated load statements they
+// need. The whole BUILD file is returned if any of the targets isn't defined literally in it, e.g.
+// because it is declared by a macro, or if the BUILD file contains anything else the targets may
+// depend on, like a package() declaration.
+func getHandcraftedBuildContent(ctx *CodegenContext, b android.Bazelable, pathToBuildFile string, targetNames []string) (BazelTarget, error) {
 	p := android.ExistentPathForSource(ctx, pathToBuildFile, HandcraftedBuildFileName)
 	if !p.Valid() {
 		return BazelTarget{}, fmt.Errorf("Could not find file %q for handcrafted target.", pathToBuildFile)
@@ -402,19 +496,52 @@ func getHandcraftedBuildContent(ctx *CodegenContext, b android.Bazelable, pathTo
 	if err != nil {
 		return BazelTarget{}, err
 	}
-	// TODO(b/181575318): once this is more targeted, we need to include name, rule class, etc
+	if extracted, err := extractHandcraftedTargets(c, android.FirstUniqueStrings(targetNames)); err == nil {
+		c = extracted
+	}
+	// TODO(b/181575318): include the name, rule class, etc of the extracted targets
 	return BazelTarget{
 		content:     c,
 		handcrafted: true,
 	}, nil
 }
 
-func generateBazelTargets(ctx bpToBuildContext, m android.Module) []BazelTarget {
+func generateBazelTargets(ctx bpToBuildContext, m android.Module) ([]BazelTarget, error) {
 	var targets []BazelTarget
 	for _, m := range m.Bp2buildTargets() {
-		targets = append(targets, generateBazelTarget(ctx, m))
+		target, err := generateBazelTarget(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// checkBazelTargetNameCollisions returns an error if the name of a target generated for m is
+// escaped to the same Bazel target name as a differently named target in the same package.
+// targetNames maps the labels of the targets checked so far to their unescaped names.
+func checkBazelTargetNameCollisions(targetNames map[string]string, m android.Module) error {
+	for _, t := range m.Bp2buildTargets() {
+		name := t.TargetName()
+		label := t.TargetPackage() + ":" + android.BazelTargetName(name)
+		if other, exists := targetNames[label]; exists && other != name {
+			return fmt.Errorf("%q and %q both generate the Bazel target %q in package %q",
+				other, name, android.BazelTargetName(name), t.TargetPackage())
+		}
+		targetNames[label] = name
+	}
+	return nil
+}
+
+// hasBazelAttributeOverrides returns whether any target generated for m has attribute overrides.
+func hasBazelAttributeOverrides(m android.Module) bool {
+	for _, t := range m.Bp2buildTargets() {
+		if t.BazelAttributeOverrides() != nil {
+			return true
+		}
 	}
-	return targets
+	return false
 }
 
 type bp2buildModule interface {
@@ -423,9 +550,10 @@ type bp2buildModule interface {
 	BazelRuleClass() string
 	BazelRuleLoadLocation() string
 	BazelAttributes() []interface{}
+	BazelAttributeOverrides() android.BazelAttributeOverrides
 }
 
-func generateBazelTarget(ctx bpToBuildContext, m bp2buildModule) BazelTarget {
+func generateBazelTarget(ctx bpToBuildContext, m bp2buildModule) (BazelTarget, error) {
 	ruleClass := m.BazelRuleClass()
 	bzlLoadLocation := m.BazelRuleLoadLocation()
 
@@ -436,10 +564,21 @@ func generateBazelTarget(ctx bpToBuildContext, m bp2buildModule) BazelTarget {
 	// name is handled in a special manner
 	delete(props.Attrs, "name")
 
+	// Apply the module's attribute overrides as a final patch over the converter's output.
+	overrides := m.BazelAttributeOverrides()
+	for _, name := range android.SortedStringKeys(overrides) {
+		prettyPrintedValue, err := prettyPrint(reflect.ValueOf(overrides[name]), 1, true)
+		if err != nil {
+			return BazelTarget{}, fmt.Errorf("Error while parsing attribute override %q of %q: %s",
+				name, m.TargetName(), err)
+		}
+		props.Attrs[name] = prettyPrintedValue
+	}
+
 	// Return the Bazel target with rule class and attributes, ready to be
 	// code-generated.
 	attributes := propsToAttributes(props.Attrs)
-	targetName := m.TargetName()
+	targetName := android.BazelTargetName(m.TargetName())
 	return BazelTarget{
 		name:            targetName,
 		packageName:     m.TargetPackage(),
@@ -452,7 +591,7 @@ func generateBazelTarget(ctx bpToBuildContext, m bp2buildModule) BazelTarget {
 			attributes,
 		),
 		handcrafted: false,
-	}
+	}, nil
 }
 
 // Convert a module and its deps and props into a Bazel macro/rule
@@ -917,6 +917,40 @@ func TestAllowlistingBp2buildTargetsExplicitly(t *testing.T) {
 	}
 }
 
+func TestModuleConversionOutcomes(t *testing.T) {
+	bp := `filegroup {
+    name: "converted",
+    srcs: ["a"],
+    bazel_module: { bp2build_available: true },
+}
+
+filegroup {
+    name: "unconverted",
+    srcs: ["b"],
+    bazel_module: { bp2build_available: false },
+}`
+
+	config := android.TestConfig(buildDir, nil, bp, nil)
+	ctx := android.NewTestContext(config)
+	ctx.RegisterModuleType("filegroup", android.FileGroupFactory)
+	ctx.RegisterForBazelConversion()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.ResolveDependencies(config)
+	android.FailIfErrored(t, errs)
+
+	codegenCtx := NewCodegenContext(config, *ctx.Context, Bp2Build)
+	res, errs := GenerateBazelTargets(codegenCtx, false)
+	android.FailIfErrored(t, errs)
+
+	expected := map[string]string{
+		"//:converted":   conversionOutcomeGenerated,
+		"//:unconverted": conversionOutcomeUnconverted,
+	}
+	android.AssertDeepEquals(t, "module conversion outcomes", expected, res.metrics.moduleConversionOutcomes)
+}
+
 func TestAllowlistingBp2buildTargetsWithConfig(t *testing.T) {
 	testCases := []struct {
 		moduleTypeUnderTest        string
@@ -1122,6 +1156,92 @@ func TestCombineBuildFilesBp2buildTargets(t *testing.T) {
 				`// BUILD file`,
 			},
 		},
+		{
+			description:                "filegroups with bazel_module.label sharing a load",
+			moduleTypeUnderTest:        "filegroup",
+			moduleTypeUnderTestFactory: android.FileGroupFactory,
+			filesystem: map[string]string{
+				"other/BUILD.bazel": `load("//build/bazel/rules:filegroup.bzl", "fg", "unused")
+
+fg(
+    name = "fg_foo",
+)
+
+fg(
+    name = "fg_unused",
+)
+
+fg(
+    name = "fg_bar",
+    srcs = [":fg_foo"],
+)`,
+			},
+			blueprint: `filegroup {
+        name: "fg_foo",
+        bazel_module: {
+          label: "//other:fg_foo",
+        },
+    }
+
+    filegroup {
+        name: "fg_bar",
+        bazel_module: {
+          label: "//other:fg_bar",
+        },
+    }`,
+			expectedBazelTargets: []string{
+				`load("//build/bazel/rules:filegroup.bzl", "fg")
+
+fg(
+    name = "fg_foo",
+)
+
+fg(
+    name = "fg_bar",
+    srcs = [":fg_foo"],
+)`,
+			},
+		},
+		{
+			description:                "filegroup bazel_module.label in a BUILD file with a package declaration",
+			moduleTypeUnderTest:        "filegroup",
+			moduleTypeUnderTestFactory: android.FileGroupFactory,
+			filesystem: map[string]string{
+				"other/BUILD.bazel": `load("//build/bazel/rules:filegroup.bzl", "fg")
+
+package(default_visibility = ["//visibility:private"])
+
+fg(
+    name = "fg_helper",
+)
+
+fg(
+    name = "fg_foo",
+    srcs = [":fg_helper"],
+)`,
+			},
+			blueprint: `filegroup {
+        name: "fg_foo",
+        bazel_module: {
+          label: "//other:fg_foo",
+        },
+    }`,
+			// The whole BUILD file is kept, as the target depends on the package declaration.
+			expectedBazelTargets: []string{
+				`load("//build/bazel/rules:filegroup.bzl", "fg")
+
+package(default_visibility = ["//visibility:private"])
+
+fg(
+    name = "fg_helper",
+)
+
+fg(
+    name = "fg_foo",
+    srcs = [":fg_helper"],
+)`,
+			},
+		},
 	}
 
 	dir := "."
@@ -1182,6 +1302,139 @@ func TestCombineBuildFilesBp2buildTargets(t *testing.T) {
 	}
 }
 
+func TestHandcraftedBuildFileInfoProvider(t *testing.T) {
+	bp := `
+filegroup {
+    name: "fg_found",
+    bazel_module: { label: "//other:fg_found" },
+}
+
+filegroup {
+    name: "fg_missing",
+    bazel_module: { label: "//missing:fg_missing" },
+}`
+	fs := map[string][]byte{
+		"other/BUILD.bazel": []byte(`// BUILD file`),
+	}
+	config := android.TestConfig(buildDir, nil, bp, fs)
+	ctx := android.NewTestContext(config)
+	ctx.RegisterModuleType("filegroup", android.FileGroupFactory)
+	ctx.RegisterForBazelConversion()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.ResolveDependencies(config)
+	android.FailIfErrored(t, errs)
+
+	infoFor := func(name string) android.HandcraftedBuildFileInfo {
+		m := ctx.ModuleForTests(name, "").Module()
+		return ctx.ModuleProvider(m, android.HandcraftedBuildFileInfoProvider).(android.HandcraftedBuildFileInfo)
+	}
+
+	found := infoFor("fg_found")
+	android.AssertBoolEquals(t, "fg_found found", true, found.Found)
+	android.AssertStringEquals(t, "fg_found path", "other/BUILD.bazel", found.Path)
+	android.AssertStringEquals(t, "fg_found error", "", found.Error)
+
+	missing := infoFor("fg_missing")
+	android.AssertBoolEquals(t, "fg_missing found", false, missing.Found)
+	android.AssertStringEquals(t, "fg_missing path", "missing/BUILD.bazel", missing.Path)
+	if missing.Error == "" {
+		t.Errorf("expected an error for fg_missing, got none")
+	}
+}
+
+func TestBazelTargetNames(t *testing.T) {
+	testCases := []bp2buildTestCase{
+		{
+			description: "dependency on a module in the top-level package",
+			blueprint: `filegroup {
+    name: "fg_root",
+    srcs: ["a"],
+    bazel_module: { bp2build_available: true },
+}`,
+			dir: "other",
+			filesystem: map[string]string{
+				"other/Android.bp": `filegroup {
+    name: "fg_other",
+    srcs: [":fg_root"],
+    bazel_module: { bp2build_available: true },
+}`,
+			},
+			expectedBazelTargets: []string{
+				makeBazelTarget("filegroup", "fg_other", attrNameToString{
+					"srcs": `["//:fg_root"]`,
+				}),
+			},
+		},
+		{
+			description: "module names with characters allowed in target names",
+			blueprint: `filegroup {
+    name: "fg++",
+    srcs: ["a"],
+    bazel_module: { bp2build_available: true },
+}
+
+filegroup {
+    name: "fg_user",
+    srcs: [":fg++"],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{
+				makeBazelTarget("filegroup", "fg++", attrNameToString{
+					"srcs": `["a"]`,
+				}),
+				makeBazelTarget("filegroup", "fg_user", attrNameToString{
+					"srcs": `[":fg++"]`,
+				}),
+			},
+		},
+		{
+			description: "module names with characters Bazel rejects in target names",
+			blueprint: `filegroup {
+    name: "fg bar",
+    srcs: ["a"],
+    bazel_module: { bp2build_available: true },
+}
+
+filegroup {
+    name: "fg_user",
+    srcs: [":fg bar"],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{
+				makeBazelTarget("filegroup", "fg_20_bar", attrNameToString{
+					"srcs": `["a"]`,
+				}),
+				makeBazelTarget("filegroup", "fg_user", attrNameToString{
+					"srcs": `[":fg_20_bar"]`,
+				}),
+			},
+		},
+		{
+			description: "escaped module name collides with another module",
+			blueprint: `filegroup {
+    name: "fg bar",
+    srcs: ["a"],
+    bazel_module: { bp2build_available: true },
+}
+
+filegroup {
+    name: "fg_20_bar",
+    srcs: ["b"],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedErr: fmt.Errorf(`"fg bar" and "fg_20_bar" both generate the Bazel target "fg_20_bar" in package "."`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			runFilegroupTestCase(t, tc)
+		})
+	}
+}
+
 func TestGlobExcludeSrcs(t *testing.T) {
 	testCases := []bp2buildTestCase{
 		{
@@ -1258,15 +1511,22 @@ func TestCommonBp2BuildModuleAttrs(t *testing.T) {
 			description:                "Required into data test",
 			moduleTypeUnderTest:        "filegroup",
 			moduleTypeUnderTestFactory: android.FileGroupFactory,
-			blueprint: simpleModuleDoNotConvertBp2build("filegroup", "reqd") + `
+			blueprint: `
+filegroup {
+    name: "reqd",
+    bazel_module: { bp2build_available: true },
+}
+
 filegroup {
     name: "fg_foo",
     required: ["reqd"],
     bazel_module: { bp2build_available: true },
 }`,
 			expectedBazelTargets: []string{
+				makeBazelTarget("filegroup", "reqd", map[string]string{}),
 				makeBazelTarget("filegroup", "fg_foo", map[string]string{
 					"data": `[":reqd"]`,
+					"tags": `["required://:reqd"]`,
 				}),
 			},
 		},
@@ -1274,8 +1534,17 @@ filegroup {
 			description:                "Required via arch into data test",
 			moduleTypeUnderTest:        "python_library",
 			moduleTypeUnderTestFactory: python.PythonLibraryFactory,
-			blueprint: simpleModuleDoNotConvertBp2build("python_library", "reqdx86") +
-				simpleModuleDoNotConvertBp2build("python_library", "reqdarm") + `
+			blueprint: `
+python_library {
+    name: "reqdx86",
+    bazel_module: { bp2build_available: true },
+}
+
+python_library {
+    name: "reqdarm",
+    bazel_module: { bp2build_available: true },
+}
+
 python_library {
     name: "fg_foo",
     arch: {
@@ -1289,6 +1558,12 @@ python_library {
     bazel_module: { bp2build_available: true },
 }`,
 			expectedBazelTargets: []string{
+				makeBazelTarget("py_library", "reqdx86", map[string]string{
+					"srcs_version": `"PY3"`,
+				}),
+				makeBazelTarget("py_library", "reqdarm", map[string]string{
+					"srcs_version": `"PY3"`,
+				}),
 				makeBazelTarget("py_library", "fg_foo", map[string]string{
 					"data": `select({
         "//build/bazel/platforms/arch:arm": [":reqdarm"],
@@ -1296,6 +1571,11 @@ python_library {
         "//conditions:default": [],
     })`,
 					"srcs_version": `"PY3"`,
+					"tags": `select({
+        "//build/bazel/platforms/arch:arm": ["required://:reqdarm"],
+        "//build/bazel/platforms/arch:x86": ["required://:reqdx86"],
+        "//conditions:default": [],
+    })`,
 				}),
 			},
 		},
@@ -1307,7 +1587,12 @@ python_library {
 				"data.bin": "",
 				"src.py":   "",
 			},
-			blueprint: simpleModuleDoNotConvertBp2build("python_library", "reqd") + `
+			blueprint: `
+python_library {
+    name: "reqd",
+    bazel_module: { bp2build_available: true },
+}
+
 python_library {
     name: "fg_foo",
     data: ["data.bin"],
@@ -1315,12 +1600,16 @@ python_library {
     bazel_module: { bp2build_available: true },
 }`,
 			expectedBazelTargets: []string{
+				makeBazelTarget("py_library", "reqd", map[string]string{
+					"srcs_version": `"PY3"`,
+				}),
 				makeBazelTarget("py_library", "fg_foo", map[string]string{
 					"data": `[
         "data.bin",
         ":reqd",
     ]`,
 					"srcs_version": `"PY3"`,
+					"tags":         `["required://:reqd"]`,
 				}),
 			},
 		},
@@ -1328,18 +1617,44 @@ python_library {
 			description:                "All props-to-attrs at once together test",
 			moduleTypeUnderTest:        "filegroup",
 			moduleTypeUnderTestFactory: android.FileGroupFactory,
-			blueprint: simpleModuleDoNotConvertBp2build("filegroup", "reqd") + `
+			blueprint: `
+filegroup {
+    name: "reqd",
+    bazel_module: { bp2build_available: true },
+}
+
 filegroup {
     name: "fg_foo",
     required: ["reqd"],
     bazel_module: { bp2build_available: true },
 }`,
 			expectedBazelTargets: []string{
+				makeBazelTarget("filegroup", "reqd", map[string]string{}),
 				makeBazelTarget("filegroup", "fg_foo", map[string]string{
 					"data": `[":reqd"]`,
+					"tags": `["required://:reqd"]`,
 				}),
 			},
 		},
+		{
+			description:                "Unconverted required module test",
+			moduleTypeUnderTest:        "filegroup",
+			moduleTypeUnderTestFactory: android.FileGroupFactory,
+			blueprint: simpleModuleDoNotConvertBp2build("filegroup", "unconverted") + `
+filegroup {
+    name: "reqd",
+    bazel_module: { bp2build_available: true },
+}
+
+filegroup {
+    name: "fg_foo",
+    required: ["reqd", "unconverted"],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{
+				makeBazelTarget("filegroup", "reqd", map[string]string{}),
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -1348,3 +1663,67 @@ filegroup {
 		})
 	}
 }
+
+func TestUnconvertedRequiredDeps(t *testing.T) {
+	bp := `filegroup {
+    name: "reqd",
+    bazel_module: { bp2build_available: true },
+}
+
+filegroup {
+    name: "unconverted",
+    bazel_module: { bp2build_available: false },
+}
+
+filegroup {
+    name: "fg_foo",
+    required: ["reqd", "unconverted", "missing"],
+    bazel_module: { bp2build_available: true },
+}
+
+filegroup {
+    name: "fg_bar",
+    srcs: [":fg_foo"],
+    bazel_module: { bp2build_available: true },
+}
+
+filegroup {
+    name: "fg_baz",
+    required: ["fg_foo"],
+    bazel_module: { bp2build_available: true },
+}`
+
+	config := android.TestConfig(buildDir, nil, bp, nil)
+	ctx := android.NewTestContext(config)
+	ctx.RegisterModuleType("filegroup", android.FileGroupFactory)
+	ctx.RegisterForBazelConversion()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.ResolveDependencies(config)
+	android.FailIfErrored(t, errs)
+
+	codegenCtx := NewCodegenContext(config, *ctx.Context, Bp2Build)
+	res, errs := GenerateBazelTargets(codegenCtx, false)
+	android.FailIfErrored(t, errs)
+
+	expected := map[string]string{
+		"//:reqd":        conversionOutcomeGenerated,
+		"//:unconverted": conversionOutcomeUnconverted,
+		"//:fg_foo":      conversionOutcomeUnconverted,
+		"//:fg_bar":      conversionOutcomeGenerated,
+		// The required module is dropped, so fg_baz is too.
+		"//:fg_baz": conversionOutcomeUnconverted,
+	}
+	android.AssertDeepEquals(t, "module conversion outcomes", expected, res.metrics.moduleConversionOutcomes)
+	android.AssertDeepEquals(t, "modules with unconverted required deps",
+		[]string{
+			`"fg_baz" requires unconverted modules: fg_foo`,
+			`"fg_foo" requires unconverted modules: missing, unconverted`,
+		},
+		android.SortedUniqueStrings(res.metrics.moduleWithUnconvertedRequiredDepsMsgs))
+	// Unconverted required deps aren't reported as unconverted deps, but the modules depending on a
+	// module that is dropped for its unconverted required deps are.
+	android.AssertDeepEquals(t, "modules with unconverted deps",
+		[]string{`"fg_bar" depends on unconverted modules: fg_foo`}, res.metrics.moduleWithUnconvertedDepsMsgs)
+}
@@ -0,0 +1,294 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"android/soong/android"
+)
+
+const (
+	// The header emitted at the top of every BUILD file generated by bp2build.
+	bp2buildFileHeader = `# READ THIS FIRST:
+# This file was automatically generated by bp2build for the Bazel migration project.
+# Feel free to edit or test it, but do *not* check it into your version control system.
+`
+
+	// Emitted after the header of BUILD files that contain both generated and handcrafted targets.
+	// For these, don't hardcode actual content, like package() declarations. Leave that
+	// responsibility to the checked-in BUILD file instead.
+	handcraftedContentNote = `# This file contains generated targets and handcrafted targets that are manually managed in the source tree.`
+
+	// Emitted after the header of fully-generated BUILD files to hardcode the default visibility.
+	defaultPackageVisibility = `package(default_visibility = ["//visibility:public"])`
+)
+
+var (
+	// Matches a single-line load statement whose loaded symbols are all plain strings, e.g.
+	// load("//build/bazel/rules:cc.bzl", "cc_binary", "cc_library")
+	// Load statements with aliased symbols or spanning multiple lines are left untouched.
+	loadStatementRegexp = regexp.MustCompile(`^load\(\s*"([^"]+)"((?:\s*,\s*"[^"]+")+)\s*,?\s*\)\s*$`)
+	loadSymbolRegexp    = regexp.MustCompile(`"([^"]+)"`)
+)
+
+// buildFileBuilder assembles the contents of a single generated BUILD file. It collects the load
+// statements required by the rule classes of its targets, deduplicates them against the load
+// statements of any appended handcrafted content, and emits the targets in a deterministic order
+// regardless of the order in which they were added.
+type buildFileBuilder struct {
+	// Map of .bzl file label to the symbols loaded from it.
+	loads   map[string][]string
+	targets BazelTargets
+}
+
+func newBuildFileBuilder() *buildFileBuilder {
+	return &buildFileBuilder{
+		loads: make(map[string][]string),
+	}
+}
+
+// addLoad records that the given symbols need to be loaded from the bzl file.
+func (b *buildFileBuilder) addLoad(bzl string, symbols ...string) {
+	b.loads[bzl] = append(b.loads[bzl], symbols...)
+}
+
+// addTargets adds targets to the BUILD file, recording the loads required by their rule classes.
+// Simple load statements within handcrafted content are hoisted into the generated load statements
+// so that they are not duplicated.
+func (b *buildFileBuilder) addTargets(targets ...BazelTarget) {
+	for _, t := range targets {
+		if t.handcrafted {
+			t.content = b.hoistLoadStatements(t.content)
+		} else if t.IsLoadedFromStarlark() {
+			b.addLoad(t.bzlLoadLocation, t.ruleClass)
+		}
+		b.targets = append(b.targets, t)
+	}
+}
+
+// hoistLoadStatements records the load statements in content and returns content without them.
+func (b *buildFileBuilder) hoistLoadStatements(content string) string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if match := loadStatementRegexp.FindStringSubmatch(line); match != nil {
+			for _, symbol := range loadSymbolRegexp.FindAllStringSubmatch(match[2], -1) {
+				b.addLoad(match[1], symbol[1])
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// loadStatements returns the sorted and deduplicated load statements of the BUILD file.
+func (b *buildFileBuilder) loadStatements() string {
+	var loadStatements []string
+	for _, bzl := range android.SortedStringKeys(b.loads) {
+		symbols := android.SortedUniqueStrings(b.loads[bzl])
+		quoted := make([]string, 0, len(symbols))
+		for _, symbol := range symbols {
+			quoted = append(quoted, "\""+symbol+"\"")
+		}
+		loadStatements = append(loadStatements, "load(\""+bzl+"\", "+strings.Join(quoted, ", ")+")")
+	}
+	return strings.Join(loadStatements, "\n")
+}
+
+// String returns the full contents of the BUILD file: the header, the load statements and the
+// sorted targets.
+func (b *buildFileBuilder) String() string {
+	b.targets.sort()
+
+	content := bp2buildFileHeader
+	if b.targets.hasHandcraftedTargets() {
+		content += handcraftedContentNote
+	} else {
+		content += defaultPackageVisibility
+	}
+	content += "\n"
+	content += b.loadStatements()
+	content += "\n\n"
+	content += b.targets.String()
+	return content
+}
+
+// extractHandcraftedTargets returns the targets of a handcrafted BUILD file with the given names
+// and the local targets they reference, preceded by the load statements of the symbols they use.
+// Loads shared by several of the targets are merged into a single load statement per bzl file, as
+// Bazel rejects BUILD files that load the same symbol more than once. It returns an error if the
+// BUILD file contains anything besides load statements and targets, e.g. a package() or
+// exports_files() declaration, as the extracted targets may depend on it.
+func extractHandcraftedTargets(content string, names []string) (string, error) {
+	loads := newBuildFileBuilder()
+	var otherLoads []string
+	targets := make(map[string]string)
+	var order []string
+
+	statements := splitStarlarkStatements(content)
+	for _, statement := range statements {
+		if strings.HasPrefix(statement, "load(") {
+			continue
+		}
+		match := targetNameRegexp.FindStringSubmatch(statement)
+		if match == nil {
+			return "", fmt.Errorf("statement %q is neither a load statement nor a target", firstLine(statement))
+		}
+		if _, exists := targets[match[1]]; exists {
+			return "", fmt.Errorf("target %q is defined more than once", match[1])
+		}
+		targets[match[1]] = statement
+		order = append(order, match[1])
+	}
+
+	// Add the wanted targets and, transitively, the local targets they reference.
+	wanted := make(map[string]bool, len(names))
+	queue := append([]string(nil), names...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if wanted[name] {
+			continue
+		}
+		statement, exists := targets[name]
+		if !exists {
+			return "", fmt.Errorf("target %q not found", name)
+		}
+		wanted[name] = true
+		for _, other := range order {
+			if !wanted[other] && referencesTarget(statement, other) {
+				queue = append(queue, other)
+			}
+		}
+	}
+
+	var extractedTargets []string
+	for _, name := range order {
+		if wanted[name] {
+			extractedTargets = append(extractedTargets, targets[name])
+		}
+	}
+	extracted := strings.Join(extractedTargets, "\n\n")
+
+	for _, statement := range statements {
+		if !strings.HasPrefix(statement, "load(") {
+			continue
+		}
+		// Collapse multi-line load statements so that they can be parsed like single-line ones.
+		line := strings.Join(strings.Fields(statement), " ")
+		if match := loadStatementRegexp.FindStringSubmatch(line); match != nil {
+			for _, symbol := range loadSymbolRegexp.FindAllStringSubmatch(match[2], -1) {
+				if usesSymbol(extracted, symbol[1]) {
+					loads.addLoad(match[1], symbol[1])
+				}
+			}
+		} else if !android.InList(statement, otherLoads) {
+			// Load statements with aliased symbols are kept as is.
+			otherLoads = append(otherLoads, statement)
+		}
+	}
+
+	var sections []string
+	if l := loads.loadStatements(); l != "" {
+		sections = append(sections, l)
+	}
+	sections = append(sections, otherLoads...)
+	if len(sections) == 0 {
+		return extracted, nil
+	}
+	return strings.Join(sections, "\n") + "\n\n" + extracted, nil
+}
+
+// referencesTarget returns true if the target statement references the target with the given name
+// in the same package, by a label like ":name" or "name".
+func referencesTarget(statement, name string) bool {
+	return strings.Contains(statement, `":`+name+`"`) || strings.Contains(statement, `"`+name+`"`)
+}
+
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+var targetNameRegexp = regexp.MustCompile(`^\w+\((?s:.*?)\bname\s*=\s*"([^"]+)"`)
+
+// usesSymbol returns true if the symbol is referenced as an identifier in content.
+func usesSymbol(content, symbol string) bool {
+	return regexp.MustCompile(`\b` + regexp.QuoteMeta(symbol) + `\b`).MatchString(content)
+}
+
+// splitStarlarkStatements splits the contents of a BUILD file into its top-level statements,
+// dropping blank lines and comments between them. A statement spans multiple lines as long as
+// it has unclosed brackets, ignoring brackets within strings and comments.
+func splitStarlarkStatements(content string) []string {
+	var statements []string
+	var current strings.Builder
+	depth := 0
+	var quote string
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != "":
+			if c == '\\' && i+1 < len(content) {
+				current.WriteByte(c)
+				i++
+				c = content[i]
+			} else if strings.HasPrefix(content[i:], quote) {
+				current.WriteString(quote)
+				i += len(quote) - 1
+				quote = ""
+				continue
+			}
+		case c == '"' || c == '\'':
+			quote = string(c)
+			if strings.HasPrefix(content[i:], strings.Repeat(quote, 3)) {
+				quote = strings.Repeat(quote, 3)
+			}
+			current.WriteString(quote)
+			i += len(quote) - 1
+			continue
+		case c == '#':
+			// Skip the comment up to the end of the line.
+			for i+1 < len(content) && content[i+1] != '\n' {
+				i++
+			}
+			s := strings.TrimRight(current.String(), " \t")
+			current.Reset()
+			current.WriteString(s)
+			continue
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == '\n' && depth == 0:
+			if s := strings.TrimSpace(current.String()); s != "" {
+				statements = append(statements, s)
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if s := strings.TrimSpace(current.String()); s != "" {
+		statements = append(statements, s)
+	}
+	return statements
+}
@@ -0,0 +1,251 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"strings"
+	"testing"
+)
+
+var (
+	ccBinaryTarget = BazelTarget{
+		name:            "foo",
+		ruleClass:       "cc_binary",
+		bzlLoadLocation: "//build/bazel/rules:cc.bzl",
+		content:         `cc_binary(name = "foo")`,
+	}
+	ccObjectTarget = BazelTarget{
+		name:            "foo_obj",
+		ruleClass:       "cc_object",
+		bzlLoadLocation: "//build/bazel/rules:cc_object.bzl",
+		content:         `cc_object(name = "foo_obj")`,
+	}
+	ccLibraryTarget = BazelTarget{
+		name:            "libbar",
+		ruleClass:       "cc_library",
+		bzlLoadLocation: "//build/bazel/rules:cc.bzl",
+		content:         `cc_library(name = "libbar")`,
+	}
+	filegroupTarget = BazelTarget{
+		name:      "bp2build_all_srcs",
+		ruleClass: "filegroup",
+		content:   `filegroup(name = "bp2build_all_srcs", srcs = glob(["**/*"]))`,
+	}
+	handcraftedTarget = BazelTarget{
+		content: `load("//build/bazel/rules:cc.bzl", "cc_library")
+load("//build/bazel/rules:sh.bzl", "sh_binary")
+load("//build/bazel/rules:aliased.bzl", alias = "symbol")
+
+cc_library(name = "handcrafted")`,
+		handcrafted: true,
+	}
+)
+
+func TestBuildFileBuilder(t *testing.T) {
+	testCases := []struct {
+		description string
+		targets     []BazelTarget
+		expected    string
+	}{
+		{
+			description: "generated targets only",
+			targets:     []BazelTarget{ccLibraryTarget, filegroupTarget, ccObjectTarget, ccBinaryTarget},
+			expected: bp2buildFileHeader + defaultPackageVisibility + `
+load("//build/bazel/rules:cc.bzl", "cc_binary", "cc_library")
+load("//build/bazel/rules:cc_object.bzl", "cc_object")
+
+filegroup(name = "bp2build_all_srcs", srcs = glob(["**/*"]))
+
+cc_binary(name = "foo")
+
+cc_object(name = "foo_obj")
+
+cc_library(name = "libbar")`,
+		},
+		{
+			description: "generated and handcrafted targets",
+			targets:     []BazelTarget{handcraftedTarget, ccLibraryTarget, ccBinaryTarget},
+			expected: bp2buildFileHeader + handcraftedContentNote + `
+load("//build/bazel/rules:cc.bzl", "cc_binary", "cc_library")
+load("//build/bazel/rules:sh.bzl", "sh_binary")
+
+cc_binary(name = "foo")
+
+cc_library(name = "libbar")
+
+# -----------------------------
+# Section: Handcrafted targets. ` + `
+# -----------------------------
+
+load("//build/bazel/rules:aliased.bzl", alias = "symbol")
+
+cc_library(name = "handcrafted")`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			// The output must not depend on the order in which targets are added.
+			for i := range tc.targets {
+				targets := append(append([]BazelTarget{}, tc.targets[i:]...), tc.targets[:i]...)
+				buildFile := newBuildFileBuilder()
+				buildFile.addTargets(targets...)
+				if actual := buildFile.String(); actual != tc.expected {
+					t.Errorf("Rotation %d: expected BUILD file:\n%s\n\ngot:\n%s", i, tc.expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractHandcraftedTargets(t *testing.T) {
+	content := `load("//build/bazel/rules:cc.bzl", "cc_binary", "cc_library")
+load(
+    "//build/bazel/rules:sh.bzl",
+    "sh_binary",
+)
+
+# A library shared by the tests.
+cc_library(
+    name = "libfoo",
+    srcs = ["foo.cpp"],  # Not ")" or "]".
+)
+
+sh_binary(
+    name = "script",
+    srcs = ["script.sh"],
+)
+
+cc_library(
+    name = "libbar",
+    deps = [":libfoo"],
+)`
+
+	testCases := []struct {
+		description string
+		content     string
+		names       []string
+		expected    string
+		loads       int
+		err         string
+	}{
+		{
+			description: "targets sharing a load",
+			names:       []string{"libfoo", "libbar"},
+			loads:       1,
+			expected: `load("//build/bazel/rules:cc.bzl", "cc_library")
+
+cc_library(
+    name = "libfoo",
+    srcs = ["foo.cpp"],
+)
+
+cc_library(
+    name = "libbar",
+    deps = [":libfoo"],
+)`,
+		},
+		{
+			description: "targets with different loads",
+			names:       []string{"script", "libfoo"},
+			loads:       2,
+			expected: `load("//build/bazel/rules:cc.bzl", "cc_library")
+load("//build/bazel/rules:sh.bzl", "sh_binary")
+
+cc_library(
+    name = "libfoo",
+    srcs = ["foo.cpp"],
+)
+
+sh_binary(
+    name = "script",
+    srcs = ["script.sh"],
+)`,
+		},
+		{
+			description: "local dependency of a target",
+			names:       []string{"libbar"},
+			loads:       1,
+			expected: `load("//build/bazel/rules:cc.bzl", "cc_library")
+
+cc_library(
+    name = "libfoo",
+    srcs = ["foo.cpp"],
+)
+
+cc_library(
+    name = "libbar",
+    deps = [":libfoo"],
+)`,
+		},
+		{
+			description: "missing target",
+			names:       []string{"libfoo", "libbaz"},
+			err:         `target "libbaz" not found`,
+		},
+		{
+			description: "package declaration",
+			content: `load("//build/bazel/rules:cc.bzl", "cc_library")
+
+package(default_visibility = ["//visibility:private"])
+
+cc_library(
+    name = "libhelper",
+)
+
+cc_library(
+    name = "libfoo",
+    deps = [":libhelper"],
+)`,
+			names: []string{"libfoo"},
+			err:   `statement "package(default_visibility = [\"//visibility:private\"])" is neither a load statement nor a target`,
+		},
+		{
+			description: "exports_files declaration",
+			content: `cc_library(
+    name = "libfoo",
+)
+
+exports_files(["foo.txt"])`,
+			names: []string{"libfoo"},
+			err:   `statement "exports_files([\"foo.txt\"])" is neither a load statement nor a target`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			c := content
+			if tc.content != "" {
+				c = tc.content
+			}
+			actual, err := extractHandcraftedTargets(c, tc.names)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected extracted targets:\n%s\n\ngot:\n%s", tc.expected, actual)
+			}
+			if count := strings.Count(actual, "load("); count != tc.loads {
+				t.Errorf("expected %d load statements, got %d", tc.loads, count)
+			}
+		})
+	}
+}
@@ -359,6 +359,92 @@ cc_library_shared {
 	})
 }
 
+func TestCcLibrarySharedSanitizeBlocklist(t *testing.T) {
+	runCcLibrarySharedTestCase(t, bp2buildTestCase{
+		description: "cc_library_shared sanitize blocklist",
+		filesystem: map[string]string{
+			"impl.cpp":      "",
+			"blocklist.txt": "",
+		},
+		blueprint: soongCcLibrarySharedPreamble + `
+cc_library_shared {
+    name: "foo_shared",
+    srcs: ["impl.cpp"],
+    sanitize: {
+        integer_overflow: true,
+        blocklist: "blocklist.txt",
+    },
+    include_build_directory: false,
+}`,
+		expectedBazelTargets: []string{
+			makeBazelTarget("cc_library_shared", "foo_shared", attrNameToString{
+				"additional_compiler_inputs": `["blocklist.txt"]`,
+				"copts":                      `["-fsanitize-ignorelist=$(location blocklist.txt)"]`,
+				"features":                   `["ubsan_integer_overflow"]`,
+				"srcs":                       `["impl.cpp"]`,
+			}),
+		},
+	})
+}
+
+func TestCcLibrarySharedSanitizeBlocklistWithoutSanitizer(t *testing.T) {
+	runCcLibrarySharedTestCase(t, bp2buildTestCase{
+		description: "cc_library_shared sanitize blocklist without an enabled sanitizer",
+		filesystem: map[string]string{
+			"impl.cpp":      "",
+			"blocklist.txt": "",
+		},
+		blueprint: soongCcLibrarySharedPreamble + `
+cc_library_shared {
+    name: "foo_shared",
+    srcs: ["impl.cpp"],
+    sanitize: {
+        blocklist: "blocklist.txt",
+    },
+    include_build_directory: false,
+}`,
+		expectedBazelTargets: []string{
+			makeBazelTarget("cc_library_shared", "foo_shared", attrNameToString{
+				"srcs": `["impl.cpp"]`,
+			}),
+		},
+	})
+}
+
+func TestCcLibrarySharedSanitizeDiag(t *testing.T) {
+	runCcLibrarySharedTestCase(t, bp2buildTestCase{
+		description: "cc_library_shared sanitize diag",
+		filesystem: map[string]string{
+			"impl.cpp": "",
+		},
+		blueprint: soongCcLibrarySharedPreamble + `
+cc_library_shared {
+    name: "foo_shared",
+    srcs: ["impl.cpp"],
+    sanitize: {
+        undefined: true,
+        misc_undefined: ["bounds"],
+        diag: {
+            undefined: true,
+            misc_undefined: ["bounds"],
+        },
+    },
+    include_build_directory: false,
+}`,
+		expectedBazelTargets: []string{
+			makeBazelTarget("cc_library_shared", "foo_shared", attrNameToString{
+				"features": `[
+        "ubsan_undefined",
+        "ubsan_bounds",
+        "diag_ubsan_undefined",
+        "diag_ubsan_bounds",
+    ]`,
+				"srcs": `["impl.cpp"]`,
+			}),
+		},
+	})
+}
+
 func TestCcLibrarySharedNoCrtTrue(t *testing.T) {
 	runCcLibrarySharedTestCase(t, bp2buildTestCase{
 		description: "cc_library_shared - nocrt: true emits attribute",
@@ -496,3 +582,59 @@ cc_library_shared {
 	},
 	)
 }
+
+func TestCcLibrarySharedVndk(t *testing.T) {
+	runCcLibrarySharedTestCase(t, bp2buildTestCase{
+		description: "cc_library_shared vndk",
+		blueprint: soongCcLibrarySharedPreamble + `
+cc_library_shared {
+    name: "libvndk",
+    vendor_available: true,
+    product_available: true,
+    vndk: {
+        enabled: true,
+    },
+    include_build_directory: false,
+}
+
+cc_library_shared {
+    name: "libvndk_sp_private",
+    vendor_available: false,
+    vndk: {
+        enabled: true,
+        support_system_process: true,
+        private: true,
+    },
+    include_build_directory: false,
+}
+
+cc_library_shared {
+    name: "android.hardware.authsecret-V1-ndk",
+    vendor_available: true,
+    vndk: {
+        enabled: true,
+    },
+    include_build_directory: false,
+}
+`,
+		expectedBazelTargets: []string{
+			makeBazelTarget("cc_library_shared", "android.hardware.authsecret-V1-ndk", attrNameToString{
+				"must_use_vendor_variant": "True",
+				"vendor_available":        "True",
+				"vndk":                    "True",
+			}),
+			makeBazelTarget("cc_library_shared", "libvndk", attrNameToString{
+				"product_available": "True",
+				"vendor_available":  "True",
+				"vndk":              "True",
+			}),
+			makeBazelTarget("cc_library_shared", "libvndk_sp_private", attrNameToString{
+				"must_use_vendor_variant":     "True",
+				"vendor_available":            "False",
+				"vndk":                        "True",
+				"vndk_private":                "True",
+				"vndk_support_system_process": "True",
+			}),
+		},
+	})
+}
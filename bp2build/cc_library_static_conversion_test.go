@@ -1489,3 +1489,131 @@ func TestCcLibraryStaticStdInFlags(t *testing.T) {
 		},
 	})
 }
+
+func TestCcLibraryStaticAttributeOverrides(t *testing.T) {
+	runCcLibraryStaticTestCase(t, bp2buildTestCase{
+		description: "cc_library_static attribute overrides replace copts and add tags",
+		blueprint: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["common.c"],
+    cflags: ["-Wall"],
+    bazel_module: {
+        attribute_overrides: [
+            {
+                name: "copts",
+                value: ["-Wextra"],
+            },
+            {
+                name: "tags",
+                value: ["manual"],
+            },
+        ],
+    },
+    include_build_directory: false,
+}`,
+		expectedBazelTargets: []string{
+			makeBazelTarget("cc_library_static", "foo_static", attrNameToString{
+				"copts":  `["-Wextra"]`,
+				"srcs_c": `["common.c"]`,
+				"tags":   `["manual"]`,
+			}),
+		},
+	})
+}
+
+func TestCcLibraryStaticBoolAttributeOverrides(t *testing.T) {
+	runCcLibraryStaticTestCase(t, bp2buildTestCase{
+		description: "cc_library_static bool attribute overrides",
+		blueprint: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["common.c"],
+    bazel_module: {
+        bool_attribute_overrides: [
+            {
+                name: "rtti",
+                value: true,
+            },
+            {
+                name: "testonly",
+                value: true,
+            },
+        ],
+    },
+    include_build_directory: false,
+}`,
+		expectedBazelTargets: []string{
+			makeBazelTarget("cc_library_static", "foo_static", attrNameToString{
+				"rtti":     `True`,
+				"srcs_c":   `["common.c"]`,
+				"testonly": `True`,
+			}),
+		},
+	})
+}
+
+func TestCcLibraryStaticWholeAndNonWholeUsers(t *testing.T) {
+	runCcLibraryStaticTestCase(t, bp2buildTestCase{
+		description: "cc_library_static used as both whole_static_libs and static_libs",
+		blueprint: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "lib_foo",
+    srcs: ["foo.c"],
+    include_build_directory: false,
+}
+
+cc_library_static {
+    name: "static_user",
+    static_libs: ["lib_foo"],
+    include_build_directory: false,
+}
+
+cc_library_static {
+    name: "whole_user",
+    whole_static_libs: ["lib_foo"],
+    include_build_directory: false,
+}`,
+		expectedBazelTargets: []string{
+			makeBazelTarget("cc_library_static", "lib_foo", attrNameToString{
+				"srcs_c": `["foo.c"]`,
+			}),
+			makeBazelTarget("cc_library_static", "lib_foo_alwayslink", attrNameToString{
+				"alwayslink":         `True`,
+				"whole_archive_deps": `[":lib_foo"]`,
+			}),
+			makeBazelTarget("cc_library_static", "static_user", attrNameToString{
+				"implementation_deps": `[":lib_foo"]`,
+			}),
+			makeBazelTarget("cc_library_static", "whole_user", attrNameToString{
+				"whole_archive_deps": `[":lib_foo_alwayslink"]`,
+			}),
+		},
+	})
+}
+
+func TestCcLibraryStaticWholeUsersOnly(t *testing.T) {
+	runCcLibraryStaticTestCase(t, bp2buildTestCase{
+		description: "cc_library_static used only as whole_static_libs has no alwayslink target",
+		blueprint: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "lib_foo",
+    srcs: ["foo.c"],
+    include_build_directory: false,
+}
+
+cc_library_static {
+    name: "whole_user",
+    whole_static_libs: ["lib_foo"],
+    include_build_directory: false,
+}`,
+		expectedBazelTargets: []string{
+			makeBazelTarget("cc_library_static", "lib_foo", attrNameToString{
+				"srcs_c": `["foo.c"]`,
+			}),
+			makeBazelTarget("cc_library_static", "whole_user", attrNameToString{
+				"whole_archive_deps": `[":lib_foo"]`,
+			}),
+		},
+	})
+}
@@ -14,6 +14,8 @@
 
 package bp2build
 
+import "android/soong/android"
+
 var (
 	// When both a BUILD and BUILD.bazel file are exist in the same package, the BUILD.bazel file will
 	// be preferred for use within a Bazel build.
@@ -22,7 +24,10 @@ var (
 	GeneratedBuildFileName = "BUILD.bazel"
 
 	// The file name used for hand-crafted build targets.
-	// NOTE: It is okay that this matches GeneratedBuildFileName, since we generate BUILD files in a different directory to source files
-	// FIXME: Because there are hundreds of existing BUILD.bazel files in the AOSP tree, we should pick another name here, like BUILD.android
-	HandcraftedBuildFileName = "BUILD.bazel"
+	HandcraftedBuildFileName = android.HandcraftedBuildFileName
+
+	// The file name used for stubs pointing to existing BUILD files, written in place of generated
+	// BUILD files when BP2BUILD_EXISTING_BUILD_FILE_STUBS is set. It must not be a BUILD file name,
+	// as it is merged with the existing BUILD file into the same directory of the workspace.
+	ExistingBuildFileStubName = "EXISTING_BUILD_FILE"
 )
@@ -7,8 +7,10 @@ import (
 	"strings"
 
 	"android/soong/android"
+	"android/soong/bazel"
 	cc_config "android/soong/cc/config"
 	java_config "android/soong/java/config"
+	"android/soong/starlark_fmt"
 
 	"github.com/google/blueprint/proptools"
 )
@@ -29,6 +31,10 @@ func CreateSoongInjectionFiles(cfg android.Config, metrics CodegenMetrics) []Baz
 	files = append(files, newFile("java_toolchain", "constants.bzl", java_config.BazelJavaToolchainVars(cfg)))
 
 	files = append(files, newFile("metrics", "converted_modules.txt", strings.Join(metrics.convertedModules, "\n")))
+	files = append(files, newFile("metrics", "convertible_module_types.txt", strings.Join(android.Bp2buildConvertibleModuleTypes(), "\n")))
+
+	files = append(files, newFile("bp2build_config", GeneratedBuildFileName, "")) // Creates a //bp2build_config package.
+	files = append(files, newFile("bp2build_config", "bp2build_config.bzl", bp2buildConfigBzl(android.StarlarkBp2buildAllowlist(), metrics)))
 
 	files = append(files, newFile("product_config", "soong_config_variables.bzl", cfg.Bp2buildSoongConfigDefinitions.String()))
 
@@ -45,6 +51,22 @@ func CreateSoongInjectionFiles(cfg android.Config, metrics CodegenMetrics) []Baz
 	return files
 }
 
+// bp2buildConfigBzl returns the contents of a .bzl file exposing the given Starlark representation
+// of the bp2build allowlists, together with the conversion outcome of each module, to Bazel-side
+// tooling.
+func bp2buildConfigBzl(allowlist string, metrics CodegenMetrics) string {
+	outcomes := make(map[string]string, len(metrics.moduleConversionOutcomes))
+	for label, outcome := range metrics.moduleConversionOutcomes {
+		outcomes[label] = `"` + outcome + `"`
+	}
+	return fmt.Sprintf(bazel.GeneratedBazelFileWarning+`
+%s
+_module_conversion_outcomes = %s
+
+module_conversion_outcomes = _module_conversion_outcomes
+`, allowlist, starlark_fmt.PrintDict(outcomes, 0))
+}
+
 func convertedModules(convertedModules []string) string {
 	return strings.Join(convertedModules, "\n")
 }
@@ -82,44 +104,51 @@ func CreateBazelFiles(
 func createBuildFiles(buildToTargets map[string]BazelTargets, mode CodegenMode) []BazelFile {
 	files := make([]BazelFile, 0, len(buildToTargets))
 	for _, dir := range android.SortedStringKeys(buildToTargets) {
-		if mode == Bp2Build && android.ShouldKeepExistingBuildFileForDir(dir) {
+		if mode == Bp2Build && !android.EffectiveShouldWriteBuildFile(dir) {
 			fmt.Printf("[bp2build] Not writing generated BUILD file for dir: '%s'\n", dir)
 			continue
 		}
 		targets := buildToTargets[dir]
-		targets.sort()
 
 		var content string
 		if mode == Bp2Build {
-			content = `# READ THIS FIRST:
-# This file was automatically generated by bp2build for the Bazel migration project.
-# Feel free to edit or test it, but do *not* check it into your version control system.
-`
-			if targets.hasHandcraftedTargets() {
-				// For BUILD files with both handcrafted and generated targets,
-				// don't hardcode actual content, like package() declarations.
-				// Leave that responsibility to the checked-in BUILD file
-				// instead.
-				content += `# This file contains generated targets and handcrafted targets that are manually managed in the source tree.`
-			} else {
-				// For fully-generated BUILD files, hardcode the default visibility.
-				content += "package(default_visibility = [\"//visibility:public\"])"
-			}
-			content += "\n"
-			content += targets.LoadStatements()
+			buildFile := newBuildFileBuilder()
+			buildFile.addTargets(targets...)
+			content = buildFile.String()
 		} else if mode == QueryView {
-			content = soongModuleLoad
-		}
-		if content != "" {
-			// If there are load statements, add a couple of newlines.
-			content += "\n\n"
+			targets.sort()
+			content = soongModuleLoad + "\n\n" + targets.String()
+		} else {
+			targets.sort()
+			content = targets.String()
 		}
-		content += targets.String()
 		files = append(files, newFile(dir, GeneratedBuildFileName, content))
 	}
 	return files
 }
 
+// createExistingBuildFileStubs returns a stub file for each directory whose existing BUILD file is
+// kept instead of a generated one, so that tooling reading the generated tree can find the BUILD
+// file that is used for it.
+func createExistingBuildFileStubs(config android.Config, buildToTargets map[string]BazelTargets) []BazelFile {
+	var files []BazelFile
+	for _, dir := range android.SortedStringKeys(buildToTargets) {
+		if !config.Bp2buildKeepExistingBuildFile(dir) {
+			continue
+		}
+		pkg := dir
+		if pkg == "." {
+			pkg = ""
+		}
+		content := fmt.Sprintf(`%s
+# bp2build does not generate a BUILD file for this directory, the existing BUILD file is used:
+//%s
+`, bazel.GeneratedBazelFileWarning, pkg)
+		files = append(files, newFile(dir, ExistingBuildFileStubName, content))
+	}
+	return files
+}
+
 func newFile(dir, basename, content string) BazelFile {
 	return BazelFile{
 		Dir:      dir,
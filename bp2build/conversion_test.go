@@ -16,6 +16,7 @@ package bp2build
 
 import (
 	"sort"
+	"strings"
 	"testing"
 
 	"android/soong/android"
@@ -106,6 +107,18 @@ func TestCreateBazelFiles_Bp2Build_CreatesDefaultFiles(t *testing.T) {
 			dir:      "metrics",
 			basename: "converted_modules.txt",
 		},
+		{
+			dir:      "metrics",
+			basename: "convertible_module_types.txt",
+		},
+		{
+			dir:      "bp2build_config",
+			basename: GeneratedBuildFileName,
+		},
+		{
+			dir:      "bp2build_config",
+			basename: "bp2build_config.bzl",
+		},
 		{
 			dir:      "product_config",
 			basename: "soong_config_variables.bzl",
@@ -140,3 +153,95 @@ func TestCreateBazelFiles_Bp2Build_CreatesDefaultFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestBp2buildConfigBzl(t *testing.T) {
+	metrics := CodegenMetrics{}
+	metrics.addModuleConversionOutcome(".", "foo", conversionOutcomeGenerated)
+	metrics.addModuleConversionOutcome("dir", "bar", conversionOutcomeGenerated)
+	metrics.addModuleConversionOutcome("dir", "prebuilt_bar", conversionOutcomeHandcrafted)
+	metrics.addModuleConversionOutcome("dir", "prebuilt_lib foo", conversionOutcomeUnconverted)
+	metrics.addModuleConversionOutcome("dir/sub", "baz", conversionOutcomeUnconverted)
+
+	expected := `# GENERATED FOR BAZEL FROM SOONG. DO NOT EDIT.
+
+_allowlist = {}
+
+_module_conversion_outcomes = {
+    "//:foo": "generated",
+    "//dir/sub:baz": "unconverted",
+    "//dir:bar": "generated",
+    "//dir:prebuilt_bar": "handcrafted",
+    "//dir:prebuilt_lib_20_foo": "unconverted",
+}
+
+module_conversion_outcomes = _module_conversion_outcomes
+`
+	android.AssertStringEquals(t, "bp2build_config.bzl", expected,
+		bp2buildConfigBzl("\n_allowlist = {}\n", metrics))
+}
+
+func TestCreateSoongInjectionFiles_ConvertibleModuleTypes(t *testing.T) {
+	testConfig := android.TestConfig("", make(map[string]string), "", make(map[string][]byte))
+	files := CreateSoongInjectionFiles(testConfig, CodegenMetrics{})
+
+	var contents *string
+	for i := range files {
+		if files[i].Dir == "metrics" && files[i].Basename == "convertible_module_types.txt" {
+			contents = &files[i].Contents
+		}
+	}
+	if contents == nil {
+		t.Fatalf("Expected metrics/convertible_module_types.txt to be created")
+	}
+
+	moduleTypes := strings.Split(*contents, "\n")
+	android.AssertDeepEquals(t, "convertible module types", android.Bp2buildConvertibleModuleTypes(), moduleTypes)
+	for _, moduleType := range []string{"cc_library", "filegroup", "genrule"} {
+		android.AssertStringListContains(t, "convertible module types", moduleTypes, moduleType)
+	}
+}
+
+func TestCreateExistingBuildFileStubs(t *testing.T) {
+	config := android.TestConfig("", nil, "", nil)
+	ctx := android.NewTestContext(config)
+	ctx.RegisterBp2BuildConfig(android.NewBp2BuildAllowlist().SetKeepExistingBuildFile(map[string]bool{
+		"handwritten": true,
+	}))
+
+	buildToTargets := map[string]BazelTargets{
+		"handwritten":     nil,
+		"handwritten/sub": nil,
+		"generated":       nil,
+	}
+	files := createExistingBuildFileStubs(config, buildToTargets)
+
+	expected := []BazelFile{
+		{
+			Dir:      "handwritten",
+			Basename: ExistingBuildFileStubName,
+			Contents: `# GENERATED FOR BAZEL FROM SOONG. DO NOT EDIT.
+# bp2build does not generate a BUILD file for this directory, the existing BUILD file is used:
+//handwritten
+`,
+		},
+		{
+			Dir:      "handwritten/sub",
+			Basename: ExistingBuildFileStubName,
+			Contents: `# GENERATED FOR BAZEL FROM SOONG. DO NOT EDIT.
+# bp2build does not generate a BUILD file for this directory, the existing BUILD file is used:
+//handwritten/sub
+`,
+		},
+	}
+	android.AssertDeepEquals(t, "existing BUILD file stubs", expected, files)
+}
+
+func TestExistingBuildFileStubsMode(t *testing.T) {
+	config := android.TestConfig("", nil, "", nil)
+	ctx := NewCodegenContext(config, android.Context{}, Bp2Build)
+	android.AssertBoolEquals(t, "stubs disabled by default", false, ctx.existingBuildFileStubs)
+
+	config = android.TestConfig("", map[string]string{"BP2BUILD_EXISTING_BUILD_FILE_STUBS": "true"}, "", nil)
+	ctx = NewCodegenContext(config, android.Context{}, Bp2Build)
+	android.AssertBoolEquals(t, "stubs enabled by environment", true, ctx.existingBuildFileStubs)
+}
@@ -56,3 +56,132 @@ filegroup {
 		expectedErr: fmt.Errorf("filegroup 'foo' cannot contain a file with the same name"),
 	})
 }
+
+func TestFilegroupUnknownAttributeOverride(t *testing.T) {
+	runFilegroupTestCase(t, bp2buildTestCase{
+		description: "filegroup - attribute override of an attribute the rule doesn't have",
+		filesystem:  map[string]string{},
+		blueprint: `
+filegroup {
+    name: "fg_foo",
+    srcs: ["a.txt"],
+    bazel_module: {
+        attribute_overrides: [{
+            name: "copts",
+            value: ["-Wall"],
+        }],
+    },
+}
+`,
+		expectedErr: fmt.Errorf(`bazel_module.attribute_overrides: unknown attribute "copts" for rule class "filegroup"`),
+	})
+}
+
+func TestFilegroupAttributeOverrideOfTheWrongKind(t *testing.T) {
+	runFilegroupTestCase(t, bp2buildTestCase{
+		description: "filegroup - bool attribute override of a string list attribute",
+		filesystem:  map[string]string{},
+		blueprint: `
+filegroup {
+    name: "fg_foo",
+    srcs: ["a.txt"],
+    bazel_module: {
+        bool_attribute_overrides: [{
+            name: "tags",
+            value: true,
+        }],
+    },
+}
+`,
+		expectedErr: fmt.Errorf(`bazel_module.bool_attribute_overrides: attribute "tags" of rule class "filegroup" must be overridden with a string list`),
+	})
+}
+
+func TestFilegroupDuplicateAttributeOverride(t *testing.T) {
+	runFilegroupTestCase(t, bp2buildTestCase{
+		description: "filegroup - attribute overridden twice",
+		filesystem:  map[string]string{},
+		blueprint: `
+filegroup {
+    name: "fg_foo",
+    srcs: ["a.txt"],
+    bazel_module: {
+        attribute_overrides: [
+            {
+                name: "tags",
+                value: ["manual"],
+            },
+            {
+                name: "tags",
+                value: ["no-remote"],
+            },
+        ],
+    },
+}
+`,
+		expectedErr: fmt.Errorf(`bazel_module.attribute_overrides: attribute "tags" is overridden more than once`),
+	})
+}
+
+func TestFilegroupSrcsInOtherPackage(t *testing.T) {
+	filesystem := map[string]string{
+		"app/Android.bp": `
+filegroup {
+    name: "app_srcs",
+    srcs: ["main.cpp", "../common/foo.cpp"],
+}`,
+		"common/Android.bp": `
+filegroup {
+    name: "common_srcs",
+    srcs: ["bar.cpp"],
+}`,
+	}
+
+	runFilegroupTestCase(t, bp2buildTestCase{
+		description: "filegroup - srcs in other package use an absolute label",
+		filesystem:  filesystem,
+		dir:         "app",
+		expectedBazelTargets: []string{
+			makeBazelTarget("filegroup", "app_srcs", attrNameToString{
+				"srcs": `[
+        "main.cpp",
+        "//common:foo.cpp",
+    ]`,
+			}),
+		},
+	})
+
+	runFilegroupTestCase(t, bp2buildTestCase{
+		description: "filegroup - srcs in other package are exported by that package",
+		filesystem:  filesystem,
+		dir:         "common",
+		expectedBazelTargets: []string{
+			makeBazelTarget("filegroup", "common_srcs", attrNameToString{
+				"srcs": `["bar.cpp"]`,
+			}),
+			`exports_files([
+    "foo.cpp",
+])`,
+		},
+	})
+}
+
+func TestFilegroupSrcsInOtherPackageConflictsWithTarget(t *testing.T) {
+	runFilegroupTestCase(t, bp2buildTestCase{
+		description: "filegroup - exported file conflicts with a target of the same name",
+		filesystem: map[string]string{
+			"app/Android.bp": `
+filegroup {
+    name: "app_srcs",
+    srcs: ["../common/foo.cpp"],
+}`,
+			"common/Android.bp": `
+filegroup {
+    name: "foo.cpp",
+    srcs: ["bar.cpp"],
+}`,
+		},
+		dir:         "common",
+		expectedErr: fmt.Errorf(`file "foo.cpp" in package "common" is referenced from other packages, but conflicts with the target of the same name`),
+	})
+}
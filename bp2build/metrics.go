@@ -35,15 +35,32 @@ type CodegenMetrics struct {
 	// NOTE: NOT in the .proto
 	moduleWithMissingDepsMsgs []string
 
+	// List of modules not converted because they require unconverted or missing modules
+	// NOTE: NOT in the .proto
+	moduleWithUnconvertedRequiredDepsMsgs []string
+
+	// List of modules whose generated targets have attribute overrides, which should be revisited
+	// once their converters are fixed
+	// NOTE: NOT in the .proto
+	modulesWithAttributeOverrides []string
+
 	// List of converted modules
 	convertedModules []string
 
+	// Conversion outcome of each module, keyed by the Bazel label of the module
+	// NOTE: NOT in the .proto
+	moduleConversionOutcomes map[string]string
+
 	// Counts of converted modules by module type.
 	convertedModuleTypeCount map[string]uint64
 
 	// Counts of total modules by module type.
 	totalModuleTypeCount map[string]uint64
 
+	// Directories bp2build generated BUILD files for
+	// NOTE: NOT in the .proto
+	generatedPackages []string
+
 	Events []*bp2build_metrics_proto.Event
 }
 
@@ -61,6 +78,11 @@ func (metrics *CodegenMetrics) Serialize() bp2build_metrics_proto.Bp2BuildMetric
 	}
 }
 
+// GeneratedPackages returns the directories bp2build generated BUILD files for.
+func (metrics *CodegenMetrics) GeneratedPackages() []string {
+	return metrics.generatedPackages
+}
+
 // Print the codegen metrics to stdout.
 func (metrics *CodegenMetrics) Print() {
 	generatedTargetCount := uint64(0)
@@ -75,6 +97,10 @@ func (metrics *CodegenMetrics) Print() {
 	%s
 %d converted modules have missing deps:
 	%s
+%d modules are not converted because they require unconverted modules:
+	%s
+%d converted modules have attribute overrides:
+	%s
 `,
 		metrics.generatedModuleCount,
 		generatedTargetCount,
@@ -84,6 +110,10 @@ func (metrics *CodegenMetrics) Print() {
 		strings.Join(metrics.moduleWithUnconvertedDepsMsgs, "\n\t"),
 		len(metrics.moduleWithMissingDepsMsgs),
 		strings.Join(metrics.moduleWithMissingDepsMsgs, "\n\t"),
+		len(metrics.moduleWithUnconvertedRequiredDepsMsgs),
+		strings.Join(metrics.moduleWithUnconvertedRequiredDepsMsgs, "\n\t"),
+		len(metrics.modulesWithAttributeOverrides),
+		strings.Join(metrics.modulesWithAttributeOverrides, "\n\t"),
 	)
 }
 
@@ -130,6 +160,32 @@ func (metrics *CodegenMetrics) AddUnconvertedModule(moduleType string) {
 	metrics.totalModuleTypeCount[moduleType] += 1
 }
 
+// Conversion outcomes of a module, as reported to Bazel-side tooling.
+const (
+	conversionOutcomeGenerated   = "generated"
+	conversionOutcomeHandcrafted = "handcrafted"
+	conversionOutcomeUnconverted = "unconverted"
+)
+
+// addModuleConversionOutcome records the conversion outcome of the module named name in dir. The
+// prebuilt_ prefix is kept in name, so that the outcomes of a prebuilt and of the source module it
+// replaces are both recorded, and the name is escaped like the name of the generated target.
+func (metrics *CodegenMetrics) addModuleConversionOutcome(dir, name, outcome string) {
+	if dir == "." {
+		dir = ""
+	}
+	label := "//" + dir + ":" + android.BazelTargetName(name)
+	if metrics.moduleConversionOutcomes == nil {
+		metrics.moduleConversionOutcomes = make(map[string]string)
+	}
+	metrics.moduleConversionOutcomes[label] = outcome
+}
+
+// AddModuleWithAttributeOverrides records a converted module carrying attribute overrides.
+func (metrics *CodegenMetrics) AddModuleWithAttributeOverrides(moduleName string) {
+	metrics.modulesWithAttributeOverrides = append(metrics.modulesWithAttributeOverrides, moduleName)
+}
+
 func (metrics *CodegenMetrics) TotalModuleCount() uint64 {
 	return metrics.handCraftedModuleCount +
 		metrics.generatedModuleCount +
@@ -0,0 +1,140 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"android/soong/android"
+)
+
+// PackageClassification describes where the Bazel definitions of a package come from.
+type PackageClassification string
+
+const (
+	// The package uses its checked-in BUILD file, bp2build doesn't generate one.
+	PackageHandcrafted PackageClassification = "handcrafted"
+	// The package uses the BUILD file generated by bp2build, and has no checked-in BUILD file.
+	PackageGenerated PackageClassification = "generated"
+	// bp2build generates a BUILD file for the package, which takes precedence over its checked-in
+	// BUILD file in the workspace.
+	PackageMerged PackageClassification = "merged"
+)
+
+var packageClassifications = []PackageClassification{
+	PackageHandcrafted,
+	PackageGenerated,
+	PackageMerged,
+}
+
+// ClassifyPackages classifies the packages that have Bazel definitions in the bp2build workspace.
+// generatedPackages are the directories bp2build generated BUILD files for, and
+// existingBazelFiles are the paths of the Bazel related files in the source tree, of which only
+// the BUILD files are considered.
+func ClassifyPackages(generatedPackages []string, existingBazelFiles []string) map[string]PackageClassification {
+	classifications := make(map[string]PackageClassification)
+	for _, dir := range generatedPackages {
+		classifications[dir] = PackageGenerated
+	}
+	for _, file := range existingBazelFiles {
+		if base := filepath.Base(file); base != "BUILD" && base != "BUILD.bazel" {
+			continue
+		}
+		dir := filepath.Dir(file)
+		if !android.EffectiveShouldWriteBuildFile(dir) {
+			classifications[dir] = PackageHandcrafted
+		} else if _, ok := classifications[dir]; ok {
+			classifications[dir] = PackageMerged
+		}
+		// Otherwise the checked-in BUILD file is left out of the workspace, and the package has
+		// no Bazel definitions.
+	}
+	return classifications
+}
+
+// FormatPackageClassifications returns the classifications in the format of the package
+// classification manifest, sorted by package.
+func FormatPackageClassifications(classifications map[string]PackageClassification) string {
+	var sb strings.Builder
+	for _, pkg := range android.SortedStringKeys(classifications) {
+		fmt.Fprintf(&sb, "%s %s\n", pkg, classifications[pkg])
+	}
+	return sb.String()
+}
+
+// ParsePackageClassifications parses a package classification manifest. Each line contains a
+// package directory and its classification separated by whitespace. Empty lines and lines
+// starting with # are ignored.
+func ParsePackageClassifications(contents string) (map[string]PackageClassification, error) {
+	classifications := make(map[string]PackageClassification)
+	for i, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a package and its classification, got %q", i+1, line)
+		}
+		pkg, classification := fields[0], PackageClassification(fields[1])
+		if !isPackageClassification(classification) {
+			return nil, fmt.Errorf("line %d: unknown classification %q of package %q, must be one of %q",
+				i+1, classification, pkg, packageClassifications)
+		}
+		if _, ok := classifications[pkg]; ok {
+			return nil, fmt.Errorf("line %d: package %q is listed more than once", i+1, pkg)
+		}
+		classifications[pkg] = classification
+	}
+	return classifications, nil
+}
+
+func isPackageClassification(classification PackageClassification) bool {
+	for _, c := range packageClassifications {
+		if c == classification {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffPackageClassifications returns a description of each package whose classification in
+// actual differs from the one in manifest, sorted by package. It is empty if they match.
+func DiffPackageClassifications(manifest, actual map[string]PackageClassification) []string {
+	packages := make(map[string]bool)
+	for pkg := range manifest {
+		packages[pkg] = true
+	}
+	for pkg := range actual {
+		packages[pkg] = true
+	}
+
+	var diffs []string
+	for _, pkg := range android.SortedStringKeys(packages) {
+		expected, inManifest := manifest[pkg]
+		got, inActual := actual[pkg]
+		switch {
+		case !inManifest:
+			diffs = append(diffs, fmt.Sprintf("added %q: %s", pkg, got))
+		case !inActual:
+			diffs = append(diffs, fmt.Sprintf("removed %q: was %s", pkg, expected))
+		case expected != got:
+			diffs = append(diffs, fmt.Sprintf("reclassified %q: %s -> %s", pkg, expected, got))
+		}
+	}
+	return diffs
+}
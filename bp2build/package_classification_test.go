@@ -0,0 +1,113 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestClassifyPackages(t *testing.T) {
+	generated := []string{"a", "b"}
+	existing := []string{
+		// external/guava keeps its BUILD files recursively.
+		"external/guava/BUILD",
+		"external/guava/sub/BUILD.bazel",
+		"b/BUILD.bazel",
+		// Not a BUILD file.
+		"a/defs.bzl",
+		// Left out of the workspace, bp2build doesn't generate a BUILD file for it.
+		"c/BUILD",
+	}
+
+	android.AssertDeepEquals(t, "classifications", map[string]PackageClassification{
+		"a":                  PackageGenerated,
+		"b":                  PackageMerged,
+		"external/guava":     PackageHandcrafted,
+		"external/guava/sub": PackageHandcrafted,
+	}, ClassifyPackages(generated, existing))
+}
+
+func TestParsePackageClassifications(t *testing.T) {
+	manifest := `
+# Maintained by the migration team.
+a generated
+
+external/guava    handcrafted
+b merged
+`
+	classifications, err := ParsePackageClassifications(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertDeepEquals(t, "classifications", map[string]PackageClassification{
+		"a":              PackageGenerated,
+		"b":              PackageMerged,
+		"external/guava": PackageHandcrafted,
+	}, classifications)
+
+	roundTrip, err := ParsePackageClassifications(FormatPackageClassifications(classifications))
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertDeepEquals(t, "round trip", classifications, roundTrip)
+
+	for _, tc := range []struct {
+		name, manifest, err string
+	}{
+		{
+			name:     "missing classification",
+			manifest: "a generated\nb\n",
+			err:      `line 2: expected a package and its classification, got "b"`,
+		},
+		{
+			name:     "unknown classification",
+			manifest: "a written\n",
+			err:      `line 1: unknown classification "written" of package "a", must be one of ["handcrafted" "generated" "merged"]`,
+		},
+		{
+			name:     "duplicate",
+			manifest: "a generated\na merged\n",
+			err:      `line 2: package "a" is listed more than once`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParsePackageClassifications(tc.manifest)
+			android.AssertErrorMessageEquals(t, "error", tc.err, err)
+		})
+	}
+}
+
+func TestDiffPackageClassifications(t *testing.T) {
+	manifest := map[string]PackageClassification{
+		"unchanged":    PackageGenerated,
+		"removed":      PackageGenerated,
+		"reclassified": PackageHandcrafted,
+	}
+	actual := map[string]PackageClassification{
+		"unchanged":    PackageGenerated,
+		"reclassified": PackageGenerated,
+		"added":        PackageMerged,
+	}
+
+	android.AssertArrayString(t, "diff", []string{
+		`added "added": merged`,
+		`reclassified "reclassified": handcrafted -> generated`,
+		`removed "removed": was generated`,
+	}, DiffPackageClassifications(manifest, actual))
+
+	android.AssertArrayString(t, "no diff", nil, DiffPackageClassifications(manifest, manifest))
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"fmt"
+	"strings"
+
+	"android/soong/android"
+
+	"github.com/google/blueprint"
+)
+
+// TrialReport is the result of the trial conversion of the modules of a directory as if it was
+// marked Bp2BuildDefaultTrue in the allowlist, see android.Config.Bp2buildTrialDir.
+type TrialReport struct {
+	// The directory whose modules were converted.
+	Dir string
+	// The modules that convert cleanly, sorted by name.
+	Converted []string
+	// Why each module that doesn't convert cleanly is blocked, keyed by module name.
+	Blocked map[string]string
+}
+
+// Clean returns true if every module of the directory converts cleanly, so that the directory can
+// be added to the allowlist.
+func (r TrialReport) Clean() bool {
+	return len(r.Converted) > 0 && len(r.Blocked) == 0
+}
+
+// SuggestedAllowlistEntry returns the entry of the directory to add to Bp2buildDefaultConfig in
+// android/allowlists/allowlists.go.
+func (r TrialReport) SuggestedAllowlistEntry() string {
+	return fmt.Sprintf("%q: Bp2BuildDefaultTrue,", r.Dir)
+}
+
+// String returns the report in a human readable form, with the suggested allowlist entry if the
+// directory converts cleanly.
+func (r TrialReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "bp2build trial conversion of %q:\n", r.Dir)
+	for _, name := range r.Converted {
+		fmt.Fprintf(&sb, "  %s: converts\n", name)
+	}
+	for _, name := range android.SortedStringKeys(r.Blocked) {
+		fmt.Fprintf(&sb, "  %s: blocked: %s\n", name, r.Blocked[name])
+	}
+	if r.Clean() {
+		fmt.Fprintf(&sb, "All modules convert cleanly, add this entry to Bp2buildDefaultConfig in android/allowlists/allowlists.go:\n")
+		fmt.Fprintf(&sb, "  %s\n", r.SuggestedAllowlistEntry())
+	} else if len(r.Converted) == 0 && len(r.Blocked) == 0 {
+		fmt.Fprintf(&sb, "No modules in the directory support bp2build.\n")
+	} else {
+		fmt.Fprintf(&sb, "%d module(s) are blocked, the directory can't be added to the allowlist yet.\n", len(r.Blocked))
+	}
+	return sb.String()
+}
+
+// CreateTrialReport collects the results of the trial conversion of the modules of dir. A module
+// that depends on or requires unconverted modules only converts cleanly if all of them are modules
+// of dir that convert cleanly too.
+func CreateTrialReport(ctx *CodegenContext, dir string) TrialReport {
+	bpCtx := ctx.Context()
+	infos := make(map[string]android.Bp2buildTrialInfo)
+	bpCtx.VisitAllModules(func(m blueprint.Module) {
+		if _, ok := m.(android.Bazelable); !ok || bpCtx.ModuleDir(m) != dir {
+			return
+		}
+		infos[m.Name()] = bpCtx.ModuleProvider(m, android.Bp2buildTrialInfoProvider).(android.Bp2buildTrialInfo)
+	})
+
+	report := TrialReport{Dir: dir, Blocked: make(map[string]string)}
+	for name, info := range infos {
+		if info.Blocked != "" {
+			report.Blocked[name] = info.Blocked
+		} else if len(info.MissingDeps) > 0 {
+			report.Blocked[name] = "depends on missing modules: " + strings.Join(info.MissingDeps, ", ")
+		}
+	}
+
+	// Block the modules that depend on unconverted modules that are blocked or outside of dir until
+	// nothing changes.
+	for changed := true; changed; {
+		changed = false
+		for name, info := range infos {
+			if _, blocked := report.Blocked[name]; blocked {
+				continue
+			}
+			blocking := func(deps []string) []string {
+				var blockingDeps []string
+				for _, dep := range deps {
+					if _, inDir := infos[dep]; !inDir {
+						blockingDeps = append(blockingDeps, dep)
+					} else if _, blocked := report.Blocked[dep]; blocked {
+						blockingDeps = append(blockingDeps, dep)
+					}
+				}
+				return blockingDeps
+			}
+			if blockingDeps := blocking(info.UnconvertedDeps); len(blockingDeps) > 0 {
+				report.Blocked[name] = "depends on unconverted modules: " + strings.Join(blockingDeps, ", ")
+				changed = true
+			} else if blockingDeps := blocking(info.UnconvertedRequiredDeps); len(blockingDeps) > 0 {
+				report.Blocked[name] = "requires unconverted modules: " + strings.Join(blockingDeps, ", ")
+				changed = true
+			}
+		}
+	}
+
+	for _, name := range android.SortedStringKeys(infos) {
+		if _, blocked := report.Blocked[name]; !blocked {
+			report.Converted = append(report.Converted, name)
+		}
+	}
+	return report
+}
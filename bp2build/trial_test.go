@@ -0,0 +1,139 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"testing"
+
+	"android/soong/android"
+
+	"github.com/google/blueprint/proptools"
+)
+
+func TestTrialReport(t *testing.T) {
+	testCases := []struct {
+		description       string
+		candidate         string
+		expectedConverted []string
+		expectedBlocked   map[string]string
+	}{
+		{
+			description: "clean",
+			candidate: `
+custom {
+    name: "foo",
+    arch_paths: [":bar", "../other/data.txt"],
+}
+
+custom {
+    name: "bar",
+}`,
+			expectedConverted: []string{"bar", "foo"},
+			expectedBlocked:   map[string]string{},
+		},
+		{
+			description: "blocked",
+			candidate: `
+custom {
+    name: "foo",
+    arch_paths: [":bar"],
+}
+
+custom {
+    name: "bar",
+    arch_paths: [":other_unconverted"],
+}
+
+custom {
+    name: "baz",
+    bazel_module: { bp2build_available: false },
+}
+
+custom {
+    name: "qux",
+}`,
+			expectedConverted: []string{"qux"},
+			expectedBlocked: map[string]string{
+				"foo": "depends on unconverted modules: bar",
+				"bar": "depends on unconverted modules: other_unconverted",
+				"baz": "the module disables bp2build with its bazel_module properties",
+			},
+		},
+		{
+			description: "converter errors",
+			candidate: `
+custom {
+    name: "foo",
+    bazel_module: {
+        attribute_overrides: [{
+            name: "copts",
+            value: ["-Wall"],
+        }],
+    },
+}
+
+custom {
+    name: "bar",
+}`,
+			expectedConverted: []string{"bar"},
+			expectedBlocked: map[string]string{
+				"foo": `the converter reported errors: bazel_module.attribute_overrides: unknown attribute "copts" for rule class "custom"`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			fs := map[string][]byte{
+				"candidate/Android.bp": []byte(tc.candidate),
+				"other/Android.bp": []byte(`
+custom {
+    name: "other_unconverted",
+}`),
+			}
+			config := android.TestConfig(buildDir, nil, "", fs)
+			config.TestProductVariables.Bp2buildTrialDir = proptools.StringPtr("candidate")
+			ctx := android.NewTestContext(config)
+			ctx.RegisterModuleType("custom", customModuleFactory)
+			ctx.RegisterForBazelConversion()
+
+			_, errs := ctx.ParseFileList(".", []string{"Android.bp", "candidate/Android.bp", "other/Android.bp"})
+			android.FailIfErrored(t, errs)
+			_, errs = ctx.ResolveDependencies(config)
+			android.FailIfErrored(t, errs)
+
+			codegenCtx := NewCodegenContext(config, *ctx.Context, Bp2Build)
+			res, errs := GenerateBazelTargets(codegenCtx, false)
+			android.FailIfErrored(t, errs)
+
+			// The trial conversion doesn't change the generated BUILD files.
+			android.AssertIntEquals(t, "candidate targets", 0, len(res.buildFileToTargets["candidate"]))
+			android.AssertDeepEquals(t, "exported files", map[string][]string{}, android.Bp2buildExportedFiles(config))
+
+			report := CreateTrialReport(codegenCtx, "candidate")
+			android.AssertDeepEquals(t, "converted", tc.expectedConverted, report.Converted)
+			android.AssertDeepEquals(t, "blocked", tc.expectedBlocked, report.Blocked)
+
+			clean := len(tc.expectedBlocked) == 0
+			android.AssertBoolEquals(t, "clean", clean, report.Clean())
+			suggestion := `"candidate": Bp2BuildDefaultTrue,`
+			if clean {
+				android.AssertStringDoesContain(t, "report", report.String(), suggestion)
+			} else {
+				android.AssertStringDoesNotContain(t, "report", report.String(), suggestion)
+			}
+		})
+	}
+}
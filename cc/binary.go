@@ -511,6 +511,7 @@ func (binary *binaryDecorator) install(ctx ModuleContext, file android.Path) {
 		binary.baseInstaller.subDir = "bootstrap"
 	}
 	binary.baseInstaller.install(ctx, file)
+	binary.installUbsanLauncher(ctx)
 
 	var preferredArchSymlinkPath android.OptionalPath
 	for _, symlink := range binary.symlinks {
@@ -534,6 +535,19 @@ func (binary *binaryDecorator) install(ctx ModuleContext, file android.Path) {
 	}
 }
 
+// installUbsanLauncher installs the launcher script of sanitize.max_errors next to the installed
+// binary.
+func (binary *binaryDecorator) installUbsanLauncher(ctx ModuleContext) {
+	maxErrors := binary.sanitize.ubsanMaxErrors()
+	if maxErrors == 0 {
+		return
+	}
+	executable := binary.baseInstaller.path.Base()
+	launcher := android.PathForModuleOut(ctx, executable+".ubsan.sh")
+	android.WriteFileRule(ctx, launcher, ubsanLauncherScript(ctx, executable, maxErrors))
+	ctx.InstallExecutable(binary.baseInstaller.installDir(ctx), launcher.Base(), launcher, binary.baseInstaller.path)
+}
+
 func (binary *binaryDecorator) hostToolPath() android.OptionalPath {
 	return binary.toolPath
 }
@@ -620,7 +634,8 @@ func binaryBp2build(ctx android.TopDownMutatorContext, m *Module, typ string) {
 		Stl:               baseAttrs.stl,
 		Cpp_std:           baseAttrs.cppStd,
 
-		Additional_linker_inputs: baseAttrs.additionalLinkerInputs,
+		Additional_compiler_inputs: baseAttrs.additionalCompilerInputs,
+		Additional_linker_inputs:   baseAttrs.additionalLinkerInputs,
 
 		Strip: stripAttributes{
 			Keep_symbols:                 baseAttrs.stripKeepSymbols,
@@ -663,6 +678,8 @@ type binaryAttributes struct {
 	Local_includes    bazel.StringListAttribute
 	Absolute_includes bazel.StringListAttribute
 
+	Additional_compiler_inputs bazel.LabelListAttribute
+
 	Linkopts                 bazel.StringListAttribute
 	Additional_linker_inputs bazel.LabelListAttribute
 
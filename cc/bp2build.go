@@ -42,6 +42,8 @@ type staticOrSharedAttributes struct {
 	Hdrs    bazel.LabelListAttribute
 	Copts   bazel.StringListAttribute
 
+	Additional_compiler_inputs bazel.LabelListAttribute
+
 	Deps                              bazel.LabelListAttribute
 	Implementation_deps               bazel.LabelListAttribute
 	Dynamic_deps                      bazel.LabelListAttribute
@@ -291,6 +293,9 @@ type compilerAttributes struct {
 
 	hdrs bazel.LabelListAttribute
 
+	// Non-source inputs referenced by copts, e.g. sanitizer ignorelists.
+	additionalCompilerInputs bazel.LabelListAttribute
+
 	rtti bazel.BoolAttribute
 
 	// Not affected by arch variants
@@ -376,6 +381,46 @@ func (ca *compilerAttributes) convertStlProps(ctx android.ArchVariantContext, mo
 	}
 }
 
+// convertSanitizeProps converts the sanitizer properties of a module that affect compilation. As in
+// Soong, the blocklist is only passed to the configurations that enable a sanitizer.
+func (ca *compilerAttributes) convertSanitizeProps(ctx android.BazelConversionPathContext, module *Module) {
+	if module.sanitize == nil {
+		return
+	}
+	blocklist := module.sanitize.Properties.Sanitize.Blocklist
+	if blocklist == nil {
+		return
+	}
+	label := android.BazelLabelForModuleSrcSingle(ctx, *blocklist)
+	copt := fmt.Sprintf("-fsanitize-ignorelist=$(location %s)", label.Label)
+	sanitizePropsByAxis := module.GetArchVariantProperties(ctx, &SanitizeProperties{})
+	enabledByDefault := false
+	if props, ok := sanitizePropsByAxis[bazel.NoConfigAxis][""].(*SanitizeProperties); ok {
+		enabledByDefault = bp2buildSanitizersEnabled(props)
+	}
+	for axis, configToProps := range sanitizePropsByAxis {
+		if enabledByDefault && axis != bazel.NoConfigAxis {
+			// The blocklist is already passed to all configurations.
+			continue
+		}
+		for config, props := range configToProps {
+			sanitizeProps, ok := props.(*SanitizeProperties)
+			if !ok || !bp2buildSanitizersEnabled(sanitizeProps) {
+				continue
+			}
+			ca.additionalCompilerInputs.SetSelectValue(axis, config,
+				bazel.AppendBazelLabelLists(ca.additionalCompilerInputs.SelectValue(axis, config),
+					bazel.MakeLabelList([]bazel.Label{label})))
+			ca.copts.SetSelectValue(axis, config, append(ca.copts.SelectValue(axis, config), copt))
+		}
+	}
+}
+
+// bp2buildSanitizersEnabled returns true if the sanitize properties enable a sanitizer.
+func bp2buildSanitizersEnabled(props *SanitizeProperties) bool {
+	return len((&sanitize{Properties: *props}).enabledSanitizerNames()) > 0
+}
+
 func (ca *compilerAttributes) convertProductVariables(ctx android.BazelConversionPathContext, productVariableProps android.ProductConfigProperties) {
 	productVarPropNameToAttribute := map[string]*bazel.StringListAttribute{
 		"Cflags":   &ca.copts,
@@ -559,7 +604,9 @@ func bp2BuildParseBaseProps(ctx android.Bp2buildMutatorContext, module *Module)
 	}
 
 	compilerAttrs.convertStlProps(ctx, module)
+	(&compilerAttrs).convertSanitizeProps(ctx, module)
 	(&linkerAttrs).convertStripProps(ctx, module)
+	(&linkerAttrs).convertSanitizeProps(ctx, module)
 
 	productVariableProps := android.ProductVariableProperties(ctx)
 
@@ -596,6 +643,42 @@ type sdkAttributes struct {
 	Min_sdk_version *string
 }
 
+// vndkAttributes are the VNDK properties of a library, which are only set for VNDK libraries.
+type vndkAttributes struct {
+	Vendor_available            *bool
+	Product_available           *bool
+	Vndk                        *bool
+	Vndk_support_system_process *bool
+	Vndk_private                *bool
+	Vndk_extends                *bazel.LabelAttribute
+	Must_use_vendor_variant     *bool
+}
+
+// bp2BuildParseVndkAttributes returns the VNDK attributes of module, or empty attributes if it
+// isn't a VNDK library. Like MustUseVendorVariant, must_use_vendor_variant is set for VNDK-SP
+// libraries and the libraries in the VndkMustUseVendorVariantList.
+func bp2BuildParseVndkAttributes(ctx android.TopDownMutatorContext, module *Module) vndkAttributes {
+	if !module.IsVndk() {
+		return vndkAttributes{}
+	}
+	vndk := module.vndkdep.Properties.Vndk
+	attrs := vndkAttributes{
+		Vendor_available:            module.VendorProperties.Vendor_available,
+		Product_available:           module.VendorProperties.Product_available,
+		Vndk:                        vndk.Enabled,
+		Vndk_support_system_process: vndk.Support_system_process,
+		Vndk_private:                vndk.Private,
+	}
+	if vndk.Extends != nil {
+		label := android.BazelLabelForModuleDepSingle(ctx, *vndk.Extends)
+		attrs.Vndk_extends = &bazel.LabelAttribute{Value: &label}
+	}
+	if vndkMustUseVendorVariant(ctx.Config(), module) {
+		attrs.Must_use_vendor_variant = proptools.BoolPtr(true)
+	}
+	return attrs
+}
+
 // Convenience struct to hold all attributes parsed from linker properties.
 type linkerAttributes struct {
 	deps                             bazel.LabelListAttribute
@@ -726,6 +809,51 @@ func (la *linkerAttributes) convertStripProps(ctx android.BazelConversionPathCon
 	}
 }
 
+// convertSanitizeProps converts the undefined behavior sanitizers of a module to features, e.g.
+// ubsan_undefined for sanitize.undefined. The checks in sanitize.diag get a diag_ prefixed feature
+// too, e.g. diag_ubsan_undefined, so that Bazel reports them and recovers instead of trapping.
+func (la *linkerAttributes) convertSanitizeProps(ctx android.BazelConversionPathContext, module *Module) {
+	if module.sanitize == nil {
+		return
+	}
+	for axis, configToProps := range module.GetArchVariantProperties(ctx, &SanitizeProperties{}) {
+		for config, props := range configToProps {
+			if sanitizeProps, ok := props.(*SanitizeProperties); ok {
+				if features := bp2buildSanitizerFeatures(&sanitizeProps.Sanitize); len(features) > 0 {
+					la.features.SetSelectValue(axis, config, append(la.features.SelectValue(axis, config), features...))
+				}
+			}
+		}
+	}
+}
+
+func bp2buildSanitizerFeatures(s *SanitizeUserProps) []string {
+	var features []string
+	if Bool(s.All_undefined) {
+		features = append(features, "ubsan_all_undefined")
+	}
+	if Bool(s.Undefined) {
+		features = append(features, "ubsan_undefined")
+	}
+	if Bool(s.Integer_overflow) {
+		features = append(features, "ubsan_integer_overflow")
+	}
+	for _, check := range s.Misc_undefined {
+		features = append(features, "ubsan_"+check)
+	}
+
+	if Bool(s.Diag.Undefined) {
+		features = append(features, "diag_ubsan_undefined")
+	}
+	if Bool(s.Diag.Integer_overflow) {
+		features = append(features, "diag_ubsan_integer_overflow")
+	}
+	for _, check := range s.Diag.Misc_undefined {
+		features = append(features, "diag_ubsan_"+check)
+	}
+	return android.FirstUniqueStrings(features)
+}
+
 func (la *linkerAttributes) convertProductVariables(ctx android.BazelConversionPathContext, productVariableProps android.ProductConfigProperties) {
 
 	type productVarDep struct {
@@ -895,13 +1023,110 @@ func bazelLabelForSharedModule(ctx android.BazelConversionPathContext, m bluepri
 func bazelLabelForStaticWholeModuleDeps(ctx android.BazelConversionPathContext, m blueprint.Module) string {
 	label := bazelLabelForStaticModule(ctx, m)
 	if aModule, ok := m.(android.Module); ok {
-		if android.IsModulePrebuilt(aModule) {
+		if android.IsModulePrebuilt(aModule) || staticLibUsedWholeAndNonWhole(ctx.Config(), m.Name()) {
 			label += "_alwayslink"
 		}
 	}
 	return label
 }
 
+var (
+	bp2buildConvertedStaticLibsKey = android.NewOnceKey("Bp2buildConvertedStaticLibs")
+	bp2buildWholeStaticLibUsersKey = android.NewOnceKey("Bp2buildWholeStaticLibUsers")
+	bp2buildStaticLibUsersKey      = android.NewOnceKey("Bp2buildStaticLibUsers")
+)
+
+// bp2buildStaticLibUsageMutator records which static libraries are converted by bp2build, and
+// which are used as whole_static_libs and which as static_libs by converted modules, so that a
+// library used both ways can be converted with an additional alwayslink target for its whole
+// archive users.
+func bp2buildStaticLibUsageMutator(ctx android.TopDownMutatorContext) {
+	m, ok := ctx.Module().(*Module)
+	if !ok || m.linker == nil || !m.ShouldConvertWithBp2build(ctx) {
+		return
+	}
+
+	if (m.typ() == staticLibrary || m.typ() == fullLibrary) && !m.IsPrebuilt() {
+		getNamedMapForConfig(ctx.Config(), bp2buildConvertedStaticLibsKey).Store(m.Name(), true)
+	}
+
+	wholeUsers := getNamedMapForConfig(ctx.Config(), bp2buildWholeStaticLibUsersKey)
+	staticUsers := getNamedMapForConfig(ctx.Config(), bp2buildStaticLibUsersKey)
+	record := func(wholeStaticLibs, staticLibs []string) {
+		for _, lib := range wholeStaticLibs {
+			wholeUsers.Store(lib, true)
+		}
+		for _, lib := range staticLibs {
+			staticUsers.Store(lib, true)
+		}
+	}
+
+	for _, configToProps := range m.GetArchVariantProperties(ctx, &BaseLinkerProperties{}) {
+		for _, props := range configToProps {
+			if baseLinkerProps, ok := props.(*BaseLinkerProperties); ok {
+				record(baseLinkerProps.Whole_static_libs, baseLinkerProps.Static_libs)
+			}
+		}
+	}
+
+	if _, ok := m.compiler.(*libraryDecorator); ok {
+		for _, configToProps := range m.GetArchVariantProperties(ctx, &StaticProperties{}) {
+			for _, props := range configToProps {
+				if staticProps, ok := props.(*StaticProperties); ok {
+					record(staticProps.Static.Whole_static_libs, staticProps.Static.Static_libs)
+				}
+			}
+		}
+		for _, configToProps := range m.GetArchVariantProperties(ctx, &SharedProperties{}) {
+			for _, props := range configToProps {
+				if sharedProps, ok := props.(*SharedProperties); ok {
+					record(sharedProps.Shared.Whole_static_libs, sharedProps.Shared.Static_libs)
+				}
+			}
+		}
+	}
+}
+
+// staticLibUsedWholeAndNonWhole returns true if the static library with the given name is
+// converted by bp2build and is used as a whole_static_libs dependency by one converted module and
+// as a static_libs dependency by another. Such a library gets an additional alwayslink target for
+// its whole archive users.
+func staticLibUsedWholeAndNonWhole(config android.Config, name string) bool {
+	if _, converted := getNamedMapForConfig(config, bp2buildConvertedStaticLibsKey).Load(name); !converted {
+		return false
+	}
+	_, whole := getNamedMapForConfig(config, bp2buildWholeStaticLibUsersKey).Load(name)
+	_, static := getNamedMapForConfig(config, bp2buildStaticLibUsersKey).Load(name)
+	return whole && static
+}
+
+// bazelCcLibraryStaticAlwayslinkAttributes are the attributes of the alwayslink target created
+// for a static library that is used both as a whole archive dependency and as a regular static
+// dependency.
+type bazelCcLibraryStaticAlwayslinkAttributes struct {
+	Whole_archive_deps bazel.LabelListAttribute
+	Alwayslink         *bool
+}
+
+// createAlwayslinkStaticLibraryTarget creates the "<name>_alwayslink" target for the static
+// library target with the given name if the module is used both as a whole_static_libs and as a
+// static_libs dependency, so that its whole archive users can depend on it via the appropriate
+// alwayslink-wrapped target while its other users keep depending on the plain static library.
+func createAlwayslinkStaticLibraryTarget(ctx android.TopDownMutatorContext, m *Module, name string, enabled bazel.BoolAttribute) {
+	if !staticLibUsedWholeAndNonWhole(ctx.Config(), m.Name()) {
+		return
+	}
+	props := bazel.BazelTargetModuleProperties{
+		Rule_class:        "cc_library_static",
+		Bzl_load_location: "//build/bazel/rules/cc:cc_library_static.bzl",
+	}
+	attrs := &bazelCcLibraryStaticAlwayslinkAttributes{
+		Whole_archive_deps: bazel.MakeLabelListAttribute(bazel.MakeLabelList([]bazel.Label{{Label: ":" + name}})),
+		Alwayslink:         proptools.BoolPtr(true),
+	}
+	ctx.CreateBazelTargetModuleWithRestrictions(props, android.CommonAttributes{Name: name + "_alwayslink"}, attrs, enabled)
+}
+
 func bazelLabelForWholeDeps(ctx android.BazelConversionPathContext, modules []string) bazel.LabelList {
 	return android.BazelLabelForModuleDepsWithFn(ctx, modules, bazelLabelForStaticWholeModuleDeps)
 }
@@ -395,6 +395,8 @@ type builderFlags struct {
 	rsFlags       string // Flags that apply to renderscript source files
 	toolchain     config.Toolchain
 
+	asNeededSharedLibs android.Paths // Shared libraries to link with -Wl,--as-needed.
+
 	// True if these extra features are enabled.
 	sdclang      bool
 	tidy         bool
@@ -861,7 +863,11 @@ func transformObjToDynamicBinary(ctx android.ModuleContext,
 		if ctx.Windows() {
 			libFile = pathtools.ReplaceExtension(libFile, "lib")
 		}
-		libFlagsList = append(libFlagsList, libFile)
+		if android.InList(lib.String(), flags.asNeededSharedLibs.Strings()) {
+			libFlagsList = append(libFlagsList, "-Wl,--as-needed", libFile, "-Wl,--no-as-needed")
+		} else {
+			libFlagsList = append(libFlagsList, libFile)
+		}
 	}
 
 	deps = append(deps, staticLibs...)
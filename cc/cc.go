@@ -72,6 +72,8 @@ func RegisterCCBuildComponents(ctx android.RegistrationContext) {
 
 		ctx.BottomUp("check_linktype", checkLinkTypeMutator).Parallel()
 		ctx.TopDown("double_loadable", checkDoubleLoadableLibraries).Parallel()
+
+		ctx.BottomUp("sanitizer_size_overhead_deps", sanitizerSizeOverheadDepsMutator).Parallel()
 	})
 
 	ctx.FinalDepsMutators(func(ctx android.RegisterMutatorsContext) {
@@ -79,6 +81,10 @@ func RegisterCCBuildComponents(ctx android.RegistrationContext) {
 		ctx.TopDown("sabi_deps", sabiDepsMutator)
 	})
 
+	ctx.PreArchBp2BuildMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.TopDown("bp2build_static_lib_usage", bp2buildStaticLibUsageMutator).Parallel()
+	})
+
 	ctx.RegisterSingletonType("kythe_extract_all", kytheExtractAllFactory)
 }
 
@@ -171,6 +177,9 @@ type PathDeps struct {
 	// Path to the dynamic linker binary
 	DynamicLinker android.OptionalPath
 
+	// Paths to the .so files of the shared sanitizer runtime libraries
+	SanitizerRuntimeLibs android.Paths
+
 	// For Darwin builds, the path to the second architecture's output that should
 	// be combined with this architectures's output into a FAT MachO file.
 	DarwinSecondArchOutput android.OptionalPath
@@ -206,6 +215,9 @@ type Flags struct {
 	TidyFlags     []string // Flags that apply to clang-tidy
 	SAbiFlags     []string // Flags that apply to header-abi-dumper
 
+	// Shared libraries that are only recorded as dependencies if they are referenced.
+	asNeededSharedLibs android.Paths
+
 	// Global include flags that apply to C, C++, and assembly source files
 	// These must be after any module include flags, which will be in CommonFlags.
 	SystemIncludeFlags []string
@@ -485,6 +497,8 @@ type ModuleContextIntf interface {
 	static() bool
 	staticBinary() bool
 	testBinary() bool
+	fuzzBinary() bool
+	benchmarkBinary() bool
 	header() bool
 	binary() bool
 	object() bool
@@ -686,6 +700,13 @@ type libraryDependencyTag struct {
 
 	// Whether or not this dependency has to be followed for the apex variants
 	excludeInApex bool
+
+	// Whether or not the installation of the dependency is left out of the dependencies of the
+	// installation of this module
+	excludeInInstall bool
+
+	// Whether or not the dependency is the shared sanitizer runtime library of this module
+	sanitizerRuntime bool
 }
 
 // header returns true if the libraryDependencyTag is tagging a header lib dependency.
@@ -715,7 +736,7 @@ var _ android.LicenseAnnotationsDependencyTag = libraryDependencyTag{}
 // InstallDepNeeded returns true for shared libraries so that shared library dependencies of
 // binaries or other shared libraries are installed as dependencies.
 func (d libraryDependencyTag) InstallDepNeeded() bool {
-	return d.shared()
+	return d.shared() && !d.excludeInInstall
 }
 
 var _ android.InstallNeededDependencyTag = libraryDependencyTag{}
@@ -859,6 +880,10 @@ type Module struct {
 	apexSdkVersion android.ApiLevel
 
 	hideApexVariantFromMake bool
+
+	// The installed sanitizer runtime libraries linked by a sanitized host variant, which have to be
+	// available when it runs as a tool of a genrule.
+	sanitizerRuntimeTools android.InstallPaths
 }
 
 func (c *Module) AddJSONData(d *map[string]interface{}) {
@@ -1449,6 +1474,14 @@ func (ctx *moduleContextImpl) testBinary() bool {
 	return ctx.mod.testBinary()
 }
 
+func (ctx *moduleContextImpl) fuzzBinary() bool {
+	return ctx.mod.fuzzBinary()
+}
+
+func (ctx *moduleContextImpl) benchmarkBinary() bool {
+	return ctx.mod.benchmarkBinary()
+}
+
 func (ctx *moduleContextImpl) header() bool {
 	return ctx.mod.Header()
 }
@@ -1814,6 +1847,12 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 
 	c.makeLinkType = GetMakeLinkType(actx, c)
 
+	if c.IsVndk() && !c.IsVndkExt() {
+		actx.SetProvider(VndkInfoProvider, VndkInfo{
+			MustUseVendorVariant: vndkMustUseVendorVariant(actx.Config(), c),
+		})
+	}
+
 	ctx := &moduleContext{
 		ModuleContext: actx,
 		moduleContextImpl: moduleContextImpl{
@@ -1850,14 +1889,20 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	if c.stl != nil {
 		flags = c.stl.flags(ctx, flags)
 	}
+	var sanitizerFlags SanitizerFlagsInfo
+	var ltoFlags []string
 	if c.sanitize != nil {
+		before := snapshotSanitizerFlags(flags)
 		flags = c.sanitize.flags(ctx, flags)
+		sanitizerFlags = c.sanitize.sanitizerFlagsInfo(before, flags)
 	}
 	if c.coverage != nil {
 		flags, deps = c.coverage.flags(ctx, flags, deps)
 	}
 	if c.lto != nil {
+		before := allCompileFlags(flags)
 		flags = c.lto.flags(ctx, flags)
+		ltoFlags = addedCompileFlags(before, flags)
 	}
 	if c.afdo != nil {
 		flags = c.afdo.flags(ctx, flags)
@@ -1877,6 +1922,16 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	flags.Local.ConlyFlags, _ = filterList(flags.Local.ConlyFlags, config.IllegalFlags)
 
 	flags.Local.CommonFlags = append(flags.Local.CommonFlags, deps.Flags...)
+	runtimeLdFlags := append(excludeLibsRuntimeLdflags(ctx, deps), sanitizerRuntimeRpathLinkLdflags(deps)...)
+	flags.Local.LdFlags = append(flags.Local.LdFlags, runtimeLdFlags...)
+	if c.sanitize != nil {
+		runtimeOptional := c.sanitize.runtimeOptional(ctx)
+		if runtimeOptional {
+			flags.asNeededSharedLibs = append(flags.asNeededSharedLibs, deps.SanitizerRuntimeLibs...)
+		}
+		sanitizerFlags.addRuntimeLinkFlags(runtimeLdFlags, deps.SanitizerRuntimeLibs, runtimeOptional)
+		ctx.SetProvider(SanitizerFlagsInfoProvider, sanitizerFlags)
+	}
 
 	for _, dir := range deps.IncludeDirs {
 		flags.Local.CommonFlags = append(flags.Local.CommonFlags, "-I"+dir.String())
@@ -1886,6 +1941,7 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	}
 
 	c.flags = flags
+	c.setCompilerFlagsInfoProvider(ctx, flags, sanitizerFlags.compileFlags(), ltoFlags)
 	// We need access to all the flags seen by a source file.
 	if c.sabi != nil {
 		flags = c.sabi.flags(ctx, flags)
@@ -1903,6 +1959,9 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		c.objFiles = objs.objFiles
 		c.tidyFiles = objs.tidyFiles
 	}
+	if c.sanitize != nil {
+		objs = objs.Append(c.sanitize.sanitizerObjs(ctx))
+	}
 
 	if c.linker != nil {
 		outputFile := c.linker.link(ctx, flags, deps, objs)
@@ -1920,8 +1979,13 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 				i.collectHeadersForSnapshot(ctx)
 			}
 		}
+
+		c.setSanitizerSizeOverheadProvider(ctx)
 	}
 
+	c.setSanitizeInfoProvider(ctx)
+	c.setCoverageProfileInfoProvider(ctx)
+
 	c.maybeInstall(ctx, apexInfo)
 }
 
@@ -2712,6 +2776,10 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 			return
 		}
 
+		if depTag == sanitizerBaseVariantDepTag {
+			return
+		}
+
 		if dep.Target().Os != ctx.Os() {
 			ctx.ModuleErrorf("OS mismatch between %q and %q", ctx.ModuleName(), depName)
 			return
@@ -2788,6 +2856,14 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 				linkFile = android.OptionalPathForPath(sharedLibraryInfo.SharedLibrary)
 				depFile = sharedLibraryInfo.TableOfContents
 
+				if c.Host() && inList(android.RemoveOptionalPrebuiltPrefix(depName), sanitizerRuntimeLibraries(c.toolchain(ctx))) {
+					c.sanitizerRuntimeTools = append(c.sanitizerRuntimeTools, dep.FilesToInstall()...)
+				}
+
+				if libDepTag.sanitizerRuntime {
+					depPaths.SanitizerRuntimeLibs = append(depPaths.SanitizerRuntimeLibs, sharedLibraryInfo.SharedLibrary)
+				}
+
 				ptr = &depPaths.SharedLibs
 				switch libDepTag.Order {
 				case earlyLibraryDependency:
@@ -3210,6 +3286,12 @@ func (c *Module) HostToolPath() android.OptionalPath {
 	return c.installer.hostToolPath()
 }
 
+// SanitizerRuntimeTools returns the installed sanitizer runtime libraries that a sanitized host
+// variant needs when it runs as a tool.
+func (c *Module) SanitizerRuntimeTools() android.Paths {
+	return c.sanitizerRuntimeTools.Paths()
+}
+
 func (c *Module) IntermPathForModuleOut() android.OptionalPath {
 	return c.outputFile
 }
@@ -3466,6 +3548,19 @@ func (c *Module) DepIsInSameApex(ctx android.BaseModuleContext, dep android.Modu
 	return true
 }
 
+// effectiveMinSdkVersion returns the oldest API level the module supports as set by the user, which
+// is its min_sdk_version, or its sdk_version if it doesn't set one.
+func (c *Module) effectiveMinSdkVersion() string {
+	if minSdkVersion := c.MinSdkVersion(); minSdkVersion != "" {
+		return minSdkVersion
+	}
+	// JNI libs within APK-in-APEX fall into here
+	// Those are okay to set sdk_version instead
+	// We don't have to check if this is a SDK variant because
+	// non-SDK variant resets sdk_version, which works too.
+	return c.SdkVersion()
+}
+
 // Implements android.ApexModule
 func (c *Module) ShouldSupportSdkVersion(ctx android.BaseModuleContext,
 	sdkVersion android.ApiLevel) error {
@@ -3477,17 +3572,10 @@ func (c *Module) ShouldSupportSdkVersion(ctx android.BaseModuleContext,
 	if _, ok := c.linker.(prebuiltLinkerInterface); ok {
 		return nil
 	}
-	minSdkVersion := c.MinSdkVersion()
+	minSdkVersion := c.effectiveMinSdkVersion()
 	if minSdkVersion == "apex_inherit" {
 		return nil
 	}
-	if minSdkVersion == "" {
-		// JNI libs within APK-in-APEX fall into here
-		// Those are okay to set sdk_version instead
-		// We don't have to check if this is a SDK variant because
-		// non-SDK variant resets sdk_version, which works too.
-		minSdkVersion = c.SdkVersion()
-	}
 	if minSdkVersion == "" {
 		return fmt.Errorf("neither min_sdk_version nor sdk_version specificed")
 	}
@@ -3501,6 +3589,22 @@ func (c *Module) ShouldSupportSdkVersion(ctx android.BaseModuleContext,
 	if ver.GreaterThan(sdkVersion) {
 		return fmt.Errorf("newer SDK(%v)", ver)
 	}
+
+	// Unlike the global sanitizers, the sanitizers enabled by the module's own properties or directory
+	// defaults aren't skipped when they can't run on the oldest API level the module supports, see
+	// sanitize.begin. The sanitizers propagated from other modules are checked for those modules
+	// instead.
+	if c.sanitize != nil {
+		enabled := c.sanitize.enabledSanitizerNames()
+		for _, sanitizer := range c.sanitize.explicitSanitizers() {
+			if !inList(sanitizer, enabled) {
+				continue
+			}
+			if min, ok := sanitizerMinSdkVersion(ctx, sanitizer); ok && sdkVersion.LessThan(min) {
+				return fmt.Errorf("sanitizer %s requires min_sdk_version %v or later", sanitizer, min)
+			}
+		}
+	}
 	return nil
 }
 
@@ -672,6 +672,45 @@ func TestVndkUsingCoreVariant(t *testing.T) {
 	checkVndkLibrariesOutput(t, ctx, "vndkcorevariant.libraries.txt", []string{"libc++.so", "libvndk2.so", "libvndk_sp.so"})
 }
 
+func TestVndkInfoMustUseVendorVariant(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libvndk_listed",
+			vendor_available: true,
+			vndk: {
+				enabled: true,
+			},
+			nocrt: true,
+		}
+
+		cc_library {
+			name: "libvndk_unlisted",
+			vendor_available: true,
+			vndk: {
+				enabled: true,
+			},
+			nocrt: true,
+		}
+	`
+
+	config := TestConfig(t.TempDir(), android.Android, nil, bp, nil)
+	config.TestProductVariables.DeviceVndkVersion = StringPtr("current")
+	config.TestProductVariables.Platform_vndk_version = StringPtr("29")
+	config.TestProductVariables.VndkUseCoreVariant = BoolPtr(true)
+
+	setVndkMustUseVendorVariantListForTest(config, []string{"libvndk_listed"})
+
+	ctx := testCcWithConfig(t, config)
+
+	for _, variant := range []string{coreVariant, vendorVariant} {
+		listed := ctx.ModuleProvider(ctx.ModuleForTests("libvndk_listed", variant).Module(), VndkInfoProvider).(VndkInfo)
+		android.AssertBoolEquals(t, "listed "+variant, true, listed.MustUseVendorVariant)
+
+		unlisted := ctx.ModuleProvider(ctx.ModuleForTests("libvndk_unlisted", variant).Module(), VndkInfoProvider).(VndkInfo)
+		android.AssertBoolEquals(t, "unlisted "+variant, false, unlisted.MustUseVendorVariant)
+	}
+}
+
 func TestDataLibs(t *testing.T) {
 	bp := `
 		cc_test_library {
@@ -3391,6 +3430,112 @@ func TestFuzzTarget(t *testing.T) {
 	ctx.ModuleForTests("fuzz_smoke_test", variant).Rule("cc")
 }
 
+// prepareForHwasanFuzzerTest builds the device variants of cc_fuzz modules with HWASan.
+var prepareForHwasanFuzzerTest = android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+	variables.FuzzerDeviceSanitizer = StringPtr("hwaddress")
+})
+
+func TestFuzzTargetHostAndDevice(t *testing.T) {
+	bp := `
+		cc_fuzz {
+			name: "fuzz_both",
+			srcs: ["foo.c"],
+			host_supported: true,
+		}`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForHwasanFuzzerTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("cc_fuzz_packaging", fuzzPackagingFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	hostVariant := result.Config.BuildOSTarget.String() + "_asan_fuzzer"
+	host := result.ModuleForTests("fuzz_both", hostVariant)
+	hostCflags := host.Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "host cflags", hostCflags, "-fsanitize=address")
+	android.AssertStringDoesNotContain(t, "host cflags", hostCflags, "hwaddress")
+
+	device := result.ModuleForTests("fuzz_both", "android_arm64_armv8-a_hwasan_fuzzer")
+	deviceCflags := device.Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "device cflags", deviceCflags, "-fsanitize=hwaddress")
+	android.AssertStringDoesNotContain(t, "device cflags", deviceCflags, "-fsanitize=address")
+
+	packaging := result.SingletonForTests("cc_fuzz_packaging")
+	hostZip := packaging.Output("out/soong/.intermediates/fuzz/host/" +
+		result.Config.BuildOSTarget.Arch.ArchType.String() + "/fuzz_both.zip")
+	android.AssertStringListContains(t, "host package inputs", hostZip.Inputs.Strings(),
+		host.Module().(*Module).UnstrippedOutputFile().String())
+	deviceZip := packaging.Output("out/soong/.intermediates/fuzz/target/arm64/fuzz_both.zip")
+	android.AssertStringListContains(t, "device package inputs", deviceZip.Inputs.Strings(),
+		device.Module().(*Module).UnstrippedOutputFile().String())
+}
+
+// fuzzPackagedModulesSingleton aggregates FuzzPackagedModuleInfoProvider like a vendor fuzz
+// packaging singleton would.
+type fuzzPackagedModulesSingleton struct {
+	infos map[string]FuzzPackagedModuleInfo
+}
+
+func (s *fuzzPackagedModulesSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	ctx.VisitAllModules(func(m android.Module) {
+		if ctx.ModuleHasProvider(m, FuzzPackagedModuleInfoProvider) {
+			s.infos[ctx.ModuleName(m)] = ctx.ModuleProvider(m, FuzzPackagedModuleInfoProvider).(FuzzPackagedModuleInfo)
+		}
+	})
+}
+
+func TestFuzzPackagedModuleInfo(t *testing.T) {
+	bp := `
+		cc_fuzz {
+			name: "fuzz_packaged",
+			srcs: ["foo.c"],
+			corpus: ["corpus/a"],
+			dictionary: "fuzz.dict",
+			fuzz_config: {
+				cc: ["someone@example.com"],
+			},
+		}`
+
+	singleton := &fuzzPackagedModulesSingleton{infos: make(map[string]FuzzPackagedModuleInfo)}
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForHwasanFuzzerTest,
+		android.FixtureMergeMockFs(android.MockFS{
+			"corpus/a":  nil,
+			"fuzz.dict": nil,
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("cc_fuzz_packaging", fuzzPackagingFactory)
+			ctx.RegisterSingletonType("vendor_fuzz_packaging", func() android.Singleton { return singleton })
+		}),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a_hwasan_fuzzer"
+	module := result.ModuleForTests("fuzz_packaged", variant).Module().(*Module)
+
+	info, ok := singleton.infos["fuzz_packaged"]
+	if !ok {
+		t.Fatalf("expected fuzz_packaged to publish FuzzPackagedModuleInfo")
+	}
+	android.AssertStringEquals(t, "binary", module.UnstrippedOutputFile().String(), info.Binary.String())
+	android.AssertPathsRelativeToTopEquals(t, "corpus", []string{"corpus/a"}, info.Corpus)
+	android.AssertPathRelativeToTopEquals(t, "dictionary", "fuzz.dict", info.Dictionary)
+	android.AssertPathRelativeToTopEquals(t, "config",
+		"out/soong/.intermediates/fuzz_packaged/"+variant+"/config/config.json", info.Config)
+	android.AssertArrayString(t, "fuzz_config.cc", []string{"someone@example.com"}, info.FuzzConfig.Cc)
+	android.AssertArrayString(t, "sanitizers", []string{"hwaddress", "fuzzer"}, info.Sanitizers)
+
+	// The platform packaging packages the same files.
+	packaging := result.SingletonForTests("cc_fuzz_packaging")
+	zip := packaging.Output("out/soong/.intermediates/fuzz/target/arm64/fuzz_packaged.zip")
+	inputs := zip.Inputs.Strings()
+	for _, p := range []android.Path{info.Binary, info.Dictionary, info.Config} {
+		android.AssertStringListContains(t, "package inputs", inputs, p.String())
+	}
+}
+
 func TestAidl(t *testing.T) {
 }
 
@@ -67,15 +67,7 @@ func (c *compdbGeneratorSingleton) GenerateBuildActions(ctx android.SingletonCon
 	// Instruct the generator to indent the json file for easier debugging.
 	outputCompdbDebugInfo := ctx.Config().IsEnvTrue(envVariableGenerateCompdbDebugInfo)
 
-	// We only want one entry per file. We don't care what module/isa it's from
-	m := make(map[string]compDbEntry)
-	ctx.VisitAllModules(func(module android.Module) {
-		if ccModule, ok := module.(*Module); ok {
-			if compiledModule, ok := ccModule.compiler.(CompiledInterface); ok {
-				generateCompdbProject(compiledModule, ctx, ccModule, m)
-			}
-		}
-	})
+	v := collectCompdbEntries(ctx)
 
 	// Create the output file.
 	dir := android.PathForOutput(ctx, compdbOutputProjectsDirectory)
@@ -87,11 +79,6 @@ func (c *compdbGeneratorSingleton) GenerateBuildActions(ctx android.SingletonCon
 	}
 	defer f.Close()
 
-	v := make([]compDbEntry, 0, len(m))
-
-	for _, value := range m {
-		v = append(v, value)
-	}
 	var dat []byte
 	if outputCompdbDebugInfo {
 		dat, err = json.MarshalIndent(v, "", " ")
@@ -112,6 +99,42 @@ func (c *compdbGeneratorSingleton) GenerateBuildActions(ctx android.SingletonCon
 	}
 }
 
+// A compdb entry and the number of sanitizers of the variant it was generated from.
+type compdbCandidate struct {
+	entry      compDbEntry
+	sanitizers int
+}
+
+// collectCompdbEntries returns one entry per source file, sorted by file. We don't care what
+// module/isa it's from, but the entry of a variant built with sanitizers is only used if no variant
+// built with fewer of them compiles the file, so that the -fsanitize flags of sanitizer variants
+// don't end up in the compdb when a plain variant exists. Between variants with as many
+// sanitizers, the first one visited wins.
+func collectCompdbEntries(ctx android.SingletonContext) []compDbEntry {
+	m := make(map[string]compdbCandidate)
+	ctx.VisitAllModules(func(module android.Module) {
+		if ccModule, ok := module.(*Module); ok {
+			if compiledModule, ok := ccModule.compiler.(CompiledInterface); ok {
+				generateCompdbProject(compiledModule, ctx, ccModule, m)
+			}
+		}
+	})
+
+	v := make([]compDbEntry, 0, len(m))
+	for _, file := range android.SortedStringKeys(m) {
+		v = append(v, m[file].entry)
+	}
+	return v
+}
+
+// compdbSanitizers returns the number of sanitizers the variant of a module is built with.
+func compdbSanitizers(ccModule *Module) int {
+	if ccModule.sanitize == nil {
+		return 0
+	}
+	return len(ccModule.sanitize.Properties.Sanitizers)
+}
+
 func expandAllVars(ctx android.SingletonContext, args []string) []string {
 	var out []string
 	for _, arg := range args {
@@ -168,11 +191,12 @@ func getArguments(src android.Path, ctx android.SingletonContext, ccModule *Modu
 	return args
 }
 
-func generateCompdbProject(compiledModule CompiledInterface, ctx android.SingletonContext, ccModule *Module, builds map[string]compDbEntry) {
+func generateCompdbProject(compiledModule CompiledInterface, ctx android.SingletonContext, ccModule *Module, builds map[string]compdbCandidate) {
 	srcs := compiledModule.Srcs()
 	if len(srcs) == 0 {
 		return
 	}
+	sanitizers := compdbSanitizers(ccModule)
 
 	pathToCC, err := ctx.Eval(pctx, "${config.ClangBin}")
 	ccPath := "/bin/false"
@@ -182,11 +206,14 @@ func generateCompdbProject(compiledModule CompiledInterface, ctx android.Singlet
 		cxxPath = filepath.Join(pathToCC, "clang++")
 	}
 	for _, src := range srcs {
-		if _, ok := builds[src.String()]; !ok {
-			builds[src.String()] = compDbEntry{
-				Directory: android.AbsSrcDirForExistingUseCases(),
-				Arguments: getArguments(src, ctx, ccModule, ccPath, cxxPath),
-				File:      src.String(),
+		if existing, ok := builds[src.String()]; !ok || sanitizers < existing.sanitizers {
+			builds[src.String()] = compdbCandidate{
+				entry: compDbEntry{
+					Directory: android.AbsSrcDirForExistingUseCases(),
+					Arguments: getArguments(src, ctx, ccModule, ccPath, cxxPath),
+					File:      src.String(),
+				},
+				sanitizers: sanitizers,
 			}
 		}
 	}
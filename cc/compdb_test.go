@@ -0,0 +1,84 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+// compdbTestSingleton records the entries the compdb generator would write, without writing
+// compile_commands.json.
+type compdbTestSingleton struct {
+	entries []compDbEntry
+}
+
+func (s *compdbTestSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	s.entries = collectCompdbEntries(ctx)
+}
+
+func TestCompdbPrefersPlainVariants(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["common.c", "bin.c"],
+			static_libs: ["libfoo"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_static {
+			name: "libcommon",
+			srcs: ["common.c"],
+		}
+	`
+
+	singleton := &compdbTestSingleton{}
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureMergeMockFs(android.MockFS{
+			"bin.c":    nil,
+			"common.c": nil,
+			"foo.c":    nil,
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("compdb_test", func() android.Singleton { return singleton })
+		}),
+	).RunTestWithBp(t, bp)
+
+	// libfoo has a plain and an asan variant.
+	result.ModuleForTests("libfoo", "android_arm64_armv8-a_static_asan")
+
+	var files []string
+	arguments := make(map[string][]string)
+	for _, entry := range singleton.entries {
+		files = append(files, entry.File)
+		arguments[entry.File] = entry.Arguments
+	}
+	android.AssertDeepEquals(t, "files", []string{"bin.c", "common.c", "foo.c"}, files)
+
+	// The sanitized variant is only used for the files that no plain variant compiles.
+	android.AssertStringListContains(t, "bin.c arguments", arguments["bin.c"], "-fsanitize=address")
+	android.AssertStringListDoesNotContain(t, "common.c arguments", arguments["common.c"], "-fsanitize=address")
+	android.AssertStringListDoesNotContain(t, "foo.c arguments", arguments["foo.c"], "-fsanitize=address")
+}
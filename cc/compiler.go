@@ -263,6 +263,14 @@ func (compiler *baseCompiler) Srcs() android.Paths {
 	return append(android.Paths{}, compiler.srcs...)
 }
 
+// hasSrcs returns true if the module has sources of its own to compile.
+func (compiler *baseCompiler) hasSrcs() bool {
+	// The sources of shared libraries that reuse the objects of their static variant are moved to
+	// OriginalSrcs.
+	return len(compiler.Properties.Srcs) > 0 || len(compiler.Properties.OriginalSrcs) > 0 ||
+		len(compiler.Properties.Generated_sources) > 0
+}
+
 func (compiler *baseCompiler) appendCflags(flags []string) {
 	compiler.Properties.Cflags = append(compiler.Properties.Cflags, flags...)
 }
@@ -656,6 +664,17 @@ func (compiler *baseCompiler) hasSrcExt(ext string) bool {
 	return false
 }
 
+// hasCppSrcs returns true if the module compiles C++ sources, including the C++ sources generated
+// from its other sources, e.g. yacc or aidl files.
+func (compiler *baseCompiler) hasCppSrcs() bool {
+	for _, ext := range []string{".cpp", ".cc", ".cxx", ".mm", ".yy", ".ll", ".proto", ".aidl", ".sysprop"} {
+		if compiler.hasSrcExt(ext) {
+			return true
+		}
+	}
+	return false
+}
+
 var invalidDefineCharRegex = regexp.MustCompile("[^a-zA-Z0-9_]")
 
 // makeDefineString transforms a name of an APEX module into a value to be used as value for C define
@@ -0,0 +1,180 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+func init() {
+	android.RegisterSingletonType("compiler_flags_report", compilerFlagsReportFactory)
+}
+
+// CompilerFlagsInfo is the final set of flags used to compile the C, C++ and assembly sources of
+// a cc module variant, categorized by what added them. Flags that reference ninja variables, e.g.
+// "${config.CommonGlobalCflags}", are not expanded. The compiler_flags_report singleton only
+// writes them to a report when the CompilerFlagsReport product variable is set.
+type CompilerFlagsInfo struct {
+	// Flags added by the build system for all modules, e.g. the global warning and hardening flags.
+	Global []string
+	// Flags specific to the target architecture and CPU variant, including the target triple.
+	Arch []string
+	// Flags added by the module's own properties, e.g. cflags and include dirs, and by its
+	// dependencies.
+	Module []string
+	// Flags added by the sanitizers enabled for this variant.
+	Sanitize []string
+	// Flags added by LTO.
+	Lto []string
+}
+
+var CompilerFlagsInfoProvider = blueprint.NewProvider(CompilerFlagsInfo{})
+
+// compileFlags returns the flags in f that apply to compiling C, C++ and assembly sources.
+func compileFlags(f LocalOrGlobalFlags) []string {
+	var flags []string
+	flags = append(flags, f.CommonFlags...)
+	flags = append(flags, f.AsFlags...)
+	flags = append(flags, f.CFlags...)
+	flags = append(flags, f.ConlyFlags...)
+	flags = append(flags, f.CppFlags...)
+	return flags
+}
+
+// allCompileFlags returns the local and global flags in flags that apply to compiling C, C++ and
+// assembly sources.
+func allCompileFlags(flags Flags) []string {
+	return append(compileFlags(flags.Global), compileFlags(flags.Local)...)
+}
+
+// addedCompileFlags returns the compile flags in after that are not in before, which is the result
+// of allCompileFlags before after was computed.
+func addedCompileFlags(before []string, after Flags) []string {
+	return android.FirstUniqueStrings(android.RemoveListFromList(allCompileFlags(after), before))
+}
+
+// toolchainArchFlags returns the arch and CPU variant specific flags of the toolchain.
+func toolchainArchFlags(tc config.Toolchain) []string {
+	flags := []string{tc.Cflags(), tc.ToolchainCflags(), tc.Cppflags(), tc.Asflags()}
+	for _, isa := range []string{"", "arm", "thumb"} {
+		if isaFlags, err := tc.InstructionSetFlags(isa); err == nil {
+			flags = append(flags, isaFlags)
+		}
+	}
+	return flags
+}
+
+// setCompilerFlagsInfoProvider categorizes the final compile flags of this variant and publishes
+// them in CompilerFlagsInfoProvider. sanitizeFlags and ltoFlags are the flags added by the
+// sanitize and lto features respectively.
+func (c *Module) setCompilerFlagsInfoProvider(ctx ModuleContext, flags Flags, sanitizeFlags, ltoFlags []string) {
+	archFlags := toolchainArchFlags(flags.Toolchain)
+	var info CompilerFlagsInfo
+	for _, flag := range android.FirstUniqueStrings(compileFlags(flags.Global)) {
+		switch {
+		case flag == "" || android.InList(flag, sanitizeFlags) || android.InList(flag, ltoFlags):
+			// Flags added by sanitize and lto are reported in their own categories.
+		case strings.HasPrefix(flag, "-target ") || android.InList(flag, archFlags):
+			info.Arch = append(info.Arch, flag)
+		default:
+			info.Global = append(info.Global, flag)
+		}
+	}
+	for _, flag := range android.FirstUniqueStrings(compileFlags(flags.Local)) {
+		if flag != "" && !android.InList(flag, sanitizeFlags) && !android.InList(flag, ltoFlags) {
+			info.Module = append(info.Module, flag)
+		}
+	}
+	info.Sanitize = sanitizeFlags
+	info.Lto = ltoFlags
+	ctx.SetProvider(CompilerFlagsInfoProvider, info)
+}
+
+func compilerFlagsReportFactory() android.Singleton {
+	return &compilerFlagsReport{}
+}
+
+// compilerFlagsReport writes the categorized compile flags of every cc module variant to a JSON
+// file when the CompilerFlagsReport product variable is set, for auditing the hardening flags each
+// module is built with.
+type compilerFlagsReport struct {
+	outputPath android.Path
+}
+
+// compilerFlagsReportEntry is the JSON representation of the compile flags of a module variant.
+type compilerFlagsReportEntry struct {
+	Module      string   `json:"module"`
+	Variant     string   `json:"variant"`
+	Global      []string `json:"global,omitempty"`
+	Arch        []string `json:"arch,omitempty"`
+	ModuleFlags []string `json:"module_flags,omitempty"`
+	Sanitize    []string `json:"sanitize,omitempty"`
+	Lto         []string `json:"lto,omitempty"`
+}
+
+func (r *compilerFlagsReport) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().CompilerFlagsReport() {
+		return
+	}
+
+	var entries []compilerFlagsReportEntry
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() || !ctx.ModuleHasProvider(module, CompilerFlagsInfoProvider) {
+			return
+		}
+		info := ctx.ModuleProvider(module, CompilerFlagsInfoProvider).(CompilerFlagsInfo)
+		entries = append(entries, compilerFlagsReportEntry{
+			Module:      ctx.ModuleName(module),
+			Variant:     ctx.ModuleSubDir(module),
+			Global:      info.Global,
+			Arch:        info.Arch,
+			ModuleFlags: info.Module,
+			Sanitize:    info.Sanitize,
+			Lto:         info.Lto,
+		})
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Module != entries[j].Module {
+			return entries[i].Module < entries[j].Module
+		}
+		return entries[i].Variant < entries[j].Variant
+	})
+
+	contents, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to marshal compiler flags report: %s", err)
+		return
+	}
+
+	report := android.PathForOutput(ctx, "compiler_flags.json")
+	android.WriteFileRule(ctx, report, string(contents))
+	r.outputPath = report
+	ctx.Phony("compiler_flags_report", report)
+}
+
+func (r *compilerFlagsReport) MakeVars(ctx android.MakeVarsContext) {
+	if r.outputPath != nil {
+		ctx.DistForGoal("compiler_flags_report", r.outputPath)
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*compilerFlagsReport)(nil)
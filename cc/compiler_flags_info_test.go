@@ -0,0 +1,107 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func TestCompilerFlagsInfo(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			cflags: ["-DUSER_FLAG"],
+			sanitize: {
+				misc_undefined: ["bounds"],
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.CompilerFlagsReport = proptools.BoolPtr(true)
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("compiler_flags_report", compilerFlagsReportFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	bin := result.ModuleForTests("bin", "android_arm64_armv8-a").Module()
+	info := result.ModuleProvider(bin, CompilerFlagsInfoProvider).(CompilerFlagsInfo)
+
+	android.AssertStringListContains(t, "module flags", info.Module, "-DUSER_FLAG")
+	android.AssertStringListDoesNotContain(t, "module flags", info.Module, "-fsanitize=bounds")
+
+	android.AssertStringListContains(t, "sanitize flags", info.Sanitize, "-fsanitize=bounds")
+	android.AssertStringListContains(t, "sanitize flags", info.Sanitize, strings.Join(minimalRuntimeFlags, " "))
+	android.AssertStringListDoesNotContain(t, "sanitize flags", info.Sanitize, "-DUSER_FLAG")
+
+	android.AssertStringListContains(t, "global flags", info.Global, "${config.CommonGlobalCflags}")
+	android.AssertStringListDoesNotContain(t, "global flags", info.Global, "-fsanitize=bounds")
+
+	hasTarget := false
+	for _, flag := range info.Arch {
+		if strings.HasPrefix(flag, "-target aarch64-linux-android") {
+			hasTarget = true
+		}
+	}
+	if !hasTarget {
+		t.Errorf("expected arch flags to contain the target triple, got %q", info.Arch)
+	}
+
+	android.AssertIntEquals(t, "lto flags", 0, len(info.Lto))
+
+	report := result.SingletonForTests("compiler_flags_report").Output("compiler_flags.json")
+	contents := android.ContentFromFileRuleForTests(t, report)
+	android.AssertStringDoesContain(t, "report", contents, `"module": "bin"`)
+	android.AssertStringDoesContain(t, "report", contents, `"-fsanitize=bounds"`)
+}
+
+func TestCompilerFlagsInfoDisabled(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			cflags: ["-DUSER_FLAG"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("compiler_flags_report", compilerFlagsReportFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	// The flags are still available to other modules and singletons, only the report isn't written.
+	bin := result.ModuleForTests("bin", "android_arm64_armv8-a").Module()
+	info := result.ModuleProvider(bin, CompilerFlagsInfoProvider).(CompilerFlagsInfo)
+	android.AssertStringListContains(t, "module flags", info.Module, "-DUSER_FLAG")
+	android.AssertStringListContains(t, "global flags", info.Global, "${config.CommonGlobalCflags}")
+
+	report := result.SingletonForTests("compiler_flags_report").MaybeOutput("compiler_flags.json")
+	if report.Rule != nil {
+		t.Errorf("expected no compiler flags report, got %q", report.Output)
+	}
+}
@@ -683,6 +683,15 @@ func ClangPath(ctx android.PathContext, file string) android.SourcePath {
 
 var clangPathKey = android.NewOnceKey("clangPath")
 
+// ClangReleaseVersion returns the release version of the clang toolchain, e.g. "14.0.6", which
+// can be overridden with LLVM_RELEASE_VERSION like ClangShortVersion.
+func ClangReleaseVersion(config android.Config) string {
+	if override := config.Getenv("LLVM_RELEASE_VERSION"); override != "" {
+		return override
+	}
+	return ClangDefaultShortVersion
+}
+
 func clangPath(ctx android.PathContext) android.SourcePath {
 	return ctx.Config().OnceSourcePath(clangPathKey, func() android.SourcePath {
 		clangBase := ClangDefaultBase
@@ -238,6 +238,13 @@ func AddressSanitizerRuntimeLibrary(t Toolchain) string {
 	return LibclangRuntimeLibrary(t, "asan")
 }
 
+// AddressSanitizerMuslStaticLibrary returns the address sanitizer runtime library built against
+// musl, which is linked statically into executables because preloading the shared runtime is
+// unreliable with musl.
+func AddressSanitizerMuslStaticLibrary(t Toolchain) string {
+	return LibclangRuntimeLibrary(t, "asan_musl_static")
+}
+
 func HWAddressSanitizerRuntimeLibrary(t Toolchain) string {
 	return LibclangRuntimeLibrary(t, "hwasan")
 }
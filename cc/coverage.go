@@ -18,15 +18,28 @@ import (
 	"strconv"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
 )
 
-const profileInstrFlag = "-fprofile-instr-generate=/data/misc/trace/clang-%p-%m.profraw"
+// defaultCoverageProfileDir is the directory on the device where the clang coverage runtime writes
+// the profraw files of modules that don't set native_coverage_profile_dir.
+const defaultCoverageProfileDir = "/data/misc/trace"
+
+// profileInstrFlag returns the flag that enables clang coverage instrumentation and makes the
+// runtime write the profraw files to the given LLVM_PROFILE_FILE pattern by default.
+func profileInstrFlag(profileFile string) string {
+	return "-fprofile-instr-generate=" + profileFile
+}
 
 type CoverageProperties struct {
 	Native_coverage *bool
 
+	// The directory on the device where the clang coverage runtime of this module writes its
+	// profraw files, for the tooling that collects them. Defaults to /data/misc/trace.
+	Native_coverage_profile_dir *string
+
 	NeedCoverageVariant bool `blueprint:"mutated"`
 	NeedCoverageBuild   bool `blueprint:"mutated"`
 
@@ -45,6 +58,25 @@ func (cov *coverage) props() []interface{} {
 	return []interface{}{&cov.Properties}
 }
 
+// profileDir returns the directory on the device where the clang coverage runtime of this module
+// writes its profraw files.
+func (cov *coverage) profileDir() string {
+	return proptools.StringDefault(cov.Properties.Native_coverage_profile_dir, defaultCoverageProfileDir)
+}
+
+// profileFile returns the default LLVM_PROFILE_FILE pattern of the clang coverage runtime of this
+// module.
+func (cov *coverage) profileFile() string {
+	return cov.profileDir() + "/clang-%p-%m.profraw"
+}
+
+// clangProfileLinked returns true if this variant is linked with the clang coverage runtime, which
+// writes profraw files to profileFile when it runs.
+func (cov *coverage) clangProfileLinked(ctx android.BaseModuleContext) bool {
+	return cov.linkCoverage && ctx.DeviceConfig().ClangCoverageEnabled() &&
+		!ctx.DeviceConfig().GcovCoverageEnabled()
+}
+
 func getGcovProfileLibraryName(ctx ModuleContextIntf) string {
 	// This function should only ever be called for a cc.Module, so the
 	// following statement should always succeed.
@@ -100,7 +132,7 @@ func (cov *coverage) flags(ctx ModuleContext, flags Flags, deps PathDeps) (Flags
 			// flags that the module may use.
 			flags.Local.CFlags = append(flags.Local.CFlags, "-Wno-frame-larger-than=", "-O0")
 		} else if clangCoverage {
-			flags.Local.CommonFlags = append(flags.Local.CommonFlags, profileInstrFlag,
+			flags.Local.CommonFlags = append(flags.Local.CommonFlags, profileInstrFlag(cov.profileFile()),
 				"-fcoverage-mapping", "-Wno-pass-failed", "-D__ANDROID_CLANG_COVERAGE__")
 			// Override -Wframe-larger-than.  We can expect frame size increase after
 			// coverage instrumentation.
@@ -158,7 +190,7 @@ func (cov *coverage) flags(ctx ModuleContext, flags Flags, deps PathDeps) (Flags
 
 			flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--wrap,getenv")
 		} else if clangCoverage {
-			flags.Local.LdFlags = append(flags.Local.LdFlags, profileInstrFlag)
+			flags.Local.LdFlags = append(flags.Local.LdFlags, profileInstrFlag(cov.profileFile()))
 			if EnableContinuousCoverage(ctx) {
 				flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,-mllvm=-runtime-counter-relocation")
 			}
@@ -0,0 +1,89 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"encoding/json"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("coverage_profile_dirs", coverageProfileDirsFactory)
+}
+
+// CoverageProfileInfo records where the clang coverage runtime linked into a variant writes its
+// profraw files on the device, so that the tooling collecting them doesn't have to guess.
+type CoverageProfileInfo struct {
+	// The directory the profraw files are written to, e.g. /data/misc/trace.
+	Dir string
+	// The default LLVM_PROFILE_FILE pattern of the runtime, e.g.
+	// /data/misc/trace/clang-%p-%m.profraw.
+	File string
+}
+
+var CoverageProfileInfoProvider = blueprint.NewProvider(CoverageProfileInfo{})
+
+// setCoverageProfileInfoProvider publishes CoverageProfileInfoProvider for variants linked with
+// the clang coverage runtime.
+func (c *Module) setCoverageProfileInfoProvider(ctx ModuleContext) {
+	if c.coverage == nil || !c.coverage.clangProfileLinked(ctx) {
+		return
+	}
+	ctx.SetProvider(CoverageProfileInfoProvider, CoverageProfileInfo{
+		Dir:  c.coverage.profileDir(),
+		File: c.coverage.profileFile(),
+	})
+}
+
+func coverageProfileDirsFactory() android.Singleton {
+	return &coverageProfileDirsSingleton{}
+}
+
+// coverageProfileDirsSingleton writes coverage_profile_dirs.json, which maps the on-device path of
+// each installed file built with clang coverage to the directory its coverage runtime writes the
+// profraw files to, for the tooling that configures their collection on the device.
+type coverageProfileDirsSingleton struct{}
+
+func (s *coverageProfileDirsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.DeviceConfig().ClangCoverageEnabled() {
+		return
+	}
+
+	dirs := make(map[string]string)
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() || module.IsSkipInstall() || module.Target().Os.Class != android.Device ||
+			!ctx.ModuleHasProvider(module, CoverageProfileInfoProvider) {
+			return
+		}
+		info := ctx.ModuleProvider(module, CoverageProfileInfoProvider).(CoverageProfileInfo)
+		for _, installed := range module.FilesToInstall() {
+			dirs[android.InstallPathToOnDevicePath(ctx, installed)] = info.Dir
+		}
+	})
+
+	// json.Marshal sorts the keys of maps.
+	data, err := json.MarshalIndent(dirs, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to marshal coverage profile dirs: %s", err)
+		return
+	}
+
+	out := android.PathForOutput(ctx, "coverage_profile_dirs.json")
+	android.WriteFileRule(ctx, out, string(data))
+	ctx.Phony("coverage_profile_dirs", out)
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"android/soong/android"
+
+	"github.com/google/blueprint/proptools"
+)
+
+func TestCoverageProfileDirs(t *testing.T) {
+	bp := `
+		cc_fuzz {
+			name: "fuzz",
+			srcs: ["foo.c"],
+			native_coverage_profile_dir: "/data/fuzz/coverage",
+		}
+
+		cc_test {
+			name: "test",
+			srcs: ["foo.c"],
+			gtest: false,
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForHwasanFuzzerTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ClangCoverage = proptools.BoolPtr(true)
+			variables.Native_coverage = proptools.BoolPtr(true)
+			variables.NativeCoveragePaths = []string{"*"}
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("coverage_profile_dirs", coverageProfileDirsFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	fuzz := result.ModuleForTests("fuzz", "android_arm64_armv8-a_hwasan_fuzzer_cov")
+	android.AssertStringDoesContain(t, "fuzz ldflags", fuzz.Rule("ld").Args["ldFlags"],
+		"-fprofile-instr-generate=/data/fuzz/coverage/clang-%p-%m.profraw")
+	fuzzInfo := result.ModuleProvider(fuzz.Module(), CoverageProfileInfoProvider).(CoverageProfileInfo)
+	android.AssertStringEquals(t, "fuzz profile dir", "/data/fuzz/coverage", fuzzInfo.Dir)
+
+	test := result.ModuleForTests("test", "android_arm64_armv8-a_cov")
+	testInfo := result.ModuleProvider(test.Module(), CoverageProfileInfoProvider).(CoverageProfileInfo)
+	android.AssertStringEquals(t, "test profile file", "/data/misc/trace/clang-%p-%m.profraw", testInfo.File)
+
+	extraConfigs := test.Rule("autogen").Args["extraConfigs"]
+	android.AssertStringDoesContain(t, "test config", extraConfigs,
+		`<option name="config-descriptor:metadata" key="coverage-profile-dir" value="/data/misc/trace" />`)
+	android.AssertStringDoesContain(t, "test config", extraConfigs,
+		`<option name="config-descriptor:metadata" key="llvm-profile-file" value="/data/misc/trace/clang-%p-%m.profraw" />`)
+
+	// The aggregate mapping covers the installed files of both modules.
+	pathCtx := android.PathContextForTesting(result.Config)
+	expected := make(map[string]string)
+	for _, installed := range fuzz.Module().FilesToInstall() {
+		expected[android.InstallPathToOnDevicePath(pathCtx, installed)] = "/data/fuzz/coverage"
+	}
+	for _, installed := range test.Module().FilesToInstall() {
+		expected[android.InstallPathToOnDevicePath(pathCtx, installed)] = "/data/misc/trace"
+	}
+	if len(expected) < 2 {
+		t.Fatalf("expected fuzz and test to install files, got %q", expected)
+	}
+
+	singleton := result.SingletonForTests("coverage_profile_dirs")
+	var dirs map[string]string
+	content := android.ContentFromFileRuleForTests(t, singleton.Output("out/soong/coverage_profile_dirs.json"))
+	if err := json.Unmarshal([]byte(content), &dirs); err != nil {
+		t.Fatalf("failed to parse coverage_profile_dirs.json: %s", err)
+	}
+	android.AssertDeepEquals(t, "coverage profile dirs", expected, dirs)
+}
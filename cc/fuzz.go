@@ -19,6 +19,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
@@ -33,12 +34,48 @@ func init() {
 
 // cc_fuzz creates a host/device fuzzer binary. Host binaries can be found at
 // $ANDROID_HOST_OUT/fuzz/, and device binaries can be found at /data/fuzz on
-// your device, or $ANDROID_PRODUCT_OUT/data/fuzz in your build tree.
+// your device, or $ANDROID_PRODUCT_OUT/data/fuzz in your build tree. With
+// host_supported: true, a single module builds both. Unless set explicitly, the
+// host variant is built with ASan and the device variant with the sanitizer set
+// by the FuzzerDeviceSanitizer product variable, e.g. HWASan.
 func FuzzFactory() android.Module {
 	module := NewFuzz(android.HostAndDeviceSupported)
 	return module.Init()
 }
 
+// FuzzPackagedModuleInfo is published by the installed variants of cc_fuzz modules with what the
+// fuzz packaging singleton packages for them, so that other packaging singletons, e.g. for vendor
+// fuzz delivery artifacts, can package the same files without walking the module graph again.
+type FuzzPackagedModuleInfo struct {
+	// The unstripped fuzz target executable.
+	Binary android.Path
+	// The seed corpus files.
+	Corpus android.Paths
+	// The data files, relative to their directory in the source tree.
+	Data android.Paths
+	// The fuzzer dictionary, or nil if the module doesn't set one.
+	Dictionary android.Path
+	// The JSON file of the fuzz_config property, or nil if the module doesn't set it.
+	Config android.Path
+	// The fuzz_config property, or nil if the module doesn't set it.
+	FuzzConfig *fuzz.FuzzConfig
+	// The names of the sanitizers enabled for the variant, e.g. ["hwaddress", "fuzzer"].
+	Sanitizers []string
+}
+
+var FuzzPackagedModuleInfoProvider = blueprint.NewProvider(FuzzPackagedModuleInfo{})
+
+// packagedModule returns the fuzz.FuzzPackagedModule expected by fuzz.FuzzPackager for the info.
+func (info FuzzPackagedModuleInfo) packagedModule() fuzz.FuzzPackagedModule {
+	return fuzz.FuzzPackagedModule{
+		FuzzProperties: fuzz.FuzzProperties{Fuzz_config: info.FuzzConfig},
+		Dictionary:     info.Dictionary,
+		Corpus:         info.Corpus,
+		Config:         info.Config,
+		Data:           info.Data,
+	}
+}
+
 func NewFuzzInstaller() *baseInstaller {
 	return NewBaseInstaller("fuzz", "fuzz", InstallInData)
 }
@@ -224,6 +261,16 @@ func (fuzz *fuzzBinary) install(ctx ModuleContext, file android.Path) {
 		return false
 	})
 
+	ctx.SetProvider(FuzzPackagedModuleInfoProvider, FuzzPackagedModuleInfo{
+		Binary:     fuzz.binaryDecorator.unstrippedOutputFile,
+		Corpus:     fuzz.fuzzPackagedModule.Corpus,
+		Data:       fuzz.fuzzPackagedModule.Data,
+		Dictionary: fuzz.fuzzPackagedModule.Dictionary,
+		Config:     fuzz.fuzzPackagedModule.Config,
+		FuzzConfig: fuzz.fuzzPackagedModule.FuzzProperties.Fuzz_config,
+		Sanitizers: ctx.Module().(*Module).sanitize.enabledSanitizerNames(),
+	})
+
 	for _, lib := range sharedLibraries {
 		fuzz.installedSharedDeps = append(fuzz.installedSharedDeps,
 			sharedLibraryInstallLocation(
@@ -306,10 +353,11 @@ func (s *ccFuzzPackager) GenerateBuildActions(ctx android.SingletonContext) {
 			return
 		}
 
-		fuzzModule, ok := ccModule.compiler.(*fuzzBinary)
-		if !ok {
+		if !ctx.ModuleHasProvider(module, FuzzPackagedModuleInfoProvider) {
 			return
 		}
+		info := ctx.ModuleProvider(module, FuzzPackagedModuleInfoProvider).(FuzzPackagedModuleInfo)
+		fuzzPackagedModule := info.packagedModule()
 
 		hostOrTargetString := "target"
 		if ccModule.Host() {
@@ -327,15 +375,15 @@ func (s *ccFuzzPackager) GenerateBuildActions(ctx android.SingletonContext) {
 		builder := android.NewRuleBuilder(pctx, ctx)
 
 		// Package the corpus, data, dict and config into a zipfile.
-		files = s.PackageArtifacts(ctx, module, fuzzModule.fuzzPackagedModule, archDir, builder)
+		files = s.PackageArtifacts(ctx, module, fuzzPackagedModule, archDir, builder)
 
 		// Package shared libraries
 		files = append(files, GetSharedLibsToZip(sharedLibraries, ccModule, &s.FuzzPackager, archString, &sharedLibraryInstalled)...)
 
 		// The executable.
-		files = append(files, fuzz.FileToZip{ccModule.UnstrippedOutputFile(), ""})
+		files = append(files, fuzz.FileToZip{info.Binary, ""})
 
-		archDirs[archOs], ok = s.BuildZipFile(ctx, module, fuzzModule.fuzzPackagedModule, files, builder, archDir, archString, hostOrTargetString, archOs, archDirs)
+		archDirs[archOs], ok = s.BuildZipFile(ctx, module, fuzzPackagedModule, files, builder, archDir, archString, hostOrTargetString, archOs, archDirs)
 		if !ok {
 			return
 		}
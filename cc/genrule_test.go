@@ -187,3 +187,52 @@ func TestCmdPrefix(t *testing.T) {
 		})
 	}
 }
+
+func TestGenruleSanitizedToolRuntime(t *testing.T) {
+	bp := `
+		cc_binary_host {
+			name: "tool",
+			srcs: ["foo.cpp"],
+			nocrt: true,
+			no_libcrt: true,
+			stl: "none",
+			system_shared_libs: [],
+			sanitize: {
+				thread: true,
+				no_runtime_install: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libclang_rt.tsan",
+			host_supported: true,
+			nocrt: true,
+			no_libcrt: true,
+			stl: "none",
+			system_shared_libs: [],
+			sanitize: {
+				never: true,
+			},
+		}
+
+		genrule {
+			name: "gen",
+			tools: ["tool"],
+			out: ["gen.cpp"],
+			cmd: "$(location tool) $(out)",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.PrepareForTestWithHostMusl,
+	).RunTestWithBp(t, bp)
+
+	// Musl host tools link the shared runtime of the thread sanitizer, while the address sanitizer
+	// runtime is linked statically.
+	buildOS := result.Config.BuildOSTarget.String()
+	runtime := result.ModuleForTests("libclang_rt.tsan", buildOS+"_shared").Description("install")
+
+	gen := result.ModuleForTests("gen", "").Output("gen.cpp")
+	android.AssertStringListContains(t, "genrule tools", gen.RuleParams.CommandDeps, runtime.Output.String())
+}
@@ -312,6 +312,8 @@ func libraryBp2Build(ctx android.TopDownMutatorContext, m *Module) {
 		Copts:   *compilerAttrs.copts.Clone().Append(staticAttrs.Copts),
 		Hdrs:    *compilerAttrs.hdrs.Clone().Append(staticAttrs.Hdrs),
 
+		Additional_compiler_inputs: compilerAttrs.additionalCompilerInputs,
+
 		Deps:                              *linkerAttrs.deps.Clone().Append(staticAttrs.Deps),
 		Implementation_deps:               *linkerAttrs.implementationDeps.Clone().Append(staticAttrs.Implementation_deps),
 		Dynamic_deps:                      *linkerAttrs.dynamicDeps.Clone().Append(staticAttrs.Dynamic_deps),
@@ -329,6 +331,8 @@ func libraryBp2Build(ctx android.TopDownMutatorContext, m *Module) {
 		Copts:   *compilerAttrs.copts.Clone().Append(sharedAttrs.Copts),
 		Hdrs:    *compilerAttrs.hdrs.Clone().Append(sharedAttrs.Hdrs),
 
+		Additional_compiler_inputs: compilerAttrs.additionalCompilerInputs,
+
 		Deps:                        *linkerAttrs.deps.Clone().Append(sharedAttrs.Deps),
 		Implementation_deps:         *linkerAttrs.implementationDeps.Clone().Append(sharedAttrs.Implementation_deps),
 		Dynamic_deps:                *linkerAttrs.dynamicDeps.Clone().Append(sharedAttrs.Dynamic_deps),
@@ -393,6 +397,8 @@ func libraryBp2Build(ctx android.TopDownMutatorContext, m *Module) {
 
 		Stubs_symbol_file: compilerAttrs.stubsSymbolFile,
 		Stubs_versions:    compilerAttrs.stubsVersions,
+
+		vndkAttributes: bp2BuildParseVndkAttributes(ctx, m),
 	}
 
 	for axis, configToProps := range m.GetArchVariantProperties(ctx, &LibraryProperties{}) {
@@ -422,6 +428,7 @@ func libraryBp2Build(ctx android.TopDownMutatorContext, m *Module) {
 	ctx.CreateBazelTargetModuleWithRestrictions(staticProps,
 		android.CommonAttributes{Name: m.Name() + "_bp2build_cc_library_static"},
 		staticTargetAttrs, staticAttrs.Enabled)
+	createAlwayslinkStaticLibraryTarget(ctx, m, m.Name()+"_bp2build_cc_library_static", staticAttrs.Enabled)
 	ctx.CreateBazelTargetModuleWithRestrictions(sharedProps,
 		android.CommonAttributes{Name: m.Name()},
 		sharedTargetAttrs, sharedAttrs.Enabled)
@@ -1012,6 +1019,34 @@ func (library *libraryDecorator) headerAbiCheckerExplicitlyDisabled() bool {
 	return !BoolDefault(library.Properties.Header_abi_checker.Enabled, true)
 }
 
+// hasSrcs returns true if the library has sources of its own to compile, including the ones
+// specific to its static or shared variant.
+func (library *libraryDecorator) hasSrcs() bool {
+	if library.baseCompiler.hasSrcs() {
+		return true
+	}
+	if library.static() {
+		return len(library.StaticProperties.Static.Srcs) > 0
+	} else if library.shared() {
+		return len(library.SharedProperties.Shared.Srcs) > 0
+	}
+	return false
+}
+
+// hasWholeStaticLibs returns true if the library links whole static libraries, including the ones
+// specific to its static or shared variant.
+func (library *libraryDecorator) hasWholeStaticLibs() bool {
+	if library.baseLinker.hasWholeStaticLibs() {
+		return true
+	}
+	if library.static() {
+		return len(library.StaticProperties.Static.Whole_static_libs) > 0
+	} else if library.shared() {
+		return len(library.SharedProperties.Shared.Whole_static_libs) > 0
+	}
+	return false
+}
+
 func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps PathDeps) Objects {
 	if ctx.IsLlndk() {
 		// This is the vendor variant of an LLNDK library, build the LLNDK stubs.
@@ -1749,6 +1784,11 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 	library.reexportDeps(deps.ReexportedDeps...)
 	library.addExportedGeneratedHeaders(deps.ReexportedGeneratedHeaders...)
 
+	// Export the flags needed by the users of the sanitized variants of this library.
+	if sanitize := ctx.Module().(*Module).sanitize; sanitize != nil {
+		library.reexportFlags(sanitize.exportedFlags()...)
+	}
+
 	// Optionally export aidl headers.
 	if Bool(library.Properties.Aidl.Export_aidl_headers) {
 		if library.baseCompiler.hasSrcExt(".aidl") {
@@ -2195,7 +2235,9 @@ func reuseStaticLibrary(mctx android.BottomUpMutatorContext, static, shared *Mod
 			// Compare System_shared_libs properties with nil because empty lists are
 			// semantically significant for them.
 			staticCompiler.StaticProperties.Static.System_shared_libs == nil &&
-			sharedCompiler.SharedProperties.Shared.System_shared_libs == nil {
+			sharedCompiler.SharedProperties.Shared.System_shared_libs == nil &&
+			// The static and shared variants may be built with different sanitizers.
+			(static.sanitize == nil || !static.sanitize.applyToSplitsLinkages()) {
 
 			mctx.AddInterVariantDependency(reuseObjTag, shared, static)
 			sharedCompiler.baseCompiler.Properties.OriginalSrcs =
@@ -2503,6 +2545,8 @@ func sharedOrStaticLibraryBp2Build(ctx android.TopDownMutatorContext, module *Mo
 		Copts:   compilerAttrs.copts,
 		Hdrs:    compilerAttrs.hdrs,
 
+		Additional_compiler_inputs: compilerAttrs.additionalCompilerInputs,
+
 		Deps:                              linkerAttrs.deps,
 		Implementation_deps:               linkerAttrs.implementationDeps,
 		Dynamic_deps:                      linkerAttrs.dynamicDeps,
@@ -2578,6 +2622,8 @@ func sharedOrStaticLibraryBp2Build(ctx android.TopDownMutatorContext, module *Mo
 
 			Stubs_symbol_file: compilerAttrs.stubsSymbolFile,
 			Stubs_versions:    compilerAttrs.stubsVersions,
+
+			vndkAttributes: bp2BuildParseVndkAttributes(ctx, module),
 		}
 	}
 
@@ -2593,6 +2639,9 @@ func sharedOrStaticLibraryBp2Build(ctx android.TopDownMutatorContext, module *Mo
 	}
 
 	ctx.CreateBazelTargetModule(props, android.CommonAttributes{Name: module.Name()}, attrs)
+	if isStatic {
+		createAlwayslinkStaticLibraryTarget(ctx, module, module.Name(), bazel.BoolAttribute{})
+	}
 }
 
 // TODO(b/199902614): Can this be factored to share with the other Attributes?
@@ -2655,4 +2704,6 @@ type bazelCcLibrarySharedAttributes struct {
 	Stubs_symbol_file *string
 	Stubs_versions    bazel.StringListAttribute
 	Inject_bssl_hash  bazel.BoolAttribute
+
+	vndkAttributes
 }
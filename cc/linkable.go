@@ -41,6 +41,14 @@ type PlatformSanitizeable interface {
 	// SanitizeNever returns true if this module should never be sanitized.
 	SanitizeNever() bool
 
+	// SanitizeShallow returns true if the sanitizers of this module should not be propagated to
+	// its static dependencies.
+	SanitizeShallow() bool
+
+	// SanitizeSharedDepsOnly returns true if the sanitizers of this module should only be
+	// propagated to its shared dependencies.
+	SanitizeSharedDepsOnly() bool
+
 	// SanitizerSupported returns true if a sanitizer type is supported by this modules compiler.
 	SanitizerSupported(t SanitizerType) bool
 
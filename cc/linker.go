@@ -295,6 +295,12 @@ func (linker *baseLinker) linkerInit(ctx BaseModuleContext) {
 	}
 }
 
+// hasWholeStaticLibs returns true if the module links whole static libraries, whose objects are
+// linked into it as if they were its own sources.
+func (linker *baseLinker) hasWholeStaticLibs() bool {
+	return len(linker.Properties.Whole_static_libs) > 0
+}
+
 func (linker *baseLinker) linkerProps() []interface{} {
 	return []interface{}{&linker.Properties, &linker.dynamicProperties}
 }
@@ -15,6 +15,10 @@
 package cc
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
@@ -116,10 +120,10 @@ func (lto *lto) flags(ctx BaseModuleContext, flags Flags) Flags {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-fwhole-program-vtables")
 		}
 
-		if (lto.DefaultThinLTO(ctx) || lto.ThinLTO()) && ctx.Config().IsEnvTrue("USE_THINLTO_CACHE") && lto.useClangLld(ctx) {
+		cacheDir := thinLTOCacheDir(ctx, flags)
+		if (lto.DefaultThinLTO(ctx) || lto.ThinLTO()) && cacheDir != "" && lto.useClangLld(ctx) {
 			// Set appropriate ThinLTO cache policy
 			cacheDirFormat := "-Wl,--thinlto-cache-dir="
-			cacheDir := android.PathForOutput(ctx, "thinlto-cache").String()
 			flags.Local.LdFlags = append(flags.Local.LdFlags, cacheDirFormat+cacheDir)
 
 			// Limit the size of the ThinLTO cache to the lesser of 10% of available
@@ -139,6 +143,32 @@ func (lto *lto) flags(ctx BaseModuleContext, flags Flags) Flags {
 	return flags
 }
 
+// thinLTOCacheDir returns the ThinLTO cache directory for the module, or an empty string if the
+// ThinLTO cache is not used.
+func thinLTOCacheDir(ctx BaseModuleContext, flags Flags) string {
+	if dir := ctx.Config().ThinLTOCacheDir(); dir != "" {
+		// The cache directory is shared by all variants, so key it on the sanitizer flags to keep
+		// variants built with different sanitizers from reusing each other's cache entries.
+		return filepath.Join(dir, sanitizerFlagsHash(flags))
+	}
+	if ctx.Config().IsEnvTrue("USE_THINLTO_CACHE") {
+		return android.PathForOutput(ctx, "thinlto-cache").String()
+	}
+	return ""
+}
+
+// sanitizerFlagsHash returns a hash of the sanitizer flags passed to the compiler and the linker.
+func sanitizerFlagsHash(flags Flags) string {
+	h := sha256.New()
+	for _, flag := range append(append([]string(nil), flags.Local.CFlags...), flags.Local.LdFlags...) {
+		if strings.HasPrefix(flag, "-fsanitize") || strings.HasPrefix(flag, "-fno-sanitize") {
+			h.Write([]byte(flag))
+			h.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
 func (lto *lto) LTO(ctx BaseModuleContext) bool {
 	return lto.ThinLTO() || lto.FullLTO() || lto.DefaultThinLTO(ctx)
 }
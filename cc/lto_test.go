@@ -0,0 +1,101 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func TestThinLTOCacheDir(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libplain",
+		srcs: ["foo.c"],
+		lto: {
+			thin: true,
+		},
+	}
+
+	cc_library_shared {
+		name: "libcfi",
+		srcs: ["foo.c"],
+		lto: {
+			thin: true,
+		},
+		sanitize: {
+			cfi: true,
+		},
+	}
+	`
+
+	cacheDirFlag := func(t *testing.T, result *android.TestResult, name, variant string) string {
+		t.Helper()
+		ldFlags := result.ModuleForTests(name, variant).Rule("ld").Args["ldFlags"]
+		for _, flag := range strings.Fields(ldFlags) {
+			if strings.HasPrefix(flag, "-Wl,--thinlto-cache-dir=") {
+				return strings.TrimPrefix(flag, "-Wl,--thinlto-cache-dir=")
+			}
+		}
+		return ""
+	}
+
+	t.Run("shared", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+				variables.ThinLtoCacheDir = proptools.StringPtr("out/thinlto-shared-cache")
+			}),
+		).RunTestWithBp(t, bp)
+
+		plainDir := cacheDirFlag(t, result, "libplain", "android_arm64_armv8-a_shared")
+		cfiDir := cacheDirFlag(t, result, "libcfi", "android_arm64_armv8-a_shared_cfi")
+
+		android.AssertStringEquals(t, "libplain cache dir", "out/thinlto-shared-cache", filepath.Dir(plainDir))
+		android.AssertStringEquals(t, "libcfi cache dir", "out/thinlto-shared-cache", filepath.Dir(cfiDir))
+		if plainDir == cfiDir {
+			t.Errorf("expected variants with different sanitizers to use different cache dirs, both use %q", plainDir)
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureMergeEnv(map[string]string{
+				"USE_THINLTO_CACHE": "true",
+			}),
+		).RunTestWithBp(t, bp)
+
+		expected := "out/soong/thinlto-cache"
+		android.AssertStringPathRelativeToTopEquals(t, "libplain cache dir", result.Config, expected,
+			cacheDirFlag(t, result, "libplain", "android_arm64_armv8-a_shared"))
+		android.AssertStringPathRelativeToTopEquals(t, "libcfi cache dir", result.Config, expected,
+			cacheDirFlag(t, result, "libcfi", "android_arm64_armv8-a_shared_cfi"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		result := prepareForCcTest.RunTestWithBp(t, bp)
+
+		android.AssertStringEquals(t, "libplain cache dir", "",
+			cacheDirFlag(t, result, "libplain", "android_arm64_armv8-a_shared"))
+		android.AssertStringEquals(t, "libcfi cache dir", "",
+			cacheDirFlag(t, result, "libcfi", "android_arm64_armv8-a_shared_cfi"))
+	})
+}
@@ -133,6 +133,8 @@ type bazelObjectAttributes struct {
 	Absolute_includes   bazel.StringListAttribute
 	Stl                 *string
 	Linker_script       bazel.LabelAttribute
+
+	Additional_compiler_inputs bazel.LabelListAttribute
 	sdkAttributes
 }
 
@@ -193,6 +195,8 @@ func objectBp2Build(ctx android.TopDownMutatorContext, m *Module) {
 		Stl:                 compilerAttrs.stl,
 		Linker_script:       linkerScript,
 		sdkAttributes:       bp2BuildParseSdkAttributes(m),
+
+		Additional_compiler_inputs: compilerAttrs.additionalCompilerInputs,
 	}
 
 	props := bazel.BazelTargetModuleProperties{
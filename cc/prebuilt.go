@@ -50,6 +50,14 @@ type prebuiltLinkerProperties struct {
 	// symbols, etc), default true.
 	Check_elf_files *bool
 
+	// Collect the symbols of the prebuilt shared library into the prebuilt symbols zip, so that
+	// crash reports can be symbolized. Defaults to true for sanitizer runtime libraries.
+	Collect_symbols *bool
+
+	// An unstripped copy of the prebuilt shared library, collected instead of srcs when the
+	// symbols of the library are collected.
+	Symbols_file *string `android:"path,arch_variant"`
+
 	// Optionally provide an import library if this is a Windows PE DLL prebuilt.
 	// This is needed only if this library is linked by other modules in build time.
 	// Only makes sense for the Windows target.
@@ -134,6 +142,7 @@ func (p *prebuiltLibraryLinker) link(ctx ModuleContext,
 
 		if p.shared() {
 			p.unstrippedOutputFile = in
+			p.setPrebuiltSymbolsProvider(ctx, in)
 			libName := p.libraryDecorator.getLibName(ctx) + flags.Toolchain.ShlibSuffix()
 			outputFile := android.PathForModuleOut(ctx, libName)
 			var implicits android.Paths
@@ -0,0 +1,111 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+func init() {
+	android.RegisterSingletonType("prebuilt_symbols_zip", prebuiltSymbolsZipFactory)
+}
+
+// PrebuiltSymbolsInfo is provided by prebuilt shared libraries whose symbols are collected into
+// the prebuilt symbols zip.
+type PrebuiltSymbolsInfo struct {
+	// The unstripped library, or the symbols file of the library.
+	SymbolsFile android.Path
+}
+
+var PrebuiltSymbolsInfoProvider = blueprint.NewProvider(PrebuiltSymbolsInfo{})
+
+// sanitizerRuntimeLibraries returns the names of the shared sanitizer runtime libraries of the
+// toolchain, whose symbols are needed to symbolize sanitizer reports.
+func sanitizerRuntimeLibraries(t config.Toolchain) []string {
+	return []string{
+		config.AddressSanitizerRuntimeLibrary(t),
+		config.HWAddressSanitizerRuntimeLibrary(t),
+		config.ThreadSanitizerRuntimeLibrary(t),
+		config.ScudoRuntimeLibrary(t),
+		config.ScudoMinimalRuntimeLibrary(t),
+		config.UndefinedBehaviorSanitizerRuntimeLibrary(t),
+	}
+}
+
+// setPrebuiltSymbolsProvider publishes the symbols of the prebuilt shared library unstripped in
+// PrebuiltSymbolsInfoProvider if they should be collected, which is the default for the sanitizer
+// runtime libraries.
+func (p *prebuiltLibraryLinker) setPrebuiltSymbolsProvider(ctx ModuleContext, unstripped android.Path) {
+	collect := inList(ctx.baseModuleName(), sanitizerRuntimeLibraries(ctx.toolchain()))
+	if !BoolDefault(p.properties.Collect_symbols, collect) {
+		return
+	}
+
+	symbolsFile := unstripped
+	if p.properties.Symbols_file != nil {
+		symbolsFile = android.PathForModuleSrc(ctx, *p.properties.Symbols_file)
+	}
+	ctx.SetProvider(PrebuiltSymbolsInfoProvider, PrebuiltSymbolsInfo{
+		SymbolsFile: symbolsFile,
+	})
+}
+
+func prebuiltSymbolsZipFactory() android.Singleton {
+	return &prebuiltSymbolsZipSingleton{}
+}
+
+// prebuiltSymbolsZipSingleton zips the symbols of the prebuilt shared libraries installed on the
+// device, which are not collected with the symbols of the libraries built from source. Each file
+// is stored at the path the library is installed at, relative to the root of the device.
+type prebuiltSymbolsZipSingleton struct{}
+
+func (s *prebuiltSymbolsZipSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	entries := make(map[string]android.Path)
+
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() || module.IsSkipInstall() || module.Target().Os.Class != android.Device ||
+			!ctx.ModuleHasProvider(module, PrebuiltSymbolsInfoProvider) {
+			return
+		}
+		installed := module.FilesToInstall()
+		if len(installed) == 0 {
+			return
+		}
+		info := ctx.ModuleProvider(module, PrebuiltSymbolsInfoProvider).(PrebuiltSymbolsInfo)
+		entry := installed[0].Partition() + strings.TrimPrefix(installed[0].String(), installed[0].PartitionDir())
+		entries[entry] = info.SymbolsFile
+	})
+
+	if len(entries) == 0 {
+		return
+	}
+
+	zip := android.PathForOutput(ctx, "prebuilt_symbols.zip")
+	builder := android.NewRuleBuilder(pctx, ctx)
+	cmd := builder.Command().
+		BuiltTool("soong_zip").
+		FlagWithOutput("-o ", zip)
+	for _, name := range android.SortedStringKeys(entries) {
+		cmd.FlagWithArg("-e ", name).FlagWithInput("-f ", entries[name])
+	}
+	builder.Build("prebuilt_symbols_zip", "prebuilt symbols zip")
+
+	ctx.Phony("prebuilt_symbols", zip)
+}
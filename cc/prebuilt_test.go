@@ -520,3 +520,59 @@ func TestPrebuiltStubNoinstall(t *testing.T) {
 		testFunc(t, disabledSourceStublibBp+prebuiltStublibBp+installedlibBp)
 	})
 }
+
+func TestPrebuiltSymbolsZip(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_prebuilt_library_shared {
+			name: "libclang_rt.asan",
+			srcs: ["libclang_rt.asan.so"],
+		}
+
+		cc_prebuilt_library_shared {
+			name: "libcollected",
+			srcs: ["libcollected.so"],
+			collect_symbols: true,
+			symbols_file: "libcollected.unstripped.so",
+		}
+
+		cc_prebuilt_library_shared {
+			name: "libnot_collected",
+			srcs: ["libnot_collected.so"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForPrebuiltTest,
+		android.FixtureMergeMockFs(android.MockFS{
+			"foo.c":                      nil,
+			"libclang_rt.asan.so":        nil,
+			"libcollected.so":            nil,
+			"libcollected.unstripped.so": nil,
+			"libnot_collected.so":        nil,
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("prebuilt_symbols_zip", prebuiltSymbolsZipFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	zip := result.SingletonForTests("prebuilt_symbols_zip").Output("out/soong/prebuilt_symbols.zip")
+	inputs := zip.Implicits.Strings()
+	android.AssertStringListContains(t, "zip inputs", inputs, "libclang_rt.asan.so")
+	android.AssertStringListContains(t, "zip inputs", inputs, "libcollected.unstripped.so")
+	android.AssertStringListDoesNotContain(t, "zip inputs", inputs, "libcollected.so")
+	android.AssertStringListDoesNotContain(t, "zip inputs", inputs, "libnot_collected.so")
+
+	command := zip.RuleParams.Command
+	android.AssertStringDoesContain(t, "zip command", command,
+		"-e system/lib64/libclang_rt.asan.so -f libclang_rt.asan.so")
+	android.AssertStringDoesContain(t, "zip command", command,
+		"-e system/lib64/libcollected.so -f libcollected.unstripped.so")
+}
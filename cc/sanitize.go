@@ -16,6 +16,7 @@ package cc
 
 import (
 	"fmt"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -71,10 +72,67 @@ var (
 		"-Wl,-plugin-opt,O1"}
 	cfiExportsMapPath = "build/soong/cc/config/cfi_exports.map"
 
+	kcfiCflags = []string{"-fsanitize=kcfi"}
+
 	intOverflowCflags = []string{"-fsanitize-ignorelist=build/soong/cc/config/integer_overflow_blocklist.txt"}
 
-	minimalRuntimeFlags = []string{"-fsanitize-minimal-runtime", "-fno-sanitize-trap=integer,undefined",
-		"-fno-sanitize-recover=integer,undefined"}
+	// Whether the checks trap is left to the -fsanitize-trap flags, which disable trapping for the
+	// checks listed in sanitize.diag only.
+	minimalRuntimeFlags = []string{"-fsanitize-minimal-runtime", "-fno-sanitize-recover=integer,undefined"}
+	// Compile-time diagnostics for undefined behavior that the sanitizers would otherwise only
+	// catch at runtime, promoted to errors by sanitize.warnings_as_errors.
+	sanitizerWarningsAsErrorsCflags = []string{
+		"-Werror=array-bounds",
+		"-Werror=division-by-zero",
+		"-Werror=integer-overflow",
+		"-Werror=null-dereference",
+		"-Werror=shift-count-negative",
+		"-Werror=shift-count-overflow",
+		"-Werror=shift-negative-value",
+	}
+
+	// The undefined behavior checks and groups of checks supported by clang, which can be excluded
+	// with sanitize.misc_undefined_exclude.
+	ubsanChecks = []string{
+		"alignment",
+		"array-bounds",
+		"bool",
+		"bounds",
+		"builtin",
+		"enum",
+		"float-cast-overflow",
+		"float-divide-by-zero",
+		"function",
+		"implicit-conversion",
+		"implicit-integer-sign-change",
+		"implicit-integer-truncation",
+		"implicit-signed-integer-truncation",
+		"implicit-unsigned-integer-truncation",
+		"integer",
+		"integer-divide-by-zero",
+		"local-bounds",
+		"nonnull-attribute",
+		"null",
+		"nullability",
+		"nullability-arg",
+		"nullability-assign",
+		"nullability-return",
+		"object-size",
+		"pointer-overflow",
+		"return",
+		"returns-nonnull-attribute",
+		"shift",
+		"shift-base",
+		"shift-exponent",
+		"signed-integer-overflow",
+		"undefined",
+		"unreachable",
+		"unsigned-integer-overflow",
+		"unsigned-shift-base",
+		"vla-bound",
+		"vptr",
+	}
+
 	hwasanGlobalOptions = []string{"heap_history_size=1023", "stack_history_size=512",
 		"export_memory_stats=0", "max_malloc_fill_size=4096", "malloc_fill_byte=0"}
 )
@@ -89,6 +147,7 @@ const (
 	scs
 	Fuzzer
 	Memtag_heap
+	kcfi
 	cfi // cfi is last to prevent it running before incompatible mutators
 )
 
@@ -100,6 +159,7 @@ var Sanitizers = []SanitizerType{
 	scs,
 	Fuzzer,
 	Memtag_heap,
+	kcfi,
 	cfi, // cfi is last to prevent it running before incompatible mutators
 }
 
@@ -116,6 +176,8 @@ func (t SanitizerType) variationName() string {
 		return "intOverflow"
 	case cfi:
 		return "cfi"
+	case kcfi:
+		return "kcfi"
 	case scs:
 		return "scs"
 	case Memtag_heap:
@@ -127,6 +189,27 @@ func (t SanitizerType) variationName() string {
 	}
 }
 
+// SanitizersFromVariantName returns the sanitizers whose variations are suffixed to the given
+// variant name, e.g. [Asan, Fuzzer] for "android_arm64_armv8-a_shared_asan_fuzzer", in the order in
+// which they appear. Returns nil if the variant name has no sanitizer suffix.
+func SanitizersFromVariantName(name string) []SanitizerType {
+	var sanitizers []SanitizerType
+	for {
+		found := false
+		for _, t := range Sanitizers {
+			if suffix := "_" + t.variationName(); strings.HasSuffix(name, suffix) {
+				sanitizers = append([]SanitizerType{t}, sanitizers...)
+				name = strings.TrimSuffix(name, suffix)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return sanitizers
+		}
+	}
+}
+
 // This is the sanitizer names in SANITIZE_[TARGET|HOST]
 func (t SanitizerType) name() string {
 	switch t {
@@ -142,6 +225,8 @@ func (t SanitizerType) name() string {
 		return "integer_overflow"
 	case cfi:
 		return "cfi"
+	case kcfi:
+		return "kcfi"
 	case scs:
 		return "shadow-call-stack"
 	case Fuzzer:
@@ -152,15 +237,13 @@ func (t SanitizerType) name() string {
 }
 
 func (t SanitizerType) registerMutators(ctx android.RegisterMutatorsContext) {
-	switch t {
-	case Asan, Hwasan, Fuzzer, scs, tsan, cfi:
-		ctx.TopDown(t.variationName()+"_deps", sanitizerDepsMutator(t))
-		ctx.BottomUp(t.variationName(), sanitizerMutator(t))
-	case Memtag_heap, intOverflow:
-		// do nothing
-	default:
-		panic(fmt.Errorf("unknown SanitizerType %d", t))
+	p, ok := t.propagation()
+	if !ok {
+		// The sanitizer only affects the modules that enable it.
+		return
 	}
+	ctx.TopDown(t.variationName()+"_deps", sanitizerDepsMutator(t, p))
+	ctx.BottomUp(t.variationName(), sanitizerMutator(t, p))
 }
 
 func (*Module) SanitizerSupported(t SanitizerType) bool {
@@ -175,6 +258,8 @@ func (*Module) SanitizerSupported(t SanitizerType) bool {
 		return true
 	case cfi:
 		return true
+	case kcfi:
+		return true
 	case scs:
 		return true
 	case Fuzzer:
@@ -188,18 +273,71 @@ func (*Module) SanitizerSupported(t SanitizerType) bool {
 
 // incompatibleWithCfi returns true if a sanitizer is incompatible with CFI.
 func (t SanitizerType) incompatibleWithCfi() bool {
-	return t == Asan || t == Fuzzer || t == Hwasan
+	return t == Asan || t == Fuzzer || t == Hwasan || t == kcfi
 }
 
 type SanitizeUserProps struct {
 	// Prevent use of any sanitizers on this module
 	Never *bool `android:"arch_variant"`
+	// The linkages of a library, "static" or "shared", whose variants the sanitizers enabled by
+	// these properties apply to, e.g. ["static"] to instrument the static library linked into
+	// fuzzers while keeping the shared library installed on the device clean. The variants of the
+	// other linkages are still sanitized as dependencies of the sanitized modules that need them.
+	// Defaults to all linkages.
+	Apply_to []string `android:"arch_variant"`
+	// The reason sanitizers are disabled with never: true, reported in the sanitizer coverage
+	// report. Required in the directories listed in SanitizeNeverRequiresReasonPaths.
+	Never_reason *string
+
+	// Report an error if a requested sanitizer needs a runtime library that isn't built for the
+	// target OS, instead of building the module without that sanitizer.
+	Require_runtime *bool
+
+	// Instrument only this binary's own sources with the enabled sanitizers. Static dependencies
+	// are not marked for sanitization and the base variants of them are linked instead. Only
+	// supported for binaries. Not supported with address or hwaddress for modules using
+	// libc++_static, which must be built with the same sanitizers as the code using it.
+	Shallow *bool `android:"arch_variant"`
+
+	// Propagate the enabled sanitizers to the shared libraries reachable from this module, but
+	// not to its static dependencies, which stay unsanitized and link their base variants. This
+	// is the opposite of the default, which only propagates address, hwaddress, cfi and scs to
+	// static dependencies. Not supported with address or hwaddress for modules using
+	// libc++_static, which must be built with the same sanitizers as the code using it.
+	Shared_deps_only *bool `android:"arch_variant"`
+
+	// The static library has no sources of its own and only forwards its dependencies. Its
+	// sanitizer variants aren't built with the sanitizers, installed or exported to Make, they
+	// only forward the variant of the modules depending on it to its dependencies. Header
+	// libraries always behave this way. Ignored by the shared variant of a cc_library.
+	Passthrough *bool
+
+	// Set to false for modules that can never be built with hwaddress, e.g. because they use
+	// inline assembly that is incompatible with tagged pointers. Global hwaddress builds skip them
+	// without affecting the other sanitizers, unlike never: true, and they are not sanitized as a
+	// dependency of hwaddress modules either. Defaults to true.
+	Hwaddress_compatible *bool `android:"arch_variant"`
+
+	// Link the sanitizer runtime library without making the installation of this module depend
+	// on the installation of the runtime library, for modules that expect the runtime library to
+	// be installed by other means.
+	No_runtime_install *bool `android:"arch_variant"`
+
+	// Link the shared sanitizer runtime library with -Wl,--as-needed, so that it is only recorded
+	// as a dependency of this module if it is referenced, for binaries and shared libraries that
+	// must load on devices where the runtime library may not be installed. The other shared
+	// libraries are linked as usual. Not supported for static executables, which link the
+	// runtime library statically.
+	Runtime_optional *bool `android:"arch_variant"`
 
 	// ASan (Address sanitizer), incompatible with static binaries.
 	// Always runs in a diagnostic mode.
 	// Use of address sanitizer disables cfi sanitizer.
 	// Hwaddress sanitizer takes precedence over this sanitizer.
 	Address *bool `android:"arch_variant"`
+	// Prebuilt objects linked only into the address variant of this module, e.g. shims that the
+	// code built with the address sanitizer needs, and not into the variant built without it.
+	Address_objs []string `android:"path,arch_variant"`
 	// TSan (Thread sanitizer), incompatible with static binaries and 32 bit architectures.
 	// Always runs in a diagnostic mode.
 	// Use of thread sanitizer disables cfi and scudo sanitizers.
@@ -208,19 +346,53 @@ type SanitizeUserProps struct {
 	// HWASan (Hardware Address sanitizer).
 	// Use of hwasan sanitizer disables cfi, address, thread, and scudo sanitizers.
 	Hwaddress *bool `android:"arch_variant"`
+	// Preprocessor defines added only to the hwaddress variant of this module, e.g.
+	// ["__HWASAN__=1"], as there is no standard macro for code to detect hwaddress like
+	// __SANITIZE_ADDRESS__ for address.
+	Hwaddress_defines []string `android:"arch_variant"`
 
 	// Undefined behavior sanitizer
 	All_undefined *bool `android:"arch_variant"`
+	// The number of undefined behavior reports after which an executable is stopped. Installs a
+	// <name>.ubsan.sh launcher next to the executable that runs it with UBSAN_OPTIONS set to
+	// halt_on_error=0, so that noisy runs report more than the first error without running
+	// unbounded. Options already set in UBSAN_OPTIONS take precedence. On devices, only the checks
+	// in diag report errors, the others still abort at the first error. The launcher counts the
+	// reports on stderr, so the executable is only stopped at its next write to stderr after the
+	// last counted report.
+	Max_errors *int `android:"arch_variant"`
 	// Subset of undefined behavior sanitizer
 	Undefined *bool `android:"arch_variant"`
 	// List of specific undefined behavior sanitizers to enable
 	Misc_undefined []string `android:"arch_variant"`
+	// The UBSan runtime library linked for the undefined behavior checks, either "minimal", which
+	// traps on the first error, or "standalone", which reports every error with a readable
+	// diagnostic like the checks in diag, e.g. for userdebug builds. Defaults to "minimal" unless
+	// diag enables undefined behavior checks, which require "standalone".
+	Undefined_runtime *string `android:"arch_variant"`
+	// List of specific undefined behavior checks to disable, subtracted from the checks enabled by
+	// the other properties and by the global and per-path configuration, e.g. ["array-bounds"] to
+	// keep the rest of the bounds sanitizer.
+	Misc_undefined_exclude []string `android:"arch_variant"`
+	// The relative order of the sanitizers in the combined -fsanitize= flag, e.g.
+	// ["address", "undefined"] to put the address sanitizer before the undefined behavior checks.
+	// Each entry is the name of a sanitize property, and "undefined" covers all the undefined
+	// behavior checks. Sanitizers that aren't listed follow the listed ones in the default order.
+	Order []string `android:"arch_variant"`
 	// Fuzzer, incompatible with static binaries.
 	Fuzzer *bool `android:"arch_variant"`
 	// safe-stack sanitizer, incompatible with 32-bit architectures.
 	Safestack *bool `android:"arch_variant"`
 	// cfi sanitizer, incompatible with asan, hwasan, fuzzer, or Darwin
 	Cfi *bool `android:"arch_variant"`
+	// Subset of the cfi schemes to enable instead of the whole cfi group, e.g. ["cfi-icall"] for
+	// C code, which has no virtual calls or casts to check. Enables the cfi sanitizer, and can't be
+	// combined with cfi: true.
+	Cfi_modes []string `android:"arch_variant"`
+	// kcfi sanitizer, which checks the type of the target of indirect calls at the call site and,
+	// unlike cfi, doesn't require LTO. Can't be combined with cfi, and requires a clang release that
+	// supports it.
+	Kcfi *bool `android:"arch_variant"`
 	// signed/unsigned integer overflow sanitizer, incompatible with Darwin.
 	Integer_overflow *bool `android:"arch_variant"`
 	// scudo sanitizer, incompatible with asan, hwasan, tsan
@@ -236,6 +408,14 @@ type SanitizeUserProps struct {
 	// A modifier for ASAN and HWASAN for write only instrumentation
 	Writeonly *bool `android:"arch_variant"`
 
+	// A modifier for HWASAN to disable tagging of global variables, while keeping stack and heap
+	// instrumentation. Only affects this module, not its dependencies. Defaults to true.
+	Hwaddress_globals *bool `android:"arch_variant"`
+
+	// Treat compile-time diagnostics for undefined behavior that the enabled sanitizers would
+	// report at runtime as errors. Only affects variants with sanitizers enabled.
+	Warnings_as_errors *bool `android:"arch_variant"`
+
 	// Sanitizers to run in the diagnostic mode (as opposed to the release mode).
 	// Replaces abort() on error with a human-readable error message.
 	// Address and Thread sanitizers always run in diagnostic mode.
@@ -264,6 +444,22 @@ type SanitizeUserProps struct {
 		Cfi_assembly_support *bool `android:"arch_variant"`
 	} `android:"arch_variant"`
 
+	// The maximum number of bytes by which the output of a sanitizer variant of this module may
+	// be larger than the output of the variant built without those sanitizers. Exceeding it is a
+	// build error, reported when both variants have been linked. Only supported on static
+	// libraries, not on libraries that also build a shared variant.
+	Max_sanitizer_size_increase *int64 `android:"arch_variant"`
+
+	// Flags added to the cflags of the modules that directly link the variants of this library
+	// built with the corresponding sanitizer, e.g. ["-fno-builtin-memcpy"] for users of a hwaddress
+	// library that must not make interprocedural assumptions about it. Users of the variants built
+	// without the sanitizer don't get them.
+	Export_sanitize_flags struct {
+		Address   []string `android:"arch_variant"`
+		Hwaddress []string `android:"arch_variant"`
+		Thread    []string `android:"arch_variant"`
+	} `android:"arch_variant"`
+
 	// List of sanitizers to pass to -fsanitize-recover
 	// allows execution to continue for these sanitizers to detect multiple errors rather than only
 	// the first one
@@ -271,6 +467,10 @@ type SanitizeUserProps struct {
 
 	// value to pass to -fsanitize-ignorelist
 	Blocklist *string
+
+	// List of functions to exclude from sanitizer instrumentation. A generated ignorelist with a
+	// fun:<name> entry for each function is passed to -fsanitize-ignorelist.
+	No_sanitize_functions []string
 }
 
 type SanitizeProperties struct {
@@ -283,6 +483,42 @@ type SanitizeProperties struct {
 	InSanitizerDir    bool              `blueprint:"mutated"`
 	Sanitizers        []string          `blueprint:"mutated"`
 	DiagSanitizers    []string          `blueprint:"mutated"`
+
+	// The sanitizers that were requested but disabled because their runtime library isn't built
+	// for the target OS.
+	DroppedSanitizers []string `blueprint:"mutated"`
+
+	// The globally enabled sanitizers that were skipped because the module declares that it is
+	// incompatible with them.
+	IncompatibleSanitizers []string `blueprint:"mutated"`
+
+	// The named module set, e.g. boot_critical, whose membership excluded the module from global
+	// device sanitization, and the global sanitizers it skipped because of it.
+	ExcludedByModuleSet         string   `blueprint:"mutated"`
+	ModuleSetExcludedSanitizers []string `blueprint:"mutated"`
+
+	// The globally enabled sanitizers that were skipped because they can't run on the oldest API
+	// level the module supports, see sanitizerMinSdkVersions.
+	MinSdkSkippedSanitizers []string `blueprint:"mutated"`
+
+	// The sanitizers enabled by the module's own properties, before the directory defaults and the
+	// global sanitizers are applied and sanitizers are propagated to it from other modules.
+	OwnSanitizers []string `blueprint:"mutated"`
+
+	// The sanitizers enabled by the cc_sanitize_defaults_dir modules of the module's directory and
+	// the enclosing directories, see applyDirectoryDefaults.
+	DirectoryDefaultSanitizers []string `blueprint:"mutated"`
+
+	// Problems with the sanitize properties of the module that don't prevent building it, reported
+	// by the sanitizer_warnings singleton.
+	Warnings []string `blueprint:"mutated"`
+
+	// The sanitizer variations the sanitizer mutators split the module into that aren't hidden from
+	// Make, the one of them this variant is, and whether this variant isn't the variant installed by
+	// default. They are used to resolve the dist entries that name a variant, see DistVariants.
+	SanitizerVariations []string `blueprint:"mutated"`
+	SanitizerVariation  string   `blueprint:"mutated"`
+	NonDefaultVariant   bool     `blueprint:"mutated"`
 }
 
 type sanitize struct {
@@ -299,15 +535,127 @@ var _ android.SkipApexAllowedDependenciesCheck = (*libraryDependencyTag)(nil)
 func init() {
 	android.RegisterMakeVarsProvider(pctx, cfiMakeVarsProvider)
 	android.RegisterMakeVarsProvider(pctx, hwasanMakeVarsProvider)
+	android.RegisterSingletonType("sanitize_modules_file", sanitizeModulesFileFactory)
+}
+
+func sanitizeModulesFileFactory() android.Singleton {
+	return &sanitizeModulesFileSingleton{}
+}
+
+// sanitizeModulesFileSingleton reports the errors loading SANITIZE_MODULES_FILE once for the whole
+// build, and reruns soong when the file changes.
+type sanitizeModulesFileSingleton struct{}
+
+func (s *sanitizeModulesFileSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	file, err := ctx.Config().SanitizeModulesFile()
+	if file == "" {
+		return
+	}
+	ctx.AddNinjaFileDeps(file)
+	if err != nil {
+		ctx.Errorf("%s", err)
+	}
 }
 
 func (sanitize *sanitize) props() []interface{} {
 	return []interface{}{&sanitize.Properties}
 }
 
+// testLikeForSanitizers returns true for the modules that get the default sanitizer policies of
+// tests instead of those of plain binaries and libraries: tests, test libraries and benchmarks,
+// including their host variants.
+func testLikeForSanitizers(ctx BaseModuleContext) bool {
+	return ctx.testBinary() || ctx.benchmarkBinary()
+}
+
+// bootCriticalModules are the modules needed to boot the device far enough to debug it, which
+// every global device sanitizer rollout has to exclude.
+var bootCriticalModules = []string{
+	"apexd",
+	"hwservicemanager",
+	"init",
+	"init_first_stage",
+	"init_second_stage",
+	"linkerconfig",
+	"lmkd",
+	"logd",
+	"servicemanager",
+	"ueventd",
+	"vold",
+	"vndservicemanager",
+}
+
+// sanitizeModuleSet is a named set of modules that global device sanitization, e.g.
+// SANITIZE_TARGET, skips.
+type sanitizeModuleSet struct {
+	name string
+	// The built-in members of the set.
+	modules []string
+	// The members added by the product, if any.
+	productModules func(config android.Config) []string
+}
+
+var sanitizeModuleSets = []sanitizeModuleSet{
+	{
+		name:    "boot_critical",
+		modules: bootCriticalModules,
+		productModules: func(config android.Config) []string {
+			return config.SanitizeBootCriticalModules()
+		},
+	},
+}
+
+// sanitizeModuleSetOf returns the name of the first module set the module belongs to, or an empty
+// string if it doesn't belong to any.
+func sanitizeModuleSetOf(ctx BaseModuleContext) string {
+	name := ctx.ModuleName()
+	for _, set := range sanitizeModuleSets {
+		if inList(name, set.modules) || inList(name, set.productModules(ctx.Config())) {
+			return set.name
+		}
+	}
+	return ""
+}
+
 func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 	s := &sanitize.Properties.Sanitize
 
+	// The shared variants of libraries that also build a static variant, e.g. cc_library, ignore
+	// passthrough, see isSanitizerPassthrough.
+	if Bool(s.Passthrough) {
+		if c, ok := ctx.Module().(*Module); !ok || c.library == nil || !c.library.buildStatic() {
+			ctx.PropertyErrorf("sanitize.passthrough", "is only supported on static libraries")
+		} else if !sanitizeWithoutSources(c) {
+			// The passthrough variants aren't built with the sanitizers, so the module's own
+			// sources would be linked into the sanitized variants of its dependents unsanitized.
+			ctx.PropertyErrorf("sanitize.passthrough", "can't be combined with srcs, passthrough "+
+				"libraries only forward their dependencies")
+		}
+	}
+
+	if len(s.Apply_to) > 0 {
+		sanitize.applyToLinkages(ctx)
+	}
+
+	// Directory defaults are applied before the global sanitizers, which only enable sanitizers
+	// that are still unset.
+	own := sanitize.enabledSanitizerNames()
+	sanitize.applyDirectoryDefaults(ctx)
+
+	// The fuzzer sanitizer links in the libFuzzer main function, so only executables can request it.
+	// Libraries get their fuzzer variants from the fuzz targets that depend on them. Checked after
+	// the directory defaults are applied, which can enable it too.
+	if Bool(s.Fuzzer) && !ctx.binary() {
+		ctx.PropertyErrorf("sanitize.fuzzer", "is only supported on executables, it can't be "+
+			"enabled on %s modules", ctx.ModuleType())
+	}
+
+	if Bool(s.Never) && String(s.Never_reason) == "" &&
+		ctx.Config().SanitizeNeverRequiresReasonForPath(ctx.ModuleDir()) {
+		ctx.PropertyErrorf("sanitize.never", "requires never_reason explaining why sanitizers are "+
+			"disabled for modules in %q", ctx.ModuleDir())
+	}
+
 	// Don't apply sanitizers to NDK code.
 	if ctx.useSdk() {
 		s.Never = BoolPtr(true)
@@ -318,8 +666,17 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		return
 	}
 
-	// cc_test targets default to SYNC MemTag unless explicitly set to ASYNC (via diag: {memtag_heap}).
-	if ctx.testBinary() {
+	sanitize.checkHasSources(ctx)
+	sanitize.checkMinSdkVersion(ctx)
+
+	// The heap tagging that test-like targets default to below isn't one of their own sanitizers, so
+	// it doesn't fail the min_sdk_version check of the apexes containing them.
+	sanitize.Properties.OwnSanitizers = own
+	sanitize.Properties.DirectoryDefaultSanitizers = android.RemoveListFromList(
+		sanitize.enabledSanitizerNames(), own)
+
+	// Test-like targets default to SYNC MemTag unless explicitly set to ASYNC (via diag: {memtag_heap}).
+	if testLikeForSanitizers(ctx) {
 		if s.Memtag_heap == nil {
 			s.Memtag_heap = proptools.BoolPtr(true)
 		}
@@ -340,7 +697,87 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		if len(arches) == 0 || inList(ctx.Arch().ArchType.Name, arches) {
 			globalSanitizers = ctx.Config().SanitizeDevice()
 			globalSanitizersDiag = ctx.Config().SanitizeDeviceDiag()
+
+			// Code built into the vendor and product images may enable additional sanitizers
+			// without affecting the core variants of the same modules.
+			if ctx.inVendor() || ctx.inProduct() {
+				globalSanitizers = append(globalSanitizers, ctx.Config().SanitizeVendorImage()...)
+			}
+
+			// Members of a named module set, e.g. the boot-critical modules, skip global device
+			// sanitization. Their own sanitize properties and directory defaults still apply, so they
+			// can opt back in, and the sanitizers they opt back in to aren't reported as excluded.
+			if set := sanitizeModuleSetOf(ctx); set != "" && len(globalSanitizers)+len(globalSanitizersDiag) > 0 {
+				excluded := android.RemoveListFromList(android.FirstUniqueStrings(globalSanitizers),
+					sanitize.explicitSanitizers())
+				if len(excluded) > 0 {
+					sanitize.Properties.ExcludedByModuleSet = set
+					sanitize.Properties.ModuleSetExcludedSanitizers = excluded
+				}
+				globalSanitizers, globalSanitizersDiag = nil, nil
+			}
+		}
+	}
+
+	// Modules listed in SANITIZE_MODULES_FILE get their sanitizers as if they were enabled globally.
+	// Errors loading the file are reported by the sanitize_modules_file singleton.
+	globalSanitizers = append(globalSanitizers, ctx.Config().SanitizeModules()[ctx.ModuleName()]...)
+
+	// Global sanitizers that can't run on the oldest API level supported by a module that declares
+	// one, e.g. a mainline module, are skipped. Enabling them with the module's own properties or
+	// directory defaults is an error, see checkMinSdkVersion and ShouldSupportSdkVersion.
+	if level, ok := sanitizerMinSdkLevel(ctx); ok && len(globalSanitizers) > 0 {
+		var kept []string
+		for _, sanitizer := range globalSanitizers {
+			if min, ok := sanitizerMinSdkVersion(ctx, sanitizer); ok && level.LessThan(min) {
+				sanitize.Properties.MinSdkSkippedSanitizers = append(
+					sanitize.Properties.MinSdkSkippedSanitizers, sanitizer)
+			} else {
+				kept = append(kept, sanitizer)
+			}
 		}
+		globalSanitizers = kept
+	}
+
+	// Modules that are incompatible with hwaddress are treated as if they explicitly disabled it,
+	// so that global hwaddress builds skip them and it isn't propagated to them.
+	if s.Hwaddress_compatible != nil && !*s.Hwaddress_compatible {
+		if Bool(s.Hwaddress) {
+			ctx.PropertyErrorf("sanitize.hwaddress", "cannot be enabled for a module with "+
+				"hwaddress_compatible: false")
+		}
+		if inList("hwaddress", globalSanitizers) {
+			sanitize.Properties.IncompatibleSanitizers = append(sanitize.Properties.IncompatibleSanitizers,
+				Hwasan.name())
+		}
+		s.Hwaddress = proptools.BoolPtr(false)
+	}
+
+	if len(s.Cfi_modes) > 0 {
+		if Bool(s.Cfi) {
+			ctx.PropertyErrorf("sanitize.cfi_modes", "cannot be combined with cfi: true, cfi_modes "+
+				"enables cfi with only the listed schemes")
+		}
+		if s.Cfi == nil {
+			s.Cfi = proptools.BoolPtr(true)
+		}
+	}
+
+	switch String(s.Undefined_runtime) {
+	case "", "standalone":
+	case "minimal":
+		if Bool(s.Diag.Undefined) || Bool(s.Diag.Integer_overflow) || len(s.Diag.Misc_undefined) > 0 {
+			ctx.PropertyErrorf("sanitize.undefined_runtime", "minimal cannot be combined with diag "+
+				"undefined behavior checks, which require the standalone runtime")
+		}
+	default:
+		ctx.PropertyErrorf("sanitize.undefined_runtime", "must be \"minimal\" or \"standalone\", "+
+			"got %q", String(s.Undefined_runtime))
+	}
+
+	if Bool(s.Kcfi) && Bool(s.Cfi) {
+		ctx.PropertyErrorf("sanitize.kcfi", "cannot be combined with cfi, kcfi is an alternative "+
+			"cfi scheme")
 	}
 
 	if len(globalSanitizers) > 0 {
@@ -429,8 +866,34 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		}
 	}
 
+	// cc_fuzz targets default to ASAN on host, unless either ASAN or HWASAN is set explicitly or
+	// globally. On device they default to the sanitizer set by the FuzzerDeviceSanitizer product
+	// variable, if any. HWASAN is only supported on arm64, so other device arches fall back to ASAN.
+	if ctx.fuzzBinary() && s.Address == nil && s.Hwaddress == nil {
+		if ctx.Host() {
+			s.Address = proptools.BoolPtr(true)
+		} else {
+			switch sanitizer := ctx.Config().FuzzerDeviceSanitizer(); sanitizer {
+			case "":
+			case "address":
+				s.Address = proptools.BoolPtr(true)
+			case "hwaddress":
+				if ctx.Arch().ArchType == android.Arm64 {
+					s.Hwaddress = proptools.BoolPtr(true)
+				} else {
+					s.Address = proptools.BoolPtr(true)
+				}
+			default:
+				ctx.ModuleErrorf("unknown FuzzerDeviceSanitizer %q, must be address or hwaddress", sanitizer)
+			}
+		}
+	}
+
 	// Enable Memtag for all components in the include paths (for Aarch64 only)
 	if ctx.Arch().ArchType == android.Arm64 {
+		if s.Memtag_heap != nil && !*s.Memtag_heap {
+			checkMemtagHeapDisableAllowed(ctx)
+		}
 		if ctx.Config().MemtagHeapSyncEnabledForPath(ctx.ModuleDir()) {
 			if s.Memtag_heap == nil {
 				s.Memtag_heap = proptools.BoolPtr(true)
@@ -516,14 +979,48 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		s.Diag.Cfi = nil
 	}
 
+	// KCFI is only implemented for ELF targets, and only for arm64 and x86_64.
+	if !ctx.Os().Linux() || (ctx.Arch().ArchType != android.Arm64 && ctx.Arch().ArchType != android.X86_64) {
+		s.Kcfi = nil
+	}
+
+	// KCFI replaces CFI enabled for the directory or globally.
+	if Bool(s.Kcfi) {
+		s.Cfi = nil
+		s.Diag.Cfi = nil
+	}
+
 	// Disable sanitizers that depend on the UBSan runtime for windows/darwin builds.
 	if !ctx.Os().Linux() {
 		s.Cfi = nil
 		s.Diag.Cfi = nil
-		s.Misc_undefined = nil
-		s.Undefined = nil
-		s.All_undefined = nil
-		s.Integer_overflow = nil
+	}
+	sanitize.checkUbsanRuntimeAvailable(ctx)
+
+	// None of the other sanitizer runtimes support Windows either. Requesting them for a
+	// host_cross Windows variant is not an error, the variant is just built without them instead
+	// of getting a sanitizer variation that can't be linked. The dropped sanitizers are recorded
+	// in DroppedSanitizers.
+	if ctx.Windows() {
+		for _, sanitizer := range []struct {
+			name    string
+			enabled **bool
+		}{
+			{Asan.name(), &s.Address},
+			{Hwasan.name(), &s.Hwaddress},
+			{tsan.name(), &s.Thread},
+			{Fuzzer.name(), &s.Fuzzer},
+			{"safe-stack", &s.Safestack},
+			{"scudo", &s.Scudo},
+			{scs.name(), &s.Scs},
+			{Memtag_heap.name(), &s.Memtag_heap},
+		} {
+			if Bool(*sanitizer.enabled) {
+				sanitize.Properties.DroppedSanitizers = append(sanitize.Properties.DroppedSanitizers,
+					sanitizer.name)
+			}
+			*sanitizer.enabled = nil
+		}
 	}
 
 	// Disable CFI for musl
@@ -570,7 +1067,7 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 
 	if ctx.Os() != android.Windows && (Bool(s.All_undefined) || Bool(s.Undefined) || Bool(s.Address) || Bool(s.Thread) ||
 		Bool(s.Fuzzer) || Bool(s.Safestack) || Bool(s.Cfi) || Bool(s.Integer_overflow) || len(s.Misc_undefined) > 0 ||
-		Bool(s.Scudo) || Bool(s.Hwaddress) || Bool(s.Scs) || Bool(s.Memtag_heap)) {
+		Bool(s.Scudo) || Bool(s.Hwaddress) || Bool(s.Scs) || Bool(s.Memtag_heap) || Bool(s.Kcfi)) {
 		sanitize.Properties.SanitizerEnabled = true
 	}
 
@@ -589,6 +1086,212 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 	if Bool(s.Fuzzer) {
 		s.Cfi = nil
 	}
+
+	// Fuzzing under TSan is not supported. Enabling both with the module's own properties or
+	// directory defaults is an error, while a thread sanitizer enabled globally is skipped for fuzzers.
+	if Bool(s.Fuzzer) && Bool(s.Thread) {
+		explicit := sanitize.explicitSanitizers()
+		if inList(Fuzzer.name(), explicit) && inList(tsan.name(), explicit) {
+			ctx.PropertyErrorf("sanitize.thread", "cannot be combined with fuzzer, fuzzing under the "+
+				"thread sanitizer is not supported")
+		} else {
+			s.Thread = nil
+			sanitize.Properties.IncompatibleSanitizers = append(sanitize.Properties.IncompatibleSanitizers,
+				tsan.name())
+		}
+	}
+
+	for _, check := range s.Misc_undefined_exclude {
+		if !inList(check, ubsanChecks) {
+			ctx.PropertyErrorf("sanitize.misc_undefined_exclude", "unknown undefined behavior check %q", check)
+		}
+	}
+
+	if Bool(s.Shallow) && !ctx.binary() {
+		ctx.PropertyErrorf("sanitize.shallow", "is only supported for binaries")
+	}
+
+	// A shallow binary links the base variant of libc++_static, whose container annotations must
+	// match the code using it.
+	if Bool(s.Shallow) && (Bool(s.Address) || Bool(s.Hwaddress)) {
+		if c, ok := ctx.Module().(*Module); ok && c.stl != nil && c.stl.Properties.SelectedStl == "libc++_static" {
+			ctx.PropertyErrorf("sanitize.shallow", "is not supported with address or hwaddress for "+
+				"modules using libc++_static, which must be built with the same sanitizers as the code "+
+				"using it")
+		}
+	}
+
+	if Bool(s.Runtime_optional) && ctx.staticBinary() {
+		ctx.PropertyErrorf("sanitize.runtime_optional", "cannot be used with static executables, "+
+			"which link the sanitizer runtime library statically")
+	}
+
+	for _, mode := range s.Cfi_modes {
+		if !inList(mode, cfiModes) {
+			ctx.PropertyErrorf("sanitize.cfi_modes", "unknown cfi scheme %q, must be one of %q", mode, cfiModes)
+		}
+	}
+
+	for _, name := range s.Order {
+		if !inList(name, sanitizerOrderNames) {
+			ctx.PropertyErrorf("sanitize.order", "unknown sanitizer %q, must be one of %q", name, sanitizerOrderNames)
+		}
+	}
+	if len(android.FirstUniqueStrings(s.Order)) != len(s.Order) {
+		ctx.PropertyErrorf("sanitize.order", "must not contain duplicates")
+	}
+
+	if max := s.Max_errors; max != nil {
+		if !ctx.binary() {
+			ctx.PropertyErrorf("sanitize.max_errors", "is only supported on executables")
+		} else if *max <= 0 {
+			ctx.PropertyErrorf("sanitize.max_errors", "must be positive, got %d", *max)
+		}
+	}
+
+	if max := s.Max_sanitizer_size_increase; max != nil && *max < 0 {
+		ctx.PropertyErrorf("sanitize.max_sanitizer_size_increase", "must not be negative, got %d", *max)
+	}
+
+	// Only the static variants of libraries are also built without the sanitizers of their
+	// sanitizer variants, the other modules and the shared variants of libraries that build both,
+	// e.g. cc_library, have no variant to compare the size against.
+	if s.Max_sanitizer_size_increase != nil {
+		if c, ok := ctx.Module().(*Module); !ok || c.library == nil || !c.library.buildStatic() || c.library.shared() {
+			ctx.PropertyErrorf("sanitize.max_sanitizer_size_increase", "is only supported on static "+
+				"libraries, other modules and shared libraries aren't built without their sanitizers "+
+				"to compare against")
+		}
+	}
+}
+
+// cfiModes are the cfi schemes that can be listed in sanitize.cfi_modes.
+var cfiModes = []string{
+	"cfi-cast-strict",
+	"cfi-derived-cast",
+	"cfi-icall",
+	"cfi-mfcall",
+	"cfi-nvcall",
+	"cfi-unrelated-cast",
+	"cfi-vcall",
+}
+
+// sanitizerOrderNames are the names of the sanitizers that can be listed in sanitize.order.
+var sanitizerOrderNames = []string{
+	"undefined",
+	"address",
+	"hwaddress",
+	"thread",
+	"safestack",
+	"cfi",
+	"integer_overflow",
+	"scudo",
+	"scs",
+	"fuzzer",
+}
+
+// sanitizerOrderName returns the name used in sanitize.order for the sanitizer that adds arg to
+// the -fsanitize= flag.
+func sanitizerOrderName(arg string) string {
+	switch arg {
+	case "address", "hwaddress", "thread", "cfi", "scudo":
+		return arg
+	case "cfi-cast-strict", "cfi-derived-cast", "cfi-icall", "cfi-mfcall", "cfi-nvcall",
+		"cfi-unrelated-cast", "cfi-vcall":
+		return "cfi"
+	case "safe-stack":
+		return "safestack"
+	case "signed-integer-overflow", "unsigned-integer-overflow":
+		return "integer_overflow"
+	case "shadow-call-stack":
+		return "scs"
+	case "fuzzer-no-link":
+		return "fuzzer"
+	default:
+		return "undefined"
+	}
+}
+
+// orderSanitizers returns the -fsanitize= arguments in sanitizers sorted by the position of their
+// sanitizer in order. The arguments of sanitizers that aren't in order keep their relative order
+// after the others.
+func orderSanitizers(sanitizers []string, order []string) []string {
+	if len(order) == 0 {
+		return sanitizers
+	}
+	rank := func(arg string) int {
+		if i := android.IndexList(sanitizerOrderName(arg), order); i != -1 {
+			return i
+		}
+		return len(order)
+	}
+	sorted := android.CopyOf(sanitizers)
+	sort.SliceStable(sorted, func(i, j int) bool { return rank(sorted[i]) < rank(sorted[j]) })
+	return sorted
+}
+
+// checkUbsanRuntimeAvailable handles the undefined behavior sanitizers requested for an OS for which
+// the UBSan runtime library they need isn't built. They are disabled and recorded in
+// DroppedSanitizers, or reported as an error if sanitize.require_runtime is set.
+func (sanitize *sanitize) checkUbsanRuntimeAvailable(ctx BaseModuleContext) {
+	s := &sanitize.Properties.Sanitize
+	if !Bool(s.Integer_overflow) && !Bool(s.Undefined) && !Bool(s.All_undefined) && len(s.Misc_undefined) == 0 {
+		return
+	}
+
+	runtime := ubsanMinimalRuntime
+	if enableUbsanRuntime(sanitize) {
+		runtime = ubsanStandaloneRuntime
+	}
+	if sanitizerRuntimeAvailable(runtime, ctx.Os()) {
+		return
+	}
+
+	if Bool(s.Require_runtime) {
+		ctx.PropertyErrorf("sanitize", "the %s runtime library needed by the undefined behavior "+
+			"sanitizers is not available for %s", runtime, ctx.Os())
+		return
+	}
+
+	if Bool(s.Integer_overflow) {
+		sanitize.Properties.DroppedSanitizers = append(sanitize.Properties.DroppedSanitizers, intOverflow.name())
+	}
+	if Bool(s.Undefined) || Bool(s.All_undefined) || len(s.Misc_undefined) > 0 {
+		sanitize.Properties.DroppedSanitizers = append(sanitize.Properties.DroppedSanitizers, "undefined")
+	}
+	s.Misc_undefined = nil
+	s.Undefined = nil
+	s.All_undefined = nil
+	s.Integer_overflow = nil
+}
+
+// checkMemtagHeapDisableAllowed reports an error if a module that sets memtag_heap: false lives in
+// a MemtagHeapSyncIncludePaths or MemtagHeapAsyncIncludePaths directory without being listed in
+// MemtagHeapDisableAllowlist. Directories in MemtagHeapExcludePaths are not covered.
+func checkMemtagHeapDisableAllowed(ctx BaseModuleContext) {
+	config := ctx.Config()
+	dir := ctx.ModuleDir()
+	var variable string
+	var includePaths []string
+	if config.MemtagHeapSyncEnabledForPath(dir) {
+		variable, includePaths = "MemtagHeapSyncIncludePaths", config.MemtagHeapSyncIncludePaths()
+	} else if config.MemtagHeapAsyncEnabledForPath(dir) {
+		variable, includePaths = "MemtagHeapAsyncIncludePaths", config.MemtagHeapAsyncIncludePaths()
+	} else {
+		return
+	}
+	if config.MemtagHeapDisableAllowedForModule(ctx.ModuleName()) {
+		return
+	}
+	for _, includePath := range includePaths {
+		if strings.HasPrefix(dir, includePath) {
+			ctx.PropertyErrorf("sanitize.memtag_heap",
+				"module %q disables memtag_heap, but %q is covered by %q in %s; "+
+					"add the module to MemtagHeapDisableAllowlist to allow the opt-out",
+				ctx.ModuleName(), dir, includePath, variable)
+			return
+		}
+	}
 }
 
 func toDisableImplicitIntegerChange(flags []string) bool {
@@ -635,6 +1338,13 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		return flags
 	}
 
+	// Static executables get the memtag note from the driver instead of the note libraries, see
+	// sanitizerRuntimeMutator.
+	if Bool(sanitize.Properties.Sanitize.Memtag_heap) && ctx.staticBinary() {
+		flags.Local.LdFlags = append(flags.Local.LdFlags, "-fsanitize=memtag-heap",
+			"-fsanitize-memtag-mode="+sanitize.memtagHeapMode())
+	}
+
 	if Bool(sanitize.Properties.Sanitize.Address) {
 		if ctx.Arch().ArchType == android.Arm {
 			// Frame pointer based unwinder in ASan requires ARM frame setup.
@@ -651,7 +1361,10 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		if ctx.Host() {
 			// -nodefaultlibs (provided with libc++) prevents the driver from linking
 			// libraries needed with -fsanitize=address. http://b/18650275 (WAI)
-			flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--no-as-needed")
+			// The musl runtime is a dependency of the module instead, see sanitizerRuntimeMutator.
+			if !ctx.toolchain().Musl() {
+				flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--no-as-needed")
+			}
 		} else {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-mllvm", "-asan-globals=0")
 			if ctx.bootstrap() {
@@ -667,6 +1380,9 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 
 	if Bool(sanitize.Properties.Sanitize.Hwaddress) {
 		flags.Local.CFlags = append(flags.Local.CFlags, hwasanCflags...)
+		for _, define := range sanitize.Properties.Sanitize.Hwaddress_defines {
+			flags.Local.CFlags = append(flags.Local.CFlags, "-D"+define)
+		}
 
 		for _, flag := range hwasanCommonflags {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-mllvm", flag)
@@ -678,6 +1394,10 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		if Bool(sanitize.Properties.Sanitize.Writeonly) {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-mllvm", "-hwasan-instrument-reads=0")
 		}
+
+		if !BoolDefault(sanitize.Properties.Sanitize.Hwaddress_globals, true) {
+			flags.Local.CFlags = append(flags.Local.CFlags, "-mllvm", "-hwasan-globals=0")
+		}
 	}
 
 	if Bool(sanitize.Properties.Sanitize.Fuzzer) {
@@ -727,6 +1447,11 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-fvisibility=default")
 		}
 		flags.Local.LdFlags = append(flags.Local.LdFlags, cfiLdflags...)
+		if len(sanitize.Properties.Sanitize.Cfi_modes) > 0 {
+			// Only link the checks for the selected schemes.
+			i := indexList("-fsanitize=cfi", flags.Local.LdFlags)
+			flags.Local.LdFlags[i] = "-fsanitize=" + strings.Join(sanitize.cfiSchemes(), ",")
+		}
 
 		if ctx.staticBinary() {
 			_, flags.Local.CFlags = removeFromList("-fsanitize-cfi-cross-dso", flags.Local.CFlags)
@@ -734,6 +1459,12 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		}
 	}
 
+	if Bool(sanitize.Properties.Sanitize.Kcfi) {
+		// The checks are emitted at the call sites, so unlike cfi neither LTO nor a runtime library
+		// is needed.
+		flags.Local.CFlags = append(flags.Local.CFlags, kcfiCflags...)
+	}
+
 	if Bool(sanitize.Properties.Sanitize.Integer_overflow) {
 		flags.Local.CFlags = append(flags.Local.CFlags, intOverflowCflags...)
 	}
@@ -744,11 +1475,38 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		flags.Local.AsFlags = append(flags.Local.AsFlags, sanitizeArg)
 		flags.Local.LdFlags = append(flags.Local.LdFlags, sanitizeArg)
 
+		// The excluded checks must come after the enables to subtract from them.
+		if excludes := sanitize.Properties.Sanitize.Misc_undefined_exclude; len(excludes) > 0 {
+			flags.Local.CFlags = append(flags.Local.CFlags, "-fno-sanitize="+strings.Join(excludes, ","))
+		}
+
+		// The vptr check reads the RTTI of polymorphic objects and misbehaves without it. Turn it
+		// off for modules that compile C++ sources with -fno-rtti, unless it was requested
+		// explicitly, and report it through the sanitizer_warnings singleton. The C++ flags don't
+		// apply to C sources.
+		if inList("-fno-rtti", flags.Local.CppFlags) && sanitizeCompilesCpp(ctx) {
+			if inList("vptr", sanitize.Properties.Sanitize.Misc_undefined) {
+				ctx.PropertyErrorf("sanitize.misc_undefined", "vptr requires rtti: true")
+			} else if inList("vptr", sanitize.Properties.Sanitize.Diag.Misc_undefined) {
+				ctx.PropertyErrorf("sanitize.diag.misc_undefined", "vptr requires rtti: true")
+			} else if Bool(sanitize.Properties.Sanitize.Undefined) || Bool(sanitize.Properties.Sanitize.All_undefined) {
+				flags.Local.CFlags = append(flags.Local.CFlags, "-fno-sanitize=vptr")
+				sanitize.Properties.Warnings = append(sanitize.Properties.Warnings,
+					"sanitize: the vptr check of undefined is disabled because the module is built without rtti")
+			}
+		}
+
 		if ctx.toolchain().Bionic() || ctx.toolchain().Musl() {
 			// Bionic and musl sanitizer runtimes have already been added as dependencies so that
 			// the right variant of the runtime will be used (with the "-android" or "-musl"
 			// suffixes), so don't let clang the runtime library.
 			flags.Local.LdFlags = append(flags.Local.LdFlags, "-fno-sanitize-link-runtime")
+
+			// The static musl address sanitizer runtime is only linked into executables, so
+			// there will always be undefined symbols in shared libraries.
+			if ctx.toolchain().Musl() && Bool(sanitize.Properties.Sanitize.Address) && !ctx.binary() {
+				_, flags.Global.LdFlags = removeFromList("-Wl,--no-undefined", flags.Global.LdFlags)
+			}
 		} else {
 			// Host sanitizers only link symbols in the final executable, so
 			// there will always be undefined symbols in intermediate libraries.
@@ -768,6 +1526,11 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-fno-sanitize-trap=all", "-fno-sanitize-recover=all")
 		} else if ctx.Host() {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-fno-sanitize-recover=all")
+			if sanitize.ubsanMaxErrors() > 0 {
+				// Let the launcher decide when to stop, also for the integer checks of
+				// integer_overflow, which aren't in the undefined group.
+				flags.Local.CFlags = append(flags.Local.CFlags, "-fsanitize-recover=undefined,integer")
+			}
 		} else {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-fsanitize-trap=all", "-ftrap-function=abort")
 		}
@@ -779,6 +1542,10 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		if toDisableUnsignedShiftBaseChange(flags.Local.CFlags) {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-fno-sanitize=unsigned-shift-base")
 		}
+
+		if Bool(sanitize.Properties.Sanitize.Warnings_as_errors) {
+			flags.Local.CFlags = append(flags.Local.CFlags, sanitizerWarningsAsErrorsCflags...)
+		}
 	}
 
 	if len(sanitize.Properties.DiagSanitizers) > 0 {
@@ -802,9 +1569,55 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		flags.CFlagsDeps = append(flags.CFlagsDeps, blocklist.Path())
 	}
 
+	if functions := sanitize.Properties.Sanitize.No_sanitize_functions; len(functions) > 0 {
+		ignorelist := android.PathForModuleGen(ctx, "no_sanitize_functions_ignorelist.txt")
+		android.WriteFileRule(ctx, ignorelist, noSanitizeFunctionsIgnorelist(functions))
+		flags.Local.CFlags = append(flags.Local.CFlags, "-fsanitize-ignorelist="+ignorelist.String())
+		flags.CFlagsDeps = append(flags.CFlagsDeps, ignorelist)
+	}
+
 	return flags
 }
 
+// excludeLibsRuntimeLdflags returns a -Wl,--exclude-libs flag for each static library linked into
+// the module whose basename is listed in the SanitizerRuntimesExcludeLibs product variable, so that
+// the runtime's symbols are not exported from the module.
+func excludeLibsRuntimeLdflags(ctx ModuleContext, deps PathDeps) []string {
+	runtimes := ctx.Config().SanitizerRuntimesExcludeLibs()
+	if len(runtimes) == 0 {
+		return nil
+	}
+
+	var flags []string
+	for _, lib := range append(append(android.Paths(nil), deps.StaticLibs...), deps.LateStaticLibs...) {
+		if android.InList(lib.Base(), runtimes) {
+			flags = append(flags, "-Wl,--exclude-libs,"+lib.Base())
+		}
+	}
+	return android.FirstUniqueStrings(flags)
+}
+
+// sanitizerRuntimeRpathLinkLdflags returns a -Wl,-rpath-link flag for the directory of each shared
+// sanitizer runtime library the module links, so that the linker finds the libraries the runtime
+// depends on in its build directory.
+func sanitizerRuntimeRpathLinkLdflags(deps PathDeps) []string {
+	var flags []string
+	for _, lib := range deps.SanitizerRuntimeLibs {
+		flags = append(flags, "-Wl,-rpath-link,"+filepath.Dir(lib.String()))
+	}
+	return android.FirstUniqueStrings(flags)
+}
+
+// noSanitizeFunctionsIgnorelist returns the contents of a sanitizer ignorelist excluding the given
+// functions from instrumentation.
+func noSanitizeFunctionsIgnorelist(functions []string) string {
+	var sb strings.Builder
+	for _, f := range android.FirstUniqueStrings(functions) {
+		fmt.Fprintf(&sb, "fun:%s\n", f)
+	}
+	return sb.String()
+}
+
 func (sanitize *sanitize) AndroidMkEntries(ctx AndroidMkContext, entries *android.AndroidMkEntries) {
 	// Add a suffix for cfi/hwasan/scs-enabled static/header libraries to allow surfacing
 	// both the sanitized and non-sanitized variants to make without a name conflict.
@@ -825,6 +1638,42 @@ func (sanitize *sanitize) inSanitizerDir() bool {
 	return sanitize.Properties.InSanitizerDir
 }
 
+// cfiSchemes returns the cfi schemes enabled by the cfi sanitizer, which is the whole cfi group
+// unless sanitize.cfi_modes is set.
+func (sanitize *sanitize) cfiSchemes() []string {
+	if modes := sanitize.Properties.Sanitize.Cfi_modes; len(modes) > 0 {
+		return modes
+	}
+	return []string{"cfi"}
+}
+
+// exportedFlags returns the sanitize.export_sanitize_flags of the sanitizers enabled for this
+// variant.
+func (sanitize *sanitize) exportedFlags() []string {
+	exported := &sanitize.Properties.Sanitize.Export_sanitize_flags
+	var flags []string
+	if sanitize.isSanitizerEnabled(Asan) {
+		flags = append(flags, exported.Address...)
+	}
+	if sanitize.isSanitizerEnabled(Hwasan) {
+		flags = append(flags, exported.Hwaddress...)
+	}
+	if sanitize.isSanitizerEnabled(tsan) {
+		flags = append(flags, exported.Thread...)
+	}
+	return flags
+}
+
+// sanitizerObjs returns the prebuilt objects linked only into the sanitized variant of the module.
+func (sanitize *sanitize) sanitizerObjs(ctx ModuleContext) Objects {
+	if !sanitize.isSanitizerEnabled(Asan) {
+		return Objects{}
+	}
+	return Objects{
+		objFiles: android.PathsForModuleSrc(ctx, sanitize.Properties.Sanitize.Address_objs),
+	}
+}
+
 // getSanitizerBoolPtr returns the SanitizerTypes associated bool pointer from SanitizeProperties.
 func (sanitize *sanitize) getSanitizerBoolPtr(t SanitizerType) *bool {
 	switch t {
@@ -838,6 +1687,8 @@ func (sanitize *sanitize) getSanitizerBoolPtr(t SanitizerType) *bool {
 		return sanitize.Properties.Sanitize.Integer_overflow
 	case cfi:
 		return sanitize.Properties.Sanitize.Cfi
+	case kcfi:
+		return sanitize.Properties.Sanitize.Kcfi
 	case scs:
 		return sanitize.Properties.Sanitize.Scs
 	case Memtag_heap:
@@ -855,6 +1706,7 @@ func (sanitize *sanitize) isUnsanitizedVariant() bool {
 		!sanitize.isSanitizerEnabled(Hwasan) &&
 		!sanitize.isSanitizerEnabled(tsan) &&
 		!sanitize.isSanitizerEnabled(cfi) &&
+		!sanitize.isSanitizerEnabled(kcfi) &&
 		!sanitize.isSanitizerEnabled(scs) &&
 		!sanitize.isSanitizerEnabled(Memtag_heap) &&
 		!sanitize.isSanitizerEnabled(Fuzzer)
@@ -884,6 +1736,8 @@ func (sanitize *sanitize) SetSanitizer(t SanitizerType, b bool) {
 		sanitize.Properties.Sanitize.Integer_overflow = bPtr
 	case cfi:
 		sanitize.Properties.Sanitize.Cfi = bPtr
+	case kcfi:
+		sanitize.Properties.Sanitize.Kcfi = bPtr
 	case scs:
 		sanitize.Properties.Sanitize.Scs = bPtr
 	case Memtag_heap:
@@ -923,6 +1777,12 @@ func (sanitize *sanitize) isSanitizerEnabled(t SanitizerType) bool {
 	return sanitizerVal != nil && *sanitizerVal == true
 }
 
+// runtimeOptional returns true if the shared sanitizer runtime library is linked with
+// -Wl,--as-needed.
+func (sanitize *sanitize) runtimeOptional(ctx BaseModuleContext) bool {
+	return Bool(sanitize.Properties.Sanitize.Runtime_optional) && !ctx.static() && !ctx.staticBinary()
+}
+
 // IsSanitizableDependencyTag returns true if the dependency tag is sanitizable.
 func IsSanitizableDependencyTag(tag blueprint.DependencyTag) bool {
 	switch t := tag.(type) {
@@ -940,9 +1800,9 @@ func (m *Module) SanitizableDepTagChecker() SantizableDependencyTagChecker {
 }
 
 // Determines if the current module is a static library going to be captured
-// as vendor snapshot. Such modules must create both cfi and non-cfi variants,
-// except for ones which explicitly disable cfi.
-func needsCfiForVendorSnapshot(mctx android.TopDownMutatorContext) bool {
+// as vendor snapshot. Such modules must create both sanitized and non-sanitized
+// variants, except for ones which explicitly disable the sanitizer.
+func needsSanitizerForVendorSnapshot(mctx android.TopDownMutatorContext, t SanitizerType) bool {
 	if snapshot.IsVendorProprietaryModule(mctx) {
 		return false
 	}
@@ -961,48 +1821,29 @@ func needsCfiForVendorSnapshot(mctx android.TopDownMutatorContext) bool {
 		return false
 	}
 
-	if !c.SanitizerSupported(cfi) {
+	if !c.SanitizerSupported(t) {
 		return false
 	}
 
 	return c.SanitizePropDefined() &&
 		!c.SanitizeNever() &&
-		!c.IsSanitizerExplicitlyDisabled(cfi)
+		!c.IsSanitizerExplicitlyDisabled(t)
 }
 
 // Propagate sanitizer requirements down from binaries
-func sanitizerDepsMutator(t SanitizerType) func(android.TopDownMutatorContext) {
+func sanitizerDepsMutator(t SanitizerType, p sanitizerPropagation) func(android.TopDownMutatorContext) {
 	return func(mctx android.TopDownMutatorContext) {
 		if c, ok := mctx.Module().(PlatformSanitizeable); ok {
 			enabled := c.IsSanitizerEnabled(t)
-			if t == cfi && needsCfiForVendorSnapshot(mctx) {
-				// We shouldn't change the result of isSanitizerEnabled(cfi) to correctly
+			if p.vendorSnapshotVariants && needsSanitizerForVendorSnapshot(mctx, t) {
+				// We shouldn't change the result of isSanitizerEnabled(t) to correctly
 				// determine defaultVariation in sanitizerMutator below.
-				// Instead, just mark SanitizeDep to forcefully create cfi variant.
+				// Instead, just mark SanitizeDep to forcefully create the sanitized variant.
 				enabled = true
 				c.SetSanitizeDep(true)
 			}
-			if enabled {
-				isSanitizableDependencyTag := c.SanitizableDepTagChecker()
-				mctx.WalkDeps(func(child, parent android.Module) bool {
-					if !isSanitizableDependencyTag(mctx.OtherModuleDependencyTag(child)) {
-						return false
-					}
-					if d, ok := child.(PlatformSanitizeable); ok && d.SanitizePropDefined() &&
-						!d.SanitizeNever() &&
-						!d.IsSanitizerExplicitlyDisabled(t) {
-						if t == cfi || t == Hwasan || t == scs || t == Asan {
-							if d.StaticallyLinked() && d.SanitizerSupported(t) {
-								// Rust does not support some of these sanitizers, so we need to check if it's
-								// supported before setting this true.
-								d.SetSanitizeDep(true)
-							}
-						} else {
-							d.SetSanitizeDep(true)
-						}
-					}
-					return true
-				})
+			if enabled && !c.SanitizeShallow() && !sanitizerVariantsUnneeded(c) {
+				markSanitizerDeps(mctx, c, t, p)
 			}
 		} else if sanitizeable, ok := mctx.Module().(Sanitizeable); ok {
 			// If an APEX module includes a lib which is enabled for a sanitizer T, then
@@ -1016,10 +1857,197 @@ func sanitizerDepsMutator(t SanitizerType) func(android.TopDownMutatorContext) {
 	}
 }
 
+// applyToLinkages unsets the sanitizers enabled by the module's properties in the variants of the
+// linkages that sanitize.apply_to doesn't list. They are unset rather than disabled, so that these
+// variants can still be sanitized as dependencies of sanitized modules. Must be called after the
+// static and shared variants of the library are split and before the global sanitizers are applied.
+func (sanitize *sanitize) applyToLinkages(ctx BaseModuleContext) {
+	s := &sanitize.Properties.Sanitize
+	for _, linkage := range s.Apply_to {
+		if linkage != "static" && linkage != "shared" {
+			ctx.PropertyErrorf("sanitize.apply_to", "unknown linkage %q, must be \"static\" or "+
+				"\"shared\"", linkage)
+		}
+	}
+
+	c, ok := ctx.Module().(*Module)
+	if !ok || c.library == nil {
+		ctx.PropertyErrorf("sanitize.apply_to", "is only supported on libraries")
+		return
+	}
+	if !(c.library.buildStatic() && inList("static", s.Apply_to)) &&
+		!(c.library.buildShared() && inList("shared", s.Apply_to)) {
+		ctx.PropertyErrorf("sanitize.apply_to", "none of the linkages %q are built for this module, "+
+			"which would disable its sanitizers", s.Apply_to)
+		return
+	}
+	if (c.library.static() && inList("static", s.Apply_to)) ||
+		(c.library.shared() && inList("shared", s.Apply_to)) {
+		return
+	}
+
+	sanitize.unsetSanitizers()
+}
+
+// unsetSanitizers unsets the sanitizers of the module's properties. The sanitizers with a
+// SanitizerType are unset through SetSanitizer, so that new ones are covered, followed by the
+// undefined behavior checks and the other sanitizers that don't have one, and the diagnostic
+// modes, which would otherwise apply to the sanitizers propagated to the module.
+func (sanitize *sanitize) unsetSanitizers() {
+	for _, t := range Sanitizers {
+		sanitize.SetSanitizer(t, false)
+	}
+	s := &sanitize.Properties.Sanitize
+	s.All_undefined = nil
+	s.Undefined = nil
+	s.Misc_undefined = nil
+	s.Safestack = nil
+	s.Cfi_modes = nil
+	s.Scudo = nil
+	s.Diag = SanitizeUserProps{}.Diag
+}
+
+// applyToSplitsLinkages returns true if sanitize.apply_to makes the sanitizers enabled by the
+// module's properties apply to only one of the static and shared variants of the library, which
+// then can't share their object files.
+func (sanitize *sanitize) applyToSplitsLinkages() bool {
+	s := &sanitize.Properties.Sanitize
+	if len(s.Apply_to) == 0 || (inList("static", s.Apply_to) && inList("shared", s.Apply_to)) {
+		return false
+	}
+	return len(sanitize.enabledSanitizerNames()) > 0 || Bool(s.Safestack) || Bool(s.Scudo) ||
+		len(s.Cfi_modes) > 0
+}
+
+// sanitizeCompilesCpp returns true if the module compiles C++ sources.
+func sanitizeCompilesCpp(ctx ModuleContext) bool {
+	c, ok := ctx.Module().(*Module)
+	if !ok {
+		return false
+	}
+	compiler, ok := c.compiler.(interface{ hasCppSrcs() bool })
+	return ok && compiler.hasCppSrcs()
+}
+
+// checkMinSdkVersion reports an error for each sanitizer enabled by the module's properties that
+// can't run on the oldest API level the module supports, see sanitizerMinSdkLevel. Modules available
+// to apexes are checked against the min_sdk_version of each apex containing them instead, see
+// ShouldSupportSdkVersion.
+func (sanitize *sanitize) checkMinSdkVersion(ctx BaseModuleContext) {
+	if !ctx.Device() {
+		return
+	}
+	if c, ok := ctx.Module().(*Module); ok {
+		for _, available := range c.ApexAvailable() {
+			if available != android.AvailableToPlatform {
+				return
+			}
+		}
+	}
+	level, ok := sanitizerMinSdkLevel(ctx)
+	if !ok {
+		return
+	}
+	for _, t := range Sanitizers {
+		if !Bool(sanitize.getSanitizerBoolPtr(t)) {
+			continue
+		}
+		if min, ok := sanitizerMinSdkVersion(ctx, t.name()); ok && level.LessThan(min) {
+			ctx.PropertyErrorf("sanitize."+t.name(), "requires min_sdk_version %v or later, the "+
+				"module's min_sdk_version is %v", min, level)
+		}
+	}
+}
+
+// explicitSanitizers returns the sanitizers enabled by the module's own properties or by its
+// directory defaults. Unlike the global sanitizers, they aren't skipped when they can't be applied.
+func (sanitize *sanitize) explicitSanitizers() []string {
+	return android.FirstUniqueStrings(append(android.CopyOf(sanitize.Properties.OwnSanitizers),
+		sanitize.Properties.DirectoryDefaultSanitizers...))
+}
+
+// checkHasSources warns if the module's own properties enable sanitizers but it has neither sources
+// nor whole static libraries to instrument, which usually means the properties are misplaced, e.g.
+// on a module that only forwards its dependencies. Must be called before the global sanitizers are
+// applied.
+func (sanitize *sanitize) checkHasSources(ctx BaseModuleContext) {
+	c, ok := ctx.Module().(*Module)
+	if !ok || c.Header() || !sanitizeWithoutSources(c) {
+		return
+	}
+	if l, ok := c.linker.(interface{ hasWholeStaticLibs() bool }); ok && l.hasWholeStaticLibs() {
+		return
+	}
+
+	s := &sanitize.Properties.Sanitize
+	var enabled []string
+	if Bool(s.All_undefined) || Bool(s.Undefined) || len(s.Misc_undefined) > 0 {
+		enabled = append(enabled, "undefined")
+	}
+	for _, t := range Sanitizers {
+		// Heap tagging is enabled at runtime and doesn't instrument the module's code.
+		if t != Memtag_heap && Bool(sanitize.getSanitizerBoolPtr(t)) {
+			enabled = append(enabled, t.name())
+		}
+	}
+	if len(enabled) > 0 {
+		sanitize.Properties.Warnings = append(sanitize.Properties.Warnings,
+			fmt.Sprintf("sanitize: %s enabled, but the module has no srcs or whole_static_libs to instrument",
+				strings.Join(enabled, ", ")))
+	}
+}
+
+// sanitizeWithoutSources returns true if the module compiles no sources of its own, e.g. a static
+// library that only bundles other libraries. As it contains no instrumented code it doesn't make
+// the modules depending on it link a UBSan runtime library on its own behalf, and it may not need
+// sanitizer variants at all, see sanitizerVariantsUnneeded. Prebuilt modules have no compiler and
+// are assumed to contain instrumented code.
+func sanitizeWithoutSources(c *Module) bool {
+	s, ok := c.compiler.(interface{ hasSrcs() bool })
+	return ok && !s.hasSrcs()
+}
+
+// sanitizerVariantsUnneeded returns true if the sanitizers enabled for a static or header library
+// affect neither its objects nor what is linked with it: it has no sources or whole static
+// libraries, and no sanitized module depending on it needs its sanitized variants to forward them
+// to its dependencies. Such a library isn't split by the sanitizer mutators, and its sanitizers
+// aren't propagated to its dependencies.
+func sanitizerVariantsUnneeded(c PlatformSanitizeable) bool {
+	m, ok := c.(*Module)
+	if !ok || m.Binary() || !(m.StaticallyLinked() || m.Header()) || m.SanitizeDep() ||
+		!sanitizeWithoutSources(m) {
+		return false
+	}
+	if l, ok := m.linker.(interface{ hasWholeStaticLibs() bool }); ok && l.hasWholeStaticLibs() {
+		return false
+	}
+	return true
+}
+
+// isSanitizerPassthrough returns true if the sanitizer variations of m only forward the variation
+// of the modules that depend on it to its own dependencies, see sanitize.passthrough. The sanitized
+// variants of static libraries captured by the vendor snapshot are always real, and so are the
+// shared variants of libraries that set sanitize.passthrough for their static variant.
+func isSanitizerPassthrough(m PlatformSanitizeable, t SanitizerType, p sanitizerPropagation) bool {
+	c, ok := m.(*Module)
+	if !ok || c.sanitize == nil || (p.vendorSnapshotVariants && !c.Header()) {
+		return false
+	}
+	return c.Header() || (c.static() && Bool(c.sanitize.Properties.Sanitize.Passthrough))
+}
+
 func (c *Module) SanitizeNever() bool {
 	return Bool(c.sanitize.Properties.Sanitize.Never)
 }
 
+func (c *Module) SanitizeShallow() bool {
+	return Bool(c.sanitize.Properties.Sanitize.Shallow)
+}
+
+func (c *Module) SanitizeSharedDepsOnly() bool {
+	return Bool(c.sanitize.Properties.Sanitize.Shared_deps_only)
+}
+
 func (c *Module) IsSanitizerExplicitlyDisabled(t SanitizerType) bool {
 	return c.sanitize.isSanitizerExplicitlyDisabled(t)
 }
@@ -1038,6 +2066,11 @@ func sanitizerRuntimeDepsMutator(mctx android.TopDownMutatorContext) {
 			if !ok || !d.static() {
 				return false
 			}
+			if d.sanitize != nil && sanitizeWithoutSources(d) {
+				// It contains no instrumented code of its own, only its dependencies may need a
+				// runtime library.
+				return true
+			}
 			if d.sanitize != nil {
 				if enableMinimalRuntime(d.sanitize) {
 					// If a static dependency is built with the minimal runtime,
@@ -1082,6 +2115,13 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 		if !c.Enabled() {
 			return
 		}
+		// All the sanitizer mutators have run, so the variant won't change anymore.
+		if mctx.Config().SeparateSanitizerOutputs() && c.IsSanitizerVariant() {
+			c.SetOutputSubdir("sanitized")
+		}
+		checkStlSanitizerVariant(mctx, c)
+		checkClangSupportsSanitizers(mctx, c)
+
 		var sanitizers []string
 		var diagSanitizers []string
 
@@ -1116,6 +2156,13 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 			sanitizers = append(sanitizers, c.sanitize.Properties.Sanitize.Misc_undefined...)
 		}
 
+		// With the standalone runtime all the undefined behavior checks report errors instead of
+		// trapping, as if they were in diag.
+		ubsanStandalone := c.sanitize.ubsanStandaloneRuntime()
+		if ubsanStandalone {
+			diagSanitizers = append(diagSanitizers, sanitizers...)
+		}
+
 		if Bool(c.sanitize.Properties.Sanitize.Diag.Undefined) {
 			diagSanitizers = append(diagSanitizers, "undefined")
 		}
@@ -1140,17 +2187,17 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 		}
 
 		if Bool(c.sanitize.Properties.Sanitize.Cfi) {
-			sanitizers = append(sanitizers, "cfi")
+			sanitizers = append(sanitizers, c.sanitize.cfiSchemes()...)
 
 			if Bool(c.sanitize.Properties.Sanitize.Diag.Cfi) {
-				diagSanitizers = append(diagSanitizers, "cfi")
+				diagSanitizers = append(diagSanitizers, c.sanitize.cfiSchemes()...)
 			}
 		}
 
 		if Bool(c.sanitize.Properties.Sanitize.Integer_overflow) {
 			sanitizers = append(sanitizers, "unsigned-integer-overflow")
 			sanitizers = append(sanitizers, "signed-integer-overflow")
-			if Bool(c.sanitize.Properties.Sanitize.Diag.Integer_overflow) {
+			if Bool(c.sanitize.Properties.Sanitize.Diag.Integer_overflow) || ubsanStandalone {
 				diagSanitizers = append(diagSanitizers, "unsigned-integer-overflow")
 				diagSanitizers = append(diagSanitizers, "signed-integer-overflow")
 			}
@@ -1164,11 +2211,13 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 			sanitizers = append(sanitizers, "shadow-call-stack")
 		}
 
-		if Bool(c.sanitize.Properties.Sanitize.Memtag_heap) && c.Binary() {
-			noteDep := "note_memtag_heap_async"
-			if Bool(c.sanitize.Properties.Sanitize.Diag.Memtag_heap) {
-				noteDep = "note_memtag_heap_sync"
-			}
+		if Bool(c.sanitize.Properties.Sanitize.Memtag_heap) && c.staticBinary() {
+			// The note libraries are built for dynamic executables. Static executables pass
+			// -fsanitize=memtag-heap to the link instead, see sanitize.flags, and rely on the static
+			// libc to enable heap tagging at startup.
+			checkMemtagHeapStaticLibc(mctx)
+		} else if Bool(c.sanitize.Properties.Sanitize.Memtag_heap) && c.Binary() {
+			noteDep := "note_memtag_heap_" + c.sanitize.memtagHeapMode()
 			// If we're using snapshots, redirect to snapshot whenever possible
 			// TODO(b/178470649): clean manual snapshot redirections
 			snapshot := mctx.Provider(SnapshotInfoProvider).(SnapshotInfo)
@@ -1190,8 +2239,8 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 
 		// Save the list of sanitizers. These will be used again when generating
 		// the build rules (for Cflags, etc.)
-		c.sanitize.Properties.Sanitizers = sanitizers
-		c.sanitize.Properties.DiagSanitizers = diagSanitizers
+		c.sanitize.Properties.Sanitizers = orderSanitizers(sanitizers, c.sanitize.Properties.Sanitize.Order)
+		c.sanitize.Properties.DiagSanitizers = orderSanitizers(diagSanitizers, c.sanitize.Properties.Sanitize.Order)
 
 		// TODO(b/150822854) Hosts have a different default behavior and assume the runtime library is used.
 		if c.Host() {
@@ -1200,11 +2249,19 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 
 		// Determine the runtime library required
 		runtimeLibrary := ""
+		runtimeSanitizer := ""
 		var extraStaticDeps []string
+		staticRuntime := false
 		toolchain := c.toolchain(mctx)
 		if Bool(c.sanitize.Properties.Sanitize.Address) {
 			runtimeLibrary = config.AddressSanitizerRuntimeLibrary(toolchain)
+			runtimeSanitizer = "address"
+			if toolchain.Musl() {
+				runtimeLibrary = config.AddressSanitizerMuslStaticLibrary(toolchain)
+				staticRuntime = true
+			}
 		} else if Bool(c.sanitize.Properties.Sanitize.Hwaddress) {
+			runtimeSanitizer = "hwaddress"
 			if c.staticBinary() {
 				runtimeLibrary = config.HWAddressSanitizerStaticLibrary(toolchain)
 				extraStaticDeps = []string{"libdl"}
@@ -1213,7 +2270,9 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 			}
 		} else if Bool(c.sanitize.Properties.Sanitize.Thread) {
 			runtimeLibrary = config.ThreadSanitizerRuntimeLibrary(toolchain)
+			runtimeSanitizer = "thread"
 		} else if Bool(c.sanitize.Properties.Sanitize.Scudo) {
+			runtimeSanitizer = "scudo"
 			if len(diagSanitizers) == 0 && !c.sanitize.Properties.UbsanRuntimeDep {
 				runtimeLibrary = config.ScudoMinimalRuntimeLibrary(toolchain)
 			} else {
@@ -1224,12 +2283,25 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 			Bool(c.sanitize.Properties.Sanitize.Undefined) ||
 			Bool(c.sanitize.Properties.Sanitize.All_undefined) {
 			runtimeLibrary = config.UndefinedBehaviorSanitizerRuntimeLibrary(toolchain)
+			runtimeSanitizer = "undefined"
 			if c.staticBinary() {
 				runtimeLibrary += ".static"
 			}
 		}
 
-		addStaticDeps := func(deps ...string) {
+		// Some architectures need a runtime library built for a different ABI than the default one.
+		if override, ok := mctx.Config().SanitizerRuntimeOverride(runtimeSanitizer, mctx.Arch().ArchType.Name); ok {
+			runtimeLibrary = override
+		}
+		if runtimeLibrary != "" {
+			var err error
+			runtimeLibrary, err = sanitizerRuntimeModule(mctx.Config(), mctx.OtherModuleExists, runtimeLibrary)
+			if err != nil {
+				mctx.ModuleErrorf("%s", err)
+			}
+		}
+
+		addStaticDeps := func(wholeStatic bool, deps ...string) {
 			// If we're using snapshots, redirect to snapshot whenever possible
 			snapshot := mctx.Provider(SnapshotInfoProvider).(SnapshotInfo)
 			for idx, dep := range deps {
@@ -1239,7 +2311,7 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 			}
 
 			// static executable gets static runtime libs
-			depTag := libraryDependencyTag{Kind: staticLibraryDependency}
+			depTag := libraryDependencyTag{Kind: staticLibraryDependency, wholeStatic: wholeStatic}
 			variations := append(mctx.Target().Variations(),
 				blueprint.Variation{Mutator: "link", Variation: "static"})
 			if c.Device() {
@@ -1249,14 +2321,24 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 				variations = append(variations,
 					blueprint.Variation{Mutator: "sdk", Variation: "sdk"})
 			}
-			mctx.AddFarVariationDependencies(variations, depTag, deps...)
-
+			for _, dep := range deps {
+				if sanitizerRuntimeVariantMissing(mctx, variations, "static", dep) {
+					continue
+				}
+				mctx.AddFarVariationDependencies(variations, depTag, dep)
+			}
 		}
-		if enableMinimalRuntime(c.sanitize) || c.sanitize.Properties.MinimalRuntimeDep {
-			addStaticDeps(config.UndefinedBehaviorSanitizerMinimalRuntimeLibrary(toolchain))
+		if ((enableMinimalRuntime(c.sanitize) && !sanitizeWithoutSources(c)) || c.sanitize.Properties.MinimalRuntimeDep) &&
+			sanitizerRuntimeAvailable(ubsanMinimalRuntime, c.Os()) {
+			minimalRuntime, err := sanitizerRuntimeModule(mctx.Config(), mctx.OtherModuleExists,
+				config.UndefinedBehaviorSanitizerMinimalRuntimeLibrary(toolchain))
+			if err != nil {
+				mctx.ModuleErrorf("%s", err)
+			}
+			addStaticDeps(false, minimalRuntime)
 		}
 		if c.sanitize.Properties.BuiltinsDep {
-			addStaticDeps(config.BuiltinsRuntimeLibrary(toolchain))
+			addStaticDeps(false, config.BuiltinsRuntimeLibrary(toolchain))
 		}
 
 		if runtimeLibrary != "" && (toolchain.Bionic() || toolchain.Musl() || c.sanitize.Properties.UbsanRuntimeDep) {
@@ -1269,9 +2351,18 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 			//
 			// Note that by adding dependency with {static|shared}DepTag, the lib is
 			// added to libFlags and LOCAL_SHARED_LIBRARIES by cc.Module
-			if c.staticBinary() {
-				addStaticDeps(runtimeLibrary)
-				addStaticDeps(extraStaticDeps...)
+			if staticRuntime {
+				// The musl runtime is linked statically and in full into executables only, shared
+				// libraries leave its symbols undefined.
+				if !mctx.OtherModuleExists(runtimeLibrary) {
+					mctx.ModuleErrorf("sanitize.%s on %s requires the %q runtime library, which is not defined",
+						runtimeSanitizer, c.Os(), runtimeLibrary)
+				} else if c.Binary() {
+					addStaticDeps(true, runtimeLibrary)
+				}
+			} else if c.staticBinary() {
+				addStaticDeps(false, runtimeLibrary)
+				addStaticDeps(false, extraStaticDeps...)
 			} else if !c.static() && !c.Header() {
 				// If we're using snapshots, redirect to snapshot whenever possible
 				snapshot := mctx.Provider(SnapshotInfoProvider).(SnapshotInfo)
@@ -1290,6 +2381,10 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 					Order: earlyLibraryDependency,
 
 					skipApexAllowedDependenciesCheck: diagEnabled,
+
+					excludeInInstall: Bool(c.sanitize.Properties.Sanitize.No_runtime_install),
+
+					sanitizerRuntime: true,
 				}
 				variations := append(mctx.Target().Variations(),
 					blueprint.Variation{Mutator: "link", Variation: "shared"})
@@ -1300,7 +2395,9 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 					variations = append(variations,
 						blueprint.Variation{Mutator: "sdk", Variation: "sdk"})
 				}
-				AddSharedLibDependenciesWithVersions(mctx, c, variations, depTag, runtimeLibrary, "", true)
+				if !sanitizerRuntimeVariantMissing(mctx, variations, "shared", runtimeLibrary) {
+					AddSharedLibDependenciesWithVersions(mctx, c, variations, depTag, runtimeLibrary, "", true)
+				}
 			}
 			// static lib does not have dependency to the runtime library. The
 			// dependency will be added to the executables or shared libs using
@@ -1309,6 +2406,45 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 	}
 }
 
+// checkStlSanitizerVariant reports an error if an asan or hwasan variant of a binary or shared
+// library links a variant of libc++_static built without the same sanitizer. The container
+// annotations of libc++ must match between all the code of a process, so the sanitizer mutators
+// select the sanitized variant of the STL along with the other static dependencies. This catches
+// the cases in which they can't, e.g. because the STL was excluded from the sanitizer.
+func checkStlSanitizerVariant(mctx android.BottomUpMutatorContext, c *Module) {
+	if c.stl == nil || c.stl.Properties.SelectedStl != "libc++_static" || !(c.Binary() || c.Shared()) {
+		return
+	}
+	stl := c.stl.Properties.SelectedStl
+	for _, t := range []SanitizerType{Asan, Hwasan} {
+		if !c.sanitize.isSanitizerEnabled(t) {
+			continue
+		}
+		mctx.VisitDirectDeps(func(dep android.Module) {
+			tag, ok := mctx.OtherModuleDependencyTag(dep).(libraryDependencyTag)
+			if !ok || !tag.static() || mctx.OtherModuleName(dep) != stl {
+				return
+			}
+			if d, ok := dep.(*Module); ok && d.sanitize != nil && !d.sanitize.isSanitizerEnabled(t) {
+				mctx.ModuleErrorf("links a variant of %q built without %s, the STL must be built "+
+					"with the same sanitizers as the code using it", stl, t.name())
+			}
+		})
+	}
+}
+
+// checkClangSupportsSanitizers reports an error for each sanitizer enabled for the variant, by its
+// properties or globally, that the clang toolchain of the build is too old to support, rather than
+// passing clang flags it doesn't know.
+func checkClangSupportsSanitizers(mctx android.BottomUpMutatorContext, c *Module) {
+	for _, sanitizer := range c.sanitize.enabledSanitizerNames() {
+		if ok, min := clangSupportsSanitizer(mctx.Config(), sanitizer); !ok {
+			mctx.ModuleErrorf("sanitizer %q requires clang %s or later, the toolchain is clang %s",
+				sanitizer, min, config.ClangReleaseVersion(mctx.Config()))
+		}
+	}
+}
+
 type Sanitizeable interface {
 	android.Module
 	IsSanitizerEnabled(ctx android.BaseModuleContext, sanitizerName string) bool
@@ -1360,24 +2496,92 @@ func (c *Module) SetSanitizeDep(b bool) {
 
 var _ PlatformSanitizeable = (*Module)(nil)
 
+// IsSanitizerVariant returns true if this variant was created by a sanitizer mutator with the
+// sanitizer enabled.
+func (c *Module) IsSanitizerVariant() bool {
+	return c.sanitize != nil && len(c.sanitize.variantSanitizers()) > 0
+}
+
+// testOnlySanitizers are the sanitizers that are only used for testing, unlike the sanitizers
+// such as cfi or scs that production modules are built with.
+var testOnlySanitizers = map[SanitizerType]bool{
+	Asan:   true,
+	Hwasan: true,
+	tsan:   true,
+	Fuzzer: true,
+}
+
+// IsTestOnlySanitizerVariant returns true if this variant was created by a sanitizer mutator with a
+// sanitizer in testOnlySanitizers enabled.
+func (c *Module) IsTestOnlySanitizerVariant() bool {
+	if c.sanitize == nil {
+		return false
+	}
+	for _, t := range c.sanitize.variantSanitizers() {
+		if testOnlySanitizers[t] {
+			return true
+		}
+	}
+	return false
+}
+
+var _ android.SanitizerVariantModule = (*Module)(nil)
+
+// DistVariants returns the sanitizer variations the module was split into, and the names of this
+// variant for the dist entries, see android.Dist.Variant.
+func (c *Module) DistVariants() (available []string, names []string) {
+	if c.sanitize == nil {
+		return nil, []string{""}
+	}
+	props := c.sanitize.Properties
+	if c.HiddenFromMake() {
+		// The dist entries of variants hidden from Make are never emitted.
+		return props.SanitizerVariations, nil
+	}
+	if !props.NonDefaultVariant {
+		names = append(names, "")
+	}
+	if props.SanitizerVariation != "" {
+		names = append(names, props.SanitizerVariation)
+	}
+	return props.SanitizerVariations, names
+}
+
+var _ android.DistVariantModule = (*Module)(nil)
+
 // Create sanitized variants for modules that need them
-func sanitizerMutator(t SanitizerType) func(android.BottomUpMutatorContext) {
+func sanitizerMutator(t SanitizerType, p sanitizerPropagation) func(android.BottomUpMutatorContext) {
 	return func(mctx android.BottomUpMutatorContext) {
 		if c, ok := mctx.Module().(PlatformSanitizeable); ok && c.SanitizePropDefined() {
 
 			// Make sure we're not setting CFI to any value if it's not supported.
 			cfiSupported := mctx.Module().(PlatformSanitizeable).SanitizerSupported(cfi)
 
-			if c.Binary() && c.IsSanitizerEnabled(t) {
-				modules := mctx.CreateVariations(t.variationName())
+			if c.IsSanitizerEnabled(t) && sanitizerVariantsUnneeded(c) {
+				// Static and header libraries without anything to instrument keep their only
+				// variant, as nothing depends on their sanitized variant.
+			} else if c.Binary() && c.IsSanitizerEnabled(t) && (c.SanitizeShallow() || c.SanitizeSharedDepsOnly()) {
+				// Shallow binaries are not split, so their dependencies on split static libs resolve
+				// to the first variation, which is the one built without the sanitizer. The sanitizer
+				// is still enabled for the binary's own sources. The same applies to binaries that
+				// only propagate the sanitizer to their shared deps, whose dependencies on shared libs
+				// resolve to the only, sanitized, variation of those libs.
+			} else if c.Binary() && c.IsSanitizerEnabled(t) {
+				// Fall back to the variations of the dependencies built without the sanitizer when a
+				// library, e.g. one with only a static or only a shared half, has no sanitized variation.
+				fallback := ""
+				modules := createSanitizerVariations(mctx, c, t, &fallback, t.variationName())
+				if modules == nil {
+					return
+				}
 				modules[0].(PlatformSanitizeable).SetSanitizer(t, true)
+				recordSanitizerVariations(modules, t.variationName())
 			} else if c.IsSanitizerEnabled(t) || c.SanitizeDep() {
 				isSanitizerEnabled := c.IsSanitizerEnabled(t)
-				if c.StaticallyLinked() || c.Header() || t == Fuzzer {
+				if c.StaticallyLinked() || c.Header() || p.splitShared {
 					// Static and header libs are split into non-sanitized and sanitized variants.
-					// Shared libs are not split. However, for asan and fuzzer, we split even for shared
-					// libs because a library sanitized for asan/fuzzer can't be linked from a library
-					// that isn't sanitized for asan/fuzzer.
+					// Shared libs are not split, unless the sanitizer sets splitShared because a
+					// library built with it can't be linked from a library built without it.
 					//
 					// Note for defaultVariation: since we don't split for shared libs but for static/header
 					// libs, it is possible for the sanitized variant of a static/header lib to depend
@@ -1388,9 +2592,10 @@ func sanitizerMutator(t SanitizerType) func(android.BottomUpMutatorContext) {
 					// is redirected to the sanitized variant of the dependent module.
 					defaultVariation := t.variationName()
 					// Not all PlatformSanitizeable modules support the CFI sanitizer
-					mctx.SetDefaultDependencyVariation(&defaultVariation)
-
-					modules := mctx.CreateVariations("", t.variationName())
+					modules := createSanitizerVariations(mctx, c, t, &defaultVariation, "", t.variationName())
+					if modules == nil {
+						return
+					}
 					modules[0].(PlatformSanitizeable).SetSanitizer(t, false)
 					modules[1].(PlatformSanitizeable).SetSanitizer(t, true)
 					modules[0].(PlatformSanitizeable).SetSanitizeDep(false)
@@ -1402,10 +2607,18 @@ func sanitizerMutator(t SanitizerType) func(android.BottomUpMutatorContext) {
 						modules[1].(PlatformSanitizeable).SetSanitizer(cfi, false)
 					}
 
-					// For cfi/scs/hwasan, we can export both sanitized and un-sanitized variants
-					// to Make, because the sanitized version has a different suffix in name.
-					// For other types of sanitizers, suppress the variation that is disabled.
-					if t != cfi && t != scs && t != Hwasan {
+					passthrough := isSanitizerPassthrough(c, t, p)
+					if passthrough {
+						// Header and forwarding libraries only need the sanitized variation to forward
+						// it to their dependencies. It isn't built with the sanitizer, and only the
+						// default variant is installed and exported to Make.
+						modules[1].(PlatformSanitizeable).SetSanitizer(t, false)
+						modules[1].(PlatformSanitizeable).SetPreventInstall()
+						modules[1].(PlatformSanitizeable).SetHideFromMake()
+					} else if !p.exportBothVariants {
+						// Sanitizers with exportBothVariants export both sanitized and un-sanitized
+						// variants to Make, because the sanitized version has a different suffix in
+						// name. For other types of sanitizers, suppress the variation that is disabled.
 						if isSanitizerEnabled {
 							modules[0].(PlatformSanitizeable).SetPreventInstall()
 							modules[0].(PlatformSanitizeable).SetHideFromMake()
@@ -1414,23 +2627,29 @@ func sanitizerMutator(t SanitizerType) func(android.BottomUpMutatorContext) {
 							modules[1].(PlatformSanitizeable).SetHideFromMake()
 						}
 					}
+					recordSanitizerVariations(modules, "", t.variationName())
 
 					// Export the static lib name to make
-					if c.StaticallyLinked() && c.ExportedToMake() {
-						if t == cfi {
-							cfiStaticLibs(mctx.Config()).add(c, c.Module().Name())
-						} else if t == Hwasan {
-							hwasanStaticLibs(mctx.Config()).add(c, c.Module().Name())
-						}
+					if c.StaticallyLinked() && c.ExportedToMake() && !passthrough && p.staticLibs != nil {
+						p.staticLibs(mctx.Config()).add(c, c.Module().Name())
 					}
 				} else {
 					// Shared libs are not split. Only the sanitized variant is created.
-					modules := mctx.CreateVariations(t.variationName())
+					// Fall back to the variations of the dependencies built without the sanitizer when a
+					// library, e.g. one with only a static or only a shared half, has no sanitized variation.
+					fallback := ""
+					modules := createSanitizerVariations(mctx, c, t, &fallback, t.variationName())
+					if modules == nil {
+						return
+					}
 					modules[0].(PlatformSanitizeable).SetSanitizer(t, true)
 					modules[0].(PlatformSanitizeable).SetSanitizeDep(false)
+					recordSanitizerVariations(modules, t.variationName())
 
-					// locate the asan libraries under /data/asan
-					if mctx.Device() && t == Asan && isSanitizerEnabled {
+					// locate the asan libraries under /data/asan, unless the module isn't installed
+					// at all, which would leave orphan files in the sanitizer directory.
+					if mctx.Device() && p.installInSanitizerDir && isSanitizerEnabled &&
+						proptools.BoolDefault(c.Installable(), true) {
 						modules[0].(PlatformSanitizeable).SetInSanitizerDir()
 					}
 
@@ -1445,7 +2664,8 @@ func sanitizerMutator(t SanitizerType) func(android.BottomUpMutatorContext) {
 		} else if sanitizeable, ok := mctx.Module().(Sanitizeable); ok && sanitizeable.IsSanitizerEnabled(mctx, t.name()) {
 			// APEX modules fall here
 			sanitizeable.AddSanitizerDependencies(mctx, t.name())
-			mctx.CreateVariations(t.variationName())
+			modules := mctx.CreateVariations(t.variationName())
+			sanitizerSplitsForConfig(mctx.Config()).record(t, modules[0], t.variationName())
 		} else if c, ok := mctx.Module().(*Module); ok {
 			//TODO: When Rust modules have vendor support, enable this path for PlatformSanitizeable
 
@@ -1455,21 +2675,225 @@ func sanitizerMutator(t SanitizerType) func(android.BottomUpMutatorContext) {
 				defaultVariation := t.variationName()
 				mctx.SetDefaultDependencyVariation(&defaultVariation)
 				modules := mctx.CreateVariations("", t.variationName())
+				sanitizerSplitsForConfig(mctx.Config()).record(t, modules[0], "", t.variationName())
 				modules[0].(*Module).linker.(snapshotSanitizer).setSanitizerVariation(t, false)
 				modules[1].(*Module).linker.(snapshotSanitizer).setSanitizerVariation(t, true)
 
 				// Export the static lib name to make
-				if c.static() && c.ExportedToMake() {
-					if t == cfi {
-						// use BaseModuleName which is the name for Make.
-						cfiStaticLibs(mctx.Config()).add(c, c.BaseModuleName())
-					}
+				if c.static() && c.ExportedToMake() && p.vendorSnapshotVariants && p.staticLibs != nil {
+					// use BaseModuleName which is the name for Make.
+					p.staticLibs(mctx.Config()).add(c, c.BaseModuleName())
 				}
 			}
 		}
 	}
 }
 
+// memtagHeapMode returns the heap tagging mode, sync if diag.memtag_heap is set and async otherwise.
+func (sanitize *sanitize) memtagHeapMode() string {
+	if Bool(sanitize.Properties.Sanitize.Diag.Memtag_heap) {
+		return "sync"
+	}
+	return "async"
+}
+
+// ubsanMaxErrors returns the number of undefined behavior reports after which the executable is
+// stopped by its launcher, or 0 if it doesn't have a launcher.
+func (sanitize *sanitize) ubsanMaxErrors() int {
+	if sanitize == nil {
+		return 0
+	}
+	s := &sanitize.Properties.Sanitize
+	if s.Max_errors == nil || *s.Max_errors <= 0 {
+		return 0
+	}
+	if !Bool(s.Undefined) && !Bool(s.All_undefined) && len(s.Misc_undefined) == 0 &&
+		!Bool(s.Integer_overflow) {
+		return 0
+	}
+	return *s.Max_errors
+}
+
+// ubsanLauncherScript returns a shell script that runs the executable next to it with
+// halt_on_error=0 added to UBSAN_OPTIONS, and stops it after maxErrors undefined behavior reports:
+// the loop reading its stderr exits, so it gets SIGPIPE at its next write to stderr.
+func ubsanLauncherScript(ctx ModuleContext, executable string, maxErrors int) string {
+	shell := "/system/bin/sh"
+	if ctx.Host() {
+		shell = "/bin/bash"
+	}
+	return strings.Join([]string{
+		"#!" + shell,
+		"export UBSAN_OPTIONS=\"halt_on_error=0${UBSAN_OPTIONS:+:$UBSAN_OPTIONS}\"",
+		"set -o pipefail",
+		fmt.Sprintf("{ \"${0%%/*}/%s\" \"$@\" 2>&1 >&3 3>&- | {", executable),
+		"  n=0",
+		"  while IFS= read -r line; do",
+		"    printf '%s\\n' \"$line\"",
+		"    case \"$line\" in",
+		"      *\"runtime error:\"*)",
+		"        n=$((n + 1))",
+		fmt.Sprintf("        if [ $n -ge %d ]; then", maxErrors),
+		fmt.Sprintf("          echo \"%s: stopping after %d undefined behavior reports\"", executable, maxErrors),
+		"          exit 1",
+		"        fi;;",
+		"    esac",
+		"  done",
+		"} >&2; } 3>&1",
+	}, "\n")
+}
+
+// checkMemtagHeapStaticLibc reports an error if a static executable with memtag_heap doesn't link
+// bionic's libc statically. Without a dynamic linker, the static libc is what reads the memtag note
+// and enables heap tagging when the executable starts.
+func checkMemtagHeapStaticLibc(mctx android.BottomUpMutatorContext) {
+	libc := "libc"
+	snapshot := mctx.Provider(SnapshotInfoProvider).(SnapshotInfo)
+	if lib, ok := snapshot.StaticLibs[libc]; ok {
+		libc = lib
+	}
+
+	found := false
+	mctx.VisitDirectDeps(func(dep android.Module) {
+		if IsStaticDepTag(mctx.OtherModuleDependencyTag(dep)) && mctx.OtherModuleName(dep) == libc {
+			found = true
+		}
+	})
+	if !found {
+		mctx.PropertyErrorf("sanitize.memtag_heap", "requires the static executable to link %q "+
+			"statically, which enables heap tagging at startup", libc)
+	}
+}
+
+// sanitizerRuntimeVariantMissing returns true and reports a module error if the sanitizer runtime
+// library dep exists but has no variant matching variations, e.g. because the linkage needed by
+// this module is disabled for it. Adding the dependency anyway would fail inside blueprint without
+// naming the missing linkage. Runtime libraries that don't exist at all are left to the normal
+// missing dependency handling.
+func sanitizerRuntimeVariantMissing(mctx android.BottomUpMutatorContext, variations []blueprint.Variation,
+	linkage, dep string) bool {
+	if !mctx.OtherModuleExists(dep) || mctx.OtherModuleFarDependencyVariantExists(variations, dep) {
+		return false
+	}
+	mctx.ModuleErrorf("sanitizer runtime library %q has no %s variant for %s, but it is required by the "+
+		"sanitizers enabled for this module", dep, linkage, mctx.Target().String())
+	return true
+}
+
+// sanitizerSplits records the variations that the modules split by each sanitizer mutator were
+// split into. They are keyed by the first variation, which is the variant that dependents keep
+// referencing until they are split themselves.
+type sanitizerSplits struct {
+	lock       sync.Mutex
+	variations map[sanitizerSplitKey][]string
+}
+
+type sanitizerSplitKey struct {
+	t      SanitizerType
+	module blueprint.Module
+}
+
+var sanitizerSplitsKey = android.NewOnceKey("sanitizerSplits")
+
+func sanitizerSplitsForConfig(config android.Config) *sanitizerSplits {
+	return config.Once(sanitizerSplitsKey, func() interface{} {
+		return &sanitizerSplits{variations: make(map[sanitizerSplitKey][]string)}
+	}).(*sanitizerSplits)
+}
+
+func (s *sanitizerSplits) record(t SanitizerType, first blueprint.Module, variations ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.variations[sanitizerSplitKey{t, first}] = variations
+}
+
+func (s *sanitizerSplits) get(t SanitizerType, module blueprint.Module) ([]string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	variations, ok := s.variations[sanitizerSplitKey{t, module}]
+	return variations, ok
+}
+
+// createSanitizerVariations splits the module into the given variations of the mutator of t, after
+// checking that each dependency that was split by the same mutator has the variants the module's
+// dependencies will be redirected to. Blueprint would otherwise fail with an error that lists the
+// variants of the dependency without mentioning the sanitizer. defaultVariation, if set, is the
+// variation dependencies fall back to when they don't have the one they are redirected to. Returns
+// nil after reporting an error if a variant is missing.
+func createSanitizerVariations(mctx android.BottomUpMutatorContext, c PlatformSanitizeable, t SanitizerType,
+	defaultVariation *string, variations ...string) []android.Module {
+	splits := sanitizerSplitsForConfig(mctx.Config())
+	if defaultVariation != nil {
+		mctx.SetDefaultDependencyVariation(defaultVariation)
+	}
+
+	missing := false
+	mctx.VisitDirectDepsBlueprint(func(dep blueprint.Module) {
+		available, ok := splits.get(t, dep)
+		if !ok || (defaultVariation != nil && inList(*defaultVariation, available)) {
+			return
+		}
+		for _, variation := range variations {
+			if inList(variation, available) {
+				continue
+			}
+			var availableDescriptions []string
+			for _, v := range available {
+				availableDescriptions = append(availableDescriptions, sanitizerVariationDescription(v))
+			}
+			mctx.ModuleErrorf("the %s variant of this module requires the matching variant of dependency %q, "+
+				"because %s, but %q only has the %s variants", sanitizerVariationDescription(variation),
+				mctx.OtherModuleName(dep), sanitizerRequestReason(c, t), mctx.OtherModuleName(dep),
+				strings.Join(availableDescriptions, ", "))
+			missing = true
+		}
+	})
+	if missing {
+		return nil
+	}
+
+	modules := mctx.CreateVariations(variations...)
+	splits.record(t, modules[0], variations...)
+	return modules
+}
+
+// recordSanitizerVariations records the variations a sanitizer mutator split the module into in
+// each of the variants, for the dist entries that name one of them. It must be called after the
+// variants hidden from Make are marked: they can't be named, and the variant installed by default
+// is the first one that isn't hidden, which isn't the first variation when the sanitizer is
+// enabled for a static library.
+func recordSanitizerVariations(modules []android.Module, variations ...string) {
+	var visible []string
+	for i, module := range modules {
+		if m, ok := module.(*Module); ok && !m.HiddenFromMake() && variations[i] != "" {
+			visible = append(visible, variations[i])
+		}
+	}
+	defaultFound := false
+	for i, module := range modules {
+		m, ok := module.(*Module)
+		if !ok || m.sanitize == nil {
+			continue
+		}
+		props := &m.sanitize.Properties
+		for _, variation := range visible {
+			if !inList(variation, props.SanitizerVariations) {
+				props.SanitizerVariations = append(props.SanitizerVariations, variation)
+			}
+		}
+		if variations[i] != "" {
+			props.SanitizerVariation = variations[i]
+		}
+		if m.HiddenFromMake() {
+			continue
+		}
+		if defaultFound {
+			props.NonDefaultVariant = true
+		}
+		defaultFound = true
+	}
+}
+
 type sanitizerStaticLibsMap struct {
 	// libsMap contains one list of modules per each image and each arch.
 	// e.g. libs[vendor]["arm"] contains arm modules installed to vendor
@@ -1540,8 +2964,149 @@ func hwasanStaticLibs(config android.Config) *sanitizerStaticLibsMap {
 	}).(*sanitizerStaticLibsMap)
 }
 
+const (
+	ubsanMinimalRuntime    = "ubsan_minimal"
+	ubsanStandaloneRuntime = "ubsan_standalone"
+)
+
+// sanitizerRuntimeAvailability lists the sanitizer runtime libraries that are not built for every
+// OS, with a function returning whether the runtime library is built for a given OS.
+var sanitizerRuntimeAvailability = map[string]func(os android.OsType) bool{
+	ubsanMinimalRuntime:    android.OsType.Linux,
+	ubsanStandaloneRuntime: android.OsType.Linux,
+}
+
+// sanitizerMinSdkVersions lists the sanitizers that can only run on devices from a given API level
+// on, e.g. because older releases don't ship their runtime library, keyed by the sanitizer names
+// used in SANITIZE_TARGET.
+var sanitizerMinSdkVersions = map[string]string{
+	"hwaddress":   "29",
+	"memtag_heap": "31",
+}
+
+// sanitizerMinSdkVersion returns the oldest API level the given sanitizer can run on, or false if
+// it can run on every API level.
+func sanitizerMinSdkVersion(ctx android.PathContext, sanitizer string) (android.ApiLevel, bool) {
+	min, ok := sanitizerMinSdkVersions[sanitizer]
+	if !ok {
+		return android.ApiLevel{}, false
+	}
+	return android.ApiLevelOrPanic(ctx, min), true
+}
+
+// sanitizerRuntimeFromSourceSuffix is appended to the name of a sanitizer runtime library module to
+// get the name of its counterpart built from the compiler-rt sources in the tree. The counterparts
+// set stem to the name of the prebuilt, so that their outputs can replace each other.
+const sanitizerRuntimeFromSourceSuffix = ".from_source"
+
+// sanitizerRuntimeModule returns the module to depend on for the sanitizer runtime library module
+// named runtime: its counterpart built from source if the config builds the sanitizer runtimes from
+// source, and runtime otherwise. It returns runtime and an error if the counterpart is required but
+// exists reports that it isn't defined, so that the prebuilt isn't silently used instead.
+func sanitizerRuntimeModule(config android.Config, exists func(name string) bool, runtime string) (string, error) {
+	if !config.SanitizerRuntimesFromSource() {
+		return runtime, nil
+	}
+	fromSource := runtime + sanitizerRuntimeFromSourceSuffix
+	if !exists(fromSource) {
+		return runtime, fmt.Errorf("the sanitizer runtimes are built from source, but the "+
+			"counterpart %q of sanitizer runtime library %q is not defined", fromSource, runtime)
+	}
+	return fromSource, nil
+}
+
+// sanitizerMinSdkLevel returns the oldest API level the module supports, as resolved for the
+// min_sdk_version checks of the apexes containing it, see ShouldSupportSdkVersion, or false if it
+// doesn't declare one.
+func sanitizerMinSdkLevel(ctx BaseModuleContext) (android.ApiLevel, bool) {
+	c, ok := ctx.Module().(*Module)
+	if !ok {
+		return android.ApiLevel{}, false
+	}
+	minSdkVersion := c.effectiveMinSdkVersion()
+	if minSdkVersion == "" || minSdkVersion == "apex_inherit" {
+		return android.ApiLevel{}, false
+	}
+	level, err := android.ApiLevelFromUser(ctx, minSdkVersion)
+	if err != nil {
+		return android.ApiLevel{}, false
+	}
+	return level, true
+}
+
+// sanitizerRuntimeAvailable returns true if the given sanitizer runtime library is built for os.
+func sanitizerRuntimeAvailable(runtime string, os android.OsType) bool {
+	available, ok := sanitizerRuntimeAvailability[runtime]
+	return !ok || available(os)
+}
+
+// SanitizerRuntimeInstallPath returns the path on the device at which the shared runtime library
+// of a sanitizer is installed for a device architecture, e.g. /system/lib64/libclang_rt.asan.so
+// for "address" and arm64. The sanitizer is named like in SANITIZE_TARGET. Returns an empty string
+// if the sanitizer has no shared runtime library, the device has no such architecture, or the
+// runtime library module isn't installed.
+func SanitizerRuntimeInstallPath(ctx android.SingletonContext, sanitizer string, arch android.ArchType) string {
+	spec, ok := sanitizerCheckSpecs[sanitizer]
+	if !ok || spec.runtime == nil || spec.staticRuntime {
+		return ""
+	}
+
+	var target *android.Target
+	for i, t := range ctx.Config().Targets[android.Android] {
+		if t.Arch.ArchType == arch && t.NativeBridge == android.NativeBridgeDisabled {
+			target = &ctx.Config().Targets[android.Android][i]
+			break
+		}
+	}
+	if target == nil {
+		return ""
+	}
+	toolchain := config.FindToolchain(target.Os, target.Arch)
+	if spec.supported != nil && !spec.supported(arch, toolchain) {
+		return ""
+	}
+	runtime := spec.runtime(toolchain)
+	if override, ok := ctx.Config().SanitizerRuntimeOverride(sanitizer, arch.Name); ok {
+		runtime = override
+	}
+	// Index the modules in a single pass, the lookups below would otherwise each visit all of them.
+	names := make(map[string]bool)
+	modulesByBaseName := make(map[string][]*Module)
+	ctx.VisitAllModules(func(m android.Module) {
+		names[ctx.ModuleName(m)] = true
+		if c, ok := m.(*Module); ok {
+			modulesByBaseName[c.BaseModuleName()] = append(modulesByBaseName[c.BaseModuleName()], c)
+		}
+	})
+
+	// A missing counterpart built from source is reported by the modules that depend on it.
+	runtime, _ = sanitizerRuntimeModule(ctx.Config(), func(name string) bool {
+		return names[name]
+	}, runtime)
+
+	for _, c := range modulesByBaseName[runtime] {
+		if !c.Enabled() || !android.IsModulePreferred(c) {
+			continue
+		}
+		if c.Os() != android.Android || c.Target().Arch.ArchType != arch ||
+			c.Target().NativeBridge != android.NativeBridgeDisabled {
+			continue
+		}
+		apexInfo := ctx.ModuleProvider(c, android.ApexInfoProvider).(android.ApexInfo)
+		if !c.Shared() || c.UseVndk() || c.InRamdisk() || c.InVendorRamdisk() || c.InRecovery() ||
+			!apexInfo.IsForPlatform() {
+			continue
+		}
+		if installed := c.FilesToInstall(); len(installed) > 0 {
+			return android.InstallPathToOnDevicePath(ctx, installed[0])
+		}
+	}
+	return ""
+}
+
 func enableMinimalRuntime(sanitize *sanitize) bool {
-	if !Bool(sanitize.Properties.Sanitize.Address) &&
+	if !sanitize.ubsanStandaloneRuntime() &&
+		!Bool(sanitize.Properties.Sanitize.Address) &&
 		!Bool(sanitize.Properties.Sanitize.Hwaddress) &&
 		!Bool(sanitize.Properties.Sanitize.Fuzzer) &&
 
@@ -1568,8 +3133,17 @@ func (m *Module) MinimalRuntimeNeeded() bool {
 	return enableMinimalRuntime(m.sanitize)
 }
 
+// ubsanStandaloneRuntime returns true if sanitize.undefined_runtime selects the standalone UBSan
+// runtime for the undefined behavior checks enabled by the module.
+func (sanitize *sanitize) ubsanStandaloneRuntime() bool {
+	s := &sanitize.Properties.Sanitize
+	return String(s.Undefined_runtime) == "standalone" &&
+		(Bool(s.Integer_overflow) || Bool(s.Undefined) || Bool(s.All_undefined) || len(s.Misc_undefined) > 0)
+}
+
 func enableUbsanRuntime(sanitize *sanitize) bool {
-	return Bool(sanitize.Properties.Sanitize.Diag.Integer_overflow) ||
+	return sanitize.ubsanStandaloneRuntime() ||
+		Bool(sanitize.Properties.Sanitize.Diag.Integer_overflow) ||
 		Bool(sanitize.Properties.Sanitize.Diag.Undefined) ||
 		len(sanitize.Properties.Sanitize.Diag.Misc_undefined) > 0
 }
@@ -0,0 +1,127 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("cc_sanitize_defaults_dir", SanitizeDefaultsDirFactory)
+}
+
+type sanitizeDefaultsDirProperties struct {
+	// The sanitize properties applied to all cc modules in this directory and its subdirectories.
+	// They take precedence over the sanitizers enabled by product variables, e.g. SANITIZE_TARGET,
+	// and over the defaults of enclosing directories, but not over the module's own properties.
+	Sanitize SanitizeUserProps
+}
+
+// sanitizeDefaultsDir is a cc_sanitize_defaults_dir module. It only records its properties for the
+// directory it is defined in, and has no build actions.
+type sanitizeDefaultsDir struct {
+	android.ModuleBase
+
+	properties sanitizeDefaultsDirProperties
+}
+
+func (d *sanitizeDefaultsDir) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	// Nothing to do.
+}
+
+// cc_sanitize_defaults_dir sets the default sanitize properties of all cc modules in the directory
+// containing it and its subdirectories, e.g. to enable integer_overflow for a whole subtree. Only
+// one may be defined per directory.
+func SanitizeDefaultsDirFactory() android.Module {
+	module := &sanitizeDefaultsDir{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidModule(module)
+
+	// Record the properties while parsing, so that they are available to the cc modules in the
+	// directory and its subdirectories when their sanitize properties are resolved.
+	android.AddLoadHook(module, func(ctx android.LoadHookContext) {
+		dirs := sanitizeDefaultsDirsForConfig(ctx.Config())
+		dirs.lock.Lock()
+		defer dirs.lock.Unlock()
+		if existing, ok := dirs.dirs[ctx.ModuleDir()]; ok {
+			ctx.ModuleErrorf("directory %q already has sanitize defaults defined by %q",
+				ctx.ModuleDir(), existing.name)
+			return
+		}
+		dirs.dirs[ctx.ModuleDir()] = sanitizeDefaultsDirEntry{
+			name:       ctx.ModuleName(),
+			properties: &module.properties.Sanitize,
+		}
+	})
+
+	return module
+}
+
+type sanitizeDefaultsDirEntry struct {
+	name       string
+	properties *SanitizeUserProps
+}
+
+// sanitizeDefaultsDirs maps directories to the sanitize defaults defined in them.
+type sanitizeDefaultsDirs struct {
+	lock sync.Mutex
+	dirs map[string]sanitizeDefaultsDirEntry
+}
+
+var sanitizeDefaultsDirsKey = android.NewOnceKey("sanitizeDefaultsDirs")
+
+func sanitizeDefaultsDirsForConfig(config android.Config) *sanitizeDefaultsDirs {
+	return config.Once(sanitizeDefaultsDirsKey, func() interface{} {
+		return &sanitizeDefaultsDirs{dirs: make(map[string]sanitizeDefaultsDirEntry)}
+	}).(*sanitizeDefaultsDirs)
+}
+
+// sanitizeDefaultsForDir returns the sanitize defaults that apply to modules in dir, ordered from
+// the innermost directory to the outermost.
+func sanitizeDefaultsForDir(config android.Config, dir string) []*SanitizeUserProps {
+	dirs := sanitizeDefaultsDirsForConfig(config)
+	dirs.lock.Lock()
+	defer dirs.lock.Unlock()
+
+	var defaults []*SanitizeUserProps
+	for {
+		if entry, ok := dirs.dirs[dir]; ok {
+			defaults = append(defaults, entry.properties)
+		}
+		if dir == "." || dir == "/" {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	return defaults
+}
+
+// applyDirectoryDefaults fills in the sanitize properties that the module doesn't set from the
+// cc_sanitize_defaults_dir modules of its directory and the enclosing directories. Lists are
+// concatenated, with the values of outer directories first.
+func (sanitize *sanitize) applyDirectoryDefaults(ctx BaseModuleContext) {
+	for _, defaults := range sanitizeDefaultsForDir(ctx.Config(), ctx.ModuleDir()) {
+		err := proptools.PrependProperties(&sanitize.Properties.Sanitize, defaults, nil)
+		if err != nil {
+			ctx.ModuleErrorf("error trying to apply directory sanitize defaults: %s", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestSanitizeDefaultsDir(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_root",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureAddFile("outer/Android.bp", []byte(`
+			cc_sanitize_defaults_dir {
+				name: "outer_sanitize_defaults",
+				sanitize: {
+					integer_overflow: false,
+					misc_undefined: ["bounds"],
+				},
+			}
+
+			cc_binary {
+				name: "bin_outer",
+				srcs: ["foo.c"],
+			}
+		`)),
+		android.FixtureAddFile("outer/inner/Android.bp", []byte(`
+			cc_sanitize_defaults_dir {
+				name: "inner_sanitize_defaults",
+				sanitize: {
+					integer_overflow: true,
+					misc_undefined: ["alignment"],
+				},
+			}
+
+			cc_binary {
+				name: "bin_inner",
+				srcs: ["foo.c"],
+			}
+
+			cc_binary {
+				name: "bin_inner_no_integer_overflow",
+				srcs: ["foo.c"],
+				sanitize: {
+					integer_overflow: false,
+				},
+			}
+
+			cc_binary {
+				name: "bin_inner_never",
+				srcs: ["foo.c"],
+				sanitize: {
+					never: true,
+				},
+			}
+		`)),
+		android.FixtureAddFile("outer/foo.c", nil),
+		android.FixtureAddFile("outer/inner/foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"integer_overflow"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	sanitizers := func(name string) []string {
+		module := result.ModuleForTests(name, variant).Module()
+		return result.ModuleProvider(module, SanitizeInfoProvider).(SanitizeInfo).Sanitizers
+	}
+	miscUndefined := func(name string) []string {
+		module := result.ModuleForTests(name, variant).Module().(*Module)
+		return module.sanitize.Properties.Sanitize.Misc_undefined
+	}
+
+	// Modules outside of any directory with sanitize defaults only get the product variables.
+	android.AssertArrayString(t, "bin_root sanitizers", []string{"integer_overflow"}, sanitizers("bin_root"))
+
+	// The outer directory defaults override the product variables.
+	android.AssertArrayString(t, "bin_outer sanitizers", []string{"undefined"}, sanitizers("bin_outer"))
+	android.AssertArrayString(t, "bin_outer misc_undefined", []string{"bounds"}, miscUndefined("bin_outer"))
+
+	// The inner directory defaults override the outer ones, and lists are concatenated.
+	android.AssertArrayString(t, "bin_inner sanitizers", []string{"undefined", "integer_overflow"},
+		sanitizers("bin_inner"))
+	android.AssertArrayString(t, "bin_inner misc_undefined", []string{"bounds", "alignment"},
+		miscUndefined("bin_inner"))
+
+	// Module properties override all directory defaults.
+	android.AssertArrayString(t, "bin_inner_no_integer_overflow sanitizers", []string{"undefined"},
+		sanitizers("bin_inner_no_integer_overflow"))
+	android.AssertIntEquals(t, "bin_inner_never sanitizers", 0, len(sanitizers("bin_inner_never")))
+
+	// Sanitizers enabled by directory defaults aren't the module's own.
+	bin := result.ModuleForTests("bin_inner", variant).Module().(*Module)
+	android.AssertIntEquals(t, "bin_inner own sanitizers", 0, len(bin.sanitize.Properties.OwnSanitizers))
+	android.AssertArrayString(t, "bin_inner directory default sanitizers", []string{"undefined", "integer_overflow"},
+		bin.sanitize.Properties.DirectoryDefaultSanitizers)
+
+	t.Run("duplicate", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("dir/Android.bp", []byte(`
+				cc_sanitize_defaults_dir {
+					name: "first",
+				}
+
+				cc_sanitize_defaults_dir {
+					name: "second",
+				}
+			`)),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`directory "dir" already has sanitize defaults defined by "first"`,
+		)).RunTest(t)
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+
+	"android/soong/android"
+)
+
+// sanitizerPropagation describes how a sanitizer enabled on a module is propagated to its
+// dependencies by sanitizerDepsMutator, and how sanitizerMutator splits the modules it reaches.
+// A sanitizer that has a sanitizerPropagation in sanitizerPropagations gets both mutators.
+type sanitizerPropagation struct {
+	// Only statically linked dependencies that support the sanitizer get the sanitized variant.
+	// Shared libraries are still walked through to reach their own static dependencies.
+	staticDepsOnly bool
+
+	// Shared libraries are split into a variant built without the sanitizer and a sanitized
+	// variant like static libraries, because a library built with the sanitizer can't be linked
+	// from a library built without it.
+	splitShared bool
+
+	// Both variants of split modules are exported to Make, as the sanitized variant has a
+	// different suffix in name. Otherwise the variant that doesn't match the sanitize properties of
+	// the module is hidden from Make and not installed.
+	exportBothVariants bool
+
+	// Vendor static libraries captured by the vendor snapshot get both variants even if nothing
+	// depends on the sanitized one, and their sanitized variants are always built with the
+	// sanitizer, see needsSanitizerForVendorSnapshot.
+	vendorSnapshotVariants bool
+
+	// The sanitized variants of shared libraries are installed in the sanitizer directory of the
+	// device, e.g. /data/asan.
+	installInSanitizerDir bool
+
+	// The config-wide list of the static libraries that have a sanitized variant, exported to Make,
+	// or nil if Make doesn't need it.
+	staticLibs func(config android.Config) *sanitizerStaticLibsMap
+}
+
+// sanitizerPropagations describes the sanitizers that are propagated to the dependencies of the
+// modules that enable them. The other sanitizers only affect the modules that enable them.
+var sanitizerPropagations = map[SanitizerType]sanitizerPropagation{
+	Asan: {
+		staticDepsOnly:        true,
+		installInSanitizerDir: true,
+	},
+	Hwasan: {
+		staticDepsOnly:     true,
+		exportBothVariants: true,
+		staticLibs:         hwasanStaticLibs,
+	},
+	tsan: {},
+	scs: {
+		staticDepsOnly:     true,
+		exportBothVariants: true,
+	},
+	Fuzzer: {
+		splitShared: true,
+	},
+	kcfi: {
+		staticDepsOnly: true,
+	},
+	cfi: {
+		staticDepsOnly:         true,
+		exportBothVariants:     true,
+		vendorSnapshotVariants: true,
+		staticLibs:             cfiStaticLibs,
+	},
+}
+
+// propagation returns how the sanitizer is propagated to dependencies, and false if it isn't.
+func (t SanitizerType) propagation() (sanitizerPropagation, bool) {
+	p, ok := sanitizerPropagations[t]
+	return p, ok
+}
+
+// sanitizerDep is what sanitizerPropagation.marks needs to know about a dependency reached from a
+// module with the sanitizer enabled.
+type sanitizerDep struct {
+	// The dependency has sanitize properties.
+	sanitizable bool
+	// The dependency sets sanitize.never.
+	never bool
+	// The dependency explicitly disables the sanitizer.
+	explicitlyDisabled bool
+	// The dependency supports the sanitizer.
+	supported bool
+	// The dependency is a static library or a static executable.
+	staticallyLinked bool
+	// The dependency is a header library.
+	header bool
+}
+
+func sanitizerDepFor(d PlatformSanitizeable, t SanitizerType) sanitizerDep {
+	return sanitizerDep{
+		sanitizable:        d.SanitizePropDefined(),
+		never:              d.SanitizePropDefined() && d.SanitizeNever(),
+		explicitlyDisabled: d.SanitizePropDefined() && d.IsSanitizerExplicitlyDisabled(t),
+		supported:          d.SanitizerSupported(t),
+		staticallyLinked:   d.StaticallyLinked(),
+		header:             d.Header(),
+	}
+}
+
+// marks returns true if a dependency reached from a module with the sanitizer enabled gets the
+// sanitized variant. sharedDepsOnly is set if the module sets sanitize.shared_deps_only, so that
+// only its shared libraries are sanitized.
+func (p sanitizerPropagation) marks(dep sanitizerDep, sharedDepsOnly bool) bool {
+	if !dep.sanitizable || dep.never || dep.explicitlyDisabled {
+		return false
+	}
+	if sharedDepsOnly {
+		// Static and header libraries keep using their base variants.
+		return !dep.staticallyLinked && !dep.header && dep.supported
+	}
+	if p.staticDepsOnly {
+		// Rust does not support some of these sanitizers, so we need to check if it's supported
+		// before marking the dependency.
+		return dep.staticallyLinked && dep.supported
+	}
+	return true
+}
+
+// markSanitizerDeps marks the transitive sanitizable dependencies of a module with the sanitizer
+// enabled that get the sanitized variant, which sanitizerMutator then creates.
+func markSanitizerDeps(mctx android.TopDownMutatorContext, c PlatformSanitizeable, t SanitizerType,
+	p sanitizerPropagation) {
+
+	isSanitizableDependencyTag := c.SanitizableDepTagChecker()
+	sharedDepsOnly := c.SanitizeSharedDepsOnly()
+	mctx.WalkDeps(func(child, parent android.Module) bool {
+		if !isSanitizableDependencyTag(mctx.OtherModuleDependencyTag(child)) {
+			return false
+		}
+		if d, ok := child.(PlatformSanitizeable); ok && p.marks(sanitizerDepFor(d, t), sharedDepsOnly) {
+			d.SetSanitizeDep(true)
+		}
+		return true
+	})
+}
+
+// sanitizerVariationDescription returns a description of a variation of a sanitizer mutator for
+// error messages.
+func sanitizerVariationDescription(variation string) string {
+	if variation == "" {
+		return "unsanitized"
+	}
+	return fmt.Sprintf("%q", variation)
+}
+
+// sanitizerRequestReason returns why the module requests the sanitized variants of its
+// dependencies, for error messages.
+func sanitizerRequestReason(c PlatformSanitizeable, t SanitizerType) string {
+	if !c.IsSanitizerEnabled(t) {
+		return fmt.Sprintf("it is a dependency of a module with %s enabled", t.name())
+	}
+	if m, ok := c.(*Module); ok && m.sanitize != nil {
+		if inList(t.name(), m.sanitize.Properties.OwnSanitizers) {
+			return fmt.Sprintf("its sanitize properties enable %s", t.name())
+		}
+		if inList(t.name(), m.sanitize.Properties.DirectoryDefaultSanitizers) {
+			return fmt.Sprintf("the cc_sanitize_defaults_dir of its directory enables %s", t.name())
+		}
+	}
+	return fmt.Sprintf("the global sanitizer configuration, e.g. SANITIZE_TARGET or SANITIZE_HOST, "+
+		"enables %s for it", t.name())
+}
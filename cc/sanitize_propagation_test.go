@@ -0,0 +1,77 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestSanitizerPropagationMarks(t *testing.T) {
+	staticLib := sanitizerDep{sanitizable: true, supported: true, staticallyLinked: true}
+	sharedLib := sanitizerDep{sanitizable: true, supported: true}
+	headerLib := sanitizerDep{sanitizable: true, supported: true, header: true}
+
+	unsupportedStaticLib := staticLib
+	unsupportedStaticLib.supported = false
+	neverStaticLib := staticLib
+	neverStaticLib.never = true
+	disabledSharedLib := sharedLib
+	disabledSharedLib.explicitlyDisabled = true
+
+	testCases := []struct {
+		name           string
+		propagation    sanitizerPropagation
+		dep            sanitizerDep
+		sharedDepsOnly bool
+		expected       bool
+	}{
+		{"all deps: static", sanitizerPropagation{}, staticLib, false, true},
+		{"all deps: shared", sanitizerPropagation{}, sharedLib, false, true},
+		{"all deps: header", sanitizerPropagation{}, headerLib, false, true},
+		{"all deps: unsupported", sanitizerPropagation{}, unsupportedStaticLib, false, true},
+		{"all deps: not sanitizable", sanitizerPropagation{}, sanitizerDep{}, false, false},
+		{"all deps: never", sanitizerPropagation{}, neverStaticLib, false, false},
+		{"all deps: explicitly disabled", sanitizerPropagation{}, disabledSharedLib, false, false},
+
+		{"static deps: static", sanitizerPropagation{staticDepsOnly: true}, staticLib, false, true},
+		{"static deps: shared", sanitizerPropagation{staticDepsOnly: true}, sharedLib, false, false},
+		{"static deps: header", sanitizerPropagation{staticDepsOnly: true}, headerLib, false, false},
+		{"static deps: unsupported", sanitizerPropagation{staticDepsOnly: true}, unsupportedStaticLib, false, false},
+		{"static deps: never", sanitizerPropagation{staticDepsOnly: true}, neverStaticLib, false, false},
+
+		{"shared_deps_only: static", sanitizerPropagation{}, staticLib, true, false},
+		{"shared_deps_only: shared", sanitizerPropagation{}, sharedLib, true, true},
+		{"shared_deps_only: header", sanitizerPropagation{}, headerLib, true, false},
+		{"shared_deps_only: explicitly disabled", sanitizerPropagation{}, disabledSharedLib, true, false},
+		{"shared_deps_only overrides static deps", sanitizerPropagation{staticDepsOnly: true}, sharedLib, true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			android.AssertBoolEquals(t, "marks", tc.expected, tc.propagation.marks(tc.dep, tc.sharedDepsOnly))
+		})
+	}
+}
+
+func TestSanitizerPropagations(t *testing.T) {
+	for _, sanitizer := range Sanitizers {
+		_, propagated := sanitizer.propagation()
+		expected := sanitizer != intOverflow && sanitizer != Memtag_heap
+		android.AssertBoolEquals(t, sanitizer.name()+" propagated", expected, propagated)
+	}
+	android.AssertIntEquals(t, "number of propagated sanitizers", 7, len(sanitizerPropagations))
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"path/filepath"
+	"strings"
+
+	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+func init() {
+	android.RegisterSingletonType("sanitize_target_build", sanitizeTargetBuildFactory)
+}
+
+func sanitizeTargetBuildFactory() android.Singleton {
+	return &sanitizeTargetBuildSingleton{}
+}
+
+// sanitizeTargetBuildSingleton creates the sanitize_target_build phony target for SANITIZE_TARGET
+// builds. It builds the outputs of the sanitized variants of all device modules and the sanitizer
+// runtime libraries, and an archive of the files installed under /data/asan, and dists the runtime
+// libraries and the archive.
+type sanitizeTargetBuildSingleton struct {
+	// The outputs of the device sanitizer runtime libraries.
+	runtimes android.Paths
+	// The archive of the files installed under /data/asan.
+	dataArchive android.Path
+}
+
+func (s *sanitizeTargetBuildSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if len(ctx.Config().SanitizeDevice()) == 0 {
+		return
+	}
+
+	var outputs, runtimes, dataFiles android.Paths
+	// The directory the paths of the files installed under /data/asan are relative to in the
+	// archive, i.e. the product out directory.
+	var dataDir string
+
+	ctx.VisitAllModules(func(module android.Module) {
+		c, ok := module.(*Module)
+		if !ok || !c.Enabled() || c.Os().Class != android.Device || !c.outputFile.Valid() {
+			return
+		}
+
+		toolchain := config.FindToolchain(c.Os(), c.Arch())
+		if inList(ctx.ModuleName(c), sanitizerRuntimeLibraries(toolchain)) {
+			runtimes = append(runtimes, c.outputFile.Path())
+		} else if c.IsSanitizerVariant() {
+			outputs = append(outputs, c.outputFile.Path())
+		}
+
+		if c.InstallInSanitizerDir() && !c.IsSkipInstall() {
+			for _, installed := range c.FilesToInstall() {
+				dataDir = filepath.Clean(strings.TrimSuffix(installed.PartitionDir(), installed.Partition()))
+				dataFiles = append(dataFiles, installed)
+			}
+		}
+	})
+
+	s.runtimes = android.SortedUniquePaths(runtimes)
+
+	deps := append(android.SortedUniquePaths(outputs), s.runtimes...)
+	if len(dataFiles) > 0 {
+		archive := android.PathForOutput(ctx, "sanitize_target_build", "asan_data.zip")
+		rspFile := android.PathForOutput(ctx, "sanitize_target_build", "asan_data.rsp")
+		builder := android.NewRuleBuilder(pctx, ctx)
+		builder.Command().
+			BuiltTool("soong_zip").
+			FlagWithOutput("-o ", archive).
+			FlagWithArg("-C ", dataDir).
+			FlagWithRspFileInputList("-r ", rspFile, android.SortedUniquePaths(dataFiles))
+		builder.Build("sanitize_target_build_asan_data", "asan data archive")
+		s.dataArchive = archive
+		deps = append(deps, archive)
+	}
+
+	ctx.Phony("sanitize_target_build", deps...)
+}
+
+func (s *sanitizeTargetBuildSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if len(s.runtimes) > 0 {
+		ctx.DistForGoal("sanitize_target_build", s.runtimes...)
+	}
+	if s.dataArchive != nil {
+		ctx.DistForGoal("sanitize_target_build", s.dataArchive)
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*sanitizeTargetBuildSingleton)(nil)
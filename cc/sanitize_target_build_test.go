@@ -0,0 +1,100 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestSanitizeTargetBuild(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			host_supported: true,
+			shared_libs: ["libfoo"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			host_supported: true,
+		}
+
+		cc_binary {
+			name: "bin_never",
+			srcs: ["foo.c"],
+			sanitize: {
+				never: true,
+			},
+		}
+	`
+
+	prepare := func(sanitizeDevice []string) android.FixturePreparer {
+		return android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+			android.FixtureAddFile("asan/Android.bp", []byte(`
+				cc_prebuilt_library_shared {
+					name: "libclang_rt.asan",
+					defaults: ["toolchain_libs_defaults"],
+				}
+			`)),
+			android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+				variables.SanitizeDevice = sanitizeDevice
+			}),
+			android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+				ctx.RegisterSingletonType("sanitize_target_build", sanitizeTargetBuildFactory)
+			}),
+			android.PrepareForTestWithPhony,
+		)
+	}
+
+	outputFile := func(result *android.TestResult, name, variant string) string {
+		return result.ModuleForTests(name, variant).Module().(*Module).outputFile.Path().String()
+	}
+
+	result := prepare([]string{"address"}).RunTestWithBp(t, bp)
+
+	phony := result.SingletonForTests("phony").Output("sanitize_target_build")
+	inputs := phony.Implicits.Strings()
+	android.AssertStringListContains(t, "phony inputs", inputs,
+		outputFile(result, "bin", "android_arm64_armv8-a_asan"))
+	android.AssertStringListContains(t, "phony inputs", inputs,
+		outputFile(result, "libfoo", "android_arm64_armv8-a_shared_asan"))
+	android.AssertStringListDoesNotContain(t, "phony inputs", inputs,
+		outputFile(result, "bin_never", "android_arm64_armv8-a"))
+	android.AssertStringListDoesNotContain(t, "phony inputs", inputs,
+		outputFile(result, "bin", result.Config.BuildOSTarget.String()))
+	android.AssertStringListContains(t, "phony inputs", inputs,
+		outputFile(result, "libclang_rt.asan", "android_arm64_armv8-a_shared"))
+	android.AssertStringListContains(t, "phony inputs", inputs,
+		"out/soong/sanitize_target_build/asan_data.zip")
+
+	archive := result.SingletonForTests("sanitize_target_build").Output("out/soong/sanitize_target_build/asan_data.zip")
+	android.AssertStringDoesContain(t, "asan data archive", archive.RuleParams.Command,
+		"-C out/target/product/test_device -r out/soong/sanitize_target_build/asan_data.rsp")
+	android.AssertStringListContains(t, "asan data archive inputs", archive.Inputs.Strings(),
+		"out/target/product/test_device/data/asan/system/lib64/libfoo.so")
+
+	t.Run("disabled", func(t *testing.T) {
+		result := prepare(nil).RunTestWithBp(t, bp)
+		if result.SingletonForTests("phony").MaybeOutput("sanitize_target_build").Rule != nil {
+			t.Errorf("expected no sanitize_target_build phony without SANITIZE_TARGET")
+		}
+	})
+}
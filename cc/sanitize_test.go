@@ -16,9 +16,14 @@ package cc
 
 import (
 	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 
+	"github.com/google/blueprint"
+
 	"android/soong/android"
 )
 
@@ -26,8 +31,44 @@ var prepareForAsanTest = android.FixtureAddFile("asan/Android.bp", []byte(`
 	cc_library_shared {
 		name: "libclang_rt.asan",
 	}
+
+	cc_library_static {
+		name: "libclang_rt.asan_musl_static",
+		host_supported: true,
+		device_supported: false,
+		enabled: false,
+		target: {
+			musl: {
+				enabled: true,
+			},
+		},
+		nocrt: true,
+		stl: "none",
+		system_shared_libs: [],
+		sanitize: {
+			never: true,
+		},
+	}
 `))
 
+// expectNoRuntimeLinkDep verifies that the from module neither depends on nor links against the
+// sanitizer runtime library named runtimeName, as a shared or a static library.
+func expectNoRuntimeLinkDep(t *testing.T, ctx *android.TestContext, from android.TestingModule, runtimeName string) {
+	t.Helper()
+	ctx.VisitDirectDeps(from.Module(), func(m blueprint.Module) {
+		if ctx.ModuleName(m) == runtimeName {
+			t.Errorf("%s should not depend on %s", from.Module(), runtimeName)
+		}
+	})
+
+	fromLink := from.Description("link")
+	for _, dep := range append(fromLink.Implicits.Strings(), fromLink.OrderOnly.Strings()...) {
+		if base := filepath.Base(dep); strings.TrimSuffix(base, filepath.Ext(base)) == runtimeName {
+			t.Errorf("%s should not link against %s, got %q", from.Module(), runtimeName, dep)
+		}
+	}
+}
+
 func TestAsan(t *testing.T) {
 	bp := `
 		cc_binary {
@@ -152,19 +193,23 @@ func TestAsan(t *testing.T) {
 		}
 
 		// expectInstallDep verifies that the install rule of the from module depends on the
-		// install rule of the to module.
+		// install rule of the to module. Host installs use an implicit dependency, because
+		// installed host tools may be run during the build and must be updated when the libraries
+		// they load change. Device installs only need the libraries installed alongside, so they
+		// use an order-only dependency.
 		expectInstallDep := func(from, to android.TestingModule) {
 			t.Helper()
 			fromInstalled := from.Description("install")
 			toInstalled := to.Description("install")
 
-			// combine implicits and order-only dependencies, host uses implicit but device uses
-			// order-only.
-			got := append(fromInstalled.Implicits.Strings(), fromInstalled.OrderOnly.Strings()...)
+			got, kind := fromInstalled.OrderOnly.Strings(), "order-only"
+			if from.Module().(*Module).Host() {
+				got, kind = fromInstalled.Implicits.Strings(), "implicit"
+			}
 			want := toInstalled.Output.String()
 			if !android.InList(want, got) {
-				t.Errorf("%s installation should depend on %s, expected %q, got %q",
-					from.Module(), to.Module(), want, got)
+				t.Errorf("%s installation should have an %s dependency on %s, expected %q, got %q",
+					from.Module(), kind, to.Module(), want, got)
 			}
 		}
 
@@ -195,359 +240,3801 @@ func TestAsan(t *testing.T) {
 		expectInstallDep(binNoAsan, libTransitive)
 		expectInstallDep(libShared, libTransitive)
 		expectInstallDep(libAsan, libTransitive)
+
+		expectNoRuntimeLinkDep(t, result.TestContext, binNoAsan, "libclang_rt.asan")
 	}
 
 	t.Run("host", func(t *testing.T) { check(t, result, result.Config.BuildOSTarget.String()) })
 	t.Run("device", func(t *testing.T) { check(t, result, "android_arm64_armv8-a") })
 }
 
-type MemtagNoteType int
+func TestAsanLibraryBothLinkages(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_shared",
+			srcs: ["foo.c"],
+			shared_libs: ["libboth"],
+		}
 
-const (
-	None MemtagNoteType = iota + 1
-	Sync
-	Async
-)
+		cc_binary {
+			name: "bin_static_asan",
+			srcs: ["foo.c"],
+			static_libs: ["libboth"],
+			sanitize: {
+				address: true,
+			},
+		}
 
-func (t MemtagNoteType) str() string {
-	switch t {
-	case None:
-		return "none"
-	case Sync:
-		return "sync"
-	case Async:
-		return "async"
-	default:
-		panic("type_note_invalid")
+		cc_binary {
+			name: "bin_static",
+			srcs: ["foo.c"],
+			static_libs: ["libboth"],
+		}
+
+		cc_library {
+			name: "libboth",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	// The shared library is only built with asan, and reuses the objects of the static asan variant.
+	sharedAsan := result.ModuleForTests("libboth", variant+"_shared_asan")
+	staticAsan := result.ModuleForTests("libboth", variant+"_static_asan")
+	android.AssertStringDoesContain(t, "libboth static asan cflags", staticAsan.Rule("cc").Args["cFlags"],
+		"-fsanitize=address")
+	if sharedAsan.MaybeRule("cc").Rule != nil {
+		t.Errorf("libboth shared asan variant should reuse the objects of the static asan variant")
+	}
+	obj := staticAsan.Rule("cc").Output.String()
+	if inputs := sharedAsan.Description("link").Inputs.Strings(); !android.InList(obj, inputs) {
+		t.Errorf("libboth shared asan variant should link %q, got %q", obj, inputs)
 	}
-}
 
-func checkHasMemtagNote(t *testing.T, m android.TestingModule, expected MemtagNoteType) {
-	t.Helper()
-	note_async := "note_memtag_heap_async"
-	note_sync := "note_memtag_heap_sync"
+	sharedLib := sharedAsan.Description("strip").Output.String()
+	if orderOnly := result.ModuleForTests("bin_shared", variant).Description("link").OrderOnly.Strings(); !android.InList(sharedLib, orderOnly) {
+		t.Errorf("bin_shared should link against %q, got %q", sharedLib, orderOnly)
+	}
 
-	found := None
-	implicits := m.Rule("ld").Implicits
-	for _, lib := range implicits {
-		if strings.Contains(lib.Rel(), note_async) {
-			found = Async
-			break
-		} else if strings.Contains(lib.Rel(), note_sync) {
-			found = Sync
-			break
-		}
+	staticAsanLib := staticAsan.Description("static link").Output.String()
+	if implicits := result.ModuleForTests("bin_static_asan", variant+"_asan").Description("link").Implicits.Strings(); !android.InList(staticAsanLib, implicits) {
+		t.Errorf("bin_static_asan should link against %q, got %q", staticAsanLib, implicits)
 	}
 
-	if found != expected {
-		t.Errorf("Wrong Memtag note in target %q: found %q, expected %q", m.Module().(*Module).Name(), found.str(), expected.str())
+	// Static consumers without asan link the variant built without it.
+	static := result.ModuleForTests("libboth", variant+"_static")
+	android.AssertStringDoesNotContain(t, "libboth static cflags", static.Rule("cc").Args["cFlags"],
+		"-fsanitize=address")
+	staticLib := static.Description("static link").Output.String()
+	if implicits := result.ModuleForTests("bin_static", variant).Description("link").Implicits.Strings(); !android.InList(staticLib, implicits) {
+		t.Errorf("bin_static should link against %q, got %q", staticLib, implicits)
 	}
 }
 
-var prepareForTestWithMemtagHeap = android.GroupFixturePreparers(
-	android.FixtureModifyMockFS(func(fs android.MockFS) {
-		templateBp := `
-		cc_test {
-			name: "unset_test_%[1]s",
-			gtest: false,
+func TestAsanObjs(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_static",
+			srcs: ["foo.c"],
+			static_libs: ["libshim"],
 		}
 
-		cc_test {
-			name: "no_memtag_test_%[1]s",
-			gtest: false,
-			sanitize: { memtag_heap: false },
+		cc_library {
+			name: "libshim",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+				address_objs: ["asan_shim.o"],
+			},
 		}
+	`
 
-		cc_test {
-			name: "set_memtag_test_%[1]s",
-			gtest: false,
-			sanitize: { memtag_heap: true },
-		}
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureAddFile("asan_shim.o", nil),
+	).RunTestWithBp(t, bp)
 
-		cc_test {
-			name: "set_memtag_set_async_test_%[1]s",
-			gtest: false,
-			sanitize: { memtag_heap: true, diag: { memtag_heap: false }  },
-		}
+	variant := "android_arm64_armv8-a"
+	shim := "asan_shim.o"
 
-		cc_test {
-			name: "set_memtag_set_sync_test_%[1]s",
-			gtest: false,
-			sanitize: { memtag_heap: true, diag: { memtag_heap: true }  },
-		}
+	sharedAsan := result.ModuleForTests("libshim", variant+"_shared_asan").Description("link")
+	if inputs := sharedAsan.Inputs.Strings(); !android.InList(shim, inputs) {
+		t.Errorf("libshim shared asan variant should link %q, got %q", shim, inputs)
+	}
+	staticAsan := result.ModuleForTests("libshim", variant+"_static_asan").Description("static link")
+	if inputs := staticAsan.Inputs.Strings(); !android.InList(shim, inputs) {
+		t.Errorf("libshim static asan variant should link %q, got %q", shim, inputs)
+	}
 
-		cc_test {
-			name: "unset_memtag_set_sync_test_%[1]s",
-			gtest: false,
-			sanitize: { diag: { memtag_heap: true }  },
+	static := result.ModuleForTests("libshim", variant+"_static").Description("static link")
+	if inputs := static.Inputs.Strings(); android.InList(shim, inputs) {
+		t.Errorf("libshim static variant should not link %q, got %q", shim, inputs)
+	}
+}
+
+func TestSanitizeApplyTo(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libstatic_only",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+				apply_to: ["static"],
+			},
 		}
 
-		cc_binary {
-			name: "unset_binary_%[1]s",
+		cc_library {
+			name: "libshared_only",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+				apply_to: ["shared"],
+			},
 		}
 
 		cc_binary {
-			name: "no_memtag_binary_%[1]s",
-			sanitize: { memtag_heap: false },
+			name: "bin_static_asan",
+			srcs: ["foo.c"],
+			static_libs: ["libshared_only"],
+			sanitize: {
+				address: true,
+			},
 		}
+	`
 
-		cc_binary {
-			name: "set_memtag_binary_%[1]s",
-			sanitize: { memtag_heap: true },
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	cflags := func(module, variant string) string {
+		return result.ModuleForTests(module, variant).Rule("cc").Args["cFlags"]
+	}
+
+	// Only the static variant of libstatic_only is built with asan.
+	android.AssertStringDoesContain(t, "libstatic_only static asan cflags",
+		cflags("libstatic_only", variant+"_static_asan"), "-fsanitize=address")
+	android.AssertStringDoesNotContain(t, "libstatic_only shared cflags",
+		cflags("libstatic_only", variant+"_shared"), "-fsanitize=address")
+	android.AssertStringListDoesNotContain(t, "libstatic_only variants",
+		result.ModuleVariantsForTests("libstatic_only"), variant+"_shared_asan")
+
+	// Only the shared variant of libshared_only is built with asan, but its static variant is still
+	// sanitized for the asan binary linking it.
+	android.AssertStringDoesContain(t, "libshared_only shared asan cflags",
+		cflags("libshared_only", variant+"_shared_asan"), "-fsanitize=address")
+	android.AssertStringDoesNotContain(t, "libshared_only static cflags",
+		cflags("libshared_only", variant+"_static"), "-fsanitize=address")
+	android.AssertStringDoesContain(t, "libshared_only static asan cflags",
+		cflags("libshared_only", variant+"_static_asan"), "-fsanitize=address")
+}
+
+func TestSanitizeApplyToUnsetsAllSanitizers(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libcfi_static",
+			srcs: ["foo.c"],
+			sanitize: {
+				cfi: true,
+				diag: {
+					cfi: true,
+					memtag_heap: true,
+				},
+				apply_to: ["static"],
+			},
 		}
 
-		cc_binary {
-			name: "set_memtag_set_async_binary_%[1]s",
-			sanitize: { memtag_heap: true, diag: { memtag_heap: false }  },
+		cc_library {
+			name: "libno_sanitizers",
+			srcs: ["foo.c"],
+			sanitize: {
+				apply_to: ["static"],
+			},
 		}
+	`
 
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	module := func(name, variant string) *Module {
+		return result.ModuleForTests(name, variant).Module().(*Module)
+	}
+
+	// The shared variant of libcfi_static has neither the sanitizers nor the diagnostic modes of
+	// the properties.
+	shared := module("libcfi_static", variant+"_shared")
+	android.AssertBoolEquals(t, "libcfi_static shared cfi", false, shared.sanitize.isSanitizerEnabled(cfi))
+	android.AssertBoolEquals(t, "libcfi_static shared diag.cfi", false,
+		Bool(shared.sanitize.Properties.Sanitize.Diag.Cfi))
+	android.AssertBoolEquals(t, "libcfi_static shared diag.memtag_heap", false,
+		Bool(shared.sanitize.Properties.Sanitize.Diag.Memtag_heap))
+	android.AssertStringDoesNotContain(t, "libcfi_static shared cflags",
+		result.ModuleForTests("libcfi_static", variant+"_shared").Rule("cc").Args["cFlags"], "-fsanitize=cfi")
+
+	// The halves of libcfi_static are built with different sanitizers, so the shared variant
+	// compiles its own sources. libno_sanitizers enables no sanitizers, so its shared variant still
+	// reuses the objects of the static variant.
+	reusesObjects := func(name string) bool {
+		compiler := module(name, variant+"_shared").compiler.(*libraryDecorator)
+		return len(compiler.baseCompiler.Properties.OriginalSrcs) > 0
+	}
+	android.AssertBoolEquals(t, "libcfi_static reuses static objects", false, reusesObjects("libcfi_static"))
+	android.AssertBoolEquals(t, "libno_sanitizers reuses static objects", true, reusesObjects("libno_sanitizers"))
+}
+
+func TestSanitizeApplyToErrors(t *testing.T) {
+	t.Run("unknown linkage", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.apply_to: unknown linkage "both", must be "static" or "shared"`)).
+			RunTestWithBp(t, `
+				cc_library {
+					name: "libfoo",
+					sanitize: {
+						address: true,
+						apply_to: ["both"],
+					},
+				}
+			`)
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.apply_to: is only supported on libraries`)).
+			RunTestWithBp(t, `
+				cc_binary {
+					name: "bin",
+					sanitize: {
+						address: true,
+						apply_to: ["static"],
+					},
+				}
+			`)
+	})
+
+	t.Run("linkage not built", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.apply_to: none of the linkages \["shared"\] are built for this module`)).
+			RunTestWithBp(t, `
+				cc_library_static {
+					name: "libstatic",
+					sanitize: {
+						address: true,
+						apply_to: ["shared"],
+					},
+				}
+			`)
+	})
+}
+
+func TestAsanNoRuntimeInstall(t *testing.T) {
+	bp := `
 		cc_binary {
-			name: "set_memtag_set_sync_binary_%[1]s",
-			sanitize: { memtag_heap: true, diag: { memtag_heap: true }  },
+			name: "bin_with_asan",
+			sanitize: {
+				address: true,
+			},
 		}
 
 		cc_binary {
-			name: "unset_memtag_set_sync_binary_%[1]s",
-			sanitize: { diag: { memtag_heap: true }  },
+			name: "bin_no_runtime_install",
+			sanitize: {
+				address: true,
+				no_runtime_install: true,
+			},
 		}
-		`
-		subdirNoOverrideBp := fmt.Sprintf(templateBp, "no_override")
-		subdirOverrideDefaultDisableBp := fmt.Sprintf(templateBp, "override_default_disable")
-		subdirSyncBp := fmt.Sprintf(templateBp, "override_default_sync")
-		subdirAsyncBp := fmt.Sprintf(templateBp, "override_default_async")
-
-		fs.Merge(android.MockFS{
-			"subdir_no_override/Android.bp":              []byte(subdirNoOverrideBp),
-			"subdir_override_default_disable/Android.bp": []byte(subdirOverrideDefaultDisableBp),
-			"subdir_sync/Android.bp":                     []byte(subdirSyncBp),
-			"subdir_async/Android.bp":                    []byte(subdirAsyncBp),
-		})
-	}),
-	android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
-		variables.MemtagHeapExcludePaths = []string{"subdir_override_default_disable"}
-		// "subdir_override_default_disable" is covered by both include and override_default_disable paths. override_default_disable wins.
-		variables.MemtagHeapSyncIncludePaths = []string{"subdir_sync", "subdir_override_default_disable"}
-		variables.MemtagHeapAsyncIncludePaths = []string{"subdir_async", "subdir_override_default_disable"}
-	}),
-)
-
-func TestSanitizeMemtagHeap(t *testing.T) {
-	variant := "android_arm64_armv8-a"
+	`
 
 	result := android.GroupFixturePreparers(
 		prepareForCcTest,
-		prepareForTestWithMemtagHeap,
-	).RunTest(t)
-	ctx := result.TestContext
+		prepareForAsanTest,
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	runtime := result.ModuleForTests("libclang_rt.asan", variant+"_shared")
+	runtimeLinked := runtime.Description("strip").Output.String()
+	runtimeInstalled := runtime.Description("install").Output.String()
+
+	for _, tc := range []struct {
+		name           string
+		installDepWant bool
+	}{
+		{name: "bin_with_asan", installDepWant: true},
+		{name: "bin_no_runtime_install", installDepWant: false},
+	} {
+		bin := result.ModuleForTests(tc.name, variant+"_asan")
+
+		linkDeps := bin.Description("link").OrderOnly.Strings()
+		if !android.InList(runtimeLinked, linkDeps) {
+			t.Errorf("%s should link against %q, got %q", tc.name, runtimeLinked, linkDeps)
+		}
 
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_no_override", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_override_default_async", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_override_default_sync", variant), None)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_no_override", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_override_default_async", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_override_default_sync", variant), None)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_no_override", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_override_default_disable", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_no_override", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_override_default_disable", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_override_default_sync", variant), Async)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_no_override", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_override_default_disable", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_override_default_sync", variant), Async)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_override_default_sync", variant), Sync)
-
-	// should sanitize: { diag: { memtag: true } } result in Sync instead of None here?
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_no_override", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_override_default_async", variant), Sync)
-	// should sanitize: { diag: { memtag: true } } result in Sync instead of None here?
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_no_override", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_sync", variant), Sync)
+		installDeps := bin.Description("install").OrderOnly.Strings()
+		android.AssertBoolEquals(t, tc.name+" install depends on the runtime installation",
+			tc.installDepWant, android.InList(runtimeInstalled, installDeps))
+	}
 }
 
-func TestSanitizeMemtagHeapWithSanitizeDevice(t *testing.T) {
-	variant := "android_arm64_armv8-a"
+func TestAsanNotInstallable(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			installable: false,
+			shared_libs: ["libfoo"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			installable: false,
+			sanitize: {
+				address: true,
+			},
+		}
+	`
 
 	result := android.GroupFixturePreparers(
 		prepareForCcTest,
-		prepareForTestWithMemtagHeap,
-		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
-			variables.SanitizeDevice = []string{"memtag_heap"}
-		}),
-	).RunTest(t)
-	ctx := result.TestContext
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	for _, name := range []string{"bin", "libfoo"} {
+		for _, variant := range result.ModuleVariantsForTests(name) {
+			if !strings.HasPrefix(variant, "android_") {
+				continue
+			}
+			module := result.ModuleForTests(name, variant)
+			android.AssertBoolEquals(t, name+" "+variant+" in sanitizer dir", false,
+				module.Module().(*Module).InstallInSanitizerDir())
+			for _, output := range module.AllOutputs() {
+				if strings.Contains(output, "/target/product/") {
+					t.Errorf("%s %s should not be installed, got install rule for %q", name, variant, output)
+				}
+			}
+		}
+	}
 
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_no_override", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_override_default_async", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_override_default_sync", variant), None)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_no_override", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_override_default_async", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_override_default_sync", variant), None)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_no_override", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_override_default_disable", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_no_override", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_override_default_disable", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_override_default_sync", variant), Async)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_no_override", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_override_default_disable", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_override_default_sync", variant), Async)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_override_default_async", variant), Sync)
-	// should sanitize: { diag: { memtag: true } } result in Sync instead of None here?
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_no_override", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_sync", variant), Sync)
+	// The asan variant of the library is still built.
+	result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared_asan").Description("link")
 }
 
-func TestSanitizeMemtagHeapWithSanitizeDeviceDiag(t *testing.T) {
-	variant := "android_arm64_armv8-a"
+func TestExcludeSanitizerVariantsFromPackaging(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libasan",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
 
-	result := android.GroupFixturePreparers(
-		prepareForCcTest,
-		prepareForTestWithMemtagHeap,
-		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
-			variables.SanitizeDevice = []string{"memtag_heap"}
-			variables.SanitizeDeviceDiag = []string{"memtag_heap"}
-		}),
-	).RunTest(t)
-	ctx := result.TestContext
+		cc_library_shared {
+			name: "libcfi",
+			srcs: ["foo.c"],
+			sanitize: {
+				cfi: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libplain",
+			srcs: ["foo.c"],
+		}
 
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_no_override", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_override_default_async", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_binary_override_default_sync", variant), None)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_no_override", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_override_default_async", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_test_override_default_sync", variant), None)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_test_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_no_override", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_override_default_disable", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_binary_override_default_sync", variant), Async)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_no_override", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_override_default_async", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_override_default_disable", variant), Async)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_async_test_override_default_sync", variant), Async)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_test_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_override_default_async", variant), Sync)
-	// should sanitize: { diag: { memtag: true } } result in Sync instead of None here?
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_memtag_set_sync_test_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_override_default_disable", variant), None)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_binary_override_default_sync", variant), Sync)
-
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_no_override", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_async", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_disable", variant), Sync)
-	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_sync", variant), Sync)
+		cc_library_shared {
+			name: "libasan_host",
+			srcs: ["foo.c"],
+			host_supported: true,
+			device_supported: false,
+			sanitize: {
+				address: true,
+			},
+		}
+	`
+
+	variant := "android_arm64_armv8-a_shared"
+	for _, exclude := range []bool{false, true} {
+		t.Run(fmt.Sprintf("exclude=%t", exclude), func(t *testing.T) {
+			result := android.GroupFixturePreparers(
+				prepareForCcTest,
+				prepareForAsanTest,
+				android.FixtureAddFile("foo.c", nil),
+				android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+					variables.ExcludeSanitizerVariantsFromPackaging = BoolPtr(exclude)
+				}),
+			).RunTestWithBp(t, bp)
+
+			libAsan := result.ModuleForTests("libasan", variant+"_asan").Module()
+			android.AssertBoolEquals(t, "libasan packaged", !exclude, len(libAsan.PackagingSpecs()) > 0)
+
+			// Variants built without sanitizers or with the sanitizers production modules use are
+			// always packaged.
+			libPlain := result.ModuleForTests("libplain", variant).Module()
+			android.AssertBoolEquals(t, "libplain packaged", true, len(libPlain.PackagingSpecs()) > 0)
+			libCfi := result.ModuleForTests("libcfi", variant+"_cfi").Module()
+			android.AssertBoolEquals(t, "libcfi packaged", true, len(libCfi.PackagingSpecs()) > 0)
+
+			// Host variants are always packaged, e.g. for the tools of genrules.
+			for _, v := range result.ModuleVariantsForTests("libasan_host") {
+				libAsanHost := result.ModuleForTests("libasan_host", v).Module()
+				android.AssertBoolEquals(t, "libasan_host "+v+" packaged", true,
+					len(libAsanHost.PackagingSpecs()) > 0)
+			}
+		})
+	}
+}
+
+func TestAsanWindows(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			host_supported: true,
+			stl: "none",
+			system_shared_libs: [],
+			target: {
+				windows: {
+					enabled: true,
+				},
+			},
+			sanitize: {
+				address: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		PrepareForTestOnWindows,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyConfig(func(config android.Config) {
+			config.Targets[android.Windows] = []android.Target{
+				{Os: android.Windows, Arch: android.Arch{ArchType: android.X86_64}, HostCross: true},
+			}
+		}),
+	).RunTestWithBp(t, bp)
+
+	variants := result.ModuleVariantsForTests("bin_with_asan")
+	android.AssertStringListContains(t, "bin_with_asan variants", variants, "windows_x86_64")
+	android.AssertStringListDoesNotContain(t, "bin_with_asan variants", variants, "windows_x86_64_asan")
+	// Other targets are still sanitized.
+	android.AssertStringListContains(t, "bin_with_asan variants", variants, "android_arm64_armv8-a_asan")
+
+	windows := result.ModuleForTests("bin_with_asan", "windows_x86_64")
+	cflags := windows.Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "windows cflags", cflags, "-fsanitize")
+	android.AssertArrayString(t, "windows dropped sanitizers", []string{"address"},
+		windows.Module().(*Module).sanitize.Properties.DroppedSanitizers)
+}
+
+func TestUbsanWindows(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_ubsan",
+			srcs: ["foo.c"],
+			host_supported: true,
+			stl: "none",
+			system_shared_libs: [],
+			target: {
+				windows: {
+					enabled: true,
+				},
+			},
+			sanitize: {
+				integer_overflow: true,
+				misc_undefined: ["bounds"],
+				require_runtime: %t,
+			},
+		}
+	`
+
+	prepareForUbsanWindowsTest := android.GroupFixturePreparers(
+		prepareForCcTest,
+		PrepareForTestOnWindows,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyConfig(func(config android.Config) {
+			config.Targets[android.Windows] = []android.Target{
+				{Os: android.Windows, Arch: android.Arch{ArchType: android.X86_64}, HostCross: true},
+			}
+		}),
+	)
+
+	t.Run("dropped", func(t *testing.T) {
+		result := prepareForUbsanWindowsTest.RunTestWithBp(t, fmt.Sprintf(bp, false))
+
+		windows := result.ModuleForTests("bin_with_ubsan", "windows_x86_64")
+		cflags := windows.Rule("cc").Args["cFlags"]
+		android.AssertStringDoesNotContain(t, "windows cflags", cflags, "-fsanitize")
+
+		result.VisitDirectDeps(windows.Module(), func(dep blueprint.Module) {
+			if name := result.ModuleName(dep); strings.HasPrefix(name, "libclang_rt.ubsan_minimal") {
+				t.Errorf("windows variant should not depend on %s", name)
+			}
+		})
+
+		info := result.ModuleProvider(windows.Module(), SanitizeInfoProvider).(SanitizeInfo)
+		android.AssertArrayString(t, "dropped sanitizers", []string{"integer_overflow", "undefined"},
+			info.DroppedSanitizers)
+
+		// Other targets are still sanitized.
+		cflags = result.ModuleForTests("bin_with_ubsan", "android_arm64_armv8-a").Rule("cc").Args["cFlags"]
+		android.AssertStringDoesContain(t, "android cflags", cflags, "-fsanitize-minimal-runtime")
+	})
+
+	t.Run("require_runtime", func(t *testing.T) {
+		prepareForUbsanWindowsTest.
+			ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+				`sanitize: the ubsan_minimal runtime library needed by the undefined behavior sanitizers is not available for windows`)).
+			RunTestWithBp(t, fmt.Sprintf(bp, true))
+	})
+}
+
+func TestSanitizerStlVariant(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			stl: "libc++_static",
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_with_hwasan",
+			srcs: ["foo.c"],
+			stl: "libc++_static",
+			sanitize: {
+				hwaddress: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_sanitizers",
+			srcs: ["foo.c"],
+			stl: "libc++_static",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	for _, tc := range []struct {
+		name, binVariant, stlVariant string
+	}{
+		{name: "bin_with_asan", binVariant: variant + "_asan", stlVariant: variant + "_static_asan"},
+		{name: "bin_with_hwasan", binVariant: variant + "_hwasan", stlVariant: variant + "_static_hwasan"},
+		{name: "bin_no_sanitizers", binVariant: variant, stlVariant: variant + "_static"},
+	} {
+		implicits := result.ModuleForTests(tc.name, tc.binVariant).Description("link").Implicits.Strings()
+		stl := result.ModuleForTests("libc++_static", tc.stlVariant).Description("static link").Output.String()
+		if !android.InList(stl, implicits) {
+			t.Errorf("%s should link against %q, got %q", tc.name, stl, implicits)
+		}
+	}
+}
+
+func TestSanitizerStlVariantShallow(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_shallow_asan",
+			srcs: ["foo.c"],
+			stl: "libc++_static",
+			sanitize: {
+				address: true,
+				shallow: true,
+			},
+		}
+	`
+
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`module "bin_with_shallow_asan".*: sanitize.shallow: is not supported with address or hwaddress `+
+			`for modules using libc\+\+_static`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestExpectNoRuntimeLinkDep(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_no_sanitizers",
+			srcs: ["foo.c"],
+			shared_libs: ["libshared"],
+		}
+
+		cc_library_shared {
+			name: "libshared",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	bin := result.ModuleForTests("bin_no_sanitizers", "android_arm64_armv8-a")
+	for _, runtime := range []string{"libclang_rt.asan", "libclang_rt.hwasan", "libclang_rt.ubsan_standalone"} {
+		expectNoRuntimeLinkDep(t, result.TestContext, bin, runtime)
+	}
+}
+
+func TestNoSanitizeFunctions(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+				no_sanitize_functions: ["foo", "bar", "foo"],
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	bin := result.ModuleForTests("bin_with_asan", "android_arm64_armv8-a_asan")
+	ignorelist := bin.Output("gen/no_sanitize_functions_ignorelist.txt")
+	android.AssertStringEquals(t, "ignorelist contents", "fun:foo\nfun:bar\n",
+		android.ContentFromFileRuleForTests(t, ignorelist))
+
+	cc := bin.Rule("cc")
+	android.AssertStringDoesContain(t, "cflags", cc.Args["cFlags"],
+		"-fsanitize-ignorelist="+ignorelist.Output.String())
+	android.AssertStringListContains(t, "implicits", cc.Implicits.Strings(), ignorelist.Output.String())
+}
+
+func TestHwaddressGlobals(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_no_hwasan_globals",
+			srcs: ["foo.c"],
+			static_libs: ["libstatic"],
+			sanitize: {
+				hwaddress: true,
+				hwaddress_globals: false,
+			},
+		}
+
+		cc_library_static {
+			name: "libstatic",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	bin := result.ModuleForTests("bin_no_hwasan_globals", "android_arm64_armv8-a_hwasan")
+	android.AssertStringDoesContain(t, "bin_no_hwasan_globals cflags",
+		bin.Rule("cc").Args["cFlags"], "-mllvm -hwasan-globals=0")
+	android.AssertStringDoesContain(t, "bin_no_hwasan_globals keeps hwasan",
+		bin.Rule("cc").Args["cFlags"], "-fsanitize=hwaddress")
+
+	// The setting is not propagated to dependencies.
+	lib := result.ModuleForTests("libstatic", "android_arm64_armv8-a_static_hwasan")
+	android.AssertStringDoesNotContain(t, "libstatic cflags",
+		lib.Rule("cc").Args["cFlags"], "-hwasan-globals=0")
+}
+
+func TestSanitizeWarningsAsErrors(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_ubsan_werror",
+			srcs: ["foo.c"],
+			sanitize: {
+				undefined: true,
+				warnings_as_errors: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_ubsan",
+			srcs: ["foo.c"],
+			sanitize: {
+				undefined: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_werror_no_sanitizers",
+			srcs: ["foo.c"],
+			sanitize: {
+				warnings_as_errors: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	cflags := result.ModuleForTests("bin_ubsan_werror", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "bin_ubsan_werror cflags", cflags, "-fsanitize=")
+	for _, flag := range sanitizerWarningsAsErrorsCflags {
+		android.AssertStringDoesContain(t, "bin_ubsan_werror cflags", cflags, flag)
+	}
+
+	cflags = result.ModuleForTests("bin_ubsan", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "bin_ubsan cflags", cflags, "-Werror=shift-count-overflow")
+
+	cflags = result.ModuleForTests("bin_werror_no_sanitizers", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "bin_werror_no_sanitizers cflags", cflags, "-Werror=shift-count-overflow")
+}
+
+func TestUndefinedTrapFlags(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_diag_integer_trap_bounds",
+			srcs: ["foo.c"],
+			sanitize: {
+				integer_overflow: true,
+				misc_undefined: ["bounds"],
+				diag: {
+					integer_overflow: true,
+				},
+			},
+		}
+
+		cc_binary {
+			name: "bin_minimal_runtime",
+			srcs: ["foo.c"],
+			sanitize: {
+				integer_overflow: true,
+				misc_undefined: ["bounds"],
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	// Only the diag checks stop trapping, bounds keeps trapping.
+	cflags := result.ModuleForTests("bin_diag_integer_trap_bounds", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "bin_diag_integer_trap_bounds cflags", cflags,
+		"-fsanitize=bounds,unsigned-integer-overflow,signed-integer-overflow ")
+	android.AssertStringDoesContain(t, "bin_diag_integer_trap_bounds cflags", cflags,
+		"-fsanitize-trap=all -ftrap-function=abort")
+	android.AssertStringDoesContain(t, "bin_diag_integer_trap_bounds cflags", cflags,
+		"-fno-sanitize-trap=unsigned-integer-overflow,signed-integer-overflow")
+	android.AssertStringDoesNotContain(t, "bin_diag_integer_trap_bounds cflags", cflags,
+		"-fno-sanitize-trap=integer")
+	android.AssertStringDoesNotContain(t, "bin_diag_integer_trap_bounds cflags", cflags,
+		"-fsanitize-minimal-runtime")
+
+	// Without diag checks everything traps, including with the minimal runtime, whose flags don't
+	// disable trapping for the integer and undefined groups anymore.
+	cflags = result.ModuleForTests("bin_minimal_runtime", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "bin_minimal_runtime cflags", cflags,
+		"-fsanitize-minimal-runtime -fno-sanitize-recover=integer,undefined")
+	android.AssertStringListDoesNotContain(t, "minimal runtime flags", minimalRuntimeFlags,
+		"-fno-sanitize-trap=integer,undefined")
+	android.AssertStringDoesNotContain(t, "bin_minimal_runtime cflags", cflags,
+		"-fno-sanitize-trap=integer,undefined")
+	android.AssertStringDoesContain(t, "bin_minimal_runtime cflags", cflags,
+		"-fsanitize-trap=all -ftrap-function=abort")
+	android.AssertStringDoesNotContain(t, "bin_minimal_runtime cflags", cflags, "-fno-sanitize-trap=")
+}
+
+func TestUndefinedRuntime(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_minimal",
+			srcs: ["foo.c"],
+			sanitize: {
+				integer_overflow: true,
+				undefined_runtime: "minimal",
+			},
+		}
+
+		cc_binary {
+			name: "bin_standalone",
+			srcs: ["foo.c"],
+			sanitize: {
+				integer_overflow: true,
+				undefined_runtime: "standalone",
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	dependsOn := func(name, dep string) bool {
+		found := false
+		result.VisitDirectDeps(result.ModuleForTests(name, variant).Module(), func(m blueprint.Module) {
+			if result.ModuleName(m) == dep {
+				found = true
+			}
+		})
+		return found
+	}
+
+	cflags := result.ModuleForTests("bin_minimal", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "bin_minimal cflags", cflags, "-fsanitize-minimal-runtime")
+	android.AssertStringDoesNotContain(t, "bin_minimal cflags", cflags, "-fno-sanitize-trap=")
+	android.AssertBoolEquals(t, "bin_minimal links the minimal runtime", true,
+		dependsOn("bin_minimal", "libclang_rt.ubsan_minimal"))
+	android.AssertBoolEquals(t, "bin_minimal links the standalone runtime", false,
+		dependsOn("bin_minimal", "libclang_rt.ubsan_standalone"))
+
+	// The standalone runtime reports the errors instead of trapping.
+	cflags = result.ModuleForTests("bin_standalone", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "bin_standalone cflags", cflags, "-fsanitize-minimal-runtime")
+	android.AssertStringDoesContain(t, "bin_standalone cflags", cflags,
+		"-fno-sanitize-trap=unsigned-integer-overflow,signed-integer-overflow")
+	android.AssertBoolEquals(t, "bin_standalone links the standalone runtime", true,
+		dependsOn("bin_standalone", "libclang_rt.ubsan_standalone"))
+	android.AssertBoolEquals(t, "bin_standalone links the minimal runtime", false,
+		dependsOn("bin_standalone", "libclang_rt.ubsan_minimal"))
+
+	t.Run("minimal with diag", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.undefined_runtime: minimal cannot be combined with diag undefined behavior checks`,
+		)).RunTestWithBp(t, `
+			cc_binary {
+				name: "bin",
+				srcs: ["foo.c"],
+				sanitize: {
+					integer_overflow: true,
+					undefined_runtime: "minimal",
+					diag: {
+						integer_overflow: true,
+					},
+				},
+			}
+		`)
+	})
+}
+
+func TestSeparateSanitizerOutputs(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			static_libs: ["libstatic"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libstatic",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SeparateSanitizerOutputs = BoolPtr(true)
+		}),
+	).RunTestWithBp(t, bp)
+
+	asan := result.ModuleForTests("libstatic", "android_arm64_armv8-a_static_asan")
+	android.AssertStringDoesContain(t, "asan variant output",
+		android.PathRelativeToTop(asan.Module().(*Module).OutputFile().Path()), "out/soong/sanitized/.intermediates/")
+
+	base := result.ModuleForTests("libstatic", "android_arm64_armv8-a_static")
+	android.AssertStringDoesNotContain(t, "base variant output",
+		android.PathRelativeToTop(base.Module().(*Module).OutputFile().Path()), "/sanitized/")
+}
+
+func TestSanitizePassthrough(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			static_libs: ["libforwarding"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libforwarding",
+			static_libs: ["libreal"],
+			sanitize: {
+				passthrough: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libreal",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	// The asan variant of the forwarding library only forwards the variant to libreal.
+	forwarding := result.ModuleForTests("libforwarding", variant+"_static_asan").Module().(*Module)
+	android.AssertBoolEquals(t, "libforwarding asan variant sanitized", false, forwarding.sanitize.isSanitizerEnabled(Asan))
+	android.AssertBoolEquals(t, "libforwarding asan variant hidden from make", true, forwarding.HiddenFromMake())
+
+	realAsan := result.ModuleForTests("libreal", variant+"_static_asan")
+	android.AssertStringDoesContain(t, "libreal asan cflags", realAsan.Rule("cc").Args["cFlags"], "-fsanitize=address")
+
+	implicits := result.ModuleForTests("bin_with_asan", variant+"_asan").Description("link").Implicits.Strings()
+	android.AssertStringListContains(t, "bin_with_asan links libreal asan variant",
+		implicits, realAsan.Description("static link").Output.String())
+
+	t.Run("cc_library", func(t *testing.T) {
+		bp := `
+			cc_binary {
+				name: "bin_with_asan",
+				srcs: ["foo.c"],
+				static_libs: ["libforwarding"],
+				sanitize: {
+					address: true,
+				},
+			}
+
+			cc_binary {
+				name: "bin_shared",
+				srcs: ["foo.c"],
+				shared_libs: ["libforwarding"],
+			}
+
+			cc_library {
+				name: "libforwarding",
+				static_libs: ["libreal"],
+				sanitize: {
+					passthrough: true,
+				},
+			}
+
+			cc_library_static {
+				name: "libreal",
+				srcs: ["foo.c"],
+			}
+		`
+
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+			android.FixtureAddFile("foo.c", nil),
+		).RunTestWithBp(t, bp)
+
+		// The static variant forwards the asan variant to libreal, the shared variant isn't
+		// rejected and still builds its own code.
+		forwarding := result.ModuleForTests("libforwarding", variant+"_static_asan").Module().(*Module)
+		android.AssertBoolEquals(t, "libforwarding static asan variant sanitized", false,
+			forwarding.sanitize.isSanitizerEnabled(Asan))
+		result.ModuleForTests("libreal", variant+"_static_asan")
+		result.ModuleForTests("libforwarding", variant+"_shared").Description("link")
+	})
+
+	t.Run("srcs", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.passthrough: can't be combined with srcs`,
+		)).RunTestWithBp(t, `
+			cc_library_static {
+				name: "libforwarding",
+				srcs: ["foo.c"],
+				sanitize: {
+					passthrough: true,
+				},
+			}
+		`)
+	})
+}
+
+func TestSanitizeShallow(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_shallow_asan",
+			static_libs: ["libstatic"],
+			sanitize: {
+				address: true,
+				shallow: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_with_asan",
+			static_libs: ["libstatic"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libstatic",
+			static_libs: ["libtransitive"],
+		}
+
+		cc_library_static {
+			name: "libtransitive",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	shallow := result.ModuleForTests("bin_with_shallow_asan", variant)
+
+	cflags := shallow.Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "bin_with_shallow_asan cflags", cflags, "-fsanitize=address")
+
+	// bin_with_asan forces libstatic to be split, but the shallow binary still links the base variant.
+	implicits := shallow.Description("link").Implicits.Strings()
+	for _, lib := range []string{"libstatic", "libtransitive"} {
+		base := result.ModuleForTests(lib, variant+"_static").Description("static link").Output.String()
+		asan := result.ModuleForTests(lib, variant+"_static_asan").Description("static link").Output.String()
+		if !android.InList(base, implicits) {
+			t.Errorf("bin_with_shallow_asan should link against %q, got %q", base, implicits)
+		}
+		if android.InList(asan, implicits) {
+			t.Errorf("bin_with_shallow_asan should not link against %q, got %q", asan, implicits)
+		}
+	}
+}
+
+func TestSanitizeShallowLibrary(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libshared",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+				shallow: true,
+			},
+		}
+	`
+
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`module "libshared".*: sanitize.shallow: is only supported for binaries`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestSanitizeNoSources(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			static_libs: ["libnosrcs_asan"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libnosrcs_asan",
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libnosrcs_unused_asan",
+			static_libs: ["libdep"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libdep",
+			srcs: ["foo.c"],
+		}
+
+		cc_binary {
+			name: "bin_with_nosrcs_ubsan",
+			srcs: ["foo.c"],
+			static_libs: ["libnosrcs_ubsan"],
+		}
+
+		cc_library_static {
+			name: "libnosrcs_ubsan",
+			sanitize: {
+				undefined: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_with_forwarded_ubsan",
+			srcs: ["foo.c"],
+			static_libs: ["libforward_ubsan"],
+		}
+
+		cc_library_static {
+			name: "libforward_ubsan",
+			whole_static_libs: ["libubsan"],
+			sanitize: {
+				undefined: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libubsan",
+			srcs: ["foo.c"],
+			sanitize: {
+				undefined: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	// The source-less library still gets both variants for the sanitized binary depending on it, with
+	// no objects of their own.
+	for _, v := range []string{variant + "_static", variant + "_static_asan"} {
+		lib := result.ModuleForTests("libnosrcs_asan", v)
+		if lib.MaybeRule("cc").Rule != nil {
+			t.Errorf("libnosrcs_asan %s should not compile anything", v)
+		}
+		lib.Description("static link")
+	}
+	asan := result.ModuleForTests("libnosrcs_asan", variant+"_static_asan").Description("static link").Output.String()
+	implicits := result.ModuleForTests("bin_with_asan", variant).Description("link").Implicits.Strings()
+	if !android.InList(asan, implicits) {
+		t.Errorf("bin_with_asan should link against %q, got %q", asan, implicits)
+	}
+
+	// Nothing needs the sanitized variants of a source-less library that no sanitized module depends
+	// on, so neither it nor its dependencies are split.
+	variants := func(name string) []string {
+		var ret []string
+		for _, v := range result.ModuleVariantsForTests(name) {
+			if strings.HasPrefix(v, variant+"_") {
+				ret = append(ret, v)
+			}
+		}
+		return ret
+	}
+	android.AssertArrayString(t, "libnosrcs_unused_asan variants", []string{variant + "_static"},
+		variants("libnosrcs_unused_asan"))
+	android.AssertArrayString(t, "libdep variants", []string{variant + "_static"}, variants("libdep"))
+
+	dependsOnUbsanMinimal := func(name, variant string) bool {
+		found := false
+		m := result.ModuleForTests(name, variant).Module()
+		result.VisitDirectDeps(m, func(dep blueprint.Module) {
+			if strings.HasPrefix(result.ModuleName(dep), "libclang_rt.ubsan_minimal") {
+				found = true
+			}
+		})
+		return found
+	}
+
+	android.AssertBoolEquals(t, "libnosrcs_ubsan depends on the ubsan_minimal runtime", false,
+		dependsOnUbsanMinimal("libnosrcs_ubsan", variant+"_static"))
+	android.AssertBoolEquals(t, "bin_with_nosrcs_ubsan depends on the ubsan_minimal runtime", false,
+		dependsOnUbsanMinimal("bin_with_nosrcs_ubsan", variant))
+	// The instrumented code of libubsan is linked through libforward_ubsan.
+	android.AssertBoolEquals(t, "bin_with_forwarded_ubsan depends on the ubsan_minimal runtime", true,
+		dependsOnUbsanMinimal("bin_with_forwarded_ubsan", variant))
+}
+
+func TestSanitizeReusedObjectsLinkUbsanRuntime(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				integer_overflow: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	// The shared variant links the objects of the static variant, which were built with
+	// -fsanitize-minimal-runtime, so it still needs the minimal runtime.
+	for _, v := range []string{variant + "_static", variant + "_shared"} {
+		m := result.ModuleForTests("libfoo", v).Module()
+		found := false
+		result.VisitDirectDeps(m, func(dep blueprint.Module) {
+			if strings.HasPrefix(result.ModuleName(dep), "libclang_rt.ubsan_minimal") {
+				found = true
+			}
+		})
+		android.AssertBoolEquals(t, "libfoo "+v+" depends on the ubsan_minimal runtime", true, found)
+	}
+}
+
+func TestSanitizeSharedDepsOnly(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_shared_deps_asan",
+			shared_libs: ["libshared"],
+			static_libs: ["libstatic"],
+			sanitize: {
+				address: true,
+				shared_deps_only: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_with_asan",
+			static_libs: ["libstatic"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libshared",
+			shared_libs: ["libtransitive"],
+			static_libs: ["libstatic_of_shared"],
+		}
+
+		cc_library_shared {
+			name: "libtransitive",
+		}
+
+		cc_library_static {
+			name: "libstatic",
+		}
+
+		cc_library_static {
+			name: "libstatic_of_shared",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	bin := result.ModuleForTests("bin_with_shared_deps_asan", variant)
+
+	cflags := bin.Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "bin_with_shared_deps_asan cflags", cflags, "-fsanitize=address")
+
+	expectSharedAsanLinkDep := func(from android.TestingModule, lib string) {
+		t.Helper()
+		to := result.ModuleForTests(lib, variant+"_shared_asan")
+		android.AssertStringDoesContain(t, lib+" cflags", to.Rule("cc").Args["cFlags"], "-fsanitize=address")
+		if g, w := from.Description("link").OrderOnly.Strings(), to.Description("strip").Output.String(); !android.InList(w, g) {
+			t.Errorf("%s should link against %s, expected %q, got %q", from.Module(), to.Module(), w, g)
+		}
+	}
+
+	expectStaticBaseLinkDep := func(from android.TestingModule, lib string) {
+		t.Helper()
+		base := result.ModuleForTests(lib, variant+"_static").Description("static link").Output.String()
+		implicits := from.Description("link").Implicits.Strings()
+		if !android.InList(base, implicits) {
+			t.Errorf("%s should link against %q, got %q", from.Module(), base, implicits)
+		}
+		if android.InList(variant+"_static_asan", result.ModuleVariantsForTests(lib)) {
+			asan := result.ModuleForTests(lib, variant+"_static_asan").Description("static link").Output.String()
+			if android.InList(asan, implicits) {
+				t.Errorf("%s should not link against %q, got %q", from.Module(), asan, implicits)
+			}
+		}
+	}
+
+	libShared := result.ModuleForTests("libshared", variant+"_shared_asan")
+	expectSharedAsanLinkDep(bin, "libshared")
+	expectSharedAsanLinkDep(libShared, "libtransitive")
+
+	// bin_with_asan forces libstatic to be split, but bin_with_shared_deps_asan still links the
+	// base variant.
+	expectStaticBaseLinkDep(bin, "libstatic")
+	expectStaticBaseLinkDep(libShared, "libstatic_of_shared")
+}
+
+func TestSanitizerRuntimesExcludeLibs(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_runtime",
+			srcs: ["foo.c"],
+			static_libs: ["libcustom_rt"],
+		}
+
+		cc_binary {
+			name: "bin_without_runtime",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_static {
+			name: "libcustom_rt",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizerRuntimesExcludeLibs = []string{"libcustom_rt.a"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	ldFlags := result.ModuleForTests("bin_with_runtime", variant).Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesContain(t, "bin_with_runtime ldflags", ldFlags, "-Wl,--exclude-libs,libcustom_rt.a")
+
+	ldFlags = result.ModuleForTests("bin_without_runtime", variant).Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesNotContain(t, "bin_without_runtime ldflags", ldFlags, "libcustom_rt.a")
+}
+
+func TestSanitizerRuntimeRpathLink(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_asan",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	runtime := result.ModuleForTests("libclang_rt.asan", variant+"_shared").Module()
+	runtimeLib := result.ModuleProvider(runtime, SharedLibraryInfoProvider).(SharedLibraryInfo).SharedLibrary
+
+	ldFlags := result.ModuleForTests("bin_with_asan", variant+"_asan").Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesContain(t, "asan variant ldflags", ldFlags,
+		"-Wl,-rpath-link,"+filepath.Dir(runtimeLib.String()))
+
+	ldFlags = result.ModuleForTests("bin_no_asan", variant).Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesNotContain(t, "bin_no_asan ldflags", ldFlags, "-rpath-link")
+}
+
+func TestSanitizerRuntimeOverrides(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			compile_multilib: "both",
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libclang_rt.asan_arm_abi",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizerRuntimeOverrides = map[string]string{
+				"address:arm": "libclang_rt.asan_arm_abi",
+			}
+		}),
+	).RunTestWithBp(t, bp)
+
+	runtimeDeps := func(variant string) []string {
+		var deps []string
+		m := result.ModuleForTests("bin_with_asan", variant).Module()
+		result.VisitDirectDeps(m, func(dep blueprint.Module) {
+			if name := result.ModuleName(dep); strings.HasPrefix(name, "libclang_rt.asan") {
+				deps = append(deps, name)
+			}
+		})
+		return android.FirstUniqueStrings(deps)
+	}
+
+	android.AssertDeepEquals(t, "arm64 runtime", []string{"libclang_rt.asan"},
+		runtimeDeps("android_arm64_armv8-a_asan"))
+	android.AssertDeepEquals(t, "arm runtime", []string{"libclang_rt.asan_arm_abi"},
+		runtimeDeps("android_arm_armv7-a-neon_asan"))
+}
+
+// prepareForAsanFromSourceTest adds the counterparts of the sanitizer runtime libraries built from
+// source to prepareForAsanTest.
+var prepareForAsanFromSourceTest = android.GroupFixturePreparers(
+	prepareForAsanTest,
+	android.FixtureAddFile("compiler-rt/Android.bp", []byte(`
+		cc_library_shared {
+			name: "libclang_rt.asan.from_source",
+			stem: "libclang_rt.asan",
+		}
+
+		cc_library_static {
+			name: "libclang_rt.ubsan_minimal.from_source",
+			stem: "libclang_rt.ubsan_minimal",
+			nocrt: true,
+			stl: "none",
+			system_shared_libs: [],
+			sanitize: {
+				never: true,
+			},
+		}
+	`)),
+)
+
+func TestSanitizerRuntimesFromSource(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_with_integer_overflow",
+			srcs: ["foo.c"],
+			sanitize: {
+				integer_overflow: true,
+			},
+		}
+	`
+
+	variant := "android_arm64_armv8-a"
+	runtimeDeps := func(result *android.TestResult, name, variant string) []string {
+		var deps []string
+		m := result.ModuleForTests(name, variant).Module()
+		result.VisitDirectDeps(m, func(dep blueprint.Module) {
+			if name := result.ModuleName(dep); strings.HasPrefix(name, "libclang_rt.asan") ||
+				strings.HasPrefix(name, "libclang_rt.ubsan_minimal") {
+				deps = append(deps, name)
+			}
+		})
+		return android.FirstUniqueStrings(deps)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		preparer android.FixturePreparer
+		asan     string
+		ubsan    string
+	}{
+		{
+			name:     "prebuilts",
+			preparer: android.NullFixturePreparer,
+			asan:     "libclang_rt.asan",
+			ubsan:    "libclang_rt.ubsan_minimal",
+		},
+		{
+			name: "product variable",
+			preparer: android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+				variables.SanitizerRuntimesFromSource = BoolPtr(true)
+			}),
+			asan:  "libclang_rt.asan.from_source",
+			ubsan: "libclang_rt.ubsan_minimal.from_source",
+		},
+		{
+			name:     "environment",
+			preparer: android.FixtureMergeEnv(map[string]string{"SANITIZER_RUNTIMES_FROM_SOURCE": "true"}),
+			asan:     "libclang_rt.asan.from_source",
+			ubsan:    "libclang_rt.ubsan_minimal.from_source",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result := android.GroupFixturePreparers(
+				prepareForCcTest,
+				prepareForAsanFromSourceTest,
+				android.FixtureAddFile("foo.c", nil),
+				tc.preparer,
+			).RunTestWithBp(t, bp)
+
+			android.AssertDeepEquals(t, "asan runtime", []string{tc.asan},
+				runtimeDeps(result, "bin_with_asan", variant+"_asan"))
+			android.AssertDeepEquals(t, "ubsan minimal runtime", []string{tc.ubsan},
+				runtimeDeps(result, "bin_with_integer_overflow", variant))
+
+			runtime := result.ModuleForTests(tc.asan, variant+"_shared").Description("strip").Output.String()
+			if orderOnly := result.ModuleForTests("bin_with_asan", variant+"_asan").Description("link").OrderOnly.Strings(); !android.InList(runtime, orderOnly) {
+				t.Errorf("bin_with_asan should link against %q, got %q", runtime, orderOnly)
+			}
+		})
+	}
+
+	// Runtimes without a counterpart built from source are an error instead of silently falling
+	// back to the prebuilts.
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizerRuntimesFromSource = BoolPtr(true)
+		}),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`module "bin_with_asan".*: the sanitizer runtimes are built from source, but the counterpart `+
+			`"libclang_rt.asan.from_source" of sanitizer runtime library "libclang_rt.asan" is not defined`,
+	)).RunTestWithBp(t, bp)
+}
+
+// sanitizerRuntimeInstallPathSingleton records the results of SanitizerRuntimeInstallPath.
+type sanitizerRuntimeInstallPathSingleton struct {
+	paths map[string]string
+}
+
+func (s *sanitizerRuntimeInstallPathSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	s.paths = map[string]string{
+		"address arm64":  SanitizerRuntimeInstallPath(ctx, "address", android.Arm64),
+		"address arm":    SanitizerRuntimeInstallPath(ctx, "address", android.Arm),
+		"address x86_64": SanitizerRuntimeInstallPath(ctx, "address", android.X86_64),
+		"fuzzer arm64":   SanitizerRuntimeInstallPath(ctx, "fuzzer", android.Arm64),
+	}
+}
+
+func TestSanitizerRuntimeInstallPath(t *testing.T) {
+	singleton := &sanitizerRuntimeInstallPathSingleton{}
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("sanitizer_runtime_install_path", func() android.Singleton { return singleton })
+		}),
+	).RunTest(t)
+
+	android.AssertDeepEquals(t, "install paths", map[string]string{
+		"address arm64":  "/system/lib64/libclang_rt.asan.so",
+		"address arm":    "/system/lib/libclang_rt.asan.so",
+		"address x86_64": "",
+		"fuzzer arm64":   "",
+	}, singleton.paths)
+}
+
+func TestSanitizeVendorImage(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libvendor_available",
+			srcs: ["foo.c"],
+			vendor_available: true,
+			product_available: true,
+		}
+
+		cc_library {
+			name: "libvendor_available_never",
+			srcs: ["foo.c"],
+			vendor_available: true,
+			sanitize: {
+				never: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureAddFile("excluded/foo.c", nil),
+		android.FixtureAddTextFile("excluded/Android.bp", `
+			cc_library {
+				name: "libvendor_available_excluded",
+				srcs: ["foo.c"],
+				vendor_available: true,
+			}
+		`),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeVendorImage = []string{"integer_overflow"}
+			variables.IntegerOverflowExcludePaths = []string{"excluded"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	const intOverflow = "-fsanitize=unsigned-integer-overflow,signed-integer-overflow"
+
+	cflags := func(name, variant string) string {
+		return result.ModuleForTests(name, variant).Rule("cc").Args["cFlags"]
+	}
+
+	for _, variant := range []string{vendorVariant, productVariant} {
+		flags := cflags("libvendor_available", variant)
+		android.AssertStringDoesContain(t, "libvendor_available "+variant+" cflags", flags, intOverflow)
+		android.AssertStringDoesContain(t, "libvendor_available "+variant+" cflags", flags, "-fsanitize-minimal-runtime")
+	}
+
+	android.AssertStringDoesNotContain(t, "libvendor_available core cflags",
+		cflags("libvendor_available", "android_arm64_armv8-a_shared"), intOverflow)
+	android.AssertStringDoesNotContain(t, "libvendor_available_never vendor cflags",
+		cflags("libvendor_available_never", vendorVariant), intOverflow)
+	android.AssertStringDoesNotContain(t, "libvendor_available_excluded vendor cflags",
+		cflags("libvendor_available_excluded", vendorVariant), intOverflow)
+}
+
+func TestSanitizerSingleLinkageLibraries(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			shared_libs: ["libshared_only"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library {
+			name: "libshared_only",
+			srcs: ["foo.c"],
+			static: {
+				enabled: false,
+			},
+		}
+
+		cc_fuzz {
+			name: "fuzz_static_only",
+			srcs: ["foo.c"],
+			static_libs: ["libstatic_only"],
+		}
+
+		cc_library {
+			name: "libstatic_only",
+			srcs: ["foo.c"],
+			shared: {
+				enabled: false,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	bin := result.ModuleForTests("bin_with_asan", "android_arm64_armv8-a_asan")
+	libShared := result.ModuleForTests("libshared_only", "android_arm64_armv8-a_shared")
+	android.AssertStringListContains(t, "bin_with_asan links libshared_only",
+		bin.Description("link").OrderOnly.Strings(), libShared.Description("strip").Output.String())
+
+	fuzz := result.ModuleForTests("fuzz_static_only", "android_arm64_armv8-a_fuzzer")
+	libStatic := result.ModuleForTests("libstatic_only", "android_arm64_armv8-a_static_fuzzer")
+	android.AssertStringListContains(t, "fuzz_static_only links libstatic_only",
+		fuzz.Description("link").Implicits.Strings(), libStatic.Description("static link").Output.String())
+}
+
+func TestSanitizerRuntimeMissingLinkage(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library {
+			name: "libclang_rt.asan",
+			shared: {
+				enabled: false,
+			},
+		}
+	`
+
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`sanitizer runtime library "libclang_rt.asan" has no shared variant`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestHwaddressIncompatible(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			static_libs: ["libincompatible"],
+		}
+
+		cc_library_static {
+			name: "libincompatible",
+			srcs: ["foo.c"],
+			static_libs: ["libinner"],
+			sanitize: {
+				hwaddress_compatible: false,
+				misc_undefined: ["bounds"],
+			},
+		}
+
+		cc_library_static {
+			name: "libinner",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_shared {
+			name: "libincompatible_shared",
+			srcs: ["foo.c"],
+			sanitize: {
+				hwaddress_compatible: false,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"hwaddress"}
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("sanitizer_coverage_report", sanitizerCoverageReportFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	bin := result.ModuleForTests("bin", variant+"_hwasan")
+	android.AssertStringDoesContain(t, "bin cflags", bin.Rule("cc").Args["cFlags"], "-fsanitize=hwaddress")
+
+	// The incompatible library is not split even though a hwasan binary depends on it, and keeps
+	// the other sanitizers.
+	android.AssertStringListDoesNotContain(t, "libincompatible variants",
+		result.ModuleVariantsForTests("libincompatible"), variant+"_static_hwasan")
+	libIncompatible := result.ModuleForTests("libincompatible", variant+"_static")
+	cflags := libIncompatible.Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "libincompatible cflags", cflags, "-fsanitize=hwaddress")
+	android.AssertStringDoesContain(t, "libincompatible cflags", cflags, "-fsanitize=bounds")
+	android.AssertStringListContains(t, "bin link implicits", bin.Description("link").Implicits.Strings(),
+		libIncompatible.Description("static link").Output.String())
+
+	info := result.ModuleProvider(libIncompatible.Module(), SanitizeInfoProvider).(SanitizeInfo)
+	android.AssertArrayString(t, "libincompatible incompatible sanitizers", []string{"hwaddress"},
+		info.IncompatibleSanitizers)
+
+	report := result.SingletonForTests("sanitizer_coverage_report")
+	system := sanitizerCoverageRows(t, android.ContentFromFileRuleForTests(t,
+		report.Output("out/soong/sanitizer_coverage/system.csv")))
+	android.AssertArrayString(t, "system report",
+		[]string{"/system/lib64/libincompatible_shared.so", "libincompatible_shared", "none", "", "hwaddress"},
+		system["/system/lib64/libincompatible_shared.so"])
+
+	t.Run("explicit", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.hwaddress: cannot be enabled for a module with hwaddress_compatible: false`)).
+			RunTestWithBp(t, `
+				cc_library_shared {
+					name: "libexplicit",
+					srcs: ["foo.c"],
+					sanitize: {
+						hwaddress: true,
+						hwaddress_compatible: false,
+					},
+				}
+			`)
+	})
+}
+
+func TestSanitizeBootCriticalModules(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "init",
+			srcs: ["foo.c"],
+		}
+
+		cc_binary {
+			name: "ueventd",
+			srcs: ["foo.c"],
+			sanitize: {
+				hwaddress: true,
+			},
+		}
+
+		cc_binary {
+			name: "product_boot_bin",
+			srcs: ["foo.c"],
+		}
+
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"hwaddress"}
+			variables.SanitizeBootCriticalModules = []string{"product_boot_bin"}
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("sanitizer_coverage_report", sanitizerCoverageReportFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	// Members of the built-in and the product's boot-critical modules stay clean.
+	for _, name := range []string{"init", "product_boot_bin"} {
+		module := result.ModuleForTests(name, variant)
+		android.AssertStringDoesNotContain(t, name+" cflags", module.Rule("cc").Args["cFlags"],
+			"-fsanitize=hwaddress")
+		info := result.ModuleProvider(module.Module(), SanitizeInfoProvider).(SanitizeInfo)
+		android.AssertStringEquals(t, name+" excluded by", "boot_critical", info.ExcludedByModuleSet)
+		android.AssertArrayString(t, name+" excluded sanitizers", []string{"hwaddress"},
+			info.ModuleSetExcludedSanitizers)
+	}
+
+	// The module's own properties opt it back in, and the sanitizer isn't reported as excluded.
+	ueventd := result.ModuleForTests("ueventd", variant+"_hwasan")
+	android.AssertStringDoesContain(t, "ueventd cflags", ueventd.Rule("cc").Args["cFlags"],
+		"-fsanitize=hwaddress")
+	ueventdInfo := result.ModuleProvider(ueventd.Module(), SanitizeInfoProvider).(SanitizeInfo)
+	android.AssertStringEquals(t, "ueventd excluded by", "", ueventdInfo.ExcludedByModuleSet)
+	android.AssertIntEquals(t, "ueventd excluded sanitizers", 0, len(ueventdInfo.ModuleSetExcludedSanitizers))
+
+	bin := result.ModuleForTests("bin", variant+"_hwasan")
+	android.AssertStringDoesContain(t, "bin cflags", bin.Rule("cc").Args["cFlags"], "-fsanitize=hwaddress")
+
+	report := result.SingletonForTests("sanitizer_coverage_report")
+	system := sanitizerCoverageRows(t, android.ContentFromFileRuleForTests(t,
+		report.Output("out/soong/sanitizer_coverage/system.csv")))
+	android.AssertArrayString(t, "init report",
+		[]string{"/system/bin/init", "init", "none", "", "hwaddress(boot_critical)"},
+		system["/system/bin/init"])
+	ueventdRow, ok := system["/system/bin/ueventd"]
+	android.AssertBoolEquals(t, "ueventd reported", true, ok)
+	if ok {
+		android.AssertStringEquals(t, "ueventd skipped sanitizers", "", ueventdRow[4])
+	}
+}
+
+func TestGlobalSanitizersMinSdkVersion(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libold",
+			srcs: ["foo.c"],
+			min_sdk_version: "28",
+		}
+
+		cc_library_shared {
+			name: "libnew",
+			srcs: ["foo.c"],
+			min_sdk_version: "29",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"hwaddress"}
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("sanitizer_coverage_report", sanitizerCoverageReportFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a_shared"
+
+	// The hwaddress runtime isn't available on API level 28.
+	android.AssertStringListDoesNotContain(t, "libold variants", result.ModuleVariantsForTests("libold"),
+		variant+"_hwasan")
+	libOld := result.ModuleForTests("libold", variant)
+	android.AssertStringDoesNotContain(t, "libold cflags", libOld.Rule("cc").Args["cFlags"],
+		"-fsanitize=hwaddress")
+	info := result.ModuleProvider(libOld.Module(), SanitizeInfoProvider).(SanitizeInfo)
+	android.AssertArrayString(t, "libold skipped sanitizers", []string{"hwaddress"}, info.MinSdkSkippedSanitizers)
+
+	libNew := result.ModuleForTests("libnew", variant+"_hwasan")
+	android.AssertStringDoesContain(t, "libnew cflags", libNew.Rule("cc").Args["cFlags"],
+		"-fsanitize=hwaddress")
+
+	report := result.SingletonForTests("sanitizer_coverage_report")
+	system := sanitizerCoverageRows(t, android.ContentFromFileRuleForTests(t,
+		report.Output("out/soong/sanitizer_coverage/system.csv")))
+	android.AssertArrayString(t, "system report",
+		[]string{"/system/lib64/libold.so", "libold", "none", "", "hwaddress(min_sdk_version)"},
+		system["/system/lib64/libold.so"])
+}
+
+func TestHwaddressMinSdkVersion(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libold",
+			srcs: ["foo.c"],
+			min_sdk_version: "28",
+			sanitize: {
+				hwaddress: true,
+			},
+		}
+	`
+
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`module "libold".*: sanitize.hwaddress: requires min_sdk_version 29 or later, the module's min_sdk_version is 28`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestHwaddressMinSdkVersionPropagated(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			static_libs: ["libold"],
+			min_sdk_version: "29",
+			sanitize: {
+				hwaddress: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libold",
+			srcs: ["foo.c"],
+			min_sdk_version: "28",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	bin := result.ModuleForTests("bin", "android_arm64_armv8-a_hwasan").Module().(*Module)
+	android.AssertArrayString(t, "bin own sanitizers", []string{"hwaddress"}, bin.sanitize.Properties.OwnSanitizers)
+
+	// The sanitizer propagated to libold isn't checked against its min_sdk_version.
+	libOld := result.ModuleForTests("libold", "android_arm64_armv8-a_static_hwasan").Module().(*Module)
+	android.AssertStringListContains(t, "libold sanitizers", libOld.sanitize.enabledSanitizerNames(), "hwaddress")
+	android.AssertIntEquals(t, "libold own sanitizers", 0, len(libOld.sanitize.Properties.OwnSanitizers))
+}
+
+func TestFuzzerWithTsan(t *testing.T) {
+	t.Run("thread", func(t *testing.T) {
+		bp := `
+			cc_binary {
+				name: "bin_with_fuzzer_tsan",
+				srcs: ["foo.c"],
+				sanitize: {
+					fuzzer: true,
+					thread: true,
+				},
+			}
+		`
+
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.thread: cannot be combined with fuzzer`)).
+			RunTestWithBp(t, bp)
+	})
+
+	t.Run("global thread", func(t *testing.T) {
+		bp := `
+			cc_binary {
+				name: "bin_with_fuzzer",
+				srcs: ["foo.c"],
+				sanitize: {
+					fuzzer: true,
+				},
+			}
+		`
+
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+			android.FixtureAddTextFile("ci/sanitize_modules.json", `{"bin_with_fuzzer": ["thread"]}`),
+			android.FixtureMergeEnv(map[string]string{
+				"SANITIZE_MODULES_FILE": "ci/sanitize_modules.json",
+			}),
+		).RunTestWithBp(t, bp)
+
+		// The globally enabled thread sanitizer is skipped for the fuzzer instead of failing the build.
+		bin := result.ModuleForTests("bin_with_fuzzer", "android_arm64_armv8-a_fuzzer")
+		cflags := bin.Rule("cc").Args["cFlags"]
+		android.AssertStringDoesNotContain(t, "cflags", cflags, "-fsanitize=thread")
+		android.AssertStringDoesContain(t, "cflags", cflags, "-fsanitize=fuzzer-no-link")
+		android.AssertArrayString(t, "incompatible sanitizers", []string{"thread"},
+			bin.Module().(*Module).sanitize.Properties.IncompatibleSanitizers)
+	})
+
+	t.Run("address", func(t *testing.T) {
+		bp := `
+			cc_binary {
+				name: "bin_with_fuzzer_asan",
+				srcs: ["foo.c"],
+				sanitize: {
+					fuzzer: true,
+					address: true,
+				},
+			}
+		`
+
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+			android.FixtureAddFile("foo.c", nil),
+		).RunTestWithBp(t, bp)
+
+		cflags := result.ModuleForTests("bin_with_fuzzer_asan", "android_arm64_armv8-a_asan_fuzzer").Rule("cc").Args["cFlags"]
+		android.AssertStringDoesContain(t, "cflags", cflags, "-fsanitize=address")
+		android.AssertStringDoesContain(t, "cflags", cflags, "-fsanitize=fuzzer-no-link")
+	})
+}
+
+func TestFuzzerOnNonBinary(t *testing.T) {
+	t.Run("shared library", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.fuzzer: is only supported on executables, it can't be enabled on cc_library_shared modules`)).
+			RunTestWithBp(t, `
+				cc_library_shared {
+					name: "libfuzzer_requested",
+					srcs: ["foo.c"],
+					sanitize: {
+						fuzzer: true,
+					},
+				}
+			`)
+	})
+
+	t.Run("shared library with directory defaults", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("dir/foo.c", nil),
+			android.FixtureAddFile("dir/Android.bp", []byte(`
+				cc_sanitize_defaults_dir {
+					name: "dir_sanitize_defaults",
+					sanitize: {
+						fuzzer: true,
+					},
+				}
+
+				cc_library_shared {
+					name: "libfuzzer_default",
+					srcs: ["foo.c"],
+				}
+			`)),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.fuzzer: is only supported on executables, it can't be enabled on cc_library_shared modules`)).
+			RunTest(t)
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).RunTestWithBp(t, `
+			cc_binary {
+				name: "bin_with_fuzzer",
+				srcs: ["foo.c"],
+				sanitize: {
+					fuzzer: true,
+				},
+			}
+		`)
+
+		cflags := result.ModuleForTests("bin_with_fuzzer", "android_arm64_armv8-a_fuzzer").Rule("cc").Args["cFlags"]
+		android.AssertStringDoesContain(t, "cflags", cflags, "-fsanitize=fuzzer-no-link")
+	})
+}
+
+func TestFuzzerNeverLibraryNotSplit(t *testing.T) {
+	bp := `
+		cc_fuzz {
+			name: "fuzz_with_deps",
+			srcs: ["foo.c"],
+			static_libs: ["libfuzzed"],
+		}
+
+		cc_library_static {
+			name: "libfuzzed",
+			srcs: ["foo.c"],
+			static_libs: ["libnofuzzer"],
+		}
+
+		cc_library_static {
+			name: "libnofuzzer",
+			srcs: ["foo.c"],
+			sanitize: {
+				never: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForHwasanFuzzerTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a_static"
+
+	// The sanitized static library is split for the fuzz target.
+	android.AssertStringListContains(t, "libfuzzed variants", result.ModuleVariantsForTests("libfuzzed"),
+		variant+"_hwasan_fuzzer")
+
+	// The library with never: true keeps a single variant per target, which is linked into the
+	// sanitized variants of its dependents, instead of a sanitizer variant compiling the same
+	// objects again.
+	for _, v := range result.ModuleVariantsForTests("libnofuzzer") {
+		if sanitizers := SanitizersFromVariantName(v); len(sanitizers) > 0 {
+			t.Errorf("libnofuzzer should not have sanitizer variants, got %q", v)
+		}
+	}
+	libNoFuzzer := result.ModuleForTests("libnofuzzer", variant).Description("static link").Output.String()
+	fuzzedLink := result.ModuleForTests("fuzz_with_deps", "android_arm64_armv8-a_hwasan_fuzzer").Description("link")
+	android.AssertStringListContains(t, "fuzz target link inputs", fuzzedLink.Implicits.Strings(), libNoFuzzer)
+}
+
+func TestKcfi(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_kcfi",
+			srcs: ["foo.c"],
+			static_libs: [
+				"libstatic",
+				"libcfi",
+			],
+			sanitize: {
+				kcfi: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libstatic",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_static {
+			name: "libcfi",
+			srcs: ["foo.c"],
+			sanitize: {
+				cfi: true,
+			},
+		}
+	`
+
+	prepareForKcfiTest := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureMergeEnv(map[string]string{"LLVM_RELEASE_VERSION": "16.0.0"}),
+	)
+
+	t.Run("flags", func(t *testing.T) {
+		result := prepareForKcfiTest.RunTestWithBp(t, bp)
+
+		variant := "android_arm64_armv8-a"
+		checkCflags := func(name, variant string) {
+			t.Helper()
+			cflags := result.ModuleForTests(name, variant).Rule("cc").Args["cFlags"]
+			android.AssertStringDoesContain(t, name+" cflags", cflags, "-fsanitize=kcfi")
+			android.AssertStringDoesNotContain(t, name+" cflags", cflags, "-flto")
+			android.AssertStringDoesNotContain(t, name+" cflags", cflags, "-fsanitize=cfi")
+		}
+		checkCflags("bin_with_kcfi", variant+"_kcfi")
+		// kcfi is propagated to static dependencies, and replaces cfi in their kcfi variants.
+		checkCflags("libstatic", variant+"_static_kcfi")
+		checkCflags("libcfi", variant+"_static_kcfi")
+
+		android.AssertStringDoesContain(t, "libcfi cflags",
+			result.ModuleForTests("libcfi", variant+"_static_cfi").Rule("cc").Args["cFlags"], "-flto")
+	})
+
+	t.Run("multilib", func(t *testing.T) {
+		result := prepareForKcfiTest.RunTestWithBp(t, `
+			cc_binary {
+				name: "bin_multilib",
+				srcs: ["foo.c"],
+				compile_multilib: "both",
+				sanitize: {
+					kcfi: true,
+				},
+			}
+		`)
+
+		android.AssertStringDoesContain(t, "arm64 cflags",
+			result.ModuleForTests("bin_multilib", "android_arm64_armv8-a_kcfi").Rule("cc").Args["cFlags"],
+			"-fsanitize=kcfi")
+
+		// kcfi isn't supported on 32-bit arm.
+		for _, variant := range result.ModuleVariantsForTests("bin_multilib") {
+			if strings.HasPrefix(variant, "android_arm_") && strings.HasSuffix(variant, "_kcfi") {
+				t.Errorf("unexpected kcfi variant %q", variant)
+			}
+		}
+		android.AssertStringDoesNotContain(t, "arm cflags",
+			result.ModuleForTests("bin_multilib", "android_arm_armv7-a-neon").Rule("cc").Args["cFlags"],
+			"-fsanitize=kcfi")
+	})
+
+	t.Run("combined with cfi", func(t *testing.T) {
+		prepareForKcfiTest.
+			ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+				`sanitize.kcfi: cannot be combined with cfi`)).
+			RunTestWithBp(t, `
+				cc_binary {
+					name: "bin",
+					srcs: ["foo.c"],
+					sanitize: {
+						cfi: true,
+						kcfi: true,
+					},
+				}
+			`)
+	})
+
+	t.Run("clang too old", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForKcfiTest,
+			android.FixtureMergeEnv(map[string]string{"LLVM_RELEASE_VERSION": "15.0.7"}),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`module "bin_with_kcfi".*: sanitizer "kcfi" requires clang 16.0.0 or later, the toolchain is clang 15.0.7`)).
+			RunTestWithBp(t, bp)
+	})
+}
+
+type MemtagNoteType int
+
+const (
+	None MemtagNoteType = iota + 1
+	Sync
+	Async
+)
+
+func (t MemtagNoteType) str() string {
+	switch t {
+	case None:
+		return "none"
+	case Sync:
+		return "sync"
+	case Async:
+		return "async"
+	default:
+		panic("type_note_invalid")
+	}
+}
+
+func checkHasMemtagNote(t *testing.T, m android.TestingModule, expected MemtagNoteType) {
+	t.Helper()
+	if found := memtagNote(t, m); found != expected {
+		t.Errorf("Wrong Memtag note in target %q: found %q, expected %q", m.Module().(*Module).Name(), found.str(), expected.str())
+	}
+}
+
+// memtagNote returns the memtag note linked into the module.
+func memtagNote(t *testing.T, m android.TestingModule) MemtagNoteType {
+	t.Helper()
+	note_async := "note_memtag_heap_async"
+	note_sync := "note_memtag_heap_sync"
+
+	found := None
+	implicits := m.Rule("ld").Implicits
+	for _, lib := range implicits {
+		if strings.Contains(lib.Rel(), note_async) {
+			found = Async
+			break
+		} else if strings.Contains(lib.Rel(), note_sync) {
+			found = Sync
+			break
+		}
+	}
+
+	// Static executables get the note from the driver instead of the note libraries.
+	if m.Module().(*Module).staticBinary() {
+		if found != None {
+			t.Errorf("Memtag note library linked into static executable %q", m.Module().(*Module).Name())
+		}
+		ldFlags := m.Rule("ld").Args["ldFlags"]
+		if strings.Contains(ldFlags, "-fsanitize-memtag-mode=async") {
+			found = Async
+		} else if strings.Contains(ldFlags, "-fsanitize-memtag-mode=sync") {
+			found = Sync
+		}
+	}
+	return found
+}
+
+// The dimensions of the modules defined by prepareForTestWithMemtagHeap, which are named
+// <props>_<kind>_<dir>. Static executables are checked separately.
+var (
+	memtagNoteProps = []string{"no_memtag", "set_memtag", "set_memtag_set_async", "set_memtag_set_sync",
+		"unset_memtag_set_sync", "unset"}
+	memtagNoteKinds = []string{"binary", "test", "benchmark"}
+	memtagNoteDirs  = []string{"no_override", "override_default_async", "override_default_disable",
+		"override_default_sync"}
+)
+
+// memtagNoteMatrix maps the sanitize properties and the kind of the modules defined by
+// prepareForTestWithMemtagHeap to their memtag notes, one for each of memtagNoteDirs.
+type memtagNoteMatrix map[string]map[string][]MemtagNoteType
+
+// checkMemtagNoteMatrix checks the memtag notes of all the modules defined by
+// prepareForTestWithMemtagHeap at once. On failure it prints the full expected and actual matrices
+// side by side, so that a systematic change, e.g. of the default of a kind of module, stands out.
+func checkMemtagNoteMatrix(t *testing.T, ctx *android.TestContext, expected memtagNoteMatrix) {
+	t.Helper()
+	variant := "android_arm64_armv8-a"
+
+	mismatches := 0
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "  %-22s %-10s %-23s | %s\n", "props", "kind", "expected", "actual")
+	for _, props := range memtagNoteProps {
+		diff.WriteString("\n")
+		for _, kind := range memtagNoteKinds {
+			want := expected[props][kind]
+			if len(want) != len(memtagNoteDirs) {
+				t.Fatalf("Expected %d memtag notes for %s_%s, one for each of %s, got %d", len(memtagNoteDirs),
+					props, kind, strings.Join(memtagNoteDirs, ", "), len(want))
+			}
+			got := make([]MemtagNoteType, len(memtagNoteDirs))
+			marker := " "
+			for i, dir := range memtagNoteDirs {
+				got[i] = memtagNote(t, ctx.ModuleForTests(props+"_"+kind+"_"+dir, variant))
+				if got[i] != want[i] {
+					marker = "!"
+					mismatches++
+				}
+			}
+			fmt.Fprintf(&diff, "%s %-22s %-10s %s | %s\n", marker, props, kind,
+				formatMemtagNotes(want), formatMemtagNotes(got))
+		}
+	}
+
+	if mismatches > 0 {
+		t.Errorf("%d wrong memtag notes in the rows marked with !, the columns are %s:\n%s", mismatches,
+			strings.Join(memtagNoteDirs, ", "), diff.String())
+	}
+}
+
+func formatMemtagNotes(notes []MemtagNoteType) string {
+	strs := make([]string, len(notes))
+	for i, note := range notes {
+		strs[i] = fmt.Sprintf("%-5s", note.str())
+	}
+	return strings.Join(strs, " ")
+}
+
+var prepareForTestWithMemtagHeap = android.GroupFixturePreparers(
+	android.FixtureModifyMockFS(func(fs android.MockFS) {
+		templateBp := `
+		cc_test {
+			name: "unset_test_%[1]s",
+			gtest: false,
+		}
+
+		cc_test {
+			name: "no_memtag_test_%[1]s",
+			gtest: false,
+			sanitize: { memtag_heap: false },
+		}
+
+		cc_test {
+			name: "set_memtag_test_%[1]s",
+			gtest: false,
+			sanitize: { memtag_heap: true },
+		}
+
+		cc_test {
+			name: "set_memtag_set_async_test_%[1]s",
+			gtest: false,
+			sanitize: { memtag_heap: true, diag: { memtag_heap: false }  },
+		}
+
+		cc_test {
+			name: "set_memtag_set_sync_test_%[1]s",
+			gtest: false,
+			sanitize: { memtag_heap: true, diag: { memtag_heap: true }  },
+		}
+
+		cc_test {
+			name: "unset_memtag_set_sync_test_%[1]s",
+			gtest: false,
+			sanitize: { diag: { memtag_heap: true }  },
+		}
+
+		cc_benchmark {
+			name: "unset_benchmark_%[1]s",
+		}
+
+		cc_benchmark {
+			name: "no_memtag_benchmark_%[1]s",
+			sanitize: { memtag_heap: false },
+		}
+
+		cc_benchmark {
+			name: "set_memtag_benchmark_%[1]s",
+			sanitize: { memtag_heap: true },
+		}
+
+		cc_benchmark {
+			name: "set_memtag_set_async_benchmark_%[1]s",
+			sanitize: { memtag_heap: true, diag: { memtag_heap: false }  },
+		}
+
+		cc_benchmark {
+			name: "set_memtag_set_sync_benchmark_%[1]s",
+			sanitize: { memtag_heap: true, diag: { memtag_heap: true }  },
+		}
+
+		cc_benchmark {
+			name: "unset_memtag_set_sync_benchmark_%[1]s",
+			sanitize: { diag: { memtag_heap: true }  },
+		}
+
+		cc_binary {
+			name: "unset_binary_%[1]s",
+		}
+
+		cc_binary {
+			name: "no_memtag_binary_%[1]s",
+			sanitize: { memtag_heap: false },
+		}
+
+		cc_binary {
+			name: "set_memtag_binary_%[1]s",
+			sanitize: { memtag_heap: true },
+		}
+
+		cc_binary {
+			name: "set_memtag_set_async_binary_%[1]s",
+			sanitize: { memtag_heap: true, diag: { memtag_heap: false }  },
+		}
+
+		cc_binary {
+			name: "set_memtag_set_sync_binary_%[1]s",
+			sanitize: { memtag_heap: true, diag: { memtag_heap: true }  },
+		}
+
+		cc_binary {
+			name: "unset_memtag_set_sync_binary_%[1]s",
+			sanitize: { diag: { memtag_heap: true }  },
+		}
+
+		cc_binary {
+			name: "unset_static_binary_%[1]s",
+			static_executable: true,
+		}
+
+		cc_binary {
+			name: "no_memtag_static_binary_%[1]s",
+			static_executable: true,
+			sanitize: { memtag_heap: false },
+		}
+
+		cc_binary {
+			name: "set_memtag_static_binary_%[1]s",
+			static_executable: true,
+			sanitize: { memtag_heap: true },
+		}
+
+		cc_binary {
+			name: "set_memtag_set_sync_static_binary_%[1]s",
+			static_executable: true,
+			sanitize: { memtag_heap: true, diag: { memtag_heap: true }  },
+		}
+		`
+		subdirNoOverrideBp := fmt.Sprintf(templateBp, "no_override")
+		subdirOverrideDefaultDisableBp := fmt.Sprintf(templateBp, "override_default_disable")
+		subdirSyncBp := fmt.Sprintf(templateBp, "override_default_sync")
+		subdirAsyncBp := fmt.Sprintf(templateBp, "override_default_async")
+
+		fs.Merge(android.MockFS{
+			"subdir_no_override/Android.bp":              []byte(subdirNoOverrideBp),
+			"subdir_override_default_disable/Android.bp": []byte(subdirOverrideDefaultDisableBp),
+			"subdir_sync/Android.bp":                     []byte(subdirSyncBp),
+			"subdir_async/Android.bp":                    []byte(subdirAsyncBp),
+		})
+	}),
+	android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+		variables.MemtagHeapExcludePaths = []string{"subdir_override_default_disable"}
+		// "subdir_override_default_disable" is covered by both include and override_default_disable paths. override_default_disable wins.
+		variables.MemtagHeapSyncIncludePaths = []string{"subdir_sync", "subdir_override_default_disable"}
+		variables.MemtagHeapAsyncIncludePaths = []string{"subdir_async", "subdir_override_default_disable"}
+		// Opting out inside an include path requires an explicit allowlist entry.
+		variables.MemtagHeapDisableAllowlist = []string{
+			"no_memtag_binary_override_default_async",
+			"no_memtag_binary_override_default_sync",
+			"no_memtag_static_binary_override_default_async",
+			"no_memtag_static_binary_override_default_sync",
+			"no_memtag_test_override_default_async",
+			"no_memtag_test_override_default_sync",
+			"no_memtag_benchmark_override_default_async",
+			"no_memtag_benchmark_override_default_sync",
+		}
+	}),
+)
+
+func TestSanitizeMemtagHeapDisableAllowlist(t *testing.T) {
+	variant := "android_arm64_armv8-a"
+
+	bp := `
+		cc_binary {
+			name: "allowlisted_no_memtag_binary",
+			sanitize: { memtag_heap: false },
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForTestWithMemtagHeap,
+		android.FixtureAddTextFile("subdir_sync/allowlisted/Android.bp", bp),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.MemtagHeapDisableAllowlist = append(variables.MemtagHeapDisableAllowlist,
+				"allowlisted_no_memtag_binary")
+		}),
+	).RunTest(t)
+
+	checkHasMemtagNote(t, result.ModuleForTests("allowlisted_no_memtag_binary", variant), None)
+}
+
+func TestSanitizeMemtagHeapDisableNotAllowlisted(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "unauthorized_no_memtag_binary",
+			sanitize: { memtag_heap: false },
+		}
+	`
+
+	testCases := []struct {
+		dir, includePath, variable string
+	}{
+		{"subdir_sync/unauthorized", "subdir_sync", "MemtagHeapSyncIncludePaths"},
+		{"subdir_async/unauthorized", "subdir_async", "MemtagHeapAsyncIncludePaths"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.variable, func(t *testing.T) {
+			android.GroupFixturePreparers(
+				prepareForCcTest,
+				prepareForTestWithMemtagHeap,
+				android.FixtureAddTextFile(tc.dir+"/Android.bp", bp),
+			).
+				ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+					fmt.Sprintf(`module "unauthorized_no_memtag_binary" disables memtag_heap, but %q is covered by %q in %s; add the module to MemtagHeapDisableAllowlist`,
+						tc.dir, tc.includePath, tc.variable))).
+				RunTest(t)
+		})
+	}
+}
+
+func TestSanitizeMemtagHeap(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForTestWithMemtagHeap,
+	).RunTest(t)
+
+	checkMemtagNoteMatrix(t, result.TestContext, memtagNoteMatrix{
+		// The columns are no_override, override_default_async, override_default_disable and
+		// override_default_sync.
+		"no_memtag": {
+			"binary":    {None, None, None, None},
+			"test":      {None, None, None, None},
+			"benchmark": {None, None, None, None},
+		},
+		"set_memtag": {
+			"binary":    {Async, Async, Async, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+		"set_memtag_set_async": {
+			"binary":    {Async, Async, Async, Async},
+			"test":      {Async, Async, Async, Async},
+			"benchmark": {Async, Async, Async, Async},
+		},
+		"set_memtag_set_sync": {
+			"binary":    {Sync, Sync, Sync, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+		"unset_memtag_set_sync": {
+			// should sanitize: { diag: { memtag: true } } result in Sync instead of None for
+			// no_override and override_default_disable?
+			"binary":    {None, Sync, None, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+		"unset": {
+			"binary":    {None, Async, None, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+	})
+}
+
+func TestSanitizeTestLikeModules(t *testing.T) {
+	bp := `
+		cc_test {
+			name: "test",
+			gtest: false,
+		}
+
+		cc_test_library {
+			name: "test_library",
+			gtest: false,
+		}
+
+		cc_benchmark {
+			name: "benchmark",
+		}
+
+		cc_binary {
+			name: "binary",
+		}
+
+		cc_library {
+			name: "library",
+		}
+
+		cc_test_library {
+			name: "async_test_library",
+			gtest: false,
+			sanitize: { diag: { memtag_heap: false } },
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+	).RunTestWithBp(t, bp)
+
+	testCases := []struct {
+		name, variant string
+		memtag, sync  bool
+	}{
+		{"test", "android_arm64_armv8-a", true, true},
+		{"test_library", "android_arm64_armv8-a_shared", true, true},
+		{"benchmark", "android_arm64_armv8-a", true, true},
+		{"binary", "android_arm64_armv8-a", false, false},
+		{"library", "android_arm64_armv8-a_shared", false, false},
+		// Explicit properties override the defaults of test-like modules.
+		{"async_test_library", "android_arm64_armv8-a_shared", true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := result.ModuleForTests(tc.name, tc.variant).Module().(*Module)
+			s := m.sanitize.Properties.Sanitize
+			android.AssertBoolEquals(t, "memtag_heap", tc.memtag, Bool(s.Memtag_heap))
+			android.AssertBoolEquals(t, "diag.memtag_heap", tc.sync, Bool(s.Diag.Memtag_heap))
+		})
+	}
+}
+
+func TestSanitizeMemtagHeapStaticExecutable(t *testing.T) {
+	variant := "android_arm64_armv8-a"
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForTestWithMemtagHeap,
+	).RunTest(t)
+	ctx := result.TestContext
+
+	checkHasMemtagNote(t, ctx.ModuleForTests("unset_static_binary_no_override", variant), None)
+	checkHasMemtagNote(t, ctx.ModuleForTests("unset_static_binary_override_default_async", variant), Async)
+	checkHasMemtagNote(t, ctx.ModuleForTests("unset_static_binary_override_default_disable", variant), None)
+	checkHasMemtagNote(t, ctx.ModuleForTests("unset_static_binary_override_default_sync", variant), Sync)
+
+	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_static_binary_no_override", variant), None)
+	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_static_binary_override_default_async", variant), None)
+	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_static_binary_override_default_disable", variant), None)
+	checkHasMemtagNote(t, ctx.ModuleForTests("no_memtag_static_binary_override_default_sync", variant), None)
+
+	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_static_binary_no_override", variant), Async)
+	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_static_binary_override_default_async", variant), Async)
+	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_static_binary_override_default_disable", variant), Async)
+	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_static_binary_override_default_sync", variant), Sync)
+
+	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_static_binary_no_override", variant), Sync)
+	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_static_binary_override_default_async", variant), Sync)
+	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_static_binary_override_default_disable", variant), Sync)
+	checkHasMemtagNote(t, ctx.ModuleForTests("set_memtag_set_sync_static_binary_override_default_sync", variant), Sync)
+}
+
+func TestSanitizeMemtagHeapStaticExecutableWithoutLibc(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "static_binary_no_libc",
+			static_executable: true,
+			system_shared_libs: [],
+			stl: "none",
+			sanitize: { memtag_heap: true },
+		}
+	`
+
+	prepareForCcTest.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.memtag_heap: requires the static executable to link "libc" statically`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestSanitizeMemtagHeapClangTooOld(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+		}
+	`
+
+	// memtag_heap is enabled globally, so the module's properties don't enable it.
+	prepareForGlobalMemtagHeap := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"memtag_heap"}
+		}),
+	)
+
+	t.Run("supported", func(t *testing.T) {
+		result := prepareForGlobalMemtagHeap.RunTestWithBp(t, bp)
+		checkHasMemtagNote(t, result.ModuleForTests("bin", "android_arm64_armv8-a"), Async)
+	})
+
+	t.Run("clang too old", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForGlobalMemtagHeap,
+			android.FixtureMergeEnv(map[string]string{"LLVM_RELEASE_VERSION": "12.0.0"}),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`module "bin".*: sanitizer "memtag_heap" requires clang 12.0.5 or later, the toolchain is clang 12.0.0`)).
+			RunTestWithBp(t, bp)
+	})
+}
+
+func TestSanitizeMemtagHeapWithSanitizeDevice(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForTestWithMemtagHeap,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"memtag_heap"}
+		}),
+	).RunTest(t)
+
+	checkMemtagNoteMatrix(t, result.TestContext, memtagNoteMatrix{
+		// The columns are no_override, override_default_async, override_default_disable and
+		// override_default_sync.
+		"no_memtag": {
+			"binary":    {None, None, None, None},
+			"test":      {None, None, None, None},
+			"benchmark": {None, None, None, None},
+		},
+		"set_memtag": {
+			"binary":    {Async, Async, Async, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+		"set_memtag_set_async": {
+			"binary":    {Async, Async, Async, Async},
+			"test":      {Async, Async, Async, Async},
+			"benchmark": {Async, Async, Async, Async},
+		},
+		"set_memtag_set_sync": {
+			"binary":    {Sync, Sync, Sync, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+		"unset_memtag_set_sync": {
+			// should sanitize: { diag: { memtag: true } } result in Sync instead of None for
+			// override_default_disable?
+			"binary":    {Sync, Sync, None, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+		"unset": {
+			"binary":    {Async, Async, None, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+	})
+}
+
+func TestSanitizeMemtagHeapWithSanitizeDeviceDiag(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForTestWithMemtagHeap,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"memtag_heap"}
+			variables.SanitizeDeviceDiag = []string{"memtag_heap"}
+		}),
+	).RunTest(t)
+
+	checkMemtagNoteMatrix(t, result.TestContext, memtagNoteMatrix{
+		// The columns are no_override, override_default_async, override_default_disable and
+		// override_default_sync.
+		"no_memtag": {
+			"binary":    {None, None, None, None},
+			"test":      {None, None, None, None},
+			"benchmark": {None, None, None, None},
+		},
+		"set_memtag": {
+			"binary":    {Sync, Sync, Sync, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+		"set_memtag_set_async": {
+			"binary":    {Async, Async, Async, Async},
+			"test":      {Async, Async, Async, Async},
+			"benchmark": {Async, Async, Async, Async},
+		},
+		"set_memtag_set_sync": {
+			"binary":    {Sync, Sync, Sync, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+		"unset_memtag_set_sync": {
+			// should sanitize: { diag: { memtag: true } } result in Sync instead of None for
+			// override_default_disable?
+			"binary":    {Sync, Sync, None, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+		"unset": {
+			"binary":    {Sync, Sync, None, Sync},
+			"test":      {Sync, Sync, Sync, Sync},
+			"benchmark": {Sync, Sync, Sync, Sync},
+		},
+	})
+}
+
+func TestSanitizersFromVariantName(t *testing.T) {
+	testCases := []struct {
+		variant  string
+		expected []SanitizerType
+	}{
+		{
+			variant:  "android_arm64_armv8-a",
+			expected: nil,
+		},
+		{
+			variant:  "android_arm64_armv8-a_shared",
+			expected: nil,
+		},
+		{
+			variant:  "android_arm64_armv8-a_asan",
+			expected: []SanitizerType{Asan},
+		},
+		{
+			variant:  "android_arm64_armv8-a_static_hwasan",
+			expected: []SanitizerType{Hwasan},
+		},
+		{
+			variant:  "android_arm64_armv8-a_static_memtag_heap",
+			expected: []SanitizerType{Memtag_heap},
+		},
+		{
+			variant:  "android_arm64_armv8-a_asan_fuzzer",
+			expected: []SanitizerType{Asan, Fuzzer},
+		},
+		{
+			variant:  "android_arm64_armv8-a_static_cfi_scs_hwasan",
+			expected: []SanitizerType{cfi, scs, Hwasan},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.variant, func(t *testing.T) {
+			actual := SanitizersFromVariantName(tc.variant)
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestHwaddressDefines(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			static_libs: ["libfoo"],
+			sanitize: {
+				hwaddress: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				hwaddress_defines: ["__HWASAN__=1"],
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a_static"
+	hwasan := result.ModuleForTests("libfoo", variant+"_hwasan").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "hwasan variant cflags", hwasan, "-D__HWASAN__=1")
+
+	base := result.ModuleForTests("libfoo", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "base variant cflags", base, "-D__HWASAN__=1")
+}
+
+func TestDistSanitizerVariant(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			compile_multilib: "64",
+			sanitize: {
+				hwaddress: true,
+			},
+			dists: [
+				{
+					targets: ["my_goal"],
+				},
+				{
+					targets: ["my_goal"],
+					variant: "hwasan",
+					suffix: ".hwasan",
+				},
+			],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a_static"
+	for _, tc := range []struct {
+		variant string
+		dest    string
+	}{
+		{variant, "libfoo.a"},
+		{variant + "_hwasan", "libfoo.hwasan.a"},
+	} {
+		module := result.ModuleForTests("libfoo", tc.variant).Module()
+		output := module.(*Module).OutputFile().Path().String()
+		entries := android.AndroidMkEntriesForTest(t, result.TestContext, module)[0]
+		android.AssertDeepEquals(t, tc.variant+" dists", []string{
+			".PHONY: my_goal\n",
+			fmt.Sprintf("$(call dist-for-goals,my_goal,%s:%s)\n", output, tc.dest),
+		}, entries.GetDistForGoals(module))
+	}
+
+	t.Run("missing variant", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`dists\[0\]\.variant: variant "asan" doesn't exist in this build`,
+		)).RunTestWithBp(t, `
+			cc_library_static {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				compile_multilib: "64",
+				sanitize: {
+					hwaddress: true,
+				},
+				dists: [
+					{
+						targets: ["my_goal"],
+						variant: "asan",
+					},
+				],
+			}
+		`)
+	})
+}
+
+func TestDistSanitizerVariantHiddenDefault(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			compile_multilib: "64",
+			sanitize: {
+				address: true,
+			},
+			dists: [
+				{
+					targets: ["my_goal"],
+				},
+				{
+					targets: ["my_goal"],
+					variant: "asan",
+					suffix: ".asan",
+				},
+			],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	// The variant without asan is hidden from Make, so the asan variant is the one installed by
+	// default and emits both dist entries.
+	module := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static_asan").Module()
+	output := module.(*Module).OutputFile().Path().String()
+	entries := android.AndroidMkEntriesForTest(t, result.TestContext, module)[0]
+	android.AssertDeepEquals(t, "asan variant dists", []string{
+		".PHONY: my_goal\n",
+		fmt.Sprintf("$(call dist-for-goals,my_goal,%s:libfoo.a)\n", output),
+		".PHONY: my_goal\n",
+		fmt.Sprintf("$(call dist-for-goals,my_goal,%s:libfoo.asan.a)\n", output),
+	}, entries.GetDistForGoals(module))
+
+	t.Run("hidden variant", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`dists\[0\]\.variant: variant "asan" doesn't exist in this build or is hidden from Make`,
+		)).RunTestWithBp(t, `
+			cc_binary {
+				name: "bin",
+				srcs: ["foo.c"],
+				compile_multilib: "64",
+				static_libs: ["libfoo"],
+				sanitize: {
+					address: true,
+				},
+			}
+
+			cc_library_static {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				compile_multilib: "64",
+				dists: [
+					{
+						targets: ["my_goal"],
+						variant: "asan",
+					},
+				],
+			}
+		`)
+	})
+}
+
+func TestMiscUndefinedExclude(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				misc_undefined_exclude: ["array-bounds"],
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.BoundSanitizerIncludePaths = []string{"."}
+		}),
+	).RunTestWithBp(t, bp)
+
+	cFlags := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static").Rule("cc").Args["cFlags"]
+	enable := strings.Index(cFlags, "-fsanitize=bounds")
+	exclude := strings.Index(cFlags, "-fno-sanitize=array-bounds")
+	if enable == -1 || exclude == -1 || exclude < enable {
+		t.Errorf("expected -fsanitize=bounds followed by -fno-sanitize=array-bounds, got %q", cFlags)
+	}
+
+	t.Run("unknown check", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`unknown undefined behavior check "array-bound"`,
+		)).RunTestWithBp(t, `
+			cc_library_static {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				sanitize: {
+					misc_undefined_exclude: ["array-bound"],
+				},
+			}
+		`)
+	})
+}
+
+func TestUbsanVptrWithoutRtti(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libnortti",
+			srcs: ["foo.cpp"],
+			sanitize: {
+				undefined: true,
+			},
+		}
+
+		cc_library_static {
+			name: "librtti",
+			srcs: ["foo.cpp"],
+			rtti: true,
+			sanitize: {
+				undefined: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libc_only",
+			srcs: ["foo.c"],
+			sanitize: {
+				undefined: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.cpp", nil),
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a_static"
+	noRtti := result.ModuleForTests("libnortti", variant)
+	android.AssertStringDoesContain(t, "cflags without rtti", noRtti.Rule("cc").Args["cFlags"], "-fno-sanitize=vptr")
+	android.AssertArrayString(t, "warnings without rtti",
+		[]string{"sanitize: the vptr check of undefined is disabled because the module is built without rtti"},
+		noRtti.Module().(*Module).sanitize.Properties.Warnings)
+
+	rtti := result.ModuleForTests("librtti", variant)
+	android.AssertStringDoesNotContain(t, "cflags with rtti", rtti.Rule("cc").Args["cFlags"], "-fno-sanitize=vptr")
+	android.AssertIntEquals(t, "warnings with rtti", 0, len(rtti.Module().(*Module).sanitize.Properties.Warnings))
+
+	// -fno-rtti is only passed to C++ sources, C sources keep the vptr check.
+	cOnly := result.ModuleForTests("libc_only", variant)
+	android.AssertStringDoesNotContain(t, "cflags of C only module", cOnly.Rule("cc").Args["cFlags"], "-fno-sanitize=vptr")
+	android.AssertIntEquals(t, "warnings of C only module", 0, len(cOnly.Module().(*Module).sanitize.Properties.Warnings))
+
+	t.Run("explicit vptr", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.cpp", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.misc_undefined: vptr requires rtti: true`,
+		)).RunTestWithBp(t, `
+			cc_library_static {
+				name: "libfoo",
+				srcs: ["foo.cpp"],
+				sanitize: {
+					misc_undefined: ["vptr"],
+				},
+			}
+		`)
+	})
+}
+
+func TestUbsanMaxErrors(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			host_supported: true,
+			srcs: ["foo.c"],
+			sanitize: {
+				undefined: true,
+				diag: {
+					undefined: true,
+				},
+				max_errors: 100,
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_max_errors",
+			srcs: ["foo.c"],
+			sanitize: {
+				undefined: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	bin := result.ModuleForTests("bin", "android_arm64_armv8-a")
+	launcher := android.ContentFromFileRuleForTests(t, bin.Output("bin.ubsan.sh"))
+	android.AssertStringDoesContain(t, "launcher", launcher, "#!/system/bin/sh\n")
+	android.AssertStringDoesContain(t, "launcher", launcher,
+		`export UBSAN_OPTIONS="halt_on_error=0${UBSAN_OPTIONS:+:$UBSAN_OPTIONS}"`)
+	android.AssertStringDoesContain(t, "launcher", launcher, `"${0%/*}/bin" "$@"`)
+	android.AssertStringDoesContain(t, "launcher", launcher, "if [ $n -ge 100 ]; then")
+
+	hostVariant := result.Config.BuildOSTarget.String()
+	hostBin := result.ModuleForTests("bin", hostVariant)
+	hostLauncher := android.ContentFromFileRuleForTests(t, hostBin.Output("bin.ubsan.sh"))
+	android.AssertStringDoesContain(t, "host launcher", hostLauncher, "#!/bin/bash\n")
+	android.AssertStringDoesContain(t, "host cflags", hostBin.Rule("cc").Args["cFlags"],
+		"-fsanitize-recover=undefined,integer")
+
+	noMaxErrors := result.ModuleForTests("bin_no_max_errors", "android_arm64_armv8-a")
+	if noMaxErrors.MaybeOutput("bin_no_max_errors.ubsan.sh").Rule != nil {
+		t.Errorf("expected no launcher for bin_no_max_errors")
+	}
+
+	t.Run("not an executable", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.max_errors: is only supported on executables`,
+		)).RunTestWithBp(t, `
+			cc_library_shared {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				sanitize: {
+					undefined: true,
+					max_errors: 100,
+				},
+			}
+		`)
+	})
+}
+
+func TestAsanMusl(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			host_supported: true,
+			srcs: ["foo.c"],
+			shared_libs: ["libshared"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libshared",
+			host_supported: true,
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.PrepareForTestWithHostMusl,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := result.Config.BuildOSTarget.String()
+	android.AssertStringEquals(t, "host variant", "linux_musl_x86_64", variant)
+
+	runtime := result.ModuleForTests("libclang_rt.asan_musl_static", variant+"_static").
+		Output("libclang_rt.asan_musl_static.a").Output.String()
+
+	bin := result.ModuleForTests("bin", variant+"_asan")
+	android.AssertStringDoesContain(t, "bin cflags", bin.Rule("cc").Args["cFlags"], "-fsanitize=address")
+
+	binLink := bin.Rule("ld")
+	libFlags := binLink.Args["libFlags"]
+	wholeArchive := strings.Index(libFlags, "-Wl,--whole-archive")
+	if wholeArchive == -1 || strings.Index(libFlags, runtime) < wholeArchive {
+		t.Errorf("expected bin to link %q as a whole static library, got %q", runtime, libFlags)
+	}
+	android.AssertStringDoesContain(t, "bin ldflags", binLink.Args["ldFlags"], "-fno-sanitize-link-runtime")
+	android.AssertStringDoesNotContain(t, "bin ldflags", binLink.Args["ldFlags"], "-Wl,--no-as-needed")
+
+	libShared := result.ModuleForTests("libshared", variant+"_shared_asan")
+	android.AssertStringDoesNotContain(t, "libshared libflags", libShared.Rule("ld").Args["libFlags"], runtime)
+
+	t.Run("missing runtime", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.PrepareForTestWithHostMusl,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.address on linux_musl requires the "libclang_rt.asan_musl_static" runtime library`,
+		)).RunTestWithBp(t, bp)
+	})
+}
+
+func TestSanitizerRequestReason(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_global",
+			srcs: ["foo.c"],
+			static_libs: ["libstatic"],
+		}
+
+		cc_binary {
+			name: "bin_explicit",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libstatic",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: false,
+			},
+		}
+
+		cc_library_static {
+			name: "libdep",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_shared {
+			name: "libshared",
+			srcs: ["foo.c"],
+			static_libs: ["libdep"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureAddFile("dir/Android.bp", []byte(`
+			cc_sanitize_defaults_dir {
+				name: "dir_sanitize_defaults",
+				sanitize: {
+					address: true,
+				},
+			}
+
+			cc_binary {
+				name: "bin_dir_defaults",
+				srcs: ["foo.c"],
+			}
+		`)),
+		android.FixtureAddFile("dir/foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"address"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	module := func(name, variant string) *Module {
+		return result.ModuleForTests(name, "android_arm64_armv8-a"+variant).Module().(*Module)
+	}
+
+	android.AssertStringEquals(t, "global sanitizer reason",
+		"the global sanitizer configuration, e.g. SANITIZE_TARGET or SANITIZE_HOST, enables address for it",
+		sanitizerRequestReason(module("bin_global", "_asan"), Asan))
+	android.AssertStringEquals(t, "explicit sanitizer reason",
+		"its sanitize properties enable address",
+		sanitizerRequestReason(module("bin_explicit", "_asan"), Asan))
+	android.AssertStringEquals(t, "directory defaults reason",
+		"the cc_sanitize_defaults_dir of its directory enables address",
+		sanitizerRequestReason(module("bin_dir_defaults", "_asan"), Asan))
+	android.AssertStringEquals(t, "dependency reason",
+		"it is a dependency of a module with address enabled",
+		sanitizerRequestReason(module("libstatic", "_static"), Asan))
+
+	// Static libs are split into non-sanitized and sanitized variants, shared libs only have the
+	// sanitized variant.
+	splits := sanitizerSplitsForConfig(result.Config)
+	libdep, _ := splits.get(Asan, module("libdep", "_static"))
+	android.AssertArrayString(t, "libdep variations", []string{"", "asan"}, libdep)
+	libshared, _ := splits.get(Asan, module("libshared", "_shared_asan"))
+	android.AssertArrayString(t, "libshared variations", []string{"asan"}, libshared)
+}
+
+func TestSanitizeOrder(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_default",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+				misc_undefined: ["bounds"],
+			},
+		}
+
+		cc_binary {
+			name: "bin_address_first",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+				misc_undefined: ["bounds"],
+				order: ["address", "undefined"],
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	cFlags := func(name string) []string {
+		return strings.Split(result.ModuleForTests(name, "android_arm64_armv8-a_asan").Rule("cc").Args["cFlags"], " ")
+	}
+
+	android.AssertStringListContains(t, "default order", cFlags("bin_default"), "-fsanitize=bounds,address")
+	android.AssertStringListContains(t, "sanitize.order", cFlags("bin_address_first"), "-fsanitize=address,bounds")
+
+	t.Run("unknown sanitizer", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.order: unknown sanitizer "asan"`,
+		)).RunTestWithBp(t, `
+			cc_binary {
+				name: "bin",
+				srcs: ["foo.c"],
+				sanitize: {
+					order: ["asan", "undefined"],
+				},
+			}
+		`)
+	})
+}
+
+func TestCfiModes(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			sanitize: {
+				cfi_modes: ["cfi-icall"],
+				diag: {
+					cfi: true,
+				},
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	bin := result.ModuleForTests("bin", "android_arm64_armv8-a_cfi")
+	cFlags := strings.Split(bin.Rule("cc").Args["cFlags"], " ")
+	ldFlags := strings.Split(bin.Rule("ld").Args["ldFlags"], " ")
+
+	android.AssertStringListContains(t, "cflags", cFlags, "-fsanitize=cfi-icall")
+	android.AssertStringListDoesNotContain(t, "cflags", cFlags, "-fsanitize=cfi")
+	android.AssertStringListContains(t, "cflags", cFlags, "-fsanitize-cfi-cross-dso")
+	android.AssertStringListContains(t, "cflags", cFlags,
+		"-fsanitize-ignorelist=external/compiler-rt/lib/cfi/cfi_blocklist.txt")
+	android.AssertStringListContains(t, "cflags", cFlags, "-fno-sanitize-trap=cfi-icall")
+
+	android.AssertStringListContains(t, "ldflags", ldFlags, "-fsanitize=cfi-icall")
+	android.AssertStringListDoesNotContain(t, "ldflags", ldFlags, "-fsanitize=cfi")
+	android.AssertStringListContains(t, "ldflags", ldFlags, "-fsanitize-cfi-cross-dso")
+
+	for _, tc := range []struct {
+		name, sanitize, err string
+	}{
+		{
+			name:     "with cfi",
+			sanitize: `cfi: true, cfi_modes: ["cfi-icall"]`,
+			err:      `sanitize.cfi_modes: cannot be combined with cfi: true`,
+		},
+		{
+			name:     "unknown mode",
+			sanitize: `cfi_modes: ["cfi-call"]`,
+			err:      `sanitize.cfi_modes: unknown cfi scheme "cfi-call"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			android.GroupFixturePreparers(
+				prepareForCcTest,
+				android.FixtureAddFile("foo.c", nil),
+			).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+				regexp.QuoteMeta(tc.err),
+			)).RunTestWithBp(t, `
+				cc_binary {
+					name: "bin",
+					srcs: ["foo.c"],
+					sanitize: {`+tc.sanitize+`},
+				}
+			`)
+		})
+	}
+}
+
+func TestSanitizeRuntimeOptional(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_runtime_optional",
+			srcs: ["foo.c"],
+			shared_libs: ["libshared"],
+			sanitize: {
+				address: true,
+				runtime_optional: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_runtime_required",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libshared",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a_asan"
+
+	link := result.ModuleForTests("bin_runtime_optional", variant).Rule("ld")
+	libFlags := link.Args["libFlags"]
+	asNeeded := strings.Index(libFlags, "-Wl,--as-needed")
+	noAsNeeded := strings.Index(libFlags, "-Wl,--no-as-needed")
+	if asNeeded == -1 || noAsNeeded < asNeeded {
+		t.Fatalf("expected an -Wl,--as-needed range in libflags, got %q", libFlags)
+	}
+	asNeededRange := libFlags[asNeeded:noAsNeeded]
+	android.AssertStringDoesContain(t, "as-needed libflags", asNeededRange, "libclang_rt.asan.so")
+	android.AssertStringDoesNotContain(t, "as-needed libflags", asNeededRange, "libshared.so")
+	android.AssertStringDoesContain(t, "bin_runtime_optional libflags", libFlags, "libshared.so")
+	android.AssertStringDoesNotContain(t, "bin_runtime_optional ldflags", link.Args["ldFlags"], "as-needed")
+
+	link = result.ModuleForTests("bin_runtime_required", variant).Rule("ld")
+	android.AssertStringDoesContain(t, "bin_runtime_required libflags", link.Args["libFlags"], "libclang_rt.asan.so")
+	android.AssertStringDoesNotContain(t, "bin_runtime_required libflags", link.Args["libFlags"], "-Wl,--as-needed")
+
+	t.Run("static executable", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureAddFile("foo.c", nil),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.runtime_optional: cannot be used with static executables`,
+		)).RunTestWithBp(t, `
+			cc_binary {
+				name: "bin",
+				srcs: ["foo.c"],
+				static_executable: true,
+				sanitize: {
+					undefined: true,
+					runtime_optional: true,
+				},
+			}
+		`)
+	})
+}
+
+func TestExportSanitizeFlags(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_hwasan",
+			srcs: ["foo.c"],
+			static_libs: ["libfoo"],
+			sanitize: {
+				hwaddress: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_hwasan",
+			srcs: ["foo.c"],
+			static_libs: ["libfoo"],
+		}
+
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				export_sanitize_flags: {
+					address: ["-DUSES_ASAN_LIBFOO"],
+					hwaddress: ["-fno-builtin-memcpy"],
+				},
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("foo.c", nil),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	hwasan := result.ModuleForTests("bin_with_hwasan", variant+"_hwasan").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "bin_with_hwasan cflags", hwasan, "-fno-builtin-memcpy")
+	android.AssertStringDoesNotContain(t, "bin_with_hwasan cflags", hwasan, "-DUSES_ASAN_LIBFOO")
+
+	base := result.ModuleForTests("bin_no_hwasan", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "bin_no_hwasan cflags", base, "-fno-builtin-memcpy")
+
+	// The library itself isn't compiled with the flags it exports.
+	lib := result.ModuleForTests("libfoo", variant+"_static_hwasan").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "libfoo cflags", lib, "-fno-builtin-memcpy")
+}
+
+func TestSanitizeModulesFile(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_forced",
+			srcs: ["foo.c"],
+		}
+
+		cc_binary {
+			name: "bin_plain",
+			srcs: ["foo.c"],
+		}
+	`
+
+	prepare := func(contents string) android.FixturePreparer {
+		return android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+			android.FixtureAddFile("foo.c", nil),
+			android.FixtureAddTextFile("ci/sanitize_modules.json", contents),
+			android.FixtureMergeEnv(map[string]string{
+				"SANITIZE_MODULES_FILE": "ci/sanitize_modules.json",
+			}),
+		)
+	}
+
+	result := prepare(`{"bin_forced": ["address"]}`).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	forced := result.ModuleForTests("bin_forced", variant+"_asan").Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "bin_forced cflags", forced, "-fsanitize=address")
+
+	plain := result.ModuleForTests("bin_plain", variant).Rule("cc").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "bin_plain cflags", plain, "-fsanitize=address")
+
+	t.Run("invalid", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepare(`["bin_forced"]`),
+			android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+				ctx.RegisterSingletonType("sanitize_modules_file", sanitizeModulesFileFactory)
+			}),
+		).ExtendWithErrorHandler(android.FixtureCustomErrorHandler(func(t *testing.T, result *android.TestResult) {
+			// The error is reported once for the build, not by every module.
+			android.AssertIntEquals(t, "errors", 1, len(result.Errs))
+			android.AssertStringDoesContain(t, "error", result.Errs[0].Error(),
+				`failed to parse SANITIZE_MODULES_FILE "ci/sanitize_modules.json"`)
+		})).RunTestWithBp(t, bp)
+	})
+}
+
+func TestPrebuiltPartialSanitizedSrcs(t *testing.T) {
+	prebuilt := `
+		cc_prebuilt_library_static {
+			name: "libprebuilt",
+			sanitized: {
+				none: {
+					srcs: ["libprebuilt.a"],
+				},
+				hwaddress: {
+					srcs: ["libprebuilt.hwasan.a"],
+				},
+			},
+		}
+	`
+	preparer := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureAddFile("libprebuilt.a", nil),
+		android.FixtureAddFile("libprebuilt.hwasan.a", nil),
+	)
+
+	t.Run("available", func(t *testing.T) {
+		result := preparer.RunTestWithBp(t, prebuilt+`
+			cc_binary {
+				name: "bin",
+				srcs: ["foo.c"],
+				static_libs: ["libprebuilt"],
+				sanitize: {
+					hwaddress: true,
+				},
+			}
+		`)
+		lib := result.ModuleForTests("libprebuilt", "android_arm64_armv8-a_static_hwasan").Module().(*Module)
+		android.AssertStringEquals(t, "libprebuilt hwasan output", "libprebuilt.hwasan.a",
+			lib.OutputFile().Path().Base())
+	})
+
+	// The variants of sanitizers the prebuilt doesn't provide sources for use the plain sources.
+	t.Run("fallback", func(t *testing.T) {
+		result := preparer.RunTestWithBp(t, prebuilt+`
+			cc_binary {
+				name: "bin",
+				srcs: ["foo.c"],
+				static_libs: ["libprebuilt"],
+				sanitize: {
+					address: true,
+				},
+			}
+		`)
+		lib := result.ModuleForTests("libprebuilt", "android_arm64_armv8-a_static_asan").Module().(*Module)
+		android.AssertStringEquals(t, "libprebuilt asan output", "libprebuilt.a",
+			lib.OutputFile().Path().Base())
+	})
 }
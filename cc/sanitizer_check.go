@@ -0,0 +1,296 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+func init() {
+	android.RegisterModuleType("cc_sanitizer_check", SanitizerCheckFactory)
+}
+
+var (
+	sanitizerCheckCompile = pctx.AndroidStaticRule("sanitizerCheckCompile",
+		blueprint.RuleParams{
+			Command:     "${config.ClangBin}/clang++ -target $triple $sanitizeFlags -c $in -o $out",
+			CommandDeps: []string{"${config.ClangBin}/clang++"},
+		},
+		"triple", "sanitizeFlags")
+
+	// Links the object compiled with a sanitizer against its runtime library into a shared object
+	// without the system libraries, which is enough for the linker to reject a runtime library that
+	// is missing or built for a different architecture.
+	sanitizerCheckLink = pctx.AndroidStaticRule("sanitizerCheckLink",
+		blueprint.RuleParams{
+			Command: "${config.ClangBin}/clang++ -target $triple -fuse-ld=lld -shared -nostdlib $in -o $out || " +
+				"(echo \"cc_sanitizer_check: the $sanitizer sanitizer failed to link against its runtime " +
+				"library $runtime for $triple\" >&2; exit 1)",
+			CommandDeps: []string{"${config.ClangBin}/clang++"},
+		},
+		"triple", "sanitizer", "runtime")
+)
+
+const sanitizerCheckSource = "int main() { return 0; }\n"
+
+// sanitizerCheckSpec describes how cc_sanitizer_check verifies a single sanitizer.
+type sanitizerCheckSpec struct {
+	// The flags enabling the sanitizer when compiling the generated source.
+	flags []string
+	// Returns the runtime library required by the sanitizer. Nil if the sanitizer has no runtime.
+	runtime func(t config.Toolchain) string
+	// Whether the runtime library is linked statically.
+	staticRuntime bool
+	// Returns true if the sanitizer can be enabled for the architecture.
+	supported func(arch android.ArchType, t config.Toolchain) bool
+}
+
+// sanitizerCheckSpecs maps the names used in SANITIZE_TARGET to their sanitizerCheckSpec.
+var sanitizerCheckSpecs = map[string]sanitizerCheckSpec{
+	"address": {
+		flags:   []string{"-fsanitize=address"},
+		runtime: config.AddressSanitizerRuntimeLibrary,
+	},
+	"hwaddress": {
+		flags:   []string{"-fsanitize=hwaddress"},
+		runtime: config.HWAddressSanitizerRuntimeLibrary,
+		// HWASan requires AArch64 hardware feature (top-byte-ignore).
+		supported: func(arch android.ArchType, t config.Toolchain) bool {
+			return arch == android.Arm64
+		},
+	},
+	"thread": {
+		flags:   []string{"-fsanitize=thread"},
+		runtime: config.ThreadSanitizerRuntimeLibrary,
+		// TSAN is not supported on 32-bit architectures.
+		supported: func(arch android.ArchType, t config.Toolchain) bool {
+			return t.Is64Bit()
+		},
+	},
+	"undefined": {
+		flags:   []string{"-fsanitize=undefined"},
+		runtime: config.UndefinedBehaviorSanitizerRuntimeLibrary,
+	},
+	"integer_overflow": {
+		flags:         []string{"-fsanitize=signed-integer-overflow,unsigned-integer-overflow", "-fsanitize-minimal-runtime"},
+		runtime:       config.UndefinedBehaviorSanitizerMinimalRuntimeLibrary,
+		staticRuntime: true,
+	},
+	"fuzzer": {
+		flags:         []string{"-fsanitize=fuzzer-no-link"},
+		runtime:       config.LibFuzzerRuntimeLibrary,
+		staticRuntime: true,
+	},
+	"cfi": {
+		flags: []string{"-flto", "-fvisibility=default", "-fsanitize=cfi"},
+	},
+	"kcfi": {
+		flags: []string{"-fsanitize=kcfi"},
+	},
+}
+
+// sanitizerMinClangVersions maps the names used in SANITIZE_TARGET to the first clang release
+// version supporting the sanitizer, for the sanitizers that not every supported release does.
+var sanitizerMinClangVersions = map[string]string{
+	"kcfi":        "16.0.0",
+	"memtag_heap": "12.0.5",
+}
+
+// clangSupportsSanitizer returns false and the first clang release version supporting the
+// sanitizer if the clang toolchain of the build is older.
+func clangSupportsSanitizer(cfg android.Config, sanitizer string) (bool, string) {
+	min, ok := sanitizerMinClangVersions[sanitizer]
+	if !ok {
+		return true, ""
+	}
+	return clangVersionAtLeast(config.ClangReleaseVersion(cfg), min), min
+}
+
+// clangVersionAtLeast returns true if the dotted release version is the same as or later than min.
+// Versions that can't be parsed, e.g. of a locally built toolchain, are assumed to be recent enough.
+func clangVersionAtLeast(version, min string) bool {
+	v := strings.Split(version, ".")
+	m := strings.Split(min, ".")
+	for i := range m {
+		want, _ := strconv.Atoi(m[i])
+		got := 0
+		if i < len(v) {
+			var err error
+			if got, err = strconv.Atoi(v[i]); err != nil {
+				return true
+			}
+		}
+		if got != want {
+			return got > want
+		}
+	}
+	return true
+}
+
+type sanitizerCheckProperties struct {
+	// List of sanitizers to check, using the names accepted by SANITIZE_TARGET, e.g. "address".
+	// Defaults to the sanitizers in SANITIZE_TARGET that cc_sanitizer_check knows how to check.
+	Sanitizers []string
+}
+
+type sanitizerCheckDepTag struct {
+	blueprint.BaseDependencyTag
+	sanitizer string
+}
+
+type sanitizerCheck struct {
+	android.ModuleBase
+
+	properties sanitizerCheckProperties
+
+	stamp android.WritablePath
+}
+
+// cc_sanitizer_check verifies that the sanitizer runtimes and toolchain support needed by a set of
+// sanitizers are available for every device architecture. For each sanitizer and architecture it
+// compiles a generated source with the sanitizer enabled and links it against the sanitizer
+// runtime library, failing with a targeted error if the runtime is missing or doesn't link. It
+// produces no installable output, only a stamp file.
+func SanitizerCheckFactory() android.Module {
+	module := &sanitizerCheck{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.DeviceSupported, android.MultilibBoth)
+	return module
+}
+
+// sanitizers returns the sanitizers checked by this module, and whether they were set explicitly.
+func (s *sanitizerCheck) sanitizers(ctx android.BaseModuleContext) ([]string, bool) {
+	if s.properties.Sanitizers != nil {
+		return s.properties.Sanitizers, true
+	}
+	var sanitizers []string
+	for _, sanitizer := range ctx.Config().SanitizeDevice() {
+		if _, ok := sanitizerCheckSpecs[sanitizer]; ok {
+			sanitizers = append(sanitizers, sanitizer)
+		}
+	}
+	return sanitizers, false
+}
+
+func (s *sanitizerCheck) DepsMutator(ctx android.BottomUpMutatorContext) {
+	sanitizers, explicit := s.sanitizers(ctx)
+	toolchain := config.FindToolchainWithContext(ctx)
+	for _, sanitizer := range android.FirstUniqueStrings(sanitizers) {
+		spec, ok := sanitizerCheckSpecs[sanitizer]
+		if !ok {
+			if explicit {
+				ctx.PropertyErrorf("sanitizers", "unknown sanitizer %q", sanitizer)
+			}
+			continue
+		}
+		if ok, min := clangSupportsSanitizer(ctx.Config(), sanitizer); !ok {
+			ctx.ModuleErrorf("sanitizer %q requires clang %s or later, the toolchain is clang %s",
+				sanitizer, min, config.ClangReleaseVersion(ctx.Config()))
+			continue
+		}
+		if spec.runtime == nil || (spec.supported != nil && !spec.supported(ctx.Arch().ArchType, toolchain)) {
+			continue
+		}
+		runtime, err := sanitizerRuntimeModule(ctx.Config(), ctx.OtherModuleExists, spec.runtime(toolchain))
+		if err != nil {
+			ctx.ModuleErrorf("%s", err)
+			continue
+		}
+		if !ctx.OtherModuleExists(runtime) {
+			ctx.ModuleErrorf("sanitizer %q requires runtime library %q, which is not defined for %s",
+				sanitizer, runtime, ctx.Arch().ArchType)
+			continue
+		}
+		link := "shared"
+		if spec.staticRuntime {
+			link = "static"
+		}
+		variations := append(ctx.Target().Variations(),
+			blueprint.Variation{Mutator: "link", Variation: link},
+			blueprint.Variation{Mutator: "image", Variation: android.CoreVariation})
+		ctx.AddFarVariationDependencies(variations, sanitizerCheckDepTag{sanitizer: sanitizer}, runtime)
+	}
+}
+
+func (s *sanitizerCheck) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	sanitizers, _ := s.sanitizers(ctx)
+	toolchain := config.FindToolchainWithContext(ctx)
+
+	src := android.PathForModuleGen(ctx, "sanitizer_check.cpp")
+	android.WriteFileRule(ctx, src, sanitizerCheckSource)
+
+	runtimes := make(map[string]android.Path)
+	ctx.VisitDirectDeps(func(dep android.Module) {
+		tag, ok := ctx.OtherModuleDependencyTag(dep).(sanitizerCheckDepTag)
+		if !ok {
+			return
+		}
+		if linkable, ok := dep.(LinkableInterface); ok && linkable.OutputFile().Valid() {
+			runtimes[tag.sanitizer] = linkable.OutputFile().Path()
+		}
+	})
+
+	var outputs android.Paths
+	for _, sanitizer := range android.FirstUniqueStrings(sanitizers) {
+		spec, ok := sanitizerCheckSpecs[sanitizer]
+		if !ok || (spec.supported != nil && !spec.supported(ctx.Arch().ArchType, toolchain)) {
+			continue
+		}
+		obj := android.PathForModuleObj(ctx, sanitizer+".o")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        sanitizerCheckCompile,
+			Description: "sanitizer check " + sanitizer,
+			Input:       src,
+			Output:      obj,
+			Args: map[string]string{
+				"triple":        toolchain.ClangTriple(),
+				"sanitizeFlags": strings.Join(spec.flags, " "),
+			},
+		})
+
+		runtime, ok := runtimes[sanitizer]
+		if !ok {
+			outputs = append(outputs, obj)
+			continue
+		}
+		linked := android.PathForModuleObj(ctx, sanitizer+".so")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        sanitizerCheckLink,
+			Description: "sanitizer check link " + sanitizer,
+			Inputs:      android.Paths{obj, runtime},
+			Output:      linked,
+			Args: map[string]string{
+				"triple":    toolchain.ClangTriple(),
+				"sanitizer": sanitizer,
+				"runtime":   runtime.Base(),
+			},
+		})
+		outputs = append(outputs, linked)
+	}
+
+	s.stamp = android.PathForModuleOut(ctx, "sanitizer_check.stamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:      android.Touch,
+		Output:    s.stamp,
+		Implicits: outputs,
+	})
+	ctx.CheckbuildFile(s.stamp)
+}
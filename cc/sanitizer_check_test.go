@@ -0,0 +1,127 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestSanitizerCheck(t *testing.T) {
+	bp := `
+		cc_sanitizer_check {
+			name: "sanitizer_check",
+			sanitizers: ["address", "hwaddress"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+	).RunTestWithBp(t, bp)
+
+	arm64 := result.ModuleForTests("sanitizer_check", "android_arm64_armv8-a")
+	arm64.Output("obj/address.o")
+	arm64.Output("obj/hwaddress.o")
+
+	// The asan object is linked against the resolved asan runtime.
+	link := arm64.Output("obj/address.so")
+	android.AssertStringListContains(t, "link inputs", link.Inputs.Strings(),
+		arm64.Output("obj/address.o").Output.String())
+	runtime := result.ModuleForTests("libclang_rt.asan", "android_arm64_armv8-a_shared").Module().(*Module)
+	android.AssertStringListContains(t, "link inputs", link.Inputs.Strings(),
+		runtime.OutputFile().Path().String())
+	android.AssertStringEquals(t, "link failure sanitizer", "address", link.Args["sanitizer"])
+	android.AssertStringEquals(t, "link failure runtime", runtime.OutputFile().Path().Base(), link.Args["runtime"])
+
+	stamp := arm64.Output("sanitizer_check.stamp")
+	android.AssertStringListContains(t, "stamp depends on the asan link",
+		stamp.Implicits.Strings(), link.Output.String())
+
+	// HWASan is only supported on arm64, so it isn't checked for arm.
+	arm := result.ModuleForTests("sanitizer_check", "android_arm_armv7-a-neon")
+	arm.Output("obj/address.o")
+	if o := arm.MaybeOutput("obj/hwaddress.o"); o.Rule != nil {
+		t.Errorf("expected hwaddress not to be checked for arm")
+	}
+}
+
+func TestSanitizerCheckDefaultsToSanitizeDevice(t *testing.T) {
+	bp := `
+		cc_sanitizer_check {
+			name: "sanitizer_check",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"hwaddress", "memtag_heap"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	arm64 := result.ModuleForTests("sanitizer_check", "android_arm64_armv8-a")
+	arm64.Output("obj/hwaddress.o")
+	if o := arm64.MaybeOutput("obj/memtag_heap.o"); o.Rule != nil {
+		t.Errorf("expected memtag_heap not to be checked")
+	}
+}
+
+func TestSanitizerCheckMissingRuntime(t *testing.T) {
+	bp := `
+		cc_sanitizer_check {
+			name: "sanitizer_check",
+			sanitizers: ["address"],
+		}
+	`
+
+	// The default mock fixture does not declare the asan runtime.
+	prepareForCcTest.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitizer "address" requires runtime library "libclang_rt.asan", which is not defined for arm64`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestSanitizerCheckUnknownSanitizer(t *testing.T) {
+	bp := `
+		cc_sanitizer_check {
+			name: "sanitizer_check",
+			sanitizers: ["bogus"],
+		}
+	`
+
+	prepareForCcTest.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitizers: unknown sanitizer "bogus"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestClangVersionAtLeast(t *testing.T) {
+	testCases := []struct {
+		version  string
+		expected bool
+	}{
+		{"16.0.0", true},
+		{"16.0.2", true},
+		{"17", true},
+		{"15.0.7", false},
+		{"16", true},
+		{"dev", true},
+	}
+	for _, tc := range testCases {
+		android.AssertBoolEquals(t, tc.version, tc.expected, clangVersionAtLeast(tc.version, "16.0.0"))
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// SanitizerFlagsInfo is the exact set of clang driver arguments the sanitizers of a cc module
+// variant add to its compile and link commands, in the order they are passed, to reproduce a
+// sanitized build outside of Soong. It is the authoritative source of the sanitizer flags, the
+// Sanitize category of CompilerFlagsInfo is derived from it.
+type SanitizerFlagsInfo struct {
+	// The sanitizers enabled for this variant, in the order of the -fsanitize= argument.
+	Sanitizers []string
+	// The sanitizers that report errors instead of trapping, in the order of the
+	// -fno-sanitize-trap= argument.
+	DiagSanitizers []string
+	// Arguments added to the commands compiling C and C++ sources.
+	CFlags []string
+	// Arguments added to the commands compiling assembly sources.
+	AsFlags []string
+	// Arguments added to the link command after the object files and libraries.
+	LdFlags []string
+	// Arguments added to the link command before the libraries.
+	LibFlags []string
+	// Arguments linking the shared sanitizer runtime libraries, among the shared libraries of the
+	// link command, wrapped in -Wl,--as-needed when sanitize.runtime_optional is set.
+	RuntimeLibFlags []string
+	// Arguments of the module that the sanitizers removed from the compile and link commands,
+	// e.g. -Wl,--no-undefined for the sanitizer runtimes of shared libraries.
+	RemovedFlags []string
+	// The dynamic linker the sanitizers require, e.g. /system/bin/linker_asan64, or empty if they
+	// don't change it.
+	DynamicLinker string
+}
+
+var SanitizerFlagsInfoProvider = blueprint.NewProvider(SanitizerFlagsInfo{})
+
+// sanitizerFlagsSnapshot is a copy of the flags that sanitize.flags modifies, taken before it
+// runs.
+type sanitizerFlagsSnapshot struct {
+	cFlags, asFlags, ldFlags, globalLdFlags, libFlags []string
+	dynamicLinker                                     string
+}
+
+func snapshotSanitizerFlags(flags Flags) sanitizerFlagsSnapshot {
+	return sanitizerFlagsSnapshot{
+		cFlags:        android.CopyOf(flags.Local.CFlags),
+		asFlags:       android.CopyOf(flags.Local.AsFlags),
+		ldFlags:       android.CopyOf(flags.Local.LdFlags),
+		globalLdFlags: android.CopyOf(flags.Global.LdFlags),
+		libFlags:      android.CopyOf(flags.libFlags),
+		dynamicLinker: flags.DynamicLinker,
+	}
+}
+
+// appendedFlags returns the flags appended to before to produce after. Flags removed from before
+// don't shift the appended ones.
+func appendedFlags(before, after []string) []string {
+	kept := 0
+	for _, flag := range before {
+		if android.InList(flag, after) {
+			kept++
+		}
+	}
+	return android.CopyOf(after[kept:])
+}
+
+// removedFlags returns the flags of before that are not in after.
+func removedFlags(before, after []string) []string {
+	var removed []string
+	for _, flag := range before {
+		if !android.InList(flag, after) {
+			removed = append(removed, flag)
+		}
+	}
+	return removed
+}
+
+// sanitizerFlagsInfo returns the arguments added and removed by sanitize.flags, given the flags
+// before and after it ran.
+func (sanitize *sanitize) sanitizerFlagsInfo(before sanitizerFlagsSnapshot, after Flags) SanitizerFlagsInfo {
+	info := SanitizerFlagsInfo{
+		Sanitizers:     sanitize.Properties.Sanitizers,
+		DiagSanitizers: sanitize.Properties.DiagSanitizers,
+		CFlags:         appendedFlags(before.cFlags, after.Local.CFlags),
+		AsFlags:        appendedFlags(before.asFlags, after.Local.AsFlags),
+		LdFlags:        appendedFlags(before.ldFlags, after.Local.LdFlags),
+		LibFlags:       appendedFlags(before.libFlags, after.libFlags),
+	}
+	info.RemovedFlags = append(info.RemovedFlags, removedFlags(before.cFlags, after.Local.CFlags)...)
+	info.RemovedFlags = append(info.RemovedFlags, removedFlags(before.ldFlags, after.Local.LdFlags)...)
+	info.RemovedFlags = append(info.RemovedFlags, removedFlags(before.globalLdFlags, after.Global.LdFlags)...)
+	if after.DynamicLinker != before.dynamicLinker {
+		info.DynamicLinker = after.DynamicLinker
+	}
+	return info
+}
+
+// addRuntimeLinkFlags records the arguments added to the link command for the sanitizer runtime
+// libraries after sanitize.flags ran: ldFlags, e.g. -Wl,-rpath-link for their directories, and the
+// shared runtime libraries, linked with -Wl,--as-needed if asNeeded is set.
+func (info *SanitizerFlagsInfo) addRuntimeLinkFlags(ldFlags []string, runtimeLibs android.Paths, asNeeded bool) {
+	info.LdFlags = append(info.LdFlags, ldFlags...)
+	for _, lib := range runtimeLibs {
+		if asNeeded {
+			info.RuntimeLibFlags = append(info.RuntimeLibFlags, "-Wl,--as-needed", lib.String(), "-Wl,--no-as-needed")
+		} else {
+			info.RuntimeLibFlags = append(info.RuntimeLibFlags, lib.String())
+		}
+	}
+}
+
+// compileFlags returns the unique arguments the sanitizers add to the compile commands.
+func (info SanitizerFlagsInfo) compileFlags() []string {
+	return android.FirstUniqueStrings(append(android.CopyOf(info.CFlags), info.AsFlags...))
+}
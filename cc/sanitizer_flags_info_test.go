@@ -0,0 +1,120 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+// assertFlagsInOrder checks that the arguments of flags appear in args in the same order, possibly
+// interleaved with other arguments.
+func assertFlagsInOrder(t *testing.T, message string, args string, flags []string) {
+	t.Helper()
+	want := strings.Fields(strings.Join(flags, " "))
+	i := 0
+	for _, arg := range strings.Fields(args) {
+		if i < len(want) && arg == want[i] {
+			i++
+		}
+	}
+	if i < len(want) {
+		t.Errorf("%s: expected %q in this order, %q is missing from %q", message, want, want[i], args)
+	}
+}
+
+func TestSanitizerFlagsInfo(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_runtime_optional",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+				runtime_optional: true,
+			},
+		}
+	`
+
+	prepare := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+	)
+	result := prepare.RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a_asan"
+	bin := result.ModuleForTests("bin", variant)
+	info := result.ModuleProvider(bin.Module(), SanitizerFlagsInfoProvider).(SanitizerFlagsInfo)
+
+	android.AssertArrayString(t, "sanitizers", []string{"address"}, info.Sanitizers)
+	android.AssertStringEquals(t, "dynamic linker", "/system/bin/linker_asan64", info.DynamicLinker)
+	android.AssertIntEquals(t, "removed flags", 0, len(info.RemovedFlags))
+
+	// The flags must be passed to the compile and link commands in the same order.
+	cFlags := bin.Rule("cc").Args["cFlags"]
+	assertFlagsInOrder(t, "cflags", cFlags, info.CFlags)
+	android.AssertStringListContains(t, "sanitizer cflags", info.CFlags, "-fsanitize=address")
+	android.AssertStringListContains(t, "sanitizer cflags", info.CFlags, "-asan-globals=0")
+
+	android.AssertIntEquals(t, "sanitizer runtime libflags", 1, len(info.RuntimeLibFlags))
+	runtimeLib := info.RuntimeLibFlags[0]
+	android.AssertStringEquals(t, "sanitizer runtime", "libclang_rt.asan.so", filepath.Base(runtimeLib))
+
+	link := bin.Rule("ld")
+	assertFlagsInOrder(t, "ldflags", link.Args["ldFlags"], info.LdFlags)
+	android.AssertStringDoesContain(t, "ldflags", link.Args["ldFlags"], "-Wl,-dynamic-linker,"+info.DynamicLinker)
+	android.AssertStringListContains(t, "sanitizer ldflags", info.LdFlags, "-fsanitize=address")
+	android.AssertStringListContains(t, "sanitizer ldflags", info.LdFlags, "-fno-sanitize-link-runtime")
+	android.AssertStringListContains(t, "sanitizer ldflags", info.LdFlags,
+		"-Wl,-rpath-link,"+filepath.Dir(runtimeLib))
+	android.AssertIntEquals(t, "sanitizer libflags", 0, len(info.LibFlags))
+	assertFlagsInOrder(t, "libflags", link.Args["libFlags"], info.RuntimeLibFlags)
+
+	// Optional runtimes are linked with -Wl,--as-needed.
+	optional := result.ModuleForTests("bin_runtime_optional", variant)
+	info = result.ModuleProvider(optional.Module(), SanitizerFlagsInfoProvider).(SanitizerFlagsInfo)
+	android.AssertArrayString(t, "optional sanitizer runtime libflags",
+		[]string{"-Wl,--as-needed", runtimeLib, "-Wl,--no-as-needed"}, info.RuntimeLibFlags)
+	android.AssertStringDoesContain(t, "optional libflags", optional.Rule("ld").Args["libFlags"],
+		strings.Join(info.RuntimeLibFlags, " "))
+
+	t.Run("compiler flags report", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepare,
+			android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+				variables.CompilerFlagsReport = proptools.BoolPtr(true)
+			}),
+		).RunTestWithBp(t, bp)
+
+		// The compile flags reported by CompilerFlagsInfo are derived from the same flags.
+		bin := result.ModuleForTests("bin", variant).Module()
+		info := result.ModuleProvider(bin, SanitizerFlagsInfoProvider).(SanitizerFlagsInfo)
+		compilerFlags := result.ModuleProvider(bin, CompilerFlagsInfoProvider).(CompilerFlagsInfo)
+		android.AssertDeepEquals(t, "compiler flags info", info.compileFlags(), compilerFlags.Sanitize)
+	})
+}
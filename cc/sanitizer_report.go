@@ -0,0 +1,368 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("sanitizer_coverage_report", sanitizerCoverageReportFactory)
+	android.RegisterSingletonType("active_sanitizers", activeSanitizersFactory)
+	android.RegisterSingletonType("sanitizer_summary", sanitizerSummaryFactory)
+	android.RegisterSingletonType("sanitizer_warnings", sanitizerWarningsFactory)
+}
+
+// SanitizeInfo is the resolved sanitizer state of a cc module variant.
+type SanitizeInfo struct {
+	// The sanitizers enabled for this variant, as named in SANITIZE_TARGET.
+	Sanitizers []string
+	// Whether sanitizers are disabled with sanitize.never.
+	Never bool
+	// The reason given in sanitize.never_reason, if any.
+	NeverReason string
+	// The sanitizers that were requested but disabled because their runtime library isn't built
+	// for the target OS.
+	DroppedSanitizers []string
+	// The globally enabled sanitizers that were skipped because the module is incompatible with
+	// them.
+	IncompatibleSanitizers []string
+	// The named module set, e.g. boot_critical, that excluded the module from global device
+	// sanitization, and the global sanitizers it skipped because of it.
+	ExcludedByModuleSet         string
+	ModuleSetExcludedSanitizers []string
+	// The global sanitizers that were skipped because they can't run on the oldest API level the
+	// module supports.
+	MinSdkSkippedSanitizers []string
+	// Problems with the sanitize properties of the module that don't prevent building it.
+	Warnings []string
+}
+
+var SanitizeInfoProvider = blueprint.NewProvider(SanitizeInfo{})
+
+// enabledSanitizerNames returns the names of the sanitizers enabled for this variant.
+func (sanitize *sanitize) enabledSanitizerNames() []string {
+	var names []string
+	s := &sanitize.Properties.Sanitize
+	if Bool(s.Never) {
+		// The sanitize properties of modules with never: true, e.g. from directory defaults, are
+		// ignored.
+		return nil
+	}
+	if Bool(s.All_undefined) || Bool(s.Undefined) || len(s.Misc_undefined) > 0 {
+		names = append(names, "undefined")
+	}
+	for _, t := range Sanitizers {
+		if sanitize.isSanitizerEnabled(t) {
+			names = append(names, t.name())
+		}
+	}
+	return names
+}
+
+// skippedSanitizers returns the global sanitizers the module skipped, with the reason appended to
+// the ones that weren't skipped because the module is incompatible with them, e.g. the name of the
+// module set that excluded it.
+func (info SanitizeInfo) skippedSanitizers() []string {
+	skipped := append([]string(nil), info.IncompatibleSanitizers...)
+	for _, s := range info.ModuleSetExcludedSanitizers {
+		skipped = append(skipped, s+"("+info.ExcludedByModuleSet+")")
+	}
+	for _, s := range info.MinSdkSkippedSanitizers {
+		skipped = append(skipped, s+"(min_sdk_version)")
+	}
+	return skipped
+}
+
+// setSanitizeInfoProvider publishes the resolved sanitizer state of this variant in
+// SanitizeInfoProvider.
+func (c *Module) setSanitizeInfoProvider(ctx ModuleContext) {
+	if c.sanitize == nil {
+		return
+	}
+	ctx.SetProvider(SanitizeInfoProvider, SanitizeInfo{
+		Sanitizers:                  c.sanitize.enabledSanitizerNames(),
+		Never:                       Bool(c.sanitize.Properties.Sanitize.Never),
+		NeverReason:                 String(c.sanitize.Properties.Sanitize.Never_reason),
+		DroppedSanitizers:           c.sanitize.Properties.DroppedSanitizers,
+		IncompatibleSanitizers:      c.sanitize.Properties.IncompatibleSanitizers,
+		ExcludedByModuleSet:         c.sanitize.Properties.ExcludedByModuleSet,
+		ModuleSetExcludedSanitizers: c.sanitize.Properties.ModuleSetExcludedSanitizers,
+		MinSdkSkippedSanitizers:     c.sanitize.Properties.MinSdkSkippedSanitizers,
+		Warnings:                    c.sanitize.Properties.Warnings,
+	})
+}
+
+func sanitizerCoverageReportFactory() android.Singleton {
+	return &sanitizerCoverageReport{}
+}
+
+// sanitizerCoverageReport writes a CSV file per device partition listing each installed file,
+// the module that installs it, the sanitizers it was built with and the global sanitizers it
+// skipped because it is incompatible with them or belongs to a named module set, e.g.
+// "hwaddress(boot_critical)", to audit sanitizer rollout. It also writes
+// never.csv, listing every module that disables sanitizers with sanitize.never, installed or
+// not, with its directory and never_reason.
+type sanitizerCoverageReport struct{}
+
+type sanitizerCoverageRow struct {
+	path, module, sanitizers, neverReason, skipped string
+}
+
+type sanitizerNeverRow struct {
+	module, dir, reason string
+}
+
+func (s *sanitizerCoverageReport) GenerateBuildActions(ctx android.SingletonContext) {
+	partitions := make(map[string][]sanitizerCoverageRow)
+	never := make(map[string]sanitizerNeverRow)
+
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() {
+			return
+		}
+
+		// NDK code has sanitizers disabled implicitly, only list the modules that set never: true.
+		if c, ok := module.(*Module); ok && !c.UseSdk() && ctx.ModuleHasProvider(module, SanitizeInfoProvider) {
+			if info := ctx.ModuleProvider(module, SanitizeInfoProvider).(SanitizeInfo); info.Never {
+				never[ctx.ModuleName(module)] = sanitizerNeverRow{
+					module: ctx.ModuleName(module),
+					dir:    ctx.ModuleDir(module),
+					reason: info.NeverReason,
+				}
+			}
+		}
+
+		if module.IsSkipInstall() || module.Target().Os.Class != android.Device {
+			return
+		}
+
+		// Modules that aren't built by cc have no sanitizer state.
+		sanitizers, neverReason, skipped := "n/a", "", ""
+		if ctx.ModuleHasProvider(module, SanitizeInfoProvider) {
+			info := ctx.ModuleProvider(module, SanitizeInfoProvider).(SanitizeInfo)
+			skipped = strings.Join(info.skippedSanitizers(), " ")
+			switch {
+			case info.Never:
+				sanitizers, neverReason = "never", info.NeverReason
+			case len(info.Sanitizers) == 0:
+				sanitizers = "none"
+			default:
+				sanitizers = strings.Join(info.Sanitizers, " ")
+			}
+		}
+
+		for _, installed := range module.FilesToInstall() {
+			partition := installed.Partition()
+			path := strings.TrimPrefix(installed.String(), installed.PartitionDir())
+			partitions[partition] = append(partitions[partition], sanitizerCoverageRow{
+				path:        "/" + partition + path,
+				module:      ctx.ModuleName(module),
+				sanitizers:  sanitizers,
+				neverReason: neverReason,
+				skipped:     skipped,
+			})
+		}
+	})
+
+	var reports android.Paths
+	for _, partition := range android.SortedStringKeys(partitions) {
+		report := android.PathForOutput(ctx, "sanitizer_coverage", partition+".csv")
+		android.WriteFileRule(ctx, report, sanitizerCoverageCsv(partitions[partition]))
+		reports = append(reports, report)
+	}
+
+	neverReport := android.PathForOutput(ctx, "sanitizer_coverage", "never.csv")
+	android.WriteFileRule(ctx, neverReport, sanitizerNeverCsv(never))
+	reports = append(reports, neverReport)
+
+	ctx.Phony("sanitizer_coverage", reports...)
+}
+
+// sanitizerCoverageCsv returns the contents of a sanitizer coverage report, sorted by path.
+func sanitizerCoverageCsv(rows []sanitizerCoverageRow) string {
+	sort.Slice(rows, func(i, j int) bool { return rows[i].path < rows[j].path })
+
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write([]string{"path", "module", "sanitizers", "never_reason", "skipped"})
+	for _, row := range rows {
+		w.Write([]string{row.path, row.module, row.sanitizers, row.neverReason, row.skipped})
+	}
+	w.Flush()
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// sanitizerNeverCsv returns the contents of the report of the modules that disable sanitizers,
+// sorted by directory and module.
+func sanitizerNeverCsv(never map[string]sanitizerNeverRow) string {
+	var rows []sanitizerNeverRow
+	for _, name := range android.SortedStringKeys(never) {
+		rows = append(rows, never[name])
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].dir < rows[j].dir })
+
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write([]string{"module", "dir", "never_reason"})
+	for _, row := range rows {
+		w.Write([]string{row.module, row.dir, row.reason})
+	}
+	w.Flush()
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func activeSanitizersFactory() android.Singleton {
+	return &activeSanitizersSingleton{}
+}
+
+// activeSanitizersSingleton records the sanitizers enabled by any cc module variant in the build
+// in the config, where they are available through Config.ActiveSanitizers.
+type activeSanitizersSingleton struct{}
+
+func (s *activeSanitizersSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var sanitizers []string
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() || !ctx.ModuleHasProvider(module, SanitizeInfoProvider) {
+			return
+		}
+		info := ctx.ModuleProvider(module, SanitizeInfoProvider).(SanitizeInfo)
+		sanitizers = append(sanitizers, info.Sanitizers...)
+	})
+	ctx.Config().SetActiveSanitizers(android.SortedUniqueStrings(sanitizers))
+}
+
+func sanitizerSummaryFactory() android.Singleton {
+	return &sanitizerSummarySingleton{}
+}
+
+// sanitizerSummarySingleton prints a table of the cc module variants built with sanitizers and
+// the sanitizers they were built with once they have all been built, when SANITIZER_SUMMARY=true
+// is set in the environment.
+type sanitizerSummarySingleton struct{}
+
+type sanitizerSummaryRow struct {
+	module, variant, sanitizers string
+}
+
+func (s *sanitizerSummarySingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().IsEnvTrue("SANITIZER_SUMMARY") {
+		return
+	}
+
+	var rows []sanitizerSummaryRow
+	var outputs android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() || !ctx.ModuleHasProvider(module, SanitizeInfoProvider) {
+			return
+		}
+		info := ctx.ModuleProvider(module, SanitizeInfoProvider).(SanitizeInfo)
+		if len(info.Sanitizers) == 0 {
+			return
+		}
+		rows = append(rows, sanitizerSummaryRow{
+			module:     ctx.ModuleName(module),
+			variant:    ctx.ModuleSubDir(module),
+			sanitizers: strings.Join(info.Sanitizers, " "),
+		})
+		if c, ok := module.(*Module); ok && c.outputFile.Valid() {
+			outputs = append(outputs, c.outputFile.Path())
+		}
+	})
+
+	summary := android.PathForOutput(ctx, "sanitizer_summary.txt")
+	android.WriteFileRule(ctx, summary, sanitizerSummaryTable(rows))
+
+	// Print the summary after the outputs of all the sanitized modules have been built.
+	stamp := android.PathForOutput(ctx, "sanitizer_summary.stamp")
+	builder := android.NewRuleBuilder(pctx, ctx)
+	builder.Command().Text("cat").Input(summary).Implicits(android.SortedUniquePaths(outputs))
+	builder.Command().Text("touch").Output(stamp)
+	builder.Build("sanitizer_summary", "sanitizer summary")
+
+	ctx.Phony("sanitizer_summary", stamp)
+	ctx.Phony("droidcore", android.PathForPhony(ctx, "sanitizer_summary"))
+}
+
+// sanitizerSummaryTable returns the rows formatted as a table with aligned columns, sorted by
+// module and variant.
+func sanitizerSummaryTable(rows []sanitizerSummaryRow) string {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].module != rows[j].module {
+			return rows[i].module < rows[j].module
+		}
+		return rows[i].variant < rows[j].variant
+	})
+
+	header := sanitizerSummaryRow{"module", "variant", "sanitizers"}
+	moduleWidth, variantWidth := len(header.module), len(header.variant)
+	for _, row := range rows {
+		if len(row.module) > moduleWidth {
+			moduleWidth = len(row.module)
+		}
+		if len(row.variant) > variantWidth {
+			variantWidth = len(row.variant)
+		}
+	}
+
+	var sb strings.Builder
+	for _, row := range append([]sanitizerSummaryRow{header}, rows...) {
+		fmt.Fprintf(&sb, "%-*s  %-*s  %s\n", moduleWidth, row.module, variantWidth, row.variant, row.sanitizers)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func sanitizerWarningsFactory() android.Singleton {
+	return &sanitizerWarningsSingleton{}
+}
+
+// sanitizerWarningsSingleton writes the SanitizeInfo warnings of every module to
+// sanitizer_warnings.txt, once per module and warning, and prints them as part of droidcore.
+type sanitizerWarningsSingleton struct{}
+
+func (s *sanitizerWarningsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	warnings := make(map[string]bool)
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() || !ctx.ModuleHasProvider(module, SanitizeInfoProvider) {
+			return
+		}
+		info := ctx.ModuleProvider(module, SanitizeInfoProvider).(SanitizeInfo)
+		for _, warning := range info.Warnings {
+			warnings[fmt.Sprintf("%s: module %q: %s", ctx.BlueprintFile(module), ctx.ModuleName(module), warning)] = true
+		}
+	})
+	if len(warnings) == 0 {
+		return
+	}
+
+	report := android.PathForOutput(ctx, "sanitizer_warnings.txt")
+	android.WriteFileRule(ctx, report, strings.Join(android.SortedStringKeys(warnings), "\n"))
+
+	stamp := android.PathForOutput(ctx, "sanitizer_warnings.stamp")
+	builder := android.NewRuleBuilder(pctx, ctx)
+	builder.Command().Text("sed 's/^/warning: /'").Input(report).Text(">&2")
+	builder.Command().Text("touch").Output(stamp)
+	builder.Build("sanitizer_warnings", "sanitizer warnings")
+
+	ctx.Phony("sanitizer_warnings", stamp)
+	ctx.Phony("droidcore", android.PathForPhony(ctx, "sanitizer_warnings"))
+}
@@ -0,0 +1,359 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+// installedNonCcModule is a device module that installs a file without being built by cc.
+type installedNonCcModule struct {
+	android.ModuleBase
+}
+
+func installedNonCcModuleFactory() android.Module {
+	m := &installedNonCcModule{}
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibFirst)
+	return m
+}
+
+func (m *installedNonCcModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	out := android.PathForModuleOut(ctx, ctx.ModuleName())
+	android.WriteFileRule(ctx, out, "")
+	ctx.InstallFile(android.PathForModuleInstall(ctx, "etc"), ctx.ModuleName(), out)
+}
+
+// sanitizerReportRows parses a sanitizer report, checks its header and returns its rows keyed by
+// their first field.
+func sanitizerReportRows(t *testing.T, report string, header ...string) map[string][]string {
+	t.Helper()
+	records, err := csv.NewReader(strings.NewReader(report)).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse sanitizer report: %s", err)
+	}
+	if len(records) == 0 {
+		t.Fatalf("sanitizer report has no header")
+	}
+	android.AssertArrayString(t, "sanitizer report header", header, records[0])
+	rows := make(map[string][]string)
+	for _, record := range records[1:] {
+		rows[record[0]] = record
+	}
+	return rows
+}
+
+// sanitizerCoverageRows parses a per-partition sanitizer coverage report.
+func sanitizerCoverageRows(t *testing.T, report string) map[string][]string {
+	t.Helper()
+	return sanitizerReportRows(t, report, "path", "module", "sanitizers", "never_reason", "skipped")
+}
+
+func TestSanitizerCoverageReport(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_asan",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_plain",
+			srcs: ["foo.c"],
+		}
+
+		cc_binary {
+			name: "bin_never",
+			srcs: ["foo.c"],
+			sanitize: {
+				never: true,
+				never_reason: "crashes during early boot, b/123",
+			},
+		}
+
+		cc_library_shared {
+			name: "libvendor",
+			srcs: ["foo.c"],
+			vendor: true,
+			compile_multilib: "first",
+			sanitize: {
+				integer_overflow: true,
+			},
+		}
+
+		non_cc_module {
+			name: "non_cc",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("non_cc_module", installedNonCcModuleFactory)
+			ctx.RegisterSingletonType("sanitizer_coverage_report", sanitizerCoverageReportFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	report := result.SingletonForTests("sanitizer_coverage_report")
+
+	system := sanitizerCoverageRows(t, android.ContentFromFileRuleForTests(t,
+		report.Output("out/soong/sanitizer_coverage/system.csv")))
+	for _, row := range [][]string{
+		{"/system/bin/bin_asan", "bin_asan", "address", "", ""},
+		{"/system/bin/bin_plain", "bin_plain", "none", "", ""},
+		{"/system/bin/bin_never", "bin_never", "never", "crashes during early boot, b/123", ""},
+		{"/system/etc/non_cc", "non_cc", "n/a", "", ""},
+	} {
+		android.AssertArrayString(t, "system report "+row[0], row, system[row[0]])
+	}
+
+	vendor := sanitizerCoverageRows(t, android.ContentFromFileRuleForTests(t,
+		report.Output("out/soong/sanitizer_coverage/vendor.csv")))
+	android.AssertArrayString(t, "vendor report",
+		[]string{"/vendor/lib64/libvendor.so", "libvendor", "integer_overflow", "", ""},
+		vendor["/vendor/lib64/libvendor.so"])
+}
+
+func TestSanitizeNeverReason(t *testing.T) {
+	requireReason := android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+		variables.SanitizeNeverRequiresReasonPaths = []string{"audited"}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			requireReason,
+			android.FixtureAddFile("audited/foo.c", nil),
+			android.FixtureAddTextFile("audited/Android.bp", `
+				cc_binary {
+					name: "bin_never",
+					srcs: ["foo.c"],
+					sanitize: {
+						never: true,
+					},
+				}
+			`),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.never: requires never_reason explaining why sanitizers are disabled for modules in "audited"`,
+		)).RunTest(t)
+	})
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		requireReason,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureAddFile("audited/foo.c", nil),
+		android.FixtureAddTextFile("audited/Android.bp", `
+			cc_library_static {
+				name: "libnever_audited",
+				srcs: ["foo.c"],
+				sanitize: {
+					never: true,
+					never_reason: "linked into the bootloader, b/456",
+				},
+			}
+		`),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("sanitizer_coverage_report", sanitizerCoverageReportFactory)
+		}),
+	).RunTestWithBp(t, `
+		cc_binary {
+			name: "bin_never",
+			srcs: ["foo.c"],
+			sanitize: {
+				never: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_plain",
+			srcs: ["foo.c"],
+		}
+	`)
+
+	// Modules outside of SanitizeNeverRequiresReasonPaths don't need a reason, and all modules
+	// with never: true are reported, including the ones that aren't installed.
+	report := result.SingletonForTests("sanitizer_coverage_report")
+	never := sanitizerReportRows(t, android.ContentFromFileRuleForTests(t,
+		report.Output("out/soong/sanitizer_coverage/never.csv")), "module", "dir", "never_reason")
+	android.AssertArrayString(t, "never report bin_never", []string{"bin_never", ".", ""},
+		never["bin_never"])
+	android.AssertArrayString(t, "never report libnever_audited",
+		[]string{"libnever_audited", "audited", "linked into the bootloader, b/456"},
+		never["libnever_audited"])
+	if _, ok := never["bin_plain"]; ok {
+		t.Errorf("never report should not list bin_plain")
+	}
+}
+
+func TestActiveSanitizers(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_asan",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_ubsan",
+			srcs: ["foo.c"],
+			sanitize: {
+				misc_undefined: ["bounds"],
+			},
+		}
+
+		cc_binary {
+			name: "bin_plain",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("active_sanitizers", activeSanitizersFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	active, ok := result.Config.ActiveSanitizers()
+	android.AssertBoolEquals(t, "active sanitizers recorded", true, ok)
+	android.AssertStringListContains(t, "active sanitizers", active, "address")
+	android.AssertStringListContains(t, "active sanitizers", active, "undefined")
+	android.AssertStringListDoesNotContain(t, "active sanitizers", active, "hwaddress")
+
+	t.Run("before active_sanitizers", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+			android.FixtureAddFile("foo.c", nil),
+		).RunTestWithBp(t, bp)
+		active, ok := result.Config.ActiveSanitizers()
+		android.AssertBoolEquals(t, "active sanitizers recorded", false, ok)
+		android.AssertArrayString(t, "active sanitizers", nil, active)
+	})
+}
+
+func TestSanitizerSummary(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_asan",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_plain",
+			srcs: ["foo.c"],
+		}
+	`
+
+	prepare := func(env map[string]string) android.FixturePreparer {
+		return android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+			android.FixtureAddFile("foo.c", nil),
+			android.FixtureMergeEnv(env),
+			android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+				ctx.RegisterSingletonType("sanitizer_summary", sanitizerSummaryFactory)
+			}),
+		)
+	}
+
+	result := prepare(map[string]string{"SANITIZER_SUMMARY": "true"}).RunTestWithBp(t, bp)
+	singleton := result.SingletonForTests("sanitizer_summary")
+
+	summary := android.ContentFromFileRuleForTests(t, singleton.Output("out/soong/sanitizer_summary.txt"))
+	lines := strings.Split(summary, "\n")
+	android.AssertStringListContains(t, "summary header", strings.Fields(lines[0]), "sanitizers")
+	android.AssertArrayString(t, "summary rows",
+		[]string{"bin_asan", "android_arm64_armv8-a_asan", "address"}, strings.Fields(lines[1]))
+	android.AssertStringDoesNotContain(t, "summary", summary, "bin_plain")
+
+	bin := result.ModuleForTests("bin_asan", "android_arm64_armv8-a_asan").Module().(*Module)
+	rule := singleton.Rule("sanitizer_summary")
+	android.AssertPathsRelativeToTopEquals(t, "summary rule implicits",
+		[]string{bin.outputFile.Path().RelativeToTop().String()}, rule.Implicits)
+
+	t.Run("disabled", func(t *testing.T) {
+		result := prepare(nil).RunTestWithBp(t, bp)
+		singleton := result.SingletonForTests("sanitizer_summary")
+		android.AssertIntEquals(t, "summary rules", 0, len(singleton.AllOutputs()))
+	})
+}
+
+func TestSanitizerWarnings(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libnosrcs",
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libforward",
+			whole_static_libs: ["libreal"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_library {
+			name: "libreal",
+			srcs: ["foo.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.FixtureAddFile("foo.c", nil),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("sanitizer_warnings", sanitizerWarningsFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	warning := "sanitize: address enabled, but the module has no srcs or whole_static_libs to instrument"
+	warnings := func(name, variant string) []string {
+		m := result.ModuleForTests(name, variant).Module()
+		return result.ModuleProvider(m, SanitizeInfoProvider).(SanitizeInfo).Warnings
+	}
+
+	variant := "android_arm64_armv8-a"
+	android.AssertArrayString(t, "libnosrcs warnings", []string{warning}, warnings("libnosrcs", variant+"_static_asan"))
+	android.AssertArrayString(t, "libforward warnings", nil, warnings("libforward", variant+"_static_asan"))
+	// The shared variant reuses the objects of the static variant, it still has sources.
+	android.AssertArrayString(t, "libreal warnings", nil, warnings("libreal", variant+"_shared_asan"))
+
+	singleton := result.SingletonForTests("sanitizer_warnings")
+	report := android.ContentFromFileRuleForTests(t, singleton.Output("out/soong/sanitizer_warnings.txt"))
+	android.AssertStringEquals(t, "report", `Android.bp: module "libnosrcs": `+warning, report)
+}
@@ -0,0 +1,270 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("sanitizer_size", sanitizerSizeFactory)
+}
+
+var (
+	sanitizerSizeOverhead = pctx.AndroidStaticRule("sanitizerSizeOverhead",
+		blueprint.RuleParams{
+			Command: "echo $$(( $$(wc -c < $in) - $$(wc -c < $base) )) > $out",
+		},
+		"base")
+
+	sanitizerSizeCheck = pctx.AndroidStaticRule("sanitizerSizeCheck",
+		blueprint.RuleParams{
+			Command: "increase=$$(( $$(wc -c < $in) - $$(wc -c < $base) )) && " +
+				"if [ $$increase -gt $max ]; then " +
+				"echo \"error: $in is $$increase bytes larger than $base, which exceeds " +
+				"sanitize.max_sanitizer_size_increase of $module ($max bytes)\" >&2; exit 1; " +
+				"fi && touch $out",
+		},
+		"base", "max", "module")
+
+	sanitizerSizeReportRow = pctx.AndroidStaticRule("sanitizerSizeReportRow",
+		blueprint.RuleParams{
+			Command: "printf '%s,%s\\n' $row \"$$(cat $in)\" > $out",
+		},
+		"row")
+)
+
+// sanitizerBaseVariantDepTag is the dependency from a sanitizer variant of a module to the variant
+// of the same module built without those sanitizers.
+var sanitizerBaseVariantDepTag = dependencyTag{name: "sanitizer base variant"}
+
+// SanitizerSizeOverheadInfo reports the size overhead of a sanitizer variant relative to the
+// variant of the same module built without its sanitizers. It is only set when the size overhead
+// is needed, see sanitizerSizeOverheadNeeded.
+type SanitizerSizeOverheadInfo struct {
+	// The sanitizers enabled in this variant but not in the base variant.
+	Sanitizers []SanitizerType
+	// The output file of the base variant.
+	BaseOutputFile android.Path
+	// The output file of the sanitizer variant.
+	OutputFile android.Path
+	// A file containing the size of OutputFile minus the size of BaseOutputFile, in bytes.
+	SizeOverheadFile android.Path
+	// A stamp file built once the size overhead has been checked against
+	// sanitize.max_sanitizer_size_increase, or nil if the module doesn't set it.
+	SizeCheckFile android.Path
+}
+
+var SanitizerSizeOverheadInfoProvider = blueprint.NewProvider(SanitizerSizeOverheadInfo{})
+
+// variantSanitizers returns the sanitizers that have a variation enabled for this module.
+func (sanitize *sanitize) variantSanitizers() []SanitizerType {
+	var sanitizers []SanitizerType
+	for _, t := range Sanitizers {
+		switch t {
+		case Memtag_heap, intOverflow:
+			// These sanitizers don't create variations.
+			continue
+		}
+		if sanitize.isSanitizerEnabled(t) {
+			sanitizers = append(sanitizers, t)
+		}
+	}
+	return sanitizers
+}
+
+// sanitizerSizeOverheadNeeded returns true if the size overhead of the sanitizer variants of the
+// module is needed: when SANITIZER_SIZE_REPORT=true is set in the environment, or the module sets
+// sanitize.max_sanitizer_size_increase. The dependency on the base variant is only added then, so
+// that it isn't visited by the other dependency walks of every build.
+func sanitizerSizeOverheadNeeded(config android.Config, sanitize *sanitize) bool {
+	return sanitize.Properties.Sanitize.Max_sanitizer_size_increase != nil ||
+		config.IsEnvTrue("SANITIZER_SIZE_REPORT")
+}
+
+// sanitizerSizeOverheadDepsMutator adds a dependency from each sanitizer variant of a module to the
+// variant of the same module built without those sanitizers, if that variant exists and the size
+// overhead is needed.
+func sanitizerSizeOverheadDepsMutator(mctx android.BottomUpMutatorContext) {
+	c, ok := mctx.Module().(*Module)
+	if !ok || c.sanitize == nil || !c.Enabled() || !sanitizerSizeOverheadNeeded(mctx.Config(), c.sanitize) {
+		return
+	}
+
+	var variations []blueprint.Variation
+	for _, t := range c.sanitize.variantSanitizers() {
+		variations = append(variations, blueprint.Variation{Mutator: t.variationName(), Variation: ""})
+	}
+	if len(variations) == 0 || !mctx.OtherModuleDependencyVariantExists(variations, mctx.ModuleName()) {
+		return
+	}
+	mctx.AddVariationDependencies(variations, sanitizerBaseVariantDepTag, mctx.ModuleName())
+}
+
+// setSanitizerSizeOverheadProvider computes the size overhead of this sanitizer variant relative to
+// its base variant and publishes it in SanitizerSizeOverheadInfoProvider.
+func (c *Module) setSanitizerSizeOverheadProvider(ctx ModuleContext) {
+	if c.sanitize == nil || !c.outputFile.Valid() {
+		return
+	}
+
+	var base android.Path
+	ctx.VisitDirectDepsWithTag(sanitizerBaseVariantDepTag, func(dep android.Module) {
+		if ccDep, ok := dep.(*Module); ok && ccDep.outputFile.Valid() {
+			base = ccDep.outputFile.Path()
+		}
+	})
+	if base == nil {
+		return
+	}
+
+	output := c.outputFile.Path()
+	overhead := android.PathForModuleOut(ctx, "sanitizer_size_overhead.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        sanitizerSizeOverhead,
+		Description: "sanitizer size overhead " + output.Base(),
+		Input:       output,
+		Implicit:    base,
+		Output:      overhead,
+		Args: map[string]string{
+			"base": base.String(),
+		},
+	})
+
+	var sizeCheck android.WritablePath
+	if max := c.sanitize.Properties.Sanitize.Max_sanitizer_size_increase; max != nil {
+		sizeCheck = android.PathForModuleOut(ctx, "sanitizer_size_check.stamp")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        sanitizerSizeCheck,
+			Description: "sanitizer size check " + output.Base(),
+			Input:       output,
+			Implicit:    base,
+			Output:      sizeCheck,
+			Args: map[string]string{
+				"base":   base.String(),
+				"max":    fmt.Sprint(*max),
+				"module": ctx.ModuleName(),
+			},
+		})
+		ctx.CheckbuildFile(sizeCheck)
+	}
+
+	info := SanitizerSizeOverheadInfo{
+		Sanitizers:       c.sanitize.variantSanitizers(),
+		BaseOutputFile:   base,
+		OutputFile:       output,
+		SizeOverheadFile: overhead,
+	}
+	if sizeCheck != nil {
+		info.SizeCheckFile = sizeCheck
+	}
+	ctx.SetProvider(SanitizerSizeOverheadInfoProvider, info)
+}
+
+func sanitizerSizeFactory() android.Singleton {
+	return &sanitizerSizeSingleton{}
+}
+
+// sanitizerSizeSingleton creates the sanitizer_size_check target, which checks the size overhead
+// of the sanitizer variants of all the modules that set sanitize.max_sanitizer_size_increase and
+// is part of droidcore. When SANITIZER_SIZE_REPORT=true is set in the environment it also writes
+// the size overhead of every sanitizer variant that has a base variant in the build to
+// sanitizer_size_report.csv, to quantify the size attributable to sanitizers.
+type sanitizerSizeSingleton struct{}
+
+type sanitizerSizeRow struct {
+	module, dir, variant, sanitizers string
+	sizeOverheadFile                 android.Path
+}
+
+func (s *sanitizerSizeSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var rows []sanitizerSizeRow
+	var checks android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() || !ctx.ModuleHasProvider(module, SanitizerSizeOverheadInfoProvider) {
+			return
+		}
+		info := ctx.ModuleProvider(module, SanitizerSizeOverheadInfoProvider).(SanitizerSizeOverheadInfo)
+		if info.SizeOverheadFile == nil {
+			return
+		}
+		var sanitizers []string
+		for _, t := range info.Sanitizers {
+			sanitizers = append(sanitizers, t.name())
+		}
+		rows = append(rows, sanitizerSizeRow{
+			module:           ctx.ModuleName(module),
+			dir:              ctx.ModuleDir(module),
+			variant:          ctx.ModuleSubDir(module),
+			sanitizers:       strings.Join(sanitizers, " "),
+			sizeOverheadFile: info.SizeOverheadFile,
+		})
+		if info.SizeCheckFile != nil {
+			checks = append(checks, info.SizeCheckFile)
+		}
+	})
+
+	if len(checks) > 0 {
+		ctx.Phony("sanitizer_size_check", checks...)
+		ctx.Phony("droidcore", android.PathForPhony(ctx, "sanitizer_size_check"))
+	}
+
+	if !ctx.Config().IsEnvTrue("SANITIZER_SIZE_REPORT") {
+		return
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].module != rows[j].module {
+			return rows[i].module < rows[j].module
+		}
+		return rows[i].variant < rows[j].variant
+	})
+
+	// The size overheads are only known once the variants have been linked, so the report is
+	// assembled by the build rules: one per row, whose outputs are concatenated through an rsp
+	// file to keep the command line short however many variants the build has.
+	var rowFiles android.Paths
+	for _, row := range rows {
+		rowFile := android.PathForOutput(ctx, "sanitizer_size_report", row.dir, row.module, row.variant+".csv")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        sanitizerSizeReportRow,
+			Description: "sanitizer size report row " + row.module,
+			Input:       row.sizeOverheadFile,
+			Output:      rowFile,
+			Args: map[string]string{
+				"row": proptools.ShellEscape(row.module + "," + row.variant + "," + row.sanitizers),
+			},
+		})
+		rowFiles = append(rowFiles, rowFile)
+	}
+
+	report := android.PathForOutput(ctx, "sanitizer_size_report.csv")
+	builder := android.NewRuleBuilder(pctx, ctx)
+	builder.Command().Text("echo module,variant,sanitizers,size_increase >").Output(report)
+	builder.Command().
+		Text("xargs cat").
+		FlagWithRspFileInputList("< ", android.PathForOutput(ctx, "sanitizer_size_report.rsp"), rowFiles).
+		Text(">>").Text(report.String())
+	builder.Build("sanitizer_size_report", "sanitizer size report")
+
+	ctx.Phony("sanitizer_size_report", report)
+}
@@ -0,0 +1,286 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+)
+
+func TestSanitizerSizeOverhead(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			static_libs: ["libstatic"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_asan",
+			static_libs: ["libstatic"],
+		}
+
+		cc_library_static {
+			name: "libstatic",
+		}
+	`
+
+	prepare := func(env map[string]string) android.FixturePreparer {
+		return android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+			android.FixtureMergeEnv(env),
+		)
+	}
+
+	result := prepare(map[string]string{"SANITIZER_SIZE_REPORT": "true"}).RunTestWithBp(t, bp)
+
+	base := result.ModuleForTests("libstatic", "android_arm64_armv8-a_static")
+	asan := result.ModuleForTests("libstatic", "android_arm64_armv8-a_static_asan")
+
+	info := result.ModuleProvider(asan.Module(), SanitizerSizeOverheadInfoProvider).(SanitizerSizeOverheadInfo)
+	if g, w := info.Sanitizers, []SanitizerType{Asan}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected sanitizers %v, got %v", w, g)
+	}
+	baseOutput := base.Module().(*Module).OutputFile().Path()
+	asanOutput := asan.Module().(*Module).OutputFile().Path()
+	android.AssertPathRelativeToTopEquals(t, "base output file",
+		android.PathRelativeToTop(baseOutput), info.BaseOutputFile)
+	android.AssertPathRelativeToTopEquals(t, "output file",
+		android.PathRelativeToTop(asanOutput), info.OutputFile)
+
+	overhead := asan.Output("sanitizer_size_overhead.txt")
+	android.AssertPathRelativeToTopEquals(t, "size overhead file",
+		android.PathRelativeToTop(overhead.Output), info.SizeOverheadFile)
+	android.AssertPathRelativeToTopEquals(t, "size overhead input",
+		android.PathRelativeToTop(asanOutput), overhead.Input)
+	android.AssertPathRelativeToTopEquals(t, "size overhead base",
+		android.PathRelativeToTop(baseOutput), overhead.Implicit)
+
+	// The base variant has no sanitizer, so it reports no overhead.
+	baseInfo := result.ModuleProvider(base.Module(), SanitizerSizeOverheadInfoProvider).(SanitizerSizeOverheadInfo)
+	if baseInfo.SizeOverheadFile != nil {
+		t.Errorf("expected base variant not to report a size overhead")
+	}
+
+	t.Run("not needed", func(t *testing.T) {
+		result := prepare(nil).RunTestWithBp(t, bp)
+		asan := result.ModuleForTests("libstatic", "android_arm64_armv8-a_static_asan").Module()
+		result.VisitDirectDeps(asan, func(dep blueprint.Module) {
+			if result.ModuleName(dep) == "libstatic" {
+				t.Errorf("expected no dependency on the base variant without SANITIZER_SIZE_REPORT")
+			}
+		})
+		info := result.ModuleProvider(asan, SanitizerSizeOverheadInfoProvider).(SanitizerSizeOverheadInfo)
+		if info.SizeOverheadFile != nil {
+			t.Errorf("expected no size overhead without SANITIZER_SIZE_REPORT")
+		}
+	})
+}
+
+// runSanitizerSizeCheck runs the command of a sanitizerSizeCheck rule on fake outputs of the given
+// sizes, and returns its error and standard error.
+func runSanitizerSizeCheck(t *testing.T, params android.TestingBuildParams, size, baseSize int) (error, string) {
+	t.Helper()
+	dir := t.TempDir()
+	in, base, out := filepath.Join(dir, "in"), filepath.Join(dir, "base"), filepath.Join(dir, "out")
+	if err := ioutil.WriteFile(in, make([]byte, size), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(base, make([]byte, baseSize), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	command := strings.NewReplacer(
+		"$$", "$",
+		"$in", in,
+		"$base", base,
+		"$out", out,
+		"$max", params.Args["max"],
+		"$module", params.Args["module"],
+	).Replace(params.RuleParams.Command)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stderr = &stderr
+	return cmd.Run(), stderr.String()
+}
+
+func TestSanitizerSizeCheck(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_asan",
+			static_libs: ["libstatic"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_asan",
+			static_libs: ["libstatic"],
+		}
+
+		cc_library_static {
+			name: "libstatic",
+			sanitize: {
+				max_sanitizer_size_increase: 100,
+			},
+		}
+	`
+
+	prepare := func(env map[string]string) android.FixturePreparer {
+		return android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+			android.FixtureMergeEnv(env),
+			android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+				ctx.RegisterSingletonType("sanitizer_size", sanitizerSizeFactory)
+			}),
+		)
+	}
+
+	result := prepare(map[string]string{"SANITIZER_SIZE_REPORT": "true"}).RunTestWithBp(t, bp)
+
+	base := result.ModuleForTests("libstatic", "android_arm64_armv8-a_static")
+	asan := result.ModuleForTests("libstatic", "android_arm64_armv8-a_static_asan")
+	baseOutput := base.Module().(*Module).OutputFile().Path()
+	asanOutput := asan.Module().(*Module).OutputFile().Path()
+
+	check := asan.Output("sanitizer_size_check.stamp")
+	android.AssertPathRelativeToTopEquals(t, "size check input",
+		android.PathRelativeToTop(asanOutput), check.Input)
+	android.AssertPathRelativeToTopEquals(t, "size check base",
+		android.PathRelativeToTop(baseOutput), check.Implicit)
+	android.AssertStringEquals(t, "size check max", "100", check.Args["max"])
+	android.AssertStringEquals(t, "size check base arg", android.PathRelativeToTop(baseOutput), check.Args["base"])
+	android.AssertStringEquals(t, "size check module", "libstatic", check.Args["module"])
+	android.AssertStringDoesContain(t, "size check command", check.RuleParams.Command,
+		"if [ $$increase -gt $max ]; then")
+
+	info := result.ModuleProvider(asan.Module(), SanitizerSizeOverheadInfoProvider).(SanitizerSizeOverheadInfo)
+	android.AssertPathRelativeToTopEquals(t, "size check file",
+		android.PathRelativeToTop(check.Output), info.SizeCheckFile)
+
+	// The base variant has nothing to check against.
+	if base.MaybeOutput("sanitizer_size_check.stamp").Rule != nil {
+		t.Errorf("expected the base variant not to check its size")
+	}
+
+	t.Run("within limit", func(t *testing.T) {
+		err, stderr := runSanitizerSizeCheck(t, check, 150, 50)
+		if err != nil {
+			t.Errorf("expected an increase of 100 bytes to pass the size check, got %s: %s", err, stderr)
+		}
+	})
+
+	t.Run("exceeds limit", func(t *testing.T) {
+		err, stderr := runSanitizerSizeCheck(t, check, 151, 50)
+		if err == nil {
+			t.Fatalf("expected an increase of 101 bytes to fail the size check")
+		}
+		android.AssertStringDoesContain(t, "size check error", stderr,
+			"101 bytes larger than")
+		android.AssertStringDoesContain(t, "size check error", stderr,
+			"exceeds sanitize.max_sanitizer_size_increase of libstatic (100 bytes)")
+	})
+
+	singleton := result.SingletonForTests("sanitizer_size")
+	row := singleton.Output("out/soong/sanitizer_size_report/libstatic/android_arm64_armv8-a_static_asan.csv")
+	android.AssertPathRelativeToTopEquals(t, "report row input",
+		info.SizeOverheadFile.RelativeToTop().String(), row.Input)
+	android.AssertStringEquals(t, "report row",
+		proptools.ShellEscape("libstatic,android_arm64_armv8-a_static_asan,address"), row.Args["row"])
+
+	report := singleton.Rule("sanitizer_size_report")
+	android.AssertPathRelativeToTopEquals(t, "report output",
+		"out/soong/sanitizer_size_report.csv", report.Output)
+	android.AssertPathsRelativeToTopEquals(t, "report inputs",
+		[]string{row.Output.String()}, report.Inputs)
+	android.AssertStringEquals(t, "report rsp file",
+		"out/soong/sanitizer_size_report.rsp", report.RuleParams.Rspfile)
+	android.AssertStringDoesContain(t, "report command", report.RuleParams.Command,
+		"xargs cat < out/soong/sanitizer_size_report.rsp >> out/soong/sanitizer_size_report.csv")
+
+	t.Run("report disabled", func(t *testing.T) {
+		result := prepare(nil).RunTestWithBp(t, bp)
+		if result.SingletonForTests("sanitizer_size").MaybeRule("sanitizer_size_report").Rule != nil {
+			t.Errorf("expected no sanitizer size report without SANITIZER_SIZE_REPORT")
+		}
+		// The size is still checked for the module that sets max_sanitizer_size_increase.
+		result.ModuleForTests("libstatic", "android_arm64_armv8-a_static_asan").Output("sanitizer_size_check.stamp")
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.max_sanitizer_size_increase: must not be negative, got -1`,
+		)).RunTestWithBp(t, `
+			cc_library_static {
+				name: "libstatic",
+				sanitize: {
+					max_sanitizer_size_increase: -1,
+				},
+			}
+		`)
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`sanitize.max_sanitizer_size_increase: is only supported on static libraries`,
+		)).RunTestWithBp(t, `
+			cc_binary {
+				name: "bin_with_asan",
+				sanitize: {
+					address: true,
+					max_sanitizer_size_increase: 100,
+				},
+			}
+		`)
+	})
+
+	t.Run("static and shared library", func(t *testing.T) {
+		// The shared variant has no base variant, so its size couldn't be checked.
+		android.GroupFixturePreparers(
+			prepareForCcTest,
+			prepareForAsanTest,
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`module "libboth" variant "android_arm64_armv8-a_shared.*": sanitize.max_sanitizer_size_increase: is only supported on static libraries`,
+		)).RunTestWithBp(t, `
+			cc_library {
+				name: "libboth",
+				sanitize: {
+					max_sanitizer_size_increase: 100,
+				},
+			}
+		`)
+	})
+}
@@ -433,6 +433,13 @@ func (test *testBinary) install(ctx ModuleContext, file android.Path) {
 	for _, tag := range test.Properties.Test_options.Test_suite_tag {
 		configs = append(configs, tradefed.Option{Name: "test-suite-tag", Value: tag})
 	}
+	// Tell the tooling collecting the coverage of the test where its coverage runtime writes the
+	// profraw files.
+	if c, ok := ctx.Module().(*Module); ok && c.coverage != nil && c.coverage.clangProfileLinked(ctx) {
+		configs = append(configs,
+			tradefed.Option{Name: "config-descriptor:metadata", Key: "coverage-profile-dir", Value: c.coverage.profileDir()},
+			tradefed.Option{Name: "config-descriptor:metadata", Key: "llvm-profile-file", Value: c.coverage.profileFile()})
+	}
 	if test.Properties.Test_options.Min_shipping_api_level != nil {
 		if test.Properties.Test_options.Vsr_min_shipping_api_level != nil {
 			ctx.PropertyErrorf("test_options.min_shipping_api_level", "must not be set at the same time as 'vsr_min_shipping_api_level'.")
@@ -471,6 +478,7 @@ func (test *testBinary) install(ctx ModuleContext, file android.Path) {
 		test.Properties.Test_options.Unit_test = proptools.BoolPtr(true)
 	}
 	test.binaryDecorator.baseInstaller.install(ctx, file)
+	test.binaryDecorator.installUbsanLauncher(ctx)
 }
 
 func NewTest(hod android.HostOrDeviceSupported) *Module {
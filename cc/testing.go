@@ -116,6 +116,7 @@ func commonDefaultModules() string {
 				"linux_bionic_supported",
 				"toolchain_libs_defaults",
 			],
+			host_supported: true,
 		}
 
 		// Needed for sanitizer
@@ -424,6 +425,57 @@ func commonDefaultModules() string {
 			system_shared_libs: [],
 			stl: "none",
 		}
+
+		cc_defaults {
+			name: "libc_musl_crt_defaults",
+			host_supported: true,
+			device_supported: false,
+			enabled: false,
+			target: {
+				musl: {
+					enabled: true,
+				},
+			},
+			stl: "none",
+			crt: true,
+			system_shared_libs: [],
+		}
+
+		cc_object {
+			name: "libc_musl_crtbegin_so",
+			defaults: ["libc_musl_crt_defaults"],
+			srcs: ["crtbegin_so.c"],
+		}
+
+		cc_object {
+			name: "libc_musl_crtbegin_dynamic",
+			defaults: ["libc_musl_crt_defaults"],
+			srcs: ["crtbegin.c"],
+		}
+
+		cc_object {
+			name: "libc_musl_crtbegin_static",
+			defaults: ["libc_musl_crt_defaults"],
+			srcs: ["crtbegin.c"],
+		}
+
+		cc_object {
+			name: "libc_musl_crtend_so",
+			defaults: ["libc_musl_crt_defaults"],
+			srcs: ["crtend_so.c"],
+		}
+
+		cc_object {
+			name: "libc_musl_crtend",
+			defaults: ["libc_musl_crt_defaults"],
+			srcs: ["crtend.c"],
+		}
+
+		cc_object {
+			name: "musl_linker_script",
+			defaults: ["libc_musl_crt_defaults"],
+			srcs: ["linker_script.c"],
+		}
 	`
 }
 
@@ -526,6 +578,8 @@ var PrepareForTestWithCcBuildComponents = android.GroupFixturePreparers(
 	android.FixtureRegisterWithContext(RegisterRequiredBuildComponentsForTest),
 	android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
 		ctx.RegisterModuleType("cc_fuzz", FuzzFactory)
+		ctx.RegisterModuleType("cc_sanitize_defaults_dir", SanitizeDefaultsDirFactory)
+		ctx.RegisterModuleType("cc_sanitizer_check", SanitizerCheckFactory)
 		ctx.RegisterModuleType("cc_test", TestFactory)
 		ctx.RegisterModuleType("cc_test_library", TestLibraryFactory)
 		ctx.RegisterModuleType("vndk_prebuilt_shared", VndkPrebuiltSharedFactory)
@@ -284,6 +284,23 @@ func vndkMustUseVendorVariantList(cfg android.Config) []string {
 	}).([]string)
 }
 
+// VndkInfo is provided by the VNDK libraries, except for the VNDK extensions.
+type VndkInfo struct {
+	// Whether the vendor variant of the library must be used by vendor modules even when the
+	// device uses the core variants of the VNDK libraries, see DeviceConfig.VndkUseCoreVariant.
+	// It is set for the VNDK-SP libraries and the libraries in the VndkMustUseVendorVariantList.
+	MustUseVendorVariant bool
+}
+
+var VndkInfoProvider = blueprint.NewProvider(VndkInfo{})
+
+// vndkMustUseVendorVariant returns true if the vendor variant of the VNDK library m must be used,
+// see VndkInfo.MustUseVendorVariant. Unlike Module.MustUseVendorVariant, it doesn't depend on the
+// variant of m.
+func vndkMustUseVendorVariant(cfg android.Config, m *Module) bool {
+	return m.IsVndkSp() || inList(m.BaseModuleName(), vndkMustUseVendorVariantList(cfg))
+}
+
 // test may call this to override global configuration(config.VndkMustUseVendorVariantList)
 // when it is called, it must be before the first call to vndkMustUseVendorVariantList()
 func setVndkMustUseVendorVariantListForTest(config android.Config, mustUseVendorVariantList []string) {
@@ -0,0 +1,72 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Verifies that the packages of the bp2build workspace are classified as handcrafted, generated or
+// merged as listed in the checked-in manifest.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"android/soong/bp2build"
+)
+
+func main() {
+	var manifestFile, actualFile string
+
+	flag.StringVar(&manifestFile, "manifest", "", "Checked-in package classification manifest")
+	flag.StringVar(&actualFile, "actual", "", "Package classification written by bp2build")
+	flag.Parse()
+
+	if manifestFile == "" || actualFile == "" || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	manifest, err := readClassifications(manifestFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	actual, err := readClassifications(actualFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	if diffs := bp2build.DiffPackageClassifications(manifest, actual); len(diffs) > 0 {
+		fmt.Fprintf(os.Stderr, "bp2build package classifications differ from %s:\n", manifestFile)
+		for _, diff := range diffs {
+			fmt.Fprintf(os.Stderr, "  %s\n", diff)
+		}
+		fmt.Fprintf(os.Stderr, "If the change is intended, update the manifest with:\n  cp %s %s\n",
+			actualFile, manifestFile)
+		os.Exit(3)
+	}
+}
+
+func readClassifications(file string) (map[string]bp2build.PackageClassification, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	classifications, err := bp2build.ParsePackageClassifications(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return classifications, nil
+}
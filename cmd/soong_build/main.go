@@ -488,6 +488,18 @@ func getExistingBazelRelatedFiles(topDir string) ([]string, error) {
 	return files, nil
 }
 
+// Writes the classification of the packages in the bp2build workspace, which the
+// bp2build_package_classification_check target compares against the checked-in manifest.
+func writeBp2buildPackageClassification(configuration android.Config, generatedPackages []string, existingBazelFiles []string) {
+	classifications := bp2build.ClassifyPackages(generatedPackages, existingBazelFiles)
+	path := shared.JoinPath(topDir, configuration.SoongOutDir(), android.Bp2buildPackageClassificationFile)
+	err := ioutil.WriteFile(path, []byte(bp2build.FormatPackageClassifications(classifications)), 0666)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing bp2build package classification '%s': %s\n", path, err)
+		os.Exit(1)
+	}
+}
+
 // Run Soong in the bp2build mode. This creates a standalone context that registers
 // an alternate pipeline of mutators and singletons specifically for generating
 // Bazel BUILD files instead of Ninja files.
@@ -558,6 +570,8 @@ func runBp2Build(configuration android.Config, extraNinjaDeps []string) {
 		os.Exit(1)
 	}
 
+	writeBp2buildPackageClassification(configuration, metrics.GeneratedPackages(), existingBazelRelatedFiles)
+
 	pathsToIgnoredBuildFiles := getPathsToIgnoredBuildFiles(topDir, generatedRoot, existingBazelRelatedFiles, configuration.IsEnvTrue("BP2BUILD_VERBOSE"))
 	excludes = append(excludes, pathsToIgnoredBuildFiles...)
 
@@ -101,6 +101,12 @@ type HostToolProvider interface {
 	android.HostToolProvider
 }
 
+// SanitizerRuntimeToolProvider is implemented by host tools that may be built with a sanitizer,
+// whose runtime libraries have to be copied into the sandbox along with the tool.
+type SanitizerRuntimeToolProvider interface {
+	SanitizerRuntimeTools() android.Paths
+}
+
 type hostToolDependencyTag struct {
 	blueprint.BaseDependencyTag
 	android.LicenseAnnotationToolchainDependencyTag
@@ -344,6 +350,11 @@ func (g *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 						tools = append(tools, path.Path())
 						addLocationLabel(tag.label, toolLocation{android.Paths{path.Path()}})
 					}
+					// A sanitized tool doesn't run without its runtime libraries, which aren't
+					// always installed along with it.
+					if r, ok := t.(SanitizerRuntimeToolProvider); ok {
+						tools = append(tools, r.SanitizerRuntimeTools()...)
+					}
 				case bootstrap.GoBinaryTool:
 					// A GoBinaryTool provides the install path to a tool, which will be copied.
 					p := android.PathForGoBinary(ctx, t)
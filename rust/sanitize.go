@@ -475,6 +475,14 @@ func (mod *Module) SanitizeNever() bool {
 	return Bool(mod.sanitize.Properties.Sanitize.Never)
 }
 
+func (mod *Module) SanitizeShallow() bool {
+	return false
+}
+
+func (mod *Module) SanitizeSharedDepsOnly() bool {
+	return false
+}
+
 var _ cc.PlatformSanitizeable = (*Module)(nil)
 
 func IsSanitizableDependencyTag(tag blueprint.DependencyTag) bool {